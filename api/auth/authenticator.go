@@ -2,8 +2,12 @@ package auth
 
 import (
 	"crypto/rsa"
+	"sync"
+	"time"
 
+	"github.com/adrianosela/padl/api/keystore"
 	"github.com/adrianosela/padl/api/store"
+	"github.com/adrianosela/padl/lib/keys"
 )
 
 const (
@@ -13,29 +17,70 @@ const (
 	// ServiceAccountAudience is the service account
 	// audience for Padl API
 	ServiceAccountAudience = "decrypt"
+
+	// DefaultAccessTokenLifetime is the lifetime of user
+	// access tokens when none is configured
+	DefaultAccessTokenLifetime = time.Hour
+	// DefaultRefreshTokenLifetime is the lifetime of user
+	// refresh tokens when none is configured
+	DefaultRefreshTokenLifetime = time.Hour * 24 * 30
+	// DefaultServiceAccountTokenLifetime is the lifetime of service
+	// account access tokens when none is configured
+	DefaultServiceAccountTokenLifetime = time.Minute * 15
 )
 
+// Lifetimes are the lifetimes of the tokens issued by the authenticator
+type Lifetimes struct {
+	Access         time.Duration // user access tokens
+	Refresh        time.Duration // user refresh tokens
+	ServiceAccount time.Duration // service account access tokens
+}
+
 // Authenticator is the module in charge of authentication
 type Authenticator struct {
 	db     store.Database
 	signer *rsa.PrivateKey
 	iss    string
 	aud    string
+
+	lifetimes Lifetimes
+
+	// signing key ring, see LoadSigningKeys()
+	mutex     sync.RWMutex
+	ks        keystore.Keystore
+	active    *rsa.PrivateKey
+	activeKID string
+	verifiers map[string]*rsa.PublicKey
+	loadedAt  time.Time
 }
 
-// NewAuthenticator is the Authenticator constructor
-func NewAuthenticator(db store.Database, key *rsa.PrivateKey, iss, aud string) *Authenticator {
+// NewAuthenticator is the Authenticator constructor. Zero token
+// lifetimes are replaced with their respective defaults
+func NewAuthenticator(db store.Database, key *rsa.PrivateKey, iss, aud string, lifetimes Lifetimes) *Authenticator {
 	a := &Authenticator{
-		db:     db,
-		signer: key,
-		aud:    aud,
-		iss:    iss,
+		db:        db,
+		signer:    key,
+		aud:       aud,
+		iss:       iss,
+		lifetimes: lifetimes,
+		active:    key,
+		activeKID: keys.GetFingerprint(&key.PublicKey),
 	}
+	a.verifiers = map[string]*rsa.PublicKey{a.activeKID: &key.PublicKey}
 	if a.iss == "" {
 		a.iss = defaultPadlIssuer
 	}
 	if a.aud == "" {
 		a.aud = PadlAPIAudience
 	}
+	if a.lifetimes.Access == 0 {
+		a.lifetimes.Access = DefaultAccessTokenLifetime
+	}
+	if a.lifetimes.Refresh == 0 {
+		a.lifetimes.Refresh = DefaultRefreshTokenLifetime
+	}
+	if a.lifetimes.ServiceAccount == 0 {
+		a.lifetimes.ServiceAccount = DefaultServiceAccountTokenLifetime
+	}
 	return a
 }
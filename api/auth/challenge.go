@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/adrianosela/padl/api/store"
+)
+
+const (
+	// ChallengeAudience is the audience of the challenges which
+	// service accounts sign to prove possession of their key.
+	// Challenges are never accepted as access tokens
+	ChallengeAudience = "challenge"
+
+	challengeLifetime = time.Minute
+)
+
+// ErrChallengeUsed is returned when a challenge is presented more than once
+var ErrChallengeUsed = errors.New("challenge already used")
+
+// GenerateChallenge issues a single use challenge for a service account.
+// The challenge is itself a signed token, so no state is kept until it
+// is consumed
+func (a *Authenticator) GenerateChallenge(sub string) (string, error) {
+	return a.GenerateJWT(sub, ChallengeAudience)
+}
+
+// ConsumeChallenge validates a challenge and marks it as used, by adding it
+// to the revocation list until it expires. Adding it is a single insert
+// which fails if it is already listed, such that only one of concurrent
+// uses of a challenge succeeds. Returns the challenge's claims
+func (a *Authenticator) ConsumeChallenge(challenge string) (*CustomClaims, error) {
+	cc, err := a.ValidateJWT(challenge, ChallengeAudience)
+	if err != nil {
+		return nil, fmt.Errorf("invalid challenge: %s", err)
+	}
+	if err = a.revoke(cc); err != nil {
+		if err == store.ErrTokenRevoked {
+			return nil, ErrChallengeUsed
+		}
+		return nil, fmt.Errorf("could not consume challenge: %s", err)
+	}
+	return cc, nil
+}
@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"time"
 
-	"github.com/adrianosela/padl/lib/keys"
 	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/google/uuid"
 )
@@ -61,9 +60,11 @@ func newJWT(claims *CustomClaims, signingMethod jwt.SigningMethod) *jwt.Token {
 	return jwt.NewWithClaims(signingMethod, claims)
 }
 
-// SignJWT signs a token with the authenticator's key
+// SignJWT signs a token with the authenticator's active key
 func (a *Authenticator) SignJWT(tk *jwt.Token) (string, error) {
-	return tk.SignedString(a.signer)
+	signer, kid := a.activeSigner()
+	tk.Header["kid"] = kid
+	return tk.SignedString(signer)
 }
 
 // ValidateJWT returns the claims within a token as a CustomClaims obect and validates its fields
@@ -71,7 +72,8 @@ func (a *Authenticator) ValidateJWT(tkString string, allowedAuds ...string) (*Cu
 	var cc CustomClaims
 	//parse onto a jwt token object
 	keyfunc := func(tk *jwt.Token) (interface{}, error) {
-		return &a.signer.PublicKey, nil // we use a single key for now
+		kid, _ := tk.Header["kid"].(string)
+		return a.verifier(kid)
 	}
 	token, err := jwt.ParseWithClaims(tkString, &cc, keyfunc)
 	if err != nil {
@@ -122,9 +124,11 @@ func (a *Authenticator) GenerateJWT(email string, aud string) (string, error) {
 	var lifetime time.Duration
 
 	if aud == PadlAPIAudience {
-		lifetime = time.Duration(time.Hour * 12)
+		lifetime = a.lifetimes.Access
 	} else if aud == ServiceAccountAudience {
-		lifetime = time.Duration(time.Hour * 24 * 365) // FIXME: valid for a year
+		lifetime = a.lifetimes.ServiceAccount
+	} else if aud == ChallengeAudience {
+		lifetime = challengeLifetime
 	} else {
 		return "", errors.New("Audience not recognized")
 	}
@@ -132,7 +136,6 @@ func (a *Authenticator) GenerateJWT(email string, aud string) (string, error) {
 	cc := NewCustomClaims(email, aud, a.iss, lifetime)
 
 	tk := newJWT(cc, jwt.SigningMethodRS512)
-	tk.Header["kid"] = keys.GetFingerprint(&a.signer.PublicKey)
 	signedTk, err := a.SignJWT(tk)
 	if err != nil {
 		return "", fmt.Errorf("could not sign JWT: %s", err)
@@ -0,0 +1,223 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/adrianosela/padl/api/keystore"
+	"github.com/adrianosela/padl/api/kms"
+	"github.com/adrianosela/padl/lib/keys"
+)
+
+const (
+	// activeSigningKeyProject is the keystore project of the active
+	// jwt signing key. Project names can not contain "." so these can
+	// not clash with the keys of user projects
+	activeSigningKeyProject = "padl.jwt-signing.active"
+	// retiredSigningKeyProject is the keystore project of jwt signing
+	// keys which have been rotated out, and are used for verification only
+	retiredSigningKeyProject = "padl.jwt-signing.retired"
+
+	// signingKeysReloadInterval is the minimum time between reloads of the
+	// signing keys triggered by tokens with unknown key ids, e.g. tokens
+	// signed by another API instance after a rotation
+	signingKeysReloadInterval = time.Minute
+)
+
+var (
+	// ErrSigningKeysNotPersisted is returned when rotating
+	// signing keys without a keystore to persist them in
+	ErrSigningKeysNotPersisted = errors.New("signing keys are not persisted, can not rotate")
+
+	// ErrActiveSigningKey is returned when trying to
+	// delete the active (or the configured) signing key
+	ErrActiveSigningKey = errors.New("can not delete the active or configured signing key")
+)
+
+// JWK is an RSA public key in JSON Web Key format (RFC 7517)
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	Modulus   string `json:"n"`
+	Exponent  string `json:"e"`
+}
+
+// JWKSet is a set of JSON Web Keys, as served at /.well-known/jwks.json
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// LoadSigningKeys loads the signing key ring from the keystore. The active
+// key signs new tokens, while every key in the ring (including the one the
+// authenticator was constructed with) verifies tokens by their "kid" header.
+// Until a key is rotated in, the configured key remains the active one
+func (a *Authenticator) LoadSigningKeys(ks keystore.Keystore) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.ks = ks
+	return a.reloadSigningKeys()
+}
+
+// RotateSigningKey generates a new active signing key and retires the
+// previous one, which remains valid for verification such that outstanding
+// tokens are not invalidated. Returns the id of the new key
+func (a *Authenticator) RotateSigningKey(bits int) (string, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.ks == nil {
+		return "", ErrSigningKeysNotPersisted
+	}
+	key, err := kms.NewPrivateKey(bits, activeSigningKeyProject)
+	if err != nil {
+		return "", fmt.Errorf("could not generate signing key: %s", err)
+	}
+	// retire before adding the new key such that there is at most
+	// one active key, if this fails midway the configured key signs
+	active, err := a.ks.ListPrivKeys(activeSigningKeyProject)
+	if err != nil {
+		return "", fmt.Errorf("could not list active signing keys: %s", err)
+	}
+	for _, k := range active {
+		k.Project = retiredSigningKeyProject
+		if err = a.ks.UpdatePrivKey(k); err != nil {
+			return "", fmt.Errorf("could not retire signing key %s: %s", k.ID, err)
+		}
+	}
+	if err = a.ks.PutPrivKey(key); err != nil {
+		return "", fmt.Errorf("could not store signing key: %s", err)
+	}
+	if err = a.reloadSigningKeys(); err != nil {
+		return "", err
+	}
+	return key.ID, nil
+}
+
+// DeleteSigningKey deletes a retired signing key, after which tokens
+// signed with it no longer validate
+func (a *Authenticator) DeleteSigningKey(kid string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.ks == nil {
+		return ErrSigningKeysNotPersisted
+	}
+	if kid == a.activeKID || kid == keys.GetFingerprint(&a.signer.PublicKey) {
+		return ErrActiveSigningKey
+	}
+	k, err := a.ks.GetPrivKey(kid)
+	if err != nil {
+		return err
+	}
+	if k.Project != retiredSigningKeyProject {
+		return keystore.ErrKeyNotFound
+	}
+	if err = a.ks.DeletePrivKey(kid); err != nil {
+		return err
+	}
+	return a.reloadSigningKeys()
+}
+
+// JWKS returns the public keys of all signing keys in the ring
+func (a *Authenticator) JWKS() *JWKSet {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	set := &JWKSet{Keys: []JWK{}}
+	for kid, pub := range a.verifiers {
+		set.Keys = append(set.Keys, JWK{
+			KeyType:   "RSA",
+			Use:       "sig",
+			Algorithm: "RS512",
+			KeyID:     kid,
+			Modulus:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			Exponent:  base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		})
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].KeyID < set.Keys[j].KeyID })
+	return set
+}
+
+// activeSigner returns the active signing key and its id
+func (a *Authenticator) activeSigner() (*rsa.PrivateKey, string) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	return a.active, a.activeKID
+}
+
+// verifier returns the public key for a key id, reloading the
+// key ring (at most once per interval) if the id is unknown
+func (a *Authenticator) verifier(kid string) (*rsa.PublicKey, error) {
+	a.mutex.RLock()
+	// tokens issued before key ids were checked are
+	// verified with the configured key, as they were
+	if kid == "" {
+		defer a.mutex.RUnlock()
+		return &a.signer.PublicKey, nil
+	}
+	pub, ok := a.verifiers[kid]
+	stale := a.ks != nil && time.Since(a.loadedAt) > signingKeysReloadInterval
+	a.mutex.RUnlock()
+	if ok {
+		return pub, nil
+	}
+	if !stale {
+		return nil, fmt.Errorf("unknown signing key %s", kid)
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if err := a.reloadSigningKeys(); err != nil {
+		log.Printf("[error] could not reload signing keys: %s", err)
+	}
+	if pub, ok = a.verifiers[kid]; !ok {
+		return nil, fmt.Errorf("unknown signing key %s", kid)
+	}
+	return pub, nil
+}
+
+// reloadSigningKeys must be called with the mutex held for writing
+func (a *Authenticator) reloadSigningKeys() error {
+	configuredKID := keys.GetFingerprint(&a.signer.PublicKey)
+	verifiers := map[string]*rsa.PublicKey{configuredKID: &a.signer.PublicKey}
+	active, activeKID := a.signer, configuredKID
+
+	if a.ks != nil {
+		a.loadedAt = time.Now()
+		retired, err := a.ks.ListPrivKeys(retiredSigningKeyProject)
+		if err != nil {
+			return fmt.Errorf("could not list retired signing keys: %s", err)
+		}
+		current, err := a.ks.ListPrivKeys(activeSigningKeyProject)
+		if err != nil {
+			return fmt.Errorf("could not list active signing keys: %s", err)
+		}
+		if len(current) > 1 {
+			log.Printf("[warn] found %d active jwt signing keys, using %s", len(current), current[0].ID)
+		}
+		for i, k := range append(current, retired...) {
+			priv, err := k.PrivRSA()
+			if err != nil {
+				return fmt.Errorf("could not decode signing key %s: %s", k.ID, err)
+			}
+			verifiers[k.ID] = &priv.PublicKey
+			if i == 0 && len(current) > 0 {
+				active, activeKID = priv, k.ID
+			}
+		}
+	}
+
+	a.verifiers = verifiers
+	a.active, a.activeKID = active, activeKID
+	return nil
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/adrianosela/padl/api/store"
+	"github.com/adrianosela/padl/api/token"
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrInvalidRefreshToken is returned when a refresh token
+	// is unknown, expired, or has been revoked
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+
+	// ErrRefreshTokenReused is returned when a refresh token which
+	// had already been rotated is presented again. The whole token
+	// family is revoked, as either the legitimate client or an
+	// attacker is holding a stolen token
+	ErrRefreshTokenReused = errors.New("refresh token reuse detected, please log in again")
+)
+
+// GenerateRefreshToken issues a refresh token for a user,
+// starting a new token family
+func (a *Authenticator) GenerateRefreshToken(sub string) (string, error) {
+	return a.issueRefreshToken(sub, uuid.New().String())
+}
+
+// RotateRefreshToken consumes a refresh token and returns the subject
+// it was issued to along with a new refresh token in the same family
+func (a *Authenticator) RotateRefreshToken(raw string) (string, string, error) {
+	id := token.HashRefreshToken(raw)
+	rt, err := a.db.GetRefreshToken(id)
+	if err != nil {
+		if err == store.ErrRefreshTokenNotFound {
+			return "", "", ErrInvalidRefreshToken
+		}
+		return "", "", fmt.Errorf("could not get refresh token: %s", err)
+	}
+	if rt.Expired() {
+		return "", "", ErrInvalidRefreshToken
+	}
+	if err = a.db.UseRefreshToken(id); err != nil {
+		switch err {
+		case store.ErrRefreshTokenNotFound:
+			return "", "", ErrInvalidRefreshToken
+		case store.ErrRefreshTokenUsed:
+			if err = a.db.DeleteRefreshTokenFamily(rt.Family); err != nil {
+				return "", "", fmt.Errorf("could not revoke refresh token family: %s", err)
+			}
+			return "", "", ErrRefreshTokenReused
+		default:
+			return "", "", fmt.Errorf("could not use refresh token: %s", err)
+		}
+	}
+	newRaw, err := a.issueRefreshToken(rt.Subject, rt.Family)
+	if err != nil {
+		return "", "", err
+	}
+	return rt.Subject, newRaw, nil
+}
+
+// RevokeRefreshTokenFamily revokes a refresh token
+// along with every token in its family
+func (a *Authenticator) RevokeRefreshTokenFamily(raw string) error {
+	rt, err := a.db.GetRefreshToken(token.HashRefreshToken(raw))
+	if err != nil {
+		if err == store.ErrRefreshTokenNotFound {
+			return ErrInvalidRefreshToken
+		}
+		return fmt.Errorf("could not get refresh token: %s", err)
+	}
+	if err = a.db.DeleteRefreshTokenFamily(rt.Family); err != nil {
+		return fmt.Errorf("could not revoke refresh token family: %s", err)
+	}
+	return nil
+}
+
+func (a *Authenticator) issueRefreshToken(sub, family string) (string, error) {
+	raw, rt, err := token.NewRefreshToken(sub, family, a.lifetimes.Refresh)
+	if err != nil {
+		return "", err
+	}
+	if err = a.db.PutRefreshToken(rt); err != nil {
+		return "", fmt.Errorf("could not store refresh token: %s", err)
+	}
+	return raw, nil
+}
@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/adrianosela/padl/api/store"
+	"github.com/adrianosela/padl/api/token"
+)
+
+// RevokeJWT adds a token to the revocation list. The token is
+// listed until its expiry. Revoking a revoked token is a no-op
+func (a *Authenticator) RevokeJWT(cc *CustomClaims) error {
+	if err := a.revoke(cc); err != nil && err != store.ErrTokenRevoked {
+		return fmt.Errorf("could not revoke token: %s", err)
+	}
+	return nil
+}
+
+// revoke adds a token to the revocation list, failing with
+// store.ErrTokenRevoked if it had already been revoked
+func (a *Authenticator) revoke(cc *CustomClaims) error {
+	if cc.Id == "" {
+		return fmt.Errorf("token has no id")
+	}
+	return a.db.RevokeToken(&token.RevokedToken{
+		ID:        cc.Id,
+		Subject:   cc.Subject,
+		ExpiresAt: time.Unix(cc.ExpiresAt, 0),
+	})
+}
+
+// IsRevoked returns true if a token is in the revocation list
+func (a *Authenticator) IsRevoked(cc *CustomClaims) (bool, error) {
+	return a.db.IsTokenRevoked(cc.Id)
+}
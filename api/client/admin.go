@@ -0,0 +1,123 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/adrianosela/padl/api/auth"
+	"github.com/adrianosela/padl/api/payloads"
+)
+
+// GetJWKS gets the public keys which verify tokens issued by the server
+func (p *Padl) GetJWKS() (*auth.JWKSet, error) {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/.well-known/jwks.json", p.HostURL), nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not build http request: %s", err)
+	}
+	resp, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not send http request: %s", err)
+	}
+	respByt, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("could not read http response body: %s", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp, respByt)
+	}
+	var set auth.JWKSet
+	if err := json.Unmarshal(respByt, &set); err != nil {
+		return nil, fmt.Errorf("could not unmarshal http response body: %s", err)
+	}
+	return &set, nil
+}
+
+// RotateSigningKey replaces the server's active jwt signing key (admin only)
+func (p *Padl) RotateSigningKey(bits int) (*payloads.RotateSigningKeyResponse, error) {
+	plBytes, err := json.Marshal(&payloads.RotateSigningKeyRequest{KeyBits: bits})
+	if err != nil {
+		return nil, fmt.Errorf("could not marshall payload: %s", err)
+	}
+	req, err := http.NewRequest(http.MethodPost,
+		fmt.Sprintf("%s/admin/signing-keys/rotate", p.HostURL),
+		bytes.NewBuffer(plBytes))
+	if err != nil {
+		return nil, fmt.Errorf("could not build http request: %s", err)
+	}
+	resp, err := p.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not send http request: %s", err)
+	}
+	respByt, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("could not read http response body: %s", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp, respByt)
+	}
+	var rotateResp payloads.RotateSigningKeyResponse
+	if err := json.Unmarshal(respByt, &rotateResp); err != nil {
+		return nil, fmt.Errorf("could not unmarshal http response body: %s", err)
+	}
+	return &rotateResp, nil
+}
+
+// DeleteSigningKey deletes a retired jwt signing key (admin only). Tokens
+// signed with the key are no longer valid once it is deleted
+func (p *Padl) DeleteSigningKey(kid string) error {
+	req, err := http.NewRequest(http.MethodDelete,
+		fmt.Sprintf("%s/admin/signing-keys/%s", p.HostURL, kid), nil)
+	if err != nil {
+		return fmt.Errorf("could not build http request: %s", err)
+	}
+	resp, err := p.do(req)
+	if err != nil {
+		return fmt.Errorf("could not send http request: %s", err)
+	}
+	respByt, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("could not read http response body: %s", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp, respByt)
+	}
+	return nil
+}
+
+// Fsck checks the consistency of the server's records, and repairs
+// the problems found if repair is set (admin only)
+func (p *Padl) Fsck(repair bool) (*payloads.FsckResponse, error) {
+	plBytes, err := json.Marshal(&payloads.FsckRequest{Repair: repair})
+	if err != nil {
+		return nil, fmt.Errorf("could not marshall payload: %s", err)
+	}
+	req, err := http.NewRequest(http.MethodPost,
+		fmt.Sprintf("%s/admin/fsck", p.HostURL),
+		bytes.NewBuffer(plBytes))
+	if err != nil {
+		return nil, fmt.Errorf("could not build http request: %s", err)
+	}
+	resp, err := p.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not send http request: %s", err)
+	}
+	respByt, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("could not read http response body: %s", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp, respByt)
+	}
+	var fsckResp payloads.FsckResponse
+	if err := json.Unmarshal(respByt, &fsckResp); err != nil {
+		return nil, fmt.Errorf("could not unmarshal http response body: %s", err)
+	}
+	return &fsckResp, nil
+}
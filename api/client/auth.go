@@ -3,6 +3,7 @@ package client
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -42,51 +43,208 @@ func (p *Padl) Register(email, password, pubKey string) error {
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("error: %s", string(respByt))
+		return responseError(resp, respByt)
 	}
 
 	return nil
 }
 
 // Login logs an existing user into a padl server
-func (p *Padl) Login(email, password string) (string, error) {
+func (p *Padl) Login(email, password string) (*payloads.LoginResponse, error) {
 	pl := &payloads.LoginRequest{
 		Email:    email,
 		Password: password,
 	}
 	plBytes, err := json.Marshal(&pl)
 	if err != nil {
-		return "", fmt.Errorf("could not marshall payload: %s", err)
+		return nil, fmt.Errorf("could not marshall payload: %s", err)
 	}
 
 	req, err := http.NewRequest(http.MethodPost,
 		fmt.Sprintf("%s/login", p.HostURL),
 		bytes.NewBuffer(plBytes))
 	if err != nil {
-		return "", fmt.Errorf("could not build http request: %s", err)
+		return nil, fmt.Errorf("could not build http request: %s", err)
 	}
 
 	resp, err := p.HTTPClient.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("could not send http request: %s", err)
+		return nil, fmt.Errorf("could not send http request: %s", err)
 	}
 
 	respByt, err := ioutil.ReadAll(resp.Body)
 	defer resp.Body.Close()
 	if err != nil {
-		return "", fmt.Errorf("could not read http response body: %s", err)
+		return nil, fmt.Errorf("could not read http response body: %s", err)
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("error: %s", string(respByt))
+		return nil, responseError(resp, respByt)
 	}
 
 	var lr payloads.LoginResponse
 	if err := json.Unmarshal(respByt, &lr); err != nil {
-		return "", fmt.Errorf("could not unmarshal http response body: %s", err)
+		return nil, fmt.Errorf("could not unmarshal http response body: %s", err)
+	}
+
+	return &lr, nil
+}
+
+// Refresh exchanges the client's refresh token for a new access token and
+// refresh token, which replace the client's own
+func (p *Padl) Refresh() error {
+	p.authMutex.Lock()
+	defer p.authMutex.Unlock()
+	return p.refresh()
+}
+
+func (p *Padl) refresh() error {
+	if p.RefreshToken == "" {
+		return errors.New("no refresh token")
+	}
+	plBytes, err := json.Marshal(&payloads.RefreshTokenRequest{RefreshToken: p.RefreshToken})
+	if err != nil {
+		return fmt.Errorf("could not marshall payload: %s", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost,
+		fmt.Sprintf("%s/token/refresh", p.HostURL),
+		bytes.NewBuffer(plBytes))
+	if err != nil {
+		return fmt.Errorf("could not build http request: %s", err)
+	}
+
+	resp, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not send http request: %s", err)
+	}
+
+	respByt, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("could not read http response body: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp, respByt)
+	}
+
+	var lr payloads.LoginResponse
+	if err := json.Unmarshal(respByt, &lr); err != nil {
+		return fmt.Errorf("could not unmarshal http response body: %s", err)
+	}
+
+	p.setTokens(lr.Token, lr.RefreshToken)
+	if p.OnRefresh != nil {
+		p.OnRefresh(lr.Token, lr.RefreshToken)
+	}
+	return nil
+}
+
+func (p *Padl) refreshToken() string {
+	p.authMutex.Lock()
+	defer p.authMutex.Unlock()
+	return p.RefreshToken
+}
+
+// Logout revokes the client's access token and, if set, its refresh token
+func (p *Padl) Logout() error {
+	plBytes, err := json.Marshal(&payloads.LogoutRequest{RefreshToken: p.refreshToken()})
+	if err != nil {
+		return fmt.Errorf("could not marshall payload: %s", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost,
+		fmt.Sprintf("%s/logout", p.HostURL),
+		bytes.NewBuffer(plBytes))
+	if err != nil {
+		return fmt.Errorf("could not build http request: %s", err)
+	}
+
+	resp, err := p.do(req)
+	if err != nil {
+		return fmt.Errorf("could not send http request: %s", err)
+	}
+
+	respByt, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("could not read http response body: %s", err)
 	}
 
-	return lr.Token, nil
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp, respByt)
+	}
+
+	p.clearTokens()
+	return nil
+}
+
+// DeleteAccount deletes the current user's account, after confirming
+// their password. Fails if the user is the only owner of any project
+func (p *Padl) DeleteAccount(password string) error {
+	plBytes, err := json.Marshal(&payloads.DeleteAccountRequest{
+		Password:     password,
+		RefreshToken: p.refreshToken(),
+	})
+	if err != nil {
+		return fmt.Errorf("could not marshall payload: %s", err)
+	}
+	req, err := http.NewRequest(http.MethodDelete,
+		fmt.Sprintf("%s/account", p.HostURL),
+		bytes.NewBuffer(plBytes))
+	if err != nil {
+		return fmt.Errorf("could not build http request: %s", err)
+	}
+	resp, err := p.do(req)
+	if err != nil {
+		return fmt.Errorf("could not send http request: %s", err)
+	}
+
+	respByt, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("could not read http response body: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp, respByt)
+	}
+
+	p.clearTokens()
+	return nil
+}
+
+// RevokeToken revokes an access token, e.g. a leaked service account token
+func (p *Padl) RevokeToken(token string) error {
+	plBytes, err := json.Marshal(&payloads.RevokeTokenRequest{Token: token})
+	if err != nil {
+		return fmt.Errorf("could not marshall payload: %s", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost,
+		fmt.Sprintf("%s/token/revoke", p.HostURL),
+		bytes.NewBuffer(plBytes))
+	if err != nil {
+		return fmt.Errorf("could not build http request: %s", err)
+	}
+
+	resp, err := p.do(req)
+	if err != nil {
+		return fmt.Errorf("could not send http request: %s", err)
+	}
+
+	respByt, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("could not read http response body: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp, respByt)
+	}
+
+	return nil
 }
 
 // RotateUserKey rotates the key for a given user
@@ -98,11 +256,10 @@ func (p *Padl) RotateUserKey(pubPEM string) error {
 	req, err := http.NewRequest(http.MethodPost,
 		fmt.Sprintf("%s/rotate", p.HostURL),
 		bytes.NewBuffer(plBytes))
-	p.setAuth(req)
 	if err != nil {
 		return fmt.Errorf("could not build http request: %s", err)
 	}
-	resp, err := p.HTTPClient.Do(req)
+	resp, err := p.do(req)
 	if err != nil {
 		return fmt.Errorf("could not send http request: %s", err)
 	}
@@ -112,7 +269,7 @@ func (p *Padl) RotateUserKey(pubPEM string) error {
 		return fmt.Errorf("could not read http response body: %s", err)
 	}
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("error: %s", string(respByt))
+		return responseError(resp, respByt)
 	}
 	return nil
 }
@@ -124,8 +281,7 @@ func (p *Padl) Valid() (*auth.CustomClaims, error) {
 	if err != nil {
 		return nil, fmt.Errorf("could not build http request: %s", err)
 	}
-	p.setAuth(req)
-	resp, err := p.HTTPClient.Do(req)
+	resp, err := p.do(req)
 	if err != nil {
 		return nil, fmt.Errorf("could not send http request: %s", err)
 	}
@@ -135,7 +291,7 @@ func (p *Padl) Valid() (*auth.CustomClaims, error) {
 		return nil, fmt.Errorf("could not read http response body: %s", err)
 	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("error: %s", string(respByt))
+		return nil, responseError(resp, respByt)
 	}
 	var cc auth.CustomClaims
 	if err := json.Unmarshal(respByt, &cc); err != nil {
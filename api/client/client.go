@@ -4,13 +4,34 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"sync"
 )
 
-// Padl represents a padl API client
+// Padl represents a padl API client. Requests may be sent concurrently
 type Padl struct {
 	HostURL    string
 	AuthToken  string
 	HTTPClient *http.Client
+
+	// RefreshToken, if set, is used to obtain a new AuthToken
+	// when a request is rejected with a 401 Unauthorized
+	RefreshToken string
+	// OnRefresh, if set, is called with the new tokens after
+	// every refresh, e.g. to persist them. Refresh tokens are
+	// single use so the new refresh token must be kept
+	OnRefresh func(authToken, refreshToken string)
+
+	// ServiceAccount, if set, is used to obtain short-lived service
+	// account tokens by proving possession of the account's key,
+	// whenever the client has no token or its token is rejected
+	ServiceAccount *ServiceAccount
+
+	// authMutex guards the tokens, which requests sent concurrently share.
+	// authGen counts the tokens obtained, such that when concurrent requests
+	// are all rejected only the first one reauthenticates (refresh tokens
+	// are single use, reusing one revokes it and all its successors)
+	authMutex sync.Mutex
+	authGen   int
 }
 
 // NewPadlClient is the constructor for the Client object
@@ -28,7 +49,94 @@ func NewPadlClient(hostURL, token string, httpClient *http.Client) (*Padl, error
 	}, nil
 }
 
-// setAuth sets the client's token in the authorization header of an http request
-func (p *Padl) setAuth(r *http.Request) {
-	r.Header.Set("Authorization", fmt.Sprintf("Bearer %s", p.AuthToken))
+// setAuth sets a token in the authorization header of an http request
+func setAuth(r *http.Request, token string) {
+	r.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+}
+
+// token returns the client's token and its generation, logging
+// in with the service account first if the client has no token
+func (p *Padl) token() (string, int, error) {
+	p.authMutex.Lock()
+	defer p.authMutex.Unlock()
+	if p.AuthToken == "" && p.ServiceAccount != nil {
+		if err := p.serviceAccountLogin(); err != nil {
+			return "", 0, err
+		}
+	}
+	return p.AuthToken, p.authGen, nil
+}
+
+// do sends an authenticated http request. If the request is rejected
+// with a 401 and the client can obtain a new token (with a refresh token
+// or service account credentials) the request is retried once
+func (p *Padl) do(r *http.Request) (*http.Response, error) {
+	token, gen, err := p.token()
+	if err != nil {
+		return nil, err
+	}
+	setAuth(r, token)
+	resp, err := p.HTTPClient.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusUnauthorized || !p.canReauthenticate() {
+		return resp, nil
+	}
+	// the request body has been consumed, it can
+	// only be retried if it can be read again
+	retry := r.Clone(r.Context())
+	if r.Body != nil && r.Body != http.NoBody {
+		if r.GetBody == nil {
+			return resp, nil
+		}
+		if retry.Body, err = r.GetBody(); err != nil {
+			return resp, nil
+		}
+	}
+	if token, err = p.reauthenticate(gen); err != nil {
+		// surface the original 401 rather than the refresh failure
+		return resp, nil
+	}
+	resp.Body.Close()
+	setAuth(retry, token)
+	return p.HTTPClient.Do(retry)
+}
+
+func (p *Padl) canReauthenticate() bool {
+	p.authMutex.Lock()
+	defer p.authMutex.Unlock()
+	return p.RefreshToken != "" || p.ServiceAccount != nil
+}
+
+// reauthenticate obtains a new token in place of the token of the given
+// generation, unless another request already has, and returns the new token
+func (p *Padl) reauthenticate(gen int) (string, error) {
+	p.authMutex.Lock()
+	defer p.authMutex.Unlock()
+	if p.authGen != gen {
+		return p.AuthToken, nil
+	}
+	var err error
+	if p.RefreshToken != "" {
+		err = p.refresh()
+	} else {
+		err = p.serviceAccountLogin()
+	}
+	return p.AuthToken, err
+}
+
+// setTokens replaces the client's tokens, the
+// caller must be holding the client's authMutex
+func (p *Padl) setTokens(authToken, refreshToken string) {
+	p.AuthToken = authToken
+	p.RefreshToken = refreshToken
+	p.authGen++
+}
+
+// clearTokens removes the client's tokens e.g. once they are revoked
+func (p *Padl) clearTokens() {
+	p.authMutex.Lock()
+	defer p.authMutex.Unlock()
+	p.setTokens("", "")
 }
@@ -0,0 +1,129 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/adrianosela/padl/api/payloads"
+	"github.com/adrianosela/padl/lib/keys"
+	"github.com/stretchr/testify/assert"
+)
+
+// tokenServer is a fake padl server which only accepts its latest token,
+// and which (like padl) treats the reuse of a refresh token as theft
+type tokenServer struct {
+	mutex     sync.Mutex
+	gen       int
+	refreshes int
+	logins    int
+	reused    bool
+}
+
+func (s *tokenServer) tokens() (string, string) {
+	return fmt.Sprintf("token-%d", s.gen), fmt.Sprintf("refresh-%d", s.gen)
+}
+
+func (s *tokenServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	switch r.URL.Path {
+	case "/token/refresh":
+		var req payloads.RefreshTokenRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if _, refresh := s.tokens(); req.RefreshToken != refresh {
+			s.reused = true
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		s.refreshes++
+		s.gen++
+		token, refresh := s.tokens()
+		json.NewEncoder(w).Encode(&payloads.LoginResponse{Token: token, RefreshToken: refresh})
+	case "/service-account/challenge":
+		json.NewEncoder(w).Encode(&payloads.ServiceAccountChallengeResponse{Challenge: "challenge"})
+	case "/service-account/token":
+		s.logins++
+		s.gen++
+		token, _ := s.tokens()
+		json.NewEncoder(w).Encode(&payloads.ServiceAccountTokenResponse{Token: token})
+	default:
+		if token, _ := s.tokens(); r.Header.Get("Authorization") != "Bearer "+token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("{}"))
+	}
+}
+
+// validConcurrently sends n requests at once, as decryption batches are
+func validConcurrently(p *Padl, n int) []error {
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = p.Valid()
+		}(i)
+	}
+	wg.Wait()
+	return errs
+}
+
+func TestConcurrentRefresh(t *testing.T) {
+	ts := &tokenServer{gen: 1}
+	srv := httptest.NewServer(ts)
+	defer srv.Close()
+
+	// the client's token has expired
+	p, err := NewPadlClient(srv.URL, "token-0", nil)
+	assert.Nil(t, err)
+	p.RefreshToken = "refresh-1"
+	saved := []string{}
+	p.OnRefresh = func(authToken, refreshToken string) {
+		saved = append(saved, refreshToken)
+	}
+
+	for _, err := range validConcurrently(p, 8) {
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, 1, ts.refreshes, "concurrent requests should refresh the token once")
+	assert.False(t, ts.reused, "the refresh token should not be reused")
+	assert.Equal(t, []string{"refresh-2"}, saved)
+	assert.Equal(t, "token-2", p.AuthToken)
+}
+
+func TestConcurrentServiceAccountLogin(t *testing.T) {
+	ts := &tokenServer{}
+	srv := httptest.NewServer(ts)
+	defer srv.Close()
+
+	priv, _, err := keys.GenerateRSAKeyPair(2048)
+	assert.Nil(t, err)
+	p, err := NewPadlClient(srv.URL, "", nil)
+	assert.Nil(t, err)
+	p.ServiceAccount = &ServiceAccount{
+		Project:    "project",
+		Name:       "ci",
+		PrivateKey: string(keys.EncodePrivKeyPEM(priv)),
+	}
+
+	// no token at first
+	for _, err := range validConcurrently(p, 8) {
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, 1, ts.logins, "concurrent requests should log in once")
+
+	// an expired token
+	ts.mutex.Lock()
+	ts.gen++
+	ts.mutex.Unlock()
+	for _, err := range validConcurrently(p, 8) {
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, 2, ts.logins, "concurrent requests should log in again once")
+}
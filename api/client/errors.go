@@ -0,0 +1,78 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/adrianosela/padl/api/payloads"
+)
+
+// The kinds of errors the padl server responds with. Errors returned
+// by the client for error responses can be checked against these with
+// errors.Is, e.g. errors.Is(err, client.ErrNotFound)
+var (
+	ErrBadRequest     = errors.New("bad request")
+	ErrInvalidRequest = errors.New("invalid request")
+	ErrUnauthorized   = errors.New("unauthorized")
+	ErrForbidden      = errors.New("forbidden")
+	ErrNotFound       = errors.New("not found")
+	ErrConflict       = errors.New("conflict")
+	ErrGone           = errors.New("gone")
+	ErrInternal       = errors.New("internal server error")
+)
+
+var errorsByCode = map[string]error{
+	payloads.ErrorCodeBadRequest:     ErrBadRequest,
+	payloads.ErrorCodeInvalidRequest: ErrInvalidRequest,
+	payloads.ErrorCodeUnauthorized:   ErrUnauthorized,
+	payloads.ErrorCodeForbidden:      ErrForbidden,
+	payloads.ErrorCodeNotFound:       ErrNotFound,
+	payloads.ErrorCodeConflict:       ErrConflict,
+	payloads.ErrorCodeGone:           ErrGone,
+	payloads.ErrorCodeInternal:       ErrInternal,
+}
+
+// Error is an error response of the padl server
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+	RequestID  string
+}
+
+func (e *Error) Error() string {
+	// server side errors are logged with the request id
+	if e.Code == payloads.ErrorCodeInternal && e.RequestID != "" {
+		return fmt.Sprintf("error: %s (request id %s)", e.Message, e.RequestID)
+	}
+	return fmt.Sprintf("error: %s", e.Message)
+}
+
+// Is reports whether the error is of the given kind, e.g. ErrNotFound
+func (e *Error) Is(target error) bool {
+	kind, ok := errorsByCode[e.Code]
+	return ok && kind == target
+}
+
+// responseError returns the error for an error response, as per its JSON
+// error envelope. Servers which predate the envelope respond in plain
+// text, in which case the error code is that of the status code
+func responseError(resp *http.Response, body []byte) *Error {
+	var env payloads.ErrorResponse
+	if err := json.Unmarshal(body, &env); err != nil || env.Code == "" {
+		return &Error{
+			StatusCode: resp.StatusCode,
+			Code:       payloads.ErrorCode(resp.StatusCode),
+			Message:    string(body),
+			RequestID:  resp.Header.Get(payloads.RequestIDHeader),
+		}
+	}
+	return &Error{
+		StatusCode: resp.StatusCode,
+		Code:       env.Code,
+		Message:    env.Message,
+		RequestID:  env.RequestID,
+	}
+}
@@ -0,0 +1,134 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/adrianosela/padl/api/invitation"
+	"github.com/adrianosela/padl/api/payloads"
+)
+
+// InviteUser invites a user to join a project with the given privilege
+// level. The invitation expires after the given number of hours, or the
+// server default if zero
+func (p *Padl) InviteUser(projectName, email string, privilegeLvl, expiresHours int) (*invitation.Invitation, error) {
+	pl := &payloads.InviteUserRequest{
+		Email:        email,
+		PrivilegeLvl: privilegeLvl,
+		ExpiresHours: expiresHours,
+	}
+	plBytes, err := json.Marshal(&pl)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshall payload: %s", err)
+	}
+	req, err := http.NewRequest(
+		http.MethodPost,
+		fmt.Sprintf("%s/project/%s/invitations", p.HostURL, projectName),
+		bytes.NewBuffer(plBytes))
+	if err != nil {
+		return nil, fmt.Errorf("could not build http request: %s", err)
+	}
+	resp, err := p.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not send http request: %s", err)
+	}
+
+	respByt, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("could not read http response body: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp, respByt)
+	}
+
+	var inv invitation.Invitation
+	if err := json.Unmarshal(respByt, &inv); err != nil {
+		return nil, fmt.Errorf("could not unmarshal http response body: %s", err)
+	}
+
+	return &inv, nil
+}
+
+// ListProjectInvitations lists the pending invitations to a project
+func (p *Padl) ListProjectInvitations(projectName string) ([]*invitation.Invitation, error) {
+	return p.listInvitations(fmt.Sprintf("%s/project/%s/invitations", p.HostURL, projectName))
+}
+
+// ListInvitations lists the current user's pending invitations
+func (p *Padl) ListInvitations() ([]*invitation.Invitation, error) {
+	return p.listInvitations(fmt.Sprintf("%s/account/invitations", p.HostURL))
+}
+
+func (p *Padl) listInvitations(url string) ([]*invitation.Invitation, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not build http request: %s", err)
+	}
+	resp, err := p.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not send http request: %s", err)
+	}
+
+	respByt, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("could not read http response body: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp, respByt)
+	}
+
+	var listResp payloads.ListInvitationsResponse
+	if err := json.Unmarshal(respByt, &listResp); err != nil {
+		return nil, fmt.Errorf("could not unmarshal http response body: %s", err)
+	}
+
+	return listResp.Invitations, nil
+}
+
+// RevokeInvitation revokes a pending invitation to a project
+func (p *Padl) RevokeInvitation(projectName, id string) error {
+	return p.sendInvitationRequest(http.MethodDelete,
+		fmt.Sprintf("%s/project/%s/invitations/%s", p.HostURL, projectName, id))
+}
+
+// AcceptInvitation accepts one of the current user's pending
+// invitations, joining the project it is for
+func (p *Padl) AcceptInvitation(id string) error {
+	return p.sendInvitationRequest(http.MethodPost,
+		fmt.Sprintf("%s/account/invitations/%s/accept", p.HostURL, id))
+}
+
+// DeclineInvitation declines one of the current user's pending invitations
+func (p *Padl) DeclineInvitation(id string) error {
+	return p.sendInvitationRequest(http.MethodPost,
+		fmt.Sprintf("%s/account/invitations/%s/decline", p.HostURL, id))
+}
+
+func (p *Padl) sendInvitationRequest(method, url string) error {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return fmt.Errorf("could not build http request: %s", err)
+	}
+	resp, err := p.do(req)
+	if err != nil {
+		return fmt.Errorf("could not send http request: %s", err)
+	}
+
+	respByt, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("could not read http response body: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp, respByt)
+	}
+	return nil
+}
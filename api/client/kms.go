@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -28,7 +29,7 @@ func (p *Padl) GetPublicKey(kid string) (*kms.PublicKey, error) {
 		return nil, fmt.Errorf("could not read http response body: %s", err)
 	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("error: %s", string(respByt))
+		return nil, responseError(resp, respByt)
 	}
 	var pub kms.PublicKey
 	if err := json.Unmarshal(respByt, &pub); err != nil {
@@ -37,9 +38,10 @@ func (p *Padl) GetPublicKey(kid string) (*kms.PublicKey, error) {
 	return &pub, nil
 }
 
-// DecryptSecret decrypts and returns a secret in plaintext
-func (p *Padl) DecryptSecret(secret, kid string) (string, error) {
-	plBytes, err := json.Marshal(&payloads.DecryptSecretRequest{Secret: secret})
+// DecryptSecret decrypts and returns a secret in plaintext. The version
+// is the wire format version of the encrypted secret (shard)
+func (p *Padl) DecryptSecret(secret, kid string, version int) (string, error) {
+	plBytes, err := json.Marshal(&payloads.DecryptSecretRequest{Secret: secret, Version: version})
 	if err != nil {
 		return "", fmt.Errorf("could not marshall payload: %s", err)
 	}
@@ -50,8 +52,7 @@ func (p *Padl) DecryptSecret(secret, kid string) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("could not build http request: %s", err)
 	}
-	p.setAuth(req)
-	resp, err := p.HTTPClient.Do(req)
+	resp, err := p.do(req)
 	if err != nil {
 		return "", fmt.Errorf("could not send http request: %s", err)
 	}
@@ -61,7 +62,7 @@ func (p *Padl) DecryptSecret(secret, kid string) (string, error) {
 		return "", fmt.Errorf("could not read http response body: %s", err)
 	}
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("error: %s", string(respByt))
+		return "", responseError(resp, respByt)
 	}
 	var res payloads.DecryptSecretResponse
 	if err := json.Unmarshal(respByt, &res); err != nil {
@@ -73,3 +74,55 @@ func (p *Padl) DecryptSecret(secret, kid string) (string, error) {
 	}
 	return string(decoded), nil
 }
+
+// DecryptSecrets decrypts a batch of secrets (shards) encrypted with the
+// same key in a single request. A secret which could not be decrypted
+// does not fail the others: the plaintexts and errors are per secret,
+// in the order of the given secrets
+func (p *Padl) DecryptSecrets(kid string, secrets []*payloads.DecryptSecretRequest) ([]string, []error, error) {
+	plBytes, err := json.Marshal(&payloads.DecryptSecretsRequest{Secrets: secrets})
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not marshall payload: %s", err)
+	}
+	req, err := http.NewRequest(
+		http.MethodPost,
+		fmt.Sprintf("%s/key/%s/decrypt/batch", p.HostURL, kid),
+		bytes.NewBuffer(plBytes))
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not build http request: %s", err)
+	}
+	resp, err := p.do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not send http request: %s", err)
+	}
+	respByt, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read http response body: %s", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, responseError(resp, respByt)
+	}
+	var res payloads.DecryptSecretsResponse
+	if err := json.Unmarshal(respByt, &res); err != nil {
+		return nil, nil, fmt.Errorf("could not unmarshal http response body: %s", err)
+	}
+	if len(res.Results) != len(secrets) {
+		return nil, nil, fmt.Errorf("got %d results for %d secrets", len(res.Results), len(secrets))
+	}
+	plains := make([]string, len(secrets))
+	errs := make([]error, len(secrets))
+	for i, r := range res.Results {
+		if r.Error != "" {
+			errs[i] = errors.New(r.Error)
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(r.Message)
+		if err != nil {
+			errs[i] = fmt.Errorf("could not decode decrypted message: %s", err)
+			continue
+		}
+		plains[i] = string(decoded)
+	}
+	return plains, errs, nil
+}
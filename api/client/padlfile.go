@@ -0,0 +1,131 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/adrianosela/padl/api/payloads"
+	"github.com/adrianosela/padl/lib/padlfile"
+)
+
+// ErrPadlfileConflict is returned by PushPadlfile when the server's
+// latest padlfile revision is not the one the pushed padlfile is based on
+var ErrPadlfileConflict = errors.New("padlfile changed on the server")
+
+// PushPadlfile stores the given padlfile as the next revision of its
+// project's padlfile on the server. The push only succeeds if the
+// padlfile's revision is the server's latest revision
+func (p *Padl) PushPadlfile(f *padlfile.File) (*payloads.PadlfileRevisionResponse, error) {
+	pl := &payloads.PushPadlfileRequest{
+		BaseRevision: f.Revision,
+		File:         f,
+	}
+	plBytes, err := json.Marshal(&pl)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshall payload: %s", err)
+	}
+	req, err := http.NewRequest(
+		http.MethodPut,
+		fmt.Sprintf("%s/project/%s/padlfile", p.HostURL, f.Data.Project),
+		bytes.NewBuffer(plBytes))
+	if err != nil {
+		return nil, fmt.Errorf("could not build http request: %s", err)
+	}
+	resp, err := p.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not send http request: %s", err)
+	}
+
+	respByt, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("could not read http response body: %s", err)
+	}
+
+	if resp.StatusCode == http.StatusConflict {
+		return nil, fmt.Errorf("%w: %s", ErrPadlfileConflict, responseError(resp, respByt).Message)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp, respByt)
+	}
+
+	var rev payloads.PadlfileRevisionResponse
+	if err := json.Unmarshal(respByt, &rev); err != nil {
+		return nil, fmt.Errorf("could not unmarshal http response body: %s", err)
+	}
+
+	return &rev, nil
+}
+
+// FetchPadlfile gets a revision of a project's padlfile
+// from the server, revision 0 being the latest revision
+func (p *Padl) FetchPadlfile(projectName string, revision uint64) (*payloads.PadlfileRevisionResponse, error) {
+	url := fmt.Sprintf("%s/project/%s/padlfile", p.HostURL, projectName)
+	if revision != 0 {
+		url = fmt.Sprintf("%s?revision=%d", url, revision)
+	}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not build http request: %s", err)
+	}
+	resp, err := p.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not send http request: %s", err)
+	}
+
+	respByt, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("could not read http response body: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp, respByt)
+	}
+
+	var rev payloads.PadlfileRevisionResponse
+	if err := json.Unmarshal(respByt, &rev); err != nil {
+		return nil, fmt.Errorf("could not unmarshal http response body: %s", err)
+	}
+	if rev.File == nil {
+		return nil, errors.New("no padlfile in http response body")
+	}
+
+	return &rev, nil
+}
+
+// PadlfileHistory lists the revisions of a project's padlfile, in order
+func (p *Padl) PadlfileHistory(projectName string) ([]*payloads.PadlfileRevisionResponse, error) {
+	req, err := http.NewRequest(
+		http.MethodGet,
+		fmt.Sprintf("%s/project/%s/padlfile/history", p.HostURL, projectName),
+		nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not build http request: %s", err)
+	}
+	resp, err := p.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not send http request: %s", err)
+	}
+
+	respByt, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("could not read http response body: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp, respByt)
+	}
+
+	var history payloads.PadlfileHistoryResponse
+	if err := json.Unmarshal(respByt, &history); err != nil {
+		return nil, fmt.Errorf("could not unmarshal http response body: %s", err)
+	}
+
+	return history.Revisions, nil
+}
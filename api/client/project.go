@@ -6,19 +6,24 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"time"
 
 	"github.com/adrianosela/padl/api/project"
 
 	"github.com/adrianosela/padl/api/payloads"
+	"github.com/adrianosela/padl/lib/audit"
 	"github.com/adrianosela/padl/lib/padlfile"
 )
 
-// CreateProject creates a new project and returns a signed padlfile
-func (p *Padl) CreateProject(name, description string, bits int) (*padlfile.File, error) {
+// CreateProject creates a new project and returns a signed padlfile.
+// An empty policy selects the default threshold policy
+func (p *Padl) CreateProject(name, description string, bits int, policy string) (*padlfile.File, error) {
 	pl := &payloads.NewProjectRequest{
 		Name:        name,
 		Description: description,
 		KeyBits:     bits,
+		Policy:      policy,
 	}
 	plBytes, err := json.Marshal(&pl)
 	if err != nil {
@@ -30,9 +35,7 @@ func (p *Padl) CreateProject(name, description string, bits int) (*padlfile.File
 	if err != nil {
 		return nil, fmt.Errorf("could not build http requests: %s", err)
 	}
-	p.setAuth(req)
-
-	resp, err := p.HTTPClient.Do(req)
+	resp, err := p.do(req)
 	if err != nil {
 		return nil, fmt.Errorf("could not send http request: %s", err)
 	}
@@ -44,7 +47,7 @@ func (p *Padl) CreateProject(name, description string, bits int) (*padlfile.File
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("error: %s", string(respByt))
+		return nil, responseError(resp, respByt)
 	}
 
 	var pf padlfile.File
@@ -72,9 +75,7 @@ func (p *Padl) CreateServiceAccount(projectName, accountName, pubKeyPEM string)
 	if err != nil {
 		return nil, fmt.Errorf("could not build http requests: %s", err)
 	}
-	p.setAuth(req)
-
-	resp, err := p.HTTPClient.Do(req)
+	resp, err := p.do(req)
 	if err != nil {
 		return nil, fmt.Errorf("could not send http request: %s", err)
 	}
@@ -86,7 +87,7 @@ func (p *Padl) CreateServiceAccount(projectName, accountName, pubKeyPEM string)
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("error: %s", string(respByt))
+		return nil, responseError(resp, respByt)
 	}
 
 	var createKeyResp payloads.CreateServiceAccountResponse
@@ -112,9 +113,7 @@ func (p *Padl) RemoveServiceAccount(projectName string, keyName string) error {
 	if err != nil {
 		return fmt.Errorf("could not build http requests: %s", err)
 	}
-	p.setAuth(req)
-
-	resp, err := p.HTTPClient.Do(req)
+	resp, err := p.do(req)
 	if err != nil {
 		return fmt.Errorf("could not send http request: %s", err)
 	}
@@ -126,7 +125,7 @@ func (p *Padl) RemoveServiceAccount(projectName string, keyName string) error {
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("error: %s", string(respByt))
+		return responseError(resp, respByt)
 	}
 
 	return nil
@@ -138,8 +137,7 @@ func (p *Padl) GetProject(name string) (*project.Project, error) {
 	if err != nil {
 		return nil, fmt.Errorf("could not build http request: %s", err)
 	}
-	p.setAuth(req)
-	resp, err := p.HTTPClient.Do(req)
+	resp, err := p.do(req)
 	if err != nil {
 		return nil, fmt.Errorf("could not send http request: %s", err)
 	}
@@ -149,7 +147,7 @@ func (p *Padl) GetProject(name string) (*project.Project, error) {
 		return nil, fmt.Errorf("could not read http response body: %s", err)
 	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("error: %s", string(respByt))
+		return nil, responseError(resp, respByt)
 	}
 	var project project.Project
 	if err := json.Unmarshal(respByt, &project); err != nil {
@@ -165,8 +163,7 @@ func (p *Padl) GetProjectKeys(name string) (*payloads.GetProjectKeysReponse, err
 	if err != nil {
 		return nil, fmt.Errorf("could not build http request: %s", err)
 	}
-	p.setAuth(req)
-	resp, err := p.HTTPClient.Do(req)
+	resp, err := p.do(req)
 	if err != nil {
 		return nil, fmt.Errorf("could not send http request: %s", err)
 	}
@@ -176,7 +173,7 @@ func (p *Padl) GetProjectKeys(name string) (*payloads.GetProjectKeysReponse, err
 		return nil, fmt.Errorf("could not read http response body: %s", err)
 	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("error: %s", string(respByt))
+		return nil, responseError(resp, respByt)
 	}
 	var keysResp payloads.GetProjectKeysReponse
 	if err := json.Unmarshal(respByt, &keysResp); err != nil {
@@ -191,9 +188,7 @@ func (p *Padl) ListProjects() (*payloads.ListProjectsResponse, error) {
 	if err != nil {
 		return nil, fmt.Errorf("could not build http requests: %s", err)
 	}
-	p.setAuth(req)
-
-	resp, err := p.HTTPClient.Do(req)
+	resp, err := p.do(req)
 	if err != nil {
 		return nil, fmt.Errorf("could not send http request: %s", err)
 	}
@@ -204,7 +199,7 @@ func (p *Padl) ListProjects() (*payloads.ListProjectsResponse, error) {
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("error: %s", string(respByt))
+		return nil, responseError(resp, respByt)
 	}
 
 	var listProjResp payloads.ListProjectsResponse
@@ -215,9 +210,10 @@ func (p *Padl) ListProjects() (*payloads.ListProjectsResponse, error) {
 	return &listProjResp, nil
 }
 
-// AddUserToProject adds another user to a project with given privilege permission
-func (p *Padl) AddUserToProject(projectName string, email string, privilegeLvl int) error {
-	pl := &payloads.AddUserToProjectRequest{
+// SetUserPrivilege changes the privilege level of a project member. Fails
+// if it would raise anyone above the current user's level, or demote the last owner
+func (p *Padl) SetUserPrivilege(projectName string, email string, privilegeLvl int) error {
+	pl := &payloads.SetUserPrivilegeRequest{
 		Email:        email,
 		PrivilegeLvl: privilegeLvl,
 	}
@@ -226,15 +222,13 @@ func (p *Padl) AddUserToProject(projectName string, email string, privilegeLvl i
 		return fmt.Errorf("could not marshall payload: %s", err)
 	}
 	req, err := http.NewRequest(
-		http.MethodPost,
+		http.MethodPatch,
 		fmt.Sprintf("%s/project/%s/user", p.HostURL, projectName),
 		bytes.NewBuffer(plBytes))
 	if err != nil {
 		return fmt.Errorf("could not build http requests: %s", err)
 	}
-	p.setAuth(req)
-
-	resp, err := p.HTTPClient.Do(req)
+	resp, err := p.do(req)
 	if err != nil {
 		return fmt.Errorf("could not send http request: %s", err)
 	}
@@ -246,13 +240,13 @@ func (p *Padl) AddUserToProject(projectName string, email string, privilegeLvl i
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("error: %s", string(respByt))
+		return responseError(resp, respByt)
 	}
 	return nil
 }
 
 // RemoveUserFromProject removes another user from the project
-// fails if the current user does not have owner privilege or if an owner tries to remove themselves
+// fails if the current user does not have owner privilege or if it would remove the last owner
 func (p *Padl) RemoveUserFromProject(projectName string, email string) error {
 	pl := &payloads.RemoveUserFromProjectRequest{
 		Email: email,
@@ -270,9 +264,7 @@ func (p *Padl) RemoveUserFromProject(projectName string, email string) error {
 		return fmt.Errorf("could not build http requests: %s", err)
 	}
 
-	p.setAuth(req)
-
-	resp, err := p.HTTPClient.Do(req)
+	resp, err := p.do(req)
 	if err != nil {
 		return fmt.Errorf("could not send http request: %s", err)
 	}
@@ -284,7 +276,7 @@ func (p *Padl) RemoveUserFromProject(projectName string, email string) error {
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("error: %s", string(respByt))
+		return responseError(resp, respByt)
 	}
 	return nil
 }
@@ -296,19 +288,238 @@ func (p *Padl) DeleteProject(projectName string) error {
 	if err != nil {
 		return fmt.Errorf("could not build http requests: %s", err)
 	}
-	p.setAuth(req)
+	resp, err := p.do(req)
+	if err != nil {
+		return fmt.Errorf("could not send http request: %s", err)
+	}
+	respByt, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("could not read http response body: %s", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp, respByt)
+	}
+	return nil
+}
+
+// RotateProjectKey replaces a project's shared key with a new one. The
+// previous key remains usable for decryption for the given grace period
+// (in hours, zero for the server default), during which padlfiles must be
+// re-encrypted under the new key
+func (p *Padl) RotateProjectKey(projectName string, bits, graceHours int) (*payloads.RotateProjectKeyResponse, error) {
+	plBytes, err := json.Marshal(&payloads.RotateProjectKeyRequest{
+		KeyBits:    bits,
+		GraceHours: graceHours,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not marshall payload: %s", err)
+	}
+	req, err := http.NewRequest(http.MethodPost,
+		fmt.Sprintf("%s/project/%s/rotate-key", p.HostURL, projectName),
+		bytes.NewBuffer(plBytes))
+	if err != nil {
+		return nil, fmt.Errorf("could not build http requests: %s", err)
+	}
+	resp, err := p.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not send http request: %s", err)
+	}
+
+	respByt, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("could not read http response body: %s", err)
+	}
 
-	resp, err := p.HTTPClient.Do(req)
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp, respByt)
+	}
+
+	var rotateResp payloads.RotateProjectKeyResponse
+	if err := json.Unmarshal(respByt, &rotateResp); err != nil {
+		return nil, fmt.Errorf("could not unmarshal http response body: %s", err)
+	}
+
+	return &rotateResp, nil
+}
+
+// SetProjectPolicy sets the threshold policy for a project's secrets. Secrets
+// in padlfiles are only split under a new policy once they are re-encrypted
+func (p *Padl) SetProjectPolicy(projectName, policy string) error {
+	plBytes, err := json.Marshal(&payloads.SetProjectPolicyRequest{Policy: policy})
+	if err != nil {
+		return fmt.Errorf("could not marshall payload: %s", err)
+	}
+	req, err := http.NewRequest(
+		http.MethodPut,
+		fmt.Sprintf("%s/project/%s/policy", p.HostURL, projectName),
+		bytes.NewBuffer(plBytes))
+	if err != nil {
+		return fmt.Errorf("could not build http requests: %s", err)
+	}
+	resp, err := p.do(req)
 	if err != nil {
 		return fmt.Errorf("could not send http request: %s", err)
 	}
+
 	respByt, err := ioutil.ReadAll(resp.Body)
 	defer resp.Body.Close()
 	if err != nil {
 		return fmt.Errorf("could not read http response body: %s", err)
 	}
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp, respByt)
+	}
+	return nil
+}
+
+// GetAuditLog gets the events in a project's audit log which match
+// the given filter (nil for all), in order. Only owners can do this
+func (p *Padl) GetAuditLog(projectName string, f *audit.Filter) ([]*audit.Event, error) {
+	q := url.Values{}
+	if f != nil {
+		if !f.Since.IsZero() {
+			q.Set("since", f.Since.Format(time.RFC3339Nano))
+		}
+		if !f.Until.IsZero() {
+			q.Set("until", f.Until.Format(time.RFC3339Nano))
+		}
+		if f.Actor != "" {
+			q.Set("actor", f.Actor)
+		}
+	}
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/project/%s/audit?%s", p.HostURL, projectName, q.Encode()), nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not build http request: %s", err)
+	}
+	resp, err := p.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not send http request: %s", err)
+	}
+	respByt, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("could not read http response body: %s", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp, respByt)
+	}
+	var logResp payloads.ListAuditEventsResponse
+	if err := json.Unmarshal(respByt, &logResp); err != nil {
+		return nil, fmt.Errorf("could not unmarshal http response body: %s", err)
+	}
+	return logResp.Events, nil
+}
+
+// VerifyAuditLog has the server verify a project's whole audit log
+// against its head. Only owners can do this
+func (p *Padl) VerifyAuditLog(projectName string) (*payloads.VerifyAuditLogResponse, error) {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/project/%s/audit/verify", p.HostURL, projectName), nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not build http request: %s", err)
+	}
+	resp, err := p.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not send http request: %s", err)
+	}
+	respByt, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("could not read http response body: %s", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp, respByt)
+	}
+	var verifyResp payloads.VerifyAuditLogResponse
+	if err := json.Unmarshal(respByt, &verifyResp); err != nil {
+		return nil, fmt.Errorf("could not unmarshal http response body: %s", err)
+	}
+	return &verifyResp, nil
+}
+
+// NominateOwner nominates a user as owner of a project. The nomination
+// takes effect once the nominee accepts it. Only owners can do this
+func (p *Padl) NominateOwner(projectName, email string) error {
+	plBytes, err := json.Marshal(&payloads.TransferOwnershipRequest{Email: email})
+	if err != nil {
+		return fmt.Errorf("could not marshall payload: %s", err)
+	}
+	req, err := http.NewRequest(
+		http.MethodPost,
+		fmt.Sprintf("%s/project/%s/transfer", p.HostURL, projectName),
+		bytes.NewBuffer(plBytes))
+	if err != nil {
+		return fmt.Errorf("could not build http request: %s", err)
+	}
+	resp, err := p.do(req)
+	if err != nil {
+		return fmt.Errorf("could not send http request: %s", err)
+	}
+
+	respByt, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("could not read http response body: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp, respByt)
+	}
+	return nil
+}
+
+// AcceptOwnership accepts a pending nomination of the current
+// user as owner of a project. The nominating owner becomes an editor
+func (p *Padl) AcceptOwnership(projectName string) error {
+	req, err := http.NewRequest(
+		http.MethodPost,
+		fmt.Sprintf("%s/project/%s/transfer/accept", p.HostURL, projectName),
+		nil)
+	if err != nil {
+		return fmt.Errorf("could not build http request: %s", err)
+	}
+	resp, err := p.do(req)
+	if err != nil {
+		return fmt.Errorf("could not send http request: %s", err)
+	}
+
+	respByt, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("could not read http response body: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp, respByt)
+	}
+	return nil
+}
+
+// CancelOwnershipTransfer cancels (or, for the nominee,
+// declines) a pending ownership transfer of a project
+func (p *Padl) CancelOwnershipTransfer(projectName string) error {
+	req, err := http.NewRequest(
+		http.MethodDelete,
+		fmt.Sprintf("%s/project/%s/transfer", p.HostURL, projectName),
+		nil)
+	if err != nil {
+		return fmt.Errorf("could not build http request: %s", err)
+	}
+	resp, err := p.do(req)
+	if err != nil {
+		return fmt.Errorf("could not send http request: %s", err)
+	}
+
+	respByt, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("could not read http response body: %s", err)
+	}
+
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("error: %s", string(respByt))
+		return responseError(resp, respByt)
 	}
 	return nil
 }
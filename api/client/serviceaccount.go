@@ -0,0 +1,103 @@
+package client
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/adrianosela/padl/api/payloads"
+	"github.com/adrianosela/padl/lib/keys"
+)
+
+// ServiceAccount holds the credentials of a padl service account
+type ServiceAccount struct {
+	Project    string `json:"project"`
+	Name       string `json:"name"`
+	PrivateKey string `json:"private_key"` // PEM encoded RSA private key
+}
+
+// ServiceAccountLogin obtains a short-lived service account token by
+// signing a server challenge with the service account's private key.
+// The token replaces the client's own
+func (p *Padl) ServiceAccountLogin() error {
+	p.authMutex.Lock()
+	defer p.authMutex.Unlock()
+	return p.serviceAccountLogin()
+}
+
+func (p *Padl) serviceAccountLogin() error {
+	if p.ServiceAccount == nil {
+		return errors.New("no service account credentials")
+	}
+	priv, err := keys.DecodePrivKeyPEM([]byte(p.ServiceAccount.PrivateKey))
+	if err != nil {
+		return fmt.Errorf("could not decode service account private key: %s", err)
+	}
+
+	var challengeResp payloads.ServiceAccountChallengeResponse
+	err = p.postJSON("/service-account/challenge", &payloads.ServiceAccountChallengeRequest{
+		Project: p.ServiceAccount.Project,
+		Name:    p.ServiceAccount.Name,
+	}, &challengeResp)
+	if err != nil {
+		return fmt.Errorf("could not get challenge: %s", err)
+	}
+
+	sig, err := keys.SignMessage([]byte(challengeResp.Challenge), priv)
+	if err != nil {
+		return fmt.Errorf("could not sign challenge: %s", err)
+	}
+
+	var tokenResp payloads.ServiceAccountTokenResponse
+	err = p.postJSON("/service-account/token", &payloads.ServiceAccountTokenRequest{
+		Challenge: challengeResp.Challenge,
+		Signature: base64.StdEncoding.EncodeToString(sig),
+	}, &tokenResp)
+	if err != nil {
+		return fmt.Errorf("could not get service account token: %s", err)
+	}
+
+	p.setTokens(tokenResp.Token, p.RefreshToken)
+	return nil
+}
+
+// postJSON sends an unauthenticated json request, and
+// unmarshals the json response onto out
+func (p *Padl) postJSON(path string, in, out interface{}) error {
+	plBytes, err := json.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("could not marshall payload: %s", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost,
+		fmt.Sprintf("%s%s", p.HostURL, path),
+		bytes.NewBuffer(plBytes))
+	if err != nil {
+		return fmt.Errorf("could not build http request: %s", err)
+	}
+
+	resp, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not send http request: %s", err)
+	}
+
+	respByt, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("could not read http response body: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp, respByt)
+	}
+
+	if err := json.Unmarshal(respByt, out); err != nil {
+		return fmt.Errorf("could not unmarshal http response body: %s", err)
+	}
+
+	return nil
+}
@@ -1,15 +1,43 @@
 package config
 
 import (
+	"encoding/base64"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"time"
 
+	"github.com/adrianosela/padl/lib/keys"
 	"gopkg.in/yaml.v2"
 )
 
+const (
+	// DriverMongoDB selects the MongoDB store and keystore
+	DriverMongoDB = "mongodb"
+	// DriverPostgres selects the PostgreSQL store and keystore
+	DriverPostgres = "postgres"
+	// DriverSQLite selects the SQLite store and keystore
+	DriverSQLite = "sqlite"
+	// DriverBolt selects the embedded single-file store and keystore
+	DriverBolt = "bolt"
+
+	embeddedDefaultPort      = ":8080"
+	embeddedSigningKeyBits   = 4096
+	embeddedSigningKeySuffix = ".signing-key.pem"
+	embeddedMasterKeySuffix  = ".master-key.yaml"
+
+	defaultRefreshTokensCollectionName = "refreshTokens"
+	defaultRevokedTokensCollectionName = "revokedTokens"
+	defaultInvitationsCollectionName   = "invitations"
+	defaultRevisionsCollectionName     = "padlfileRevisions"
+	defaultAuditEventsCollectionName   = "auditEvents"
+	defaultAuditHeadsCollectionName    = "auditHeads"
+
+	// minTokenLifetime is the shortest configurable token lifetime
+	minTokenLifetime = time.Minute
+)
+
 // Config holds the service configuration
 // necessary for endpoints to respond to requests
 type Config struct {
@@ -19,6 +47,12 @@ type Config struct {
 	Port       string `yaml:"port"`
 	Debug      bool   `yaml:"debug"`
 
+	// Store selects the backend for both the database and the keystore
+	Store struct {
+		Driver string `yaml:"driver"` // one of { "mongodb", "postgres", "sqlite", "bolt" }
+		DSN    string `yaml:"dsn"`    // data source name (or data file path for bolt)
+	} `yaml:"store"`
+
 	MongoDB struct {
 		Name             string `yaml:"name"`
 		ConnectionString string `yaml:"connectionString"`
@@ -27,13 +61,88 @@ type Config struct {
 		ProjectsCollectionName string `yaml:"projectsCollectionName"`
 		PrivKeysCollectionName string `yaml:"privKeysCollectionName"`
 		PubKeysCollectionName  string `yaml:"pubKeysCollectionName"`
+
+		RefreshTokensCollectionName string `yaml:"refreshTokensCollectionName"`
+		RevokedTokensCollectionName string `yaml:"revokedTokensCollectionName"`
+		InvitationsCollectionName   string `yaml:"invitationsCollectionName"`
+		RevisionsCollectionName     string `yaml:"revisionsCollectionName"`
+		AuditEventsCollectionName   string `yaml:"auditEventsCollectionName"`
+		AuditHeadsCollectionName    string `yaml:"auditHeadsCollectionName"`
 	} `yaml:"mongodb"`
 
+	// Keystore configures sealing of project private keys at rest.
+	// If no master keys are set private keys are stored in plaintext
+	Keystore struct {
+		MasterKeyFile   string         `yaml:"masterKeyFile"`   // yaml file of version -> base64 key, merged onto MasterKeys
+		MasterKeys      map[int]string `yaml:"masterKeys"`      // version -> base64 encoded 32 byte key
+		ActiveMasterKey int            `yaml:"activeMasterKey"` // defaults to the highest version
+		RewrapOnStart   bool           `yaml:"rewrapOnStart"`   // re-seal keys not sealed with the active version
+	} `yaml:"keystore"`
+
 	Auth struct {
 		SigningKey string `yaml:"signingKey"`
+
+		// Admins are the emails of the users allowed to perform
+		// server administration operations e.g. signing key rotation
+		Admins []string `yaml:"admins"`
+
+		// token lifetimes as duration strings e.g. "15m", "720h".
+		// Empty values select the authenticator's defaults
+		AccessTokenLifetime         string `yaml:"accessTokenLifetime"`
+		RefreshTokenLifetime        string `yaml:"refreshTokenLifetime"`
+		ServiceAccountTokenLifetime string `yaml:"serviceAccountTokenLifetime"`
 	} `yaml:"auth"`
 }
 
+// TokenLifetimes returns the configured access, refresh, and service account
+// token lifetimes. Zero is returned for lifetimes which are not configured
+func (c *Config) TokenLifetimes() (time.Duration, time.Duration, time.Duration, error) {
+	access, err := parseTokenLifetime(c.Auth.AccessTokenLifetime)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid access token lifetime: %s", err)
+	}
+	refresh, err := parseTokenLifetime(c.Auth.RefreshTokenLifetime)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid refresh token lifetime: %s", err)
+	}
+	svc, err := parseTokenLifetime(c.Auth.ServiceAccountTokenLifetime)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid service account token lifetime: %s", err)
+	}
+	return access, refresh, svc, nil
+}
+
+func parseTokenLifetime(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d < minTokenLifetime {
+		return 0, fmt.Errorf("must be at least %s", minTokenLifetime)
+	}
+	return d, nil
+}
+
+// MasterKeys returns the decoded master keys by version, and the active version
+func (c *Config) MasterKeys() (map[int][]byte, int, error) {
+	mks := make(map[int][]byte)
+	active := c.Keystore.ActiveMasterKey
+	for v, enc := range c.Keystore.MasterKeys {
+		mk, err := base64.StdEncoding.DecodeString(enc)
+		if err != nil {
+			return nil, 0, fmt.Errorf("could not decode master key version %d: %s", v, err)
+		}
+		mks[v] = mk
+		if c.Keystore.ActiveMasterKey == 0 && v > active {
+			active = v
+		}
+	}
+	return mks, active, nil
+}
+
 // BuildConfig returns a populated config struct from a yaml file
 func BuildConfig(filePath, version string) *Config {
 	config := configFromYaml(filePath)
@@ -45,12 +154,144 @@ func BuildConfig(filePath, version string) *Config {
 		config.Port = fmt.Sprintf(":%s", port)
 	}
 
+	// the store driver is optional for backwards
+	// compatibility with mongodb-only deployments
+	if config.Store.Driver == "" {
+		config.Store.Driver = DriverMongoDB
+	}
+
+	// collections added after the initial release are
+	// optional for backwards compatibility with existing configs
+	if config.MongoDB.RefreshTokensCollectionName == "" {
+		config.MongoDB.RefreshTokensCollectionName = defaultRefreshTokensCollectionName
+	}
+	if config.MongoDB.RevokedTokensCollectionName == "" {
+		config.MongoDB.RevokedTokensCollectionName = defaultRevokedTokensCollectionName
+	}
+	if config.MongoDB.InvitationsCollectionName == "" {
+		config.MongoDB.InvitationsCollectionName = defaultInvitationsCollectionName
+	}
+	if config.MongoDB.RevisionsCollectionName == "" {
+		config.MongoDB.RevisionsCollectionName = defaultRevisionsCollectionName
+	}
+	if config.MongoDB.AuditEventsCollectionName == "" {
+		config.MongoDB.AuditEventsCollectionName = defaultAuditEventsCollectionName
+	}
+	if config.MongoDB.AuditHeadsCollectionName == "" {
+		config.MongoDB.AuditHeadsCollectionName = defaultAuditHeadsCollectionName
+	}
+
+	if config.Keystore.MasterKeyFile != "" {
+		mks, err := readMasterKeyFile(config.Keystore.MasterKeyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		config.mergeMasterKeys(mks)
+	}
+
 	config.DeployTime = time.Now()
 	config.Version = version
 
 	return config
 }
 
+// BuildEmbeddedConfig returns a config for running the API in single-binary
+// mode, with all state kept in the bolt data file at the given path.
+// The JWT signing key is kept in a file alongside the data file, and is
+// generated on first run
+func BuildEmbeddedConfig(dataPath, version string) *Config {
+	config := &Config{
+		Env:  "embedded",
+		Port: embeddedDefaultPort,
+	}
+	if port := os.Getenv("PORT"); port != "" {
+		config.Port = fmt.Sprintf(":%s", port)
+	}
+
+	config.Store.Driver = DriverBolt
+	config.Store.DSN = dataPath
+
+	signingKey, err := loadOrCreateSigningKey(dataPath + embeddedSigningKeySuffix)
+	if err != nil {
+		log.Fatal(err)
+	}
+	config.Auth.SigningKey = signingKey
+
+	config.Keystore.MasterKeyFile = dataPath + embeddedMasterKeySuffix
+	mks, err := loadOrCreateMasterKeyFile(config.Keystore.MasterKeyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	config.mergeMasterKeys(mks)
+	config.Keystore.RewrapOnStart = true
+
+	config.DeployTime = time.Now()
+	config.Version = version
+
+	return config
+}
+
+func loadOrCreateSigningKey(path string) (string, error) {
+	if pem, err := ioutil.ReadFile(path); err == nil {
+		return string(pem), nil
+	} else if !os.IsNotExist(err) {
+		return "", fmt.Errorf("could not read signing key %s: %s", path, err)
+	}
+	priv, _, err := keys.GenerateRSAKeyPair(embeddedSigningKeyBits)
+	if err != nil {
+		return "", fmt.Errorf("could not generate signing key: %s", err)
+	}
+	pem := keys.EncodePrivKeyPEM(priv)
+	if err = ioutil.WriteFile(path, pem, 0600); err != nil {
+		return "", fmt.Errorf("could not write signing key %s: %s", path, err)
+	}
+	log.Printf("[info] generated new jwt signing key at %s", path)
+	return string(pem), nil
+}
+
+func (c *Config) mergeMasterKeys(mks map[int]string) {
+	if c.Keystore.MasterKeys == nil {
+		c.Keystore.MasterKeys = make(map[int]string)
+	}
+	for v, mk := range mks {
+		c.Keystore.MasterKeys[v] = mk
+	}
+}
+
+func readMasterKeyFile(path string) (map[int]string, error) {
+	byt, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read master key file %s: %s", path, err)
+	}
+	mks := make(map[int]string)
+	if err = yaml.Unmarshal(byt, &mks); err != nil {
+		return nil, fmt.Errorf("could not parse master key file %s: %s", path, err)
+	}
+	return mks, nil
+}
+
+func loadOrCreateMasterKeyFile(path string) (map[int]string, error) {
+	if _, err := os.Stat(path); err == nil {
+		return readMasterKeyFile(path)
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("could not read master key file %s: %s", path, err)
+	}
+	mk, err := keys.GenerateAESKey()
+	if err != nil {
+		return nil, fmt.Errorf("could not generate master key: %s", err)
+	}
+	mks := map[int]string{1: base64.StdEncoding.EncodeToString(mk)}
+	byt, err := yaml.Marshal(mks)
+	if err != nil {
+		return nil, err
+	}
+	if err = ioutil.WriteFile(path, byt, 0600); err != nil {
+		return nil, fmt.Errorf("could not write master key file %s: %s", path, err)
+	}
+	log.Printf("[info] generated new keystore master key at %s", path)
+	return mks, nil
+}
+
 func configFromYaml(filePath string) *Config {
 	config := &Config{}
 
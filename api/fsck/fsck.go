@@ -0,0 +1,331 @@
+package fsck
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/adrianosela/padl/api/keystore"
+	"github.com/adrianosela/padl/api/project"
+	"github.com/adrianosela/padl/api/store"
+	"github.com/adrianosela/padl/api/user"
+)
+
+const (
+	// KindDanglingUserProject is a project in a user's list of projects
+	// which does not exist, or which the user is not a member of
+	KindDanglingUserProject = "dangling-user-project"
+
+	// KindMissingUserProject is a project member whose
+	// list of projects does not include the project
+	KindMissingUserProject = "missing-user-project"
+
+	// KindMissingMemberAccount is a project member without an account
+	KindMissingMemberAccount = "missing-member-account"
+
+	// KindMissingProjectKey is a key of a project which is not in the
+	// keystore. It can not be repaired, as the key can not be recreated
+	KindMissingProjectKey = "missing-project-key"
+
+	// KindOrphanProjectKey is a project key pair in the
+	// keystore whose project does not exist
+	KindOrphanProjectKey = "orphan-project-key"
+
+	// KindUnreferencedProjectKey is a project key pair in the keystore which
+	// is not a key of its (existing) project. It is not repaired, as it may
+	// be the new key of a project whose key is being rotated
+	KindUnreferencedProjectKey = "unreferenced-project-key"
+
+	// KindOrphanInvitation is an invitation to a project which does not exist
+	KindOrphanInvitation = "orphan-invitation"
+)
+
+// Problem is an inconsistency between the records of the database and
+// the keystore, e.g. left behind by a server which crashed midway
+type Problem struct {
+	Kind     string `json:"kind"`
+	Subject  string `json:"subject"` // the user, project, key or invitation
+	Detail   string `json:"detail"`
+	Repaired bool   `json:"repaired"`
+
+	// RepairError is why a repair was attempted but failed
+	RepairError string `json:"repair_error,omitempty"`
+}
+
+type checker struct {
+	db       store.Database
+	ks       keystore.Keystore
+	repair   bool
+	problems []*Problem
+
+	// the records as read at the start of the check,
+	// and their (sorted) names to check them in order
+	users    map[string]*user.User
+	projects map[string]*project.Project
+	emails   []string
+	names    []string
+}
+
+// Check runs a consistency check, repairing the problems found if
+// repair is set. Problems are only reported once confirmed with a fresh
+// read of the records involved, as records read earlier in the check
+// may have since been changed by requests served concurrently
+func Check(db store.Database, ks keystore.Keystore, repair bool) ([]*Problem, error) {
+	c := &checker{db: db, ks: ks, repair: repair, problems: []*Problem{}}
+
+	names, err := db.ListProjectNames()
+	if err != nil {
+		return nil, fmt.Errorf("could not list projects: %s", err)
+	}
+	prjs, err := db.ListProjects(names)
+	if err != nil {
+		return nil, fmt.Errorf("could not get projects: %s", err)
+	}
+	c.projects = make(map[string]*project.Project)
+	for _, p := range prjs {
+		c.projects[p.Name] = p
+		c.names = append(c.names, p.Name)
+	}
+	emails, err := db.ListUserEmails()
+	if err != nil {
+		return nil, fmt.Errorf("could not list users: %s", err)
+	}
+	c.users = make(map[string]*user.User)
+	for _, email := range emails {
+		u, err := db.GetUser(email)
+		if err != nil {
+			// deleted since it was listed
+			if err == store.ErrUserNotFound {
+				continue
+			}
+			return nil, fmt.Errorf("could not get user %s: %s", email, err)
+		}
+		c.users[email] = u
+		c.emails = append(c.emails, email)
+	}
+
+	if err = c.checkUsers(); err != nil {
+		return nil, err
+	}
+	if err = c.checkProjects(); err != nil {
+		return nil, err
+	}
+	if err = c.checkKeys(); err != nil {
+		return nil, err
+	}
+	return c.problems, nil
+}
+
+// checkUsers checks users' lists of projects and invitations
+func (c *checker) checkUsers() error {
+	for _, email := range c.emails {
+		u := c.users[email]
+		for _, name := range u.Projects {
+			if p, ok := c.projects[name]; ok && p.HasUser(u.Email) {
+				continue
+			}
+			dangling, err := c.isDangling(u.Email, name)
+			if err != nil {
+				return err
+			}
+			if !dangling {
+				continue
+			}
+			c.report(KindDanglingUserProject, email, fmt.Sprintf("lists project %s it is not a member of", name), func() error {
+				_, err := store.ModifyUser(c.db, email, func(u *user.User) error {
+					u.RemoveProject(name)
+					return nil
+				})
+				return err
+			})
+		}
+		invs, err := c.db.ListUserInvitations(u.Email)
+		if err != nil {
+			return fmt.Errorf("could not get invitations of user %s: %s", u.Email, err)
+		}
+		for _, inv := range invs {
+			if _, ok := c.projects[inv.Project]; ok {
+				continue
+			}
+			exists, err := c.db.ProjectExists(inv.Project)
+			if err != nil {
+				return fmt.Errorf("could not check if project %s exists: %s", inv.Project, err)
+			}
+			if exists {
+				continue
+			}
+			id := inv.ID
+			c.report(KindOrphanInvitation, id, fmt.Sprintf("invites %s to project %s which does not exist", inv.Email, inv.Project), func() error {
+				if err := c.db.DeleteInvitation(id); err != nil && err != store.ErrInvitationNotFound {
+					return err
+				}
+				return nil
+			})
+		}
+	}
+	return nil
+}
+
+// isDangling confirms that a user is not a member of a project
+func (c *checker) isDangling(email, name string) (bool, error) {
+	p, err := c.db.GetProject(name)
+	if err != nil {
+		if err == store.ErrProjectNotFound {
+			return true, nil
+		}
+		return false, fmt.Errorf("could not get project %s: %s", name, err)
+	}
+	return !p.HasUser(email), nil
+}
+
+// checkProjects checks projects' members and keys
+func (c *checker) checkProjects() error {
+	for _, name := range c.names {
+		p := c.projects[name]
+		for _, email := range sortedMembers(p) {
+			if u, ok := c.users[email]; ok && contains(u.Projects, p.Name) {
+				continue
+			}
+			u, err := c.db.GetUser(email)
+			if err != nil && err != store.ErrUserNotFound {
+				return fmt.Errorf("could not get user %s: %s", email, err)
+			}
+			if err == store.ErrUserNotFound {
+				c.report(KindMissingMemberAccount, name, fmt.Sprintf("has member %s without an account", email), func() error {
+					_, err := store.ModifyProject(c.db, name, func(p *project.Project) error {
+						return p.RemoveUser(email)
+					})
+					return err
+				})
+				continue
+			}
+			if contains(u.Projects, name) {
+				continue
+			}
+			// the member may since have left the project
+			left, err := c.isDangling(email, name)
+			if err != nil {
+				return err
+			}
+			if left {
+				continue
+			}
+			c.report(KindMissingUserProject, email, fmt.Sprintf("does not list project %s it is a member of", name), func() error {
+				_, err := store.ModifyUser(c.db, email, func(u *user.User) error {
+					u.AddProject(name)
+					return nil
+				})
+				return err
+			})
+		}
+		keyIDs := []string{p.ProjectKey}
+		for _, rk := range p.PreviousKeys {
+			keyIDs = append(keyIDs, rk.KeyID)
+		}
+		for _, keyID := range keyIDs {
+			if _, err := c.ks.GetPrivKey(keyID); err != nil {
+				if err != keystore.ErrKeyNotFound {
+					return fmt.Errorf("could not get key %s: %s", keyID, err)
+				}
+				c.report(KindMissingProjectKey, p.Name, fmt.Sprintf("key %s is not in the keystore", keyID), nil)
+			}
+		}
+	}
+	return nil
+}
+
+// checkKeys checks that every project key pair in the keystore is a key
+// of its project. Keys of projects with a "." are the server's own keys
+func (c *checker) checkKeys() error {
+	referenced := make(map[string]bool)
+	for _, p := range c.projects {
+		referenced[p.ProjectKey] = true
+		for _, rk := range p.PreviousKeys {
+			referenced[rk.KeyID] = true
+		}
+	}
+	ids, err := c.ks.ListPrivKeyIDs()
+	if err != nil {
+		return fmt.Errorf("could not list keys: %s", err)
+	}
+	for _, id := range ids {
+		if referenced[id] {
+			continue
+		}
+		k, err := c.ks.GetPrivKey(id)
+		if err != nil {
+			// deleted since it was listed
+			if err == keystore.ErrKeyNotFound {
+				continue
+			}
+			return fmt.Errorf("could not get key %s: %s", id, err)
+		}
+		if strings.Contains(k.Project, ".") {
+			continue
+		}
+		p, err := c.db.GetProject(k.Project)
+		if err != nil && err != store.ErrProjectNotFound {
+			return fmt.Errorf("could not get project %s: %s", k.Project, err)
+		}
+		if err == store.ErrProjectNotFound {
+			c.report(KindOrphanProjectKey, id, fmt.Sprintf("key of project %s which does not exist", k.Project), func() error {
+				if err := c.ks.DeletePrivKey(id); err != nil && err != keystore.ErrKeyNotFound {
+					return err
+				}
+				if err := c.ks.DeletePubKey(id); err != nil && err != keystore.ErrKeyNotFound {
+					return err
+				}
+				return nil
+			})
+			continue
+		}
+		if !isKeyOf(p, id) {
+			c.report(KindUnreferencedProjectKey, id, fmt.Sprintf("key of project %s which is not one of its keys", k.Project), nil)
+		}
+	}
+	return nil
+}
+
+// report records a problem, repairing it if repairs are enabled
+// and the problem can be repaired (i.e. repair is not nil)
+func (c *checker) report(kind, subject, detail string, repair func() error) {
+	pr := &Problem{Kind: kind, Subject: subject, Detail: detail}
+	if c.repair && repair != nil {
+		if err := repair(); err != nil {
+			pr.RepairError = err.Error()
+		} else {
+			pr.Repaired = true
+		}
+	}
+	c.problems = append(c.problems, pr)
+}
+
+func isKeyOf(p *project.Project, keyID string) bool {
+	if p.ProjectKey == keyID {
+		return true
+	}
+	for _, rk := range p.PreviousKeys {
+		if rk.KeyID == keyID {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedMembers(p *project.Project) []string {
+	emails := []string{}
+	for email := range p.Members {
+		emails = append(emails, email)
+	}
+	sort.Strings(emails)
+	return emails
+}
+
+func contains(set []string, s string) bool {
+	for _, e := range set {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
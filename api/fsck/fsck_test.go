@@ -0,0 +1,192 @@
+package fsck
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adrianosela/padl/api/invitation"
+	"github.com/adrianosela/padl/api/keystore"
+	"github.com/adrianosela/padl/api/kms"
+	"github.com/adrianosela/padl/api/privilege"
+	"github.com/adrianosela/padl/api/project"
+	"github.com/adrianosela/padl/api/store"
+	"github.com/adrianosela/padl/api/user"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	owner  = "owner@padl.io"
+	reader = "reader@padl.io"
+)
+
+// newConsistent returns a database and keystore without problems: a
+// project with two members and its key, and a server key (whose project
+// has a ".") which is not the key of any project
+func newConsistent(t *testing.T) (*store.MockDatabase, *keystore.MockKeystore) {
+	db := store.NewMockDatabase()
+	ks := keystore.NewMockKeystore()
+
+	p := project.NewProject("proj", "a project", owner, "proj-key")
+	assert.Nil(t, p.AddUser(reader, privilege.PrivilegeLvlReader))
+	assert.Nil(t, db.PutProject(p))
+	for _, email := range []string{owner, reader} {
+		assert.Nil(t, db.PutUser(&user.User{Email: email, Projects: []string{"proj"}}))
+	}
+	assert.Nil(t, ks.PutPrivKey(&kms.PrivateKey{ID: "proj-key", Project: "proj"}))
+	assert.Nil(t, ks.PutPubKey(&kms.PublicKey{ID: "proj-key"}))
+	assert.Nil(t, ks.PutPrivKey(&kms.PrivateKey{ID: "server-key", Project: "padl.signing"}))
+	return db, ks
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		testName   string
+		corrupt    func(*testing.T, *store.MockDatabase, *keystore.MockKeystore)
+		kind       string
+		subject    string
+		repairable bool
+		// repaired checks the state after a repair
+		repaired func(*testing.T, *store.MockDatabase, *keystore.MockKeystore)
+	}{
+		{
+			testName: "dangling user project",
+			corrupt: func(t *testing.T, db *store.MockDatabase, ks *keystore.MockKeystore) {
+				_, err := store.ModifyUser(db, reader, func(u *user.User) error {
+					u.AddProject("gone")
+					return nil
+				})
+				assert.Nil(t, err)
+			},
+			kind:       KindDanglingUserProject,
+			subject:    reader,
+			repairable: true,
+			repaired: func(t *testing.T, db *store.MockDatabase, ks *keystore.MockKeystore) {
+				u, err := db.GetUser(reader)
+				assert.Nil(t, err)
+				assert.Equal(t, []string{"proj"}, u.Projects)
+			},
+		},
+		{
+			testName: "missing user project",
+			corrupt: func(t *testing.T, db *store.MockDatabase, ks *keystore.MockKeystore) {
+				_, err := store.ModifyUser(db, reader, func(u *user.User) error {
+					u.RemoveProject("proj")
+					return nil
+				})
+				assert.Nil(t, err)
+			},
+			kind:       KindMissingUserProject,
+			subject:    reader,
+			repairable: true,
+			repaired: func(t *testing.T, db *store.MockDatabase, ks *keystore.MockKeystore) {
+				u, err := db.GetUser(reader)
+				assert.Nil(t, err)
+				assert.Equal(t, []string{"proj"}, u.Projects)
+			},
+		},
+		{
+			testName: "missing member account",
+			corrupt: func(t *testing.T, db *store.MockDatabase, ks *keystore.MockKeystore) {
+				assert.Nil(t, db.DeleteUser(reader))
+			},
+			kind:       KindMissingMemberAccount,
+			subject:    "proj",
+			repairable: true,
+			repaired: func(t *testing.T, db *store.MockDatabase, ks *keystore.MockKeystore) {
+				p, err := db.GetProject("proj")
+				assert.Nil(t, err)
+				assert.False(t, p.HasUser(reader))
+			},
+		},
+		{
+			testName: "missing project key",
+			corrupt: func(t *testing.T, db *store.MockDatabase, ks *keystore.MockKeystore) {
+				assert.Nil(t, ks.DeletePrivKey("proj-key"))
+			},
+			kind:    KindMissingProjectKey,
+			subject: "proj",
+		},
+		{
+			testName: "orphan project key",
+			corrupt: func(t *testing.T, db *store.MockDatabase, ks *keystore.MockKeystore) {
+				assert.Nil(t, ks.PutPrivKey(&kms.PrivateKey{ID: "orphan-key", Project: "gone"}))
+				assert.Nil(t, ks.PutPubKey(&kms.PublicKey{ID: "orphan-key"}))
+			},
+			kind:       KindOrphanProjectKey,
+			subject:    "orphan-key",
+			repairable: true,
+			repaired: func(t *testing.T, db *store.MockDatabase, ks *keystore.MockKeystore) {
+				_, err := ks.GetPrivKey("orphan-key")
+				assert.Equal(t, keystore.ErrKeyNotFound, err)
+				_, err = ks.GetPubKey("orphan-key")
+				assert.Equal(t, keystore.ErrKeyNotFound, err)
+			},
+		},
+		{
+			testName: "unreferenced project key",
+			corrupt: func(t *testing.T, db *store.MockDatabase, ks *keystore.MockKeystore) {
+				assert.Nil(t, ks.PutPrivKey(&kms.PrivateKey{ID: "next-key", Project: "proj"}))
+			},
+			kind:    KindUnreferencedProjectKey,
+			subject: "next-key",
+		},
+		{
+			testName: "orphan invitation",
+			corrupt: func(t *testing.T, db *store.MockDatabase, ks *keystore.MockKeystore) {
+				inv, err := invitation.NewInvitation("gone", reader, owner, privilege.PrivilegeLvlReader, time.Hour)
+				assert.Nil(t, err)
+				inv.ID = "orphan-invitation"
+				assert.Nil(t, db.PutInvitation(inv))
+			},
+			kind:       KindOrphanInvitation,
+			subject:    "orphan-invitation",
+			repairable: true,
+			repaired: func(t *testing.T, db *store.MockDatabase, ks *keystore.MockKeystore) {
+				_, err := db.GetInvitation("orphan-invitation")
+				assert.Equal(t, store.ErrInvitationNotFound, err)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			db, ks := newConsistent(t)
+			test.corrupt(t, db, ks)
+
+			// without repair, problems are only reported
+			problems, err := Check(db, ks, false)
+			assert.Nil(t, err)
+			if !assert.Len(t, problems, 1) {
+				return
+			}
+			assert.Equal(t, test.kind, problems[0].Kind)
+			assert.Equal(t, test.subject, problems[0].Subject)
+			assert.False(t, problems[0].Repaired)
+
+			problems, err = Check(db, ks, true)
+			assert.Nil(t, err)
+			if !assert.Len(t, problems, 1) {
+				return
+			}
+			assert.Equal(t, test.kind, problems[0].Kind)
+			assert.Equal(t, test.repairable, problems[0].Repaired)
+			assert.Empty(t, problems[0].RepairError)
+			if !test.repairable {
+				return
+			}
+			test.repaired(t, db, ks)
+
+			// nothing is left to repair
+			problems, err = Check(db, ks, false)
+			assert.Nil(t, err)
+			assert.Empty(t, problems)
+		})
+	}
+}
+
+func TestCheckConsistent(t *testing.T) {
+	db, ks := newConsistent(t)
+	problems, err := Check(db, ks, true)
+	assert.Nil(t, err)
+	assert.Empty(t, problems)
+}
@@ -0,0 +1,57 @@
+package invitation
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/adrianosela/padl/api/privilege"
+)
+
+const (
+	// DefaultLifetime is how long an invitation can be accepted
+	// for when no lifetime is given by the inviting owner
+	DefaultLifetime = time.Hour * 24 * 7
+
+	// MaxLifetime is the longest lifetime an invitation can have
+	MaxLifetime = time.Hour * 24 * 30
+
+	// idSize is the size in bytes of the random invitation ids
+	idSize = 16
+)
+
+// Invitation is a pending invitation for a user to join a project.
+// The user only becomes a member once they accept it
+type Invitation struct {
+	ID           string          `json:"id"`
+	Project      string          `json:"project"`
+	Email        string          `json:"email"` // the invitee
+	PrivilegeLvl privilege.Level `json:"privilege"`
+	InvitedBy    string          `json:"invited_by"`
+	CreatedAt    time.Time       `json:"created_at"`
+	ExpiresAt    time.Time       `json:"expires_at"`
+}
+
+// NewInvitation returns a new invitation with a random id
+func NewInvitation(project, email, invitedBy string, lvl privilege.Level, lifetime time.Duration) (*Invitation, error) {
+	byt := make([]byte, idSize)
+	if _, err := rand.Read(byt); err != nil {
+		return nil, fmt.Errorf("could not generate invitation id: %s", err)
+	}
+	now := time.Now()
+	return &Invitation{
+		ID:           hex.EncodeToString(byt),
+		Project:      project,
+		Email:        email,
+		PrivilegeLvl: lvl,
+		InvitedBy:    invitedBy,
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(lifetime),
+	}, nil
+}
+
+// Expired returns true if the invitation can no longer be accepted
+func (i *Invitation) Expired() bool {
+	return time.Now().After(i.ExpiresAt)
+}
@@ -0,0 +1,157 @@
+package keystore
+
+import (
+	"encoding/json"
+
+	"github.com/adrianosela/padl/api/kms"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	privKeysBucket = []byte("priv_keys")
+	pubKeysBucket  = []byte("pub_keys")
+)
+
+// BoltKeystore is an embedded, single-file implementation of
+// the Keystore interface. Every write runs in its own bolt
+// transaction, and is therefore atomic
+type BoltKeystore struct {
+	db *bolt.DB
+}
+
+// NewBoltKeystore is the constructor for BoltKeystore. It creates
+// the buckets it needs in the given bolt file if not present
+func NewBoltKeystore(db *bolt.DB) (*BoltKeystore, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{privKeysBucket, pubKeysBucket} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &BoltKeystore{db: db}, nil
+}
+
+// PutPrivKey adds a private key to the keystore
+func (ks *BoltKeystore) PutPrivKey(k *kms.PrivateKey) error {
+	return ks.put(privKeysBucket, k.ID, k, false)
+}
+
+// GetPrivKey gets a private key by id from the keystore
+func (ks *BoltKeystore) GetPrivKey(id string) (*kms.PrivateKey, error) {
+	var k kms.PrivateKey
+	if err := ks.get(privKeysBucket, id, &k); err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// UpdatePrivKey updates a private key in the keystore
+func (ks *BoltKeystore) UpdatePrivKey(k *kms.PrivateKey) error {
+	return ks.put(privKeysBucket, k.ID, k, true)
+}
+
+// DeletePrivKey deletes a private key from the keystore
+func (ks *BoltKeystore) DeletePrivKey(id string) error {
+	return ks.delete(privKeysBucket, id)
+}
+
+// ListPrivKeyIDs returns the ids of all private keys in the keystore
+func (ks *BoltKeystore) ListPrivKeyIDs() ([]string, error) {
+	ids := []string{}
+	err := ks.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(privKeysBucket).ForEach(func(k, _ []byte) error {
+			ids = append(ids, string(k))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// ListPrivKeys returns all private keys of a project in the keystore
+func (ks *BoltKeystore) ListPrivKeys(project string) ([]*kms.PrivateKey, error) {
+	keys := []*kms.PrivateKey{}
+	err := ks.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(privKeysBucket).ForEach(func(_, v []byte) error {
+			var k kms.PrivateKey
+			if err := json.Unmarshal(v, &k); err != nil {
+				return err
+			}
+			if k.Project == project {
+				keys = append(keys, &k)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// PutPubKey adds a public key to the keystore
+func (ks *BoltKeystore) PutPubKey(k *kms.PublicKey) error {
+	return ks.put(pubKeysBucket, k.ID, k, false)
+}
+
+// GetPubKey gets a public key by id from the keystore
+func (ks *BoltKeystore) GetPubKey(id string) (*kms.PublicKey, error) {
+	var k kms.PublicKey
+	if err := ks.get(pubKeysBucket, id, &k); err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// DeletePubKey deletes a public key by id from the keystore
+func (ks *BoltKeystore) DeletePubKey(id string) error {
+	return ks.delete(pubKeysBucket, id)
+}
+
+// put writes a json-encoded key. If replace is set the key must
+// already exist, otherwise it must not exist
+func (ks *BoltKeystore) put(bucket []byte, id string, v interface{}, replace bool) error {
+	return ks.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		exists := b.Get([]byte(id)) != nil
+		if replace && !exists {
+			return ErrKeyNotFound
+		}
+		if !replace && exists {
+			return ErrKeyExists
+		}
+		byt, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(id), byt)
+	})
+}
+
+func (ks *BoltKeystore) get(bucket []byte, id string, v interface{}) error {
+	return ks.db.View(func(tx *bolt.Tx) error {
+		byt := tx.Bucket(bucket).Get([]byte(id))
+		if byt == nil {
+			return ErrKeyNotFound
+		}
+		return json.Unmarshal(byt, v)
+	})
+}
+
+func (ks *BoltKeystore) delete(bucket []byte, id string) error {
+	return ks.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		if b.Get([]byte(id)) == nil {
+			return ErrKeyNotFound
+		}
+		return b.Delete([]byte(id))
+	})
+}
@@ -26,6 +26,8 @@ type Keystore interface {
 	GetPrivKey(string) (*kms.PrivateKey, error)
 	UpdatePrivKey(*kms.PrivateKey) error
 	DeletePrivKey(string) error
+	ListPrivKeyIDs() ([]string, error)
+	ListPrivKeys(string) ([]*kms.PrivateKey, error)
 
 	PutPubKey(*kms.PublicKey) error
 	GetPubKey(string) (*kms.PublicKey, error)
@@ -54,6 +54,26 @@ func (db *MockKeystore) UpdatePrivKey(k *kms.PrivateKey) error {
 	return nil
 }
 
+// ListPrivKeyIDs returns the ids of all private keys in the keystore
+func (db *MockKeystore) ListPrivKeyIDs() ([]string, error) {
+	ids := []string{}
+	for id := range db.privs {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// ListPrivKeys returns all private keys of a project in the keystore
+func (db *MockKeystore) ListPrivKeys(project string) ([]*kms.PrivateKey, error) {
+	ks := []*kms.PrivateKey{}
+	for _, k := range db.privs {
+		if k.Project == project {
+			ks = append(ks, k)
+		}
+	}
+	return ks, nil
+}
+
 // PutPubKey adds a public key to the keystore
 func (db *MockKeystore) PutPubKey(k *kms.PublicKey) error {
 	if _, ok := db.pubs[k.ID]; ok {
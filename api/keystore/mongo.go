@@ -77,8 +77,9 @@ func (db *MongoDBKeystore) UpdatePrivKey(key *kms.PrivateKey) error {
 
 	update := bson.M{
 		"$set": bson.M{
-			"project": key.Project,
-			"pem":     key.PEM,
+			"project":    key.Project,
+			"pem":        key.PEM,
+			"keyversion": key.KeyVersion,
 		},
 	}
 	res, err := db.privKeysCollection.UpdateOne(context.TODO(), query, update)
@@ -108,6 +109,54 @@ func (db *MongoDBKeystore) DeletePrivKey(id string) error {
 	return nil
 }
 
+// ListPrivKeyIDs returns the ids of all private keys in the database
+func (db *MongoDBKeystore) ListPrivKeyIDs() ([]string, error) {
+	opts := options.Find().SetProjection(bson.D{{Key: "id", Value: 1}})
+	cur, err := db.privKeysCollection.Find(context.TODO(), bson.D{}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []string{}
+	for cur.Next(context.TODO()) {
+		var elem kms.PrivateKey
+		if err := cur.Decode(&elem); err != nil {
+			return nil, err
+		}
+		ids = append(ids, elem.ID)
+	}
+
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// ListPrivKeys returns all private keys of a project in the database
+func (db *MongoDBKeystore) ListPrivKeys(project string) ([]*kms.PrivateKey, error) {
+	query := bson.D{{Key: "project", Value: project}}
+	cur, err := db.privKeysCollection.Find(context.TODO(), query)
+	if err != nil {
+		return nil, err
+	}
+
+	ks := []*kms.PrivateKey{}
+	for cur.Next(context.TODO()) {
+		var elem kms.PrivateKey
+		if err := cur.Decode(&elem); err != nil {
+			return nil, err
+		}
+		ks = append(ks, &elem)
+	}
+
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+
+	return ks, nil
+}
+
 // PutPubKey adds a new public key to the database
 func (db *MongoDBKeystore) PutPubKey(key *kms.PublicKey) error {
 	_, err := db.pubKeysCollection.InsertOne(context.TODO(), key)
@@ -0,0 +1,163 @@
+package keystore
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/adrianosela/padl/api/kms"
+	"github.com/adrianosela/padl/lib/keys"
+)
+
+// MasterKeySize is the size in bytes of master keys (AES-256)
+const MasterKeySize = keys.AESKeySize
+
+// SealedKeystore is a Keystore decorator which seals private key PEMs
+// with a server master key (AES-256-GCM) before handing them to the
+// underlying keystore, and unseals them on the way out. The key id is
+// bound to the sealed PEM, so a sealed PEM can not be swapped between keys.
+//
+// Multiple master key versions may be loaded at once. New writes are
+// always sealed with the active version, reads unseal with whichever
+// version the key was sealed with. Keys with version 0 are treated as
+// plaintext, which allows existing keystores to be migrated with Rewrap
+type SealedKeystore struct {
+	Keystore
+	masterKeys map[int][]byte
+	active     int
+}
+
+// NewSealedKeystore is the constructor for SealedKeystore
+func NewSealedKeystore(ks Keystore, masterKeys map[int][]byte, active int) (*SealedKeystore, error) {
+	if active < 1 {
+		return nil, fmt.Errorf("active master key version must be positive, got %d", active)
+	}
+	if _, ok := masterKeys[active]; !ok {
+		return nil, fmt.Errorf("active master key version %d not loaded", active)
+	}
+	for v, mk := range masterKeys {
+		if v < 1 {
+			return nil, fmt.Errorf("master key versions must be positive, got %d", v)
+		}
+		if len(mk) != MasterKeySize {
+			return nil, fmt.Errorf("master key version %d must be %d bytes, got %d", v, MasterKeySize, len(mk))
+		}
+	}
+	return &SealedKeystore{
+		Keystore:   ks,
+		masterKeys: masterKeys,
+		active:     active,
+	}, nil
+}
+
+// PutPrivKey seals and adds a private key to the keystore
+func (ks *SealedKeystore) PutPrivKey(k *kms.PrivateKey) error {
+	sealed, err := ks.seal(k)
+	if err != nil {
+		return err
+	}
+	return ks.Keystore.PutPrivKey(sealed)
+}
+
+// GetPrivKey gets and unseals a private key by id from the keystore
+func (ks *SealedKeystore) GetPrivKey(id string) (*kms.PrivateKey, error) {
+	k, err := ks.Keystore.GetPrivKey(id)
+	if err != nil {
+		return nil, err
+	}
+	return ks.unseal(k)
+}
+
+// ListPrivKeys gets and unseals all private keys of a project in the keystore
+func (ks *SealedKeystore) ListPrivKeys(project string) ([]*kms.PrivateKey, error) {
+	sealed, err := ks.Keystore.ListPrivKeys(project)
+	if err != nil {
+		return nil, err
+	}
+	keys := []*kms.PrivateKey{}
+	for _, k := range sealed {
+		unsealed, err := ks.unseal(k)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, unsealed)
+	}
+	return keys, nil
+}
+
+// UpdatePrivKey seals and updates a private key in the keystore
+func (ks *SealedKeystore) UpdatePrivKey(k *kms.PrivateKey) error {
+	sealed, err := ks.seal(k)
+	if err != nil {
+		return err
+	}
+	return ks.Keystore.UpdatePrivKey(sealed)
+}
+
+// Rewrap re-seals every private key which is not sealed with the
+// active master key version (including plaintext keys), and returns
+// the number of keys re-sealed. Once it completes, older master key
+// versions may be retired
+func (ks *SealedKeystore) Rewrap() (int, error) {
+	ids, err := ks.Keystore.ListPrivKeyIDs()
+	if err != nil {
+		return 0, fmt.Errorf("could not list private keys: %s", err)
+	}
+	rewrapped := 0
+	for _, id := range ids {
+		stored, err := ks.Keystore.GetPrivKey(id)
+		if err != nil {
+			return rewrapped, fmt.Errorf("could not get private key %s: %s", id, err)
+		}
+		if stored.KeyVersion == ks.active {
+			continue
+		}
+		k, err := ks.unseal(stored)
+		if err != nil {
+			return rewrapped, err
+		}
+		if err = ks.UpdatePrivKey(k); err != nil {
+			return rewrapped, fmt.Errorf("could not update private key %s: %s", id, err)
+		}
+		rewrapped++
+	}
+	return rewrapped, nil
+}
+
+// seal returns a copy of the given key with its PEM sealed
+// with the active master key
+func (ks *SealedKeystore) seal(k *kms.PrivateKey) (*kms.PrivateKey, error) {
+	sealed, err := keys.SealAESGCM([]byte(k.PEM), ks.masterKeys[ks.active], []byte(k.ID))
+	if err != nil {
+		return nil, fmt.Errorf("could not seal private key %s: %s", k.ID, err)
+	}
+	return &kms.PrivateKey{
+		ID:         k.ID,
+		Project:    k.Project,
+		PEM:        base64.StdEncoding.EncodeToString(sealed),
+		KeyVersion: ks.active,
+	}, nil
+}
+
+// unseal returns a copy of the given key with its PEM in plaintext
+func (ks *SealedKeystore) unseal(k *kms.PrivateKey) (*kms.PrivateKey, error) {
+	if k.KeyVersion == 0 {
+		return k, nil
+	}
+	mk, ok := ks.masterKeys[k.KeyVersion]
+	if !ok {
+		return nil, fmt.Errorf("private key %s is sealed with master key version %d, which is not loaded", k.ID, k.KeyVersion)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(k.PEM)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode sealed private key %s: %s", k.ID, err)
+	}
+	pem, err := keys.OpenAESGCM(sealed, mk, []byte(k.ID))
+	if err != nil {
+		return nil, fmt.Errorf("could not unseal private key %s: %s", k.ID, err)
+	}
+	return &kms.PrivateKey{
+		ID:      k.ID,
+		Project: k.Project,
+		PEM:     string(pem),
+	}, nil
+}
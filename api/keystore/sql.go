@@ -0,0 +1,137 @@
+package keystore
+
+import (
+	"database/sql"
+
+	"github.com/adrianosela/padl/api/kms"
+	"github.com/adrianosela/padl/api/sqldb"
+)
+
+// SQLKeystore is a SQL (postgres or sqlite) implementation
+// of the Keystore interface
+type SQLKeystore struct {
+	db *sqldb.DB
+}
+
+// NewSQLKeystore is the constructor for SQLKeystore
+func NewSQLKeystore(db *sqldb.DB) *SQLKeystore {
+	return &SQLKeystore{db: db}
+}
+
+// PutPrivKey adds a new private key to the database
+func (ks *SQLKeystore) PutPrivKey(key *kms.PrivateKey) error {
+	res, err := ks.db.Exec(
+		`INSERT INTO priv_keys (id, project, pem, key_version) VALUES (?, ?, ?, ?) ON CONFLICT (id) DO NOTHING`,
+		key.ID, key.Project, key.PEM, key.KeyVersion)
+	if err != nil {
+		return err
+	}
+	return sqldb.ExpectAffected(res, ErrKeyExists)
+}
+
+// GetPrivKey gets a private key from the database
+func (ks *SQLKeystore) GetPrivKey(id string) (*kms.PrivateKey, error) {
+	var key kms.PrivateKey
+	err := ks.db.QueryRow(`SELECT id, project, pem, key_version FROM priv_keys WHERE id = ?`, id).
+		Scan(&key.ID, &key.Project, &key.PEM, &key.KeyVersion)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrKeyNotFound
+		}
+		return nil, err
+	}
+	return &key, nil
+}
+
+// UpdatePrivKey updates a private key in the database
+func (ks *SQLKeystore) UpdatePrivKey(key *kms.PrivateKey) error {
+	res, err := ks.db.Exec(`UPDATE priv_keys SET project = ?, pem = ?, key_version = ? WHERE id = ?`,
+		key.Project, key.PEM, key.KeyVersion, key.ID)
+	if err != nil {
+		return err
+	}
+	return sqldb.ExpectAffected(res, ErrKeyNotFound)
+}
+
+// DeletePrivKey deletes a private key from the database
+func (ks *SQLKeystore) DeletePrivKey(id string) error {
+	res, err := ks.db.Exec(`DELETE FROM priv_keys WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	return sqldb.ExpectAffected(res, ErrKeyNotFound)
+}
+
+// ListPrivKeyIDs returns the ids of all private keys in the database
+func (ks *SQLKeystore) ListPrivKeyIDs() ([]string, error) {
+	rows, err := ks.db.Query(`SELECT id FROM priv_keys`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// ListPrivKeys returns all private keys of a project in the database
+func (ks *SQLKeystore) ListPrivKeys(project string) ([]*kms.PrivateKey, error) {
+	rows, err := ks.db.Query(`SELECT id, project, pem, key_version FROM priv_keys WHERE project = ?`, project)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := []*kms.PrivateKey{}
+	for rows.Next() {
+		var key kms.PrivateKey
+		if err := rows.Scan(&key.ID, &key.Project, &key.PEM, &key.KeyVersion); err != nil {
+			return nil, err
+		}
+		keys = append(keys, &key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// PutPubKey adds a new public key to the database
+func (ks *SQLKeystore) PutPubKey(key *kms.PublicKey) error {
+	res, err := ks.db.Exec(`INSERT INTO pub_keys (id, pem) VALUES (?, ?) ON CONFLICT (id) DO NOTHING`,
+		key.ID, key.PEM)
+	if err != nil {
+		return err
+	}
+	return sqldb.ExpectAffected(res, ErrKeyExists)
+}
+
+// GetPubKey returns a public key by id
+func (ks *SQLKeystore) GetPubKey(id string) (*kms.PublicKey, error) {
+	var key kms.PublicKey
+	err := ks.db.QueryRow(`SELECT id, pem FROM pub_keys WHERE id = ?`, id).Scan(&key.ID, &key.PEM)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrKeyNotFound
+		}
+		return nil, err
+	}
+	return &key, nil
+}
+
+// DeletePubKey deletes a public key from the database
+func (ks *SQLKeystore) DeletePubKey(id string) error {
+	res, err := ks.db.Exec(`DELETE FROM pub_keys WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	return sqldb.ExpectAffected(res, ErrKeyNotFound)
+}
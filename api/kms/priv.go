@@ -12,6 +12,10 @@ type PrivateKey struct {
 	ID      string `json:"id"`
 	Project string `json:"project"`
 	PEM     string `json:"pem"`
+
+	// KeyVersion is the version of the server master key which
+	// the PEM is sealed with at rest. Zero means the PEM is not sealed
+	KeyVersion int `json:"key_version,omitempty"`
 }
 
 // NewPrivateKey is the constructor for the padl PrivateKey object
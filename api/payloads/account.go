@@ -24,11 +24,60 @@ type RotateKeyRequest struct {
 	PubKey string `json:"public_key"`
 }
 
-// LoginResponse contains the response to a login request
-type LoginResponse struct {
+// RefreshTokenRequest contains input for refreshing an access token
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// LogoutRequest contains input for logging out. The refresh
+// token is optional, if given its whole family is revoked too
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// DeleteAccountRequest contains input for deleting the caller's account.
+// The password is required such that a leaked token is not enough
+type DeleteAccountRequest struct {
+	Password     string `json:"password"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// RevokeTokenRequest contains input for revoking an access token
+type RevokeTokenRequest struct {
 	Token string `json:"token"`
 }
 
+// ServiceAccountChallengeRequest contains input for
+// requesting a service account login challenge
+type ServiceAccountChallengeRequest struct {
+	Project string `json:"project"`
+	Name    string `json:"name"`
+}
+
+// ServiceAccountChallengeResponse contains a challenge to be
+// signed with the service account's private key
+type ServiceAccountChallengeResponse struct {
+	Challenge string `json:"challenge"`
+}
+
+// ServiceAccountTokenRequest contains a challenge along with its
+// signature by the service account's private key (base64 encoded)
+type ServiceAccountTokenRequest struct {
+	Challenge string `json:"challenge"`
+	Signature string `json:"signature"`
+}
+
+// ServiceAccountTokenResponse contains a short-lived service account token
+type ServiceAccountTokenResponse struct {
+	Token string `json:"token"`
+}
+
+// LoginResponse contains the response to a login or token refresh request
+type LoginResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
 // Validate validates a registration request payload
 func (r *RegistrationRequest) Validate() error {
 	if r.Email == "" {
@@ -57,6 +106,52 @@ func (l *LoginRequest) Validate() error {
 	return nil
 }
 
+// Validate validates a token refresh request payload
+func (r *RefreshTokenRequest) Validate() error {
+	if r.RefreshToken == "" {
+		return errors.New("no refresh token provided")
+	}
+	return nil
+}
+
+// Validate validates an account deletion request payload
+func (d *DeleteAccountRequest) Validate() error {
+	if d.Password == "" {
+		return errors.New("no password provided")
+	}
+	return nil
+}
+
+// Validate validates a token revocation request payload
+func (r *RevokeTokenRequest) Validate() error {
+	if r.Token == "" {
+		return errors.New("no token provided")
+	}
+	return nil
+}
+
+// Validate validates a service account challenge request payload
+func (r *ServiceAccountChallengeRequest) Validate() error {
+	if r.Project == "" {
+		return errors.New("no project provided")
+	}
+	if r.Name == "" {
+		return errors.New("no service account name provided")
+	}
+	return nil
+}
+
+// Validate validates a service account token request payload
+func (r *ServiceAccountTokenRequest) Validate() error {
+	if r.Challenge == "" {
+		return errors.New("no challenge provided")
+	}
+	if r.Signature == "" {
+		return errors.New("no signature provided")
+	}
+	return nil
+}
+
 // Validate validates a key rotation request
 func (r *RotateKeyRequest) Validate() error {
 	if r.PubKey == "" {
@@ -0,0 +1,43 @@
+package payloads
+
+import (
+	"errors"
+
+	"github.com/adrianosela/padl/api/fsck"
+)
+
+// DefaultSigningKeyBits is the size of new jwt signing
+// keys when none is given in a rotation request
+const DefaultSigningKeyBits = 4096
+
+// RotateSigningKeyRequest contains input for rotating the API's jwt signing key
+type RotateSigningKeyRequest struct {
+	KeyBits int `json:"bits,omitempty"`
+}
+
+// RotateSigningKeyResponse contains the response to a signing key rotation
+type RotateSigningKeyResponse struct {
+	KeyID string `json:"key_id"`
+}
+
+// Validate validates a signing key rotation request
+func (r *RotateSigningKeyRequest) Validate() error {
+	if r.KeyBits == 0 {
+		r.KeyBits = DefaultSigningKeyBits
+	}
+	// keys signing tokens for every user are held to a higher bar
+	if r.KeyBits != 2048 && r.KeyBits != 4096 {
+		return errors.New("invalid bits, must be one of { 2048, 4096 }")
+	}
+	return nil
+}
+
+// FsckRequest contains input for a consistency check of the API's records
+type FsckRequest struct {
+	Repair bool `json:"repair,omitempty"`
+}
+
+// FsckResponse contains the problems found (and repaired) by a consistency check
+type FsckResponse struct {
+	Problems []*fsck.Problem `json:"problems"`
+}
@@ -0,0 +1,47 @@
+package payloads
+
+import "net/http"
+
+// Error codes are stable, machine readable identifiers
+// of the kinds of errors the API responds with
+const (
+	ErrorCodeBadRequest     = "bad_request"
+	ErrorCodeInvalidRequest = "invalid_request" // the payload failed validation
+	ErrorCodeUnauthorized   = "unauthorized"
+	ErrorCodeForbidden      = "forbidden"
+	ErrorCodeNotFound       = "not_found"
+	ErrorCodeConflict       = "conflict"
+	ErrorCodeGone           = "gone"
+	ErrorCodeInternal       = "internal"
+)
+
+// RequestIDHeader is the header carrying the id of every request
+// the API serves, which is also included in error responses
+const RequestIDHeader = "X-Request-Id"
+
+// ErrorResponse is the body of every error response of the API
+type ErrorResponse struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id"`
+}
+
+// ErrorCode returns the error code of responses with the given status
+func ErrorCode(status int) string {
+	switch status {
+	case http.StatusUnauthorized:
+		return ErrorCodeUnauthorized
+	case http.StatusForbidden:
+		return ErrorCodeForbidden
+	case http.StatusNotFound:
+		return ErrorCodeNotFound
+	case http.StatusConflict:
+		return ErrorCodeConflict
+	case http.StatusGone:
+		return ErrorCodeGone
+	}
+	if status >= http.StatusInternalServerError {
+		return ErrorCodeInternal
+	}
+	return ErrorCodeBadRequest
+}
@@ -0,0 +1,40 @@
+package payloads
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/adrianosela/padl/api/invitation"
+	"github.com/adrianosela/padl/api/privilege"
+)
+
+// InviteUserRequest is the expected payload
+// for the project invitation endpoint
+type InviteUserRequest struct {
+	Email        string `json:"email"`
+	PrivilegeLvl int    `json:"privilege"`
+	ExpiresHours int    `json:"expires_hours"` // 0 for the default lifetime
+}
+
+// ListInvitationsResponse is the response of the invitation list endpoints
+type ListInvitationsResponse struct {
+	Invitations []*invitation.Invitation `json:"invitations"`
+}
+
+// Validate validates a project invitation request
+func (i *InviteUserRequest) Validate() error {
+	if i.Email == "" {
+		return errors.New("no email provided")
+	}
+	if i.PrivilegeLvl < int(privilege.PrivilegeLvlReader) || i.PrivilegeLvl > int(privilege.PrivilegeLvlOwner) {
+		return errors.New("invalid privilege level provided")
+	}
+	if i.ExpiresHours < 0 {
+		return errors.New("invitation lifetime can not be negative")
+	}
+	if time.Duration(i.ExpiresHours)*time.Hour > invitation.MaxLifetime {
+		return fmt.Errorf("invitation lifetime can not exceed %d hours", int(invitation.MaxLifetime.Hours()))
+	}
+	return nil
+}
@@ -1,10 +1,17 @@
 package payloads
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
+
+// MaxDecryptBatchSize is the most secrets a batch decryption request can contain
+const MaxDecryptBatchSize = 100
 
 // DecryptSecretRequest contains secret to decrypt
 type DecryptSecretRequest struct {
-	Secret string `json:"secret"`
+	Secret  string `json:"secret"`
+	Version int    `json:"version,omitempty"` // shard wire format version
 }
 
 // DecryptSecretResponse contains response message to a secret decryption request
@@ -19,3 +26,40 @@ func (a *DecryptSecretRequest) Validate() error {
 	}
 	return nil
 }
+
+// DecryptSecretsRequest contains a batch of secrets to decrypt with the same key
+type DecryptSecretsRequest struct {
+	Secrets []*DecryptSecretRequest `json:"secrets"`
+}
+
+// DecryptSecretsResponse contains the results of a batch decryption, in the
+// order of the secrets in the request
+type DecryptSecretsResponse struct {
+	Results []*DecryptSecretsResult `json:"results"`
+}
+
+// DecryptSecretsResult is either the decrypted message
+// of a secret in a batch, or why it could not be decrypted
+type DecryptSecretsResult struct {
+	Message string `json:"message,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Validate validates a batch decryption request
+func (a *DecryptSecretsRequest) Validate() error {
+	if len(a.Secrets) == 0 {
+		return errors.New("no secrets provided")
+	}
+	if len(a.Secrets) > MaxDecryptBatchSize {
+		return fmt.Errorf("at most %d secrets can be decrypted at once", MaxDecryptBatchSize)
+	}
+	for i, sec := range a.Secrets {
+		if sec == nil {
+			return fmt.Errorf("secret %d: no secret provided", i)
+		}
+		if err := sec.Validate(); err != nil {
+			return fmt.Errorf("secret %d: %s", i, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package payloads
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/adrianosela/padl/api/revision"
+	"github.com/adrianosela/padl/lib/padlfile"
+	"github.com/adrianosela/padl/lib/secret"
+)
+
+// PushPadlfileRequest is the expected payload
+// for the padlfile push endpoint
+type PushPadlfileRequest struct {
+	BaseRevision uint64         `json:"base_revision"` // 0 if the project has no padlfile revisions yet
+	File         *padlfile.File `json:"file"`
+}
+
+// PadlfileRevisionResponse describes a stored revision of a
+// project's padlfile. The padlfile itself is only included
+// when fetching a revision
+type PadlfileRevisionResponse struct {
+	Revision uint64         `json:"revision"`
+	Author   string         `json:"author"`
+	Time     time.Time      `json:"time"`
+	Hash     string         `json:"hash"`
+	File     *padlfile.File `json:"file,omitempty"`
+}
+
+// PadlfileHistoryResponse is the response of the padlfile history endpoint
+type PadlfileHistoryResponse struct {
+	Revisions []*PadlfileRevisionResponse `json:"revisions"`
+}
+
+// NewPadlfileRevisionResponse returns the description of a revision
+func NewPadlfileRevisionResponse(rev *revision.Revision) *PadlfileRevisionResponse {
+	return &PadlfileRevisionResponse{
+		Revision: rev.Number,
+		Author:   rev.Author,
+		Time:     rev.Time,
+		Hash:     rev.Hash,
+	}
+}
+
+// Validate validates a padlfile push request. Every variable must be
+// an encrypted padl secret, such that plaintext is never pushed
+func (p *PushPadlfileRequest) Validate() error {
+	if p.File == nil {
+		return errors.New("no padlfile provided")
+	}
+	if p.File.Data.Project == "" {
+		return errors.New("padlfile has no project")
+	}
+	if err := validateEncrypted("", p.File.Data.Variables); err != nil {
+		return err
+	}
+	for _, name := range p.File.EnvNames() {
+		e := p.File.Data.Environments[name]
+		if e == nil {
+			return fmt.Errorf("environment %s is empty", name)
+		}
+		if err := validateEncrypted(name, e.Variables); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateEncrypted(env string, vars map[string]string) error {
+	for name, value := range vars {
+		if _, err := secret.DecodePEM(value); err != nil {
+			if env != "" {
+				name = fmt.Sprintf("%s (environment %s)", name, env)
+			}
+			return fmt.Errorf("variable %s is not an encrypted padl secret", name)
+		}
+	}
+	return nil
+}
@@ -2,10 +2,24 @@ package payloads
 
 import (
 	"errors"
+	"fmt"
 	"strings"
+	"time"
 
+	"github.com/adrianosela/padl/api/privilege"
 	"github.com/adrianosela/padl/api/project"
+	"github.com/adrianosela/padl/lib/audit"
 	"github.com/adrianosela/padl/lib/keys"
+	"github.com/adrianosela/padl/lib/policy"
+)
+
+const (
+	// DefaultKeyRotationGracePeriod is how long a rotated out project
+	// key remains usable for decryption when no grace period is given
+	DefaultKeyRotationGracePeriod = time.Hour * 24 * 7
+	// MaxKeyRotationGracePeriod is the longest a rotated out project
+	// key may remain usable for decryption
+	MaxKeyRotationGracePeriod = time.Hour * 24 * 90
 )
 
 // NewProjectRequest is the expected payload for the
@@ -14,6 +28,7 @@ type NewProjectRequest struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
 	KeyBits     int    `json:"bits"`
+	Policy      string `json:"policy,omitempty"`
 }
 
 // GetProjectKeysReponse returns all the public
@@ -23,15 +38,29 @@ type GetProjectKeysReponse struct {
 	MemberKeys []string `json:"member_keys"`
 	ProjectKey string   `json:"project_key"`
 	DeployKeys []string `json:"deploy_keys"`
+	OwnerKeys  []string `json:"owner_keys"`
+	Policy     string   `json:"policy,omitempty"`
 }
 
-// AddUserToProjectRequest is the expected payload
-// for the user addition to project endpoint
-type AddUserToProjectRequest struct {
+// SetProjectPolicyRequest is the expected payload
+// for the project policy endpoint
+type SetProjectPolicyRequest struct {
+	Policy string `json:"policy"`
+}
+
+// SetUserPrivilegeRequest is the expected payload
+// for the project member privilege endpoint
+type SetUserPrivilegeRequest struct {
 	Email        string `json:"email"`
 	PrivilegeLvl int    `json:"privilege"`
 }
 
+// TransferOwnershipRequest is the expected payload
+// for the project ownership transfer endpoint
+type TransferOwnershipRequest struct {
+	Email string `json:"email"`
+}
+
 // RemoveUserFromProjectRequest is the expected payload
 // for the user removal from project endpoint
 type RemoveUserFromProjectRequest struct {
@@ -52,9 +81,28 @@ type DeleteServiceAccountRequest struct {
 }
 
 // CreateServiceAccountResponse is the response
-// type of the service-account creation endpoint
+// type of the service-account creation endpoint.
+// Service accounts obtain tokens by proving possession
+// of their private key, see ServiceAccountTokenRequest
 type CreateServiceAccountResponse struct {
-	Token string `json:"token"`
+	Project string `json:"project"`
+	Name    string `json:"name"`
+	KeyID   string `json:"key_id"`
+}
+
+// RotateProjectKeyRequest is the expected payload
+// for the project key rotation endpoint
+type RotateProjectKeyRequest struct {
+	KeyBits    int `json:"bits"`
+	GraceHours int `json:"grace_hours"`
+}
+
+// RotateProjectKeyResponse is the response
+// of the project key rotation endpoint
+type RotateProjectKeyResponse struct {
+	ProjectKey         string    `json:"project_key"`
+	PreviousKey        string    `json:"previous_key"`
+	PreviousKeyExpires time.Time `json:"previous_key_expires"`
 }
 
 // ListProjectsResponse is the response of the project list endpoint
@@ -62,17 +110,38 @@ type ListProjectsResponse struct {
 	Projects []*project.Summary `json:"projects"`
 }
 
-// Validate validates a user addition to project request
-func (a *AddUserToProjectRequest) Validate() error {
+// ListAuditEventsResponse is the response of the project audit log endpoint
+type ListAuditEventsResponse struct {
+	Events []*audit.Event `json:"events"`
+}
+
+// VerifyAuditLogResponse is the response of the project audit log
+// verification endpoint. Error is set if the log failed verification
+type VerifyAuditLogResponse struct {
+	Events int         `json:"events"`
+	Head   *audit.Head `json:"head,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// Validate validates a project member privilege change request
+func (a *SetUserPrivilegeRequest) Validate() error {
 	if a.Email == "" {
 		return errors.New("no email provided")
 	}
-	if a.PrivilegeLvl < 0 || a.PrivilegeLvl > 3 {
+	if a.PrivilegeLvl < int(privilege.PrivilegeLvlReader) || a.PrivilegeLvl > int(privilege.PrivilegeLvlOwner) {
 		return errors.New("invalid privilege level provided")
 	}
 	return nil
 }
 
+// Validate validates a project ownership transfer request
+func (t *TransferOwnershipRequest) Validate() error {
+	if t.Email == "" {
+		return errors.New("no email provided")
+	}
+	return nil
+}
+
 // Validate validates a user removal from project request
 func (a *RemoveUserFromProjectRequest) Validate() error {
 	if a.Email == "" {
@@ -109,6 +178,31 @@ func (r *DeleteServiceAccountRequest) Validate() error {
 	return nil
 }
 
+// Validate validates a project policy request
+func (r *SetProjectPolicyRequest) Validate() error {
+	if r.Policy == "" {
+		return errors.New("no policy provided")
+	}
+	if _, err := policy.Parse(r.Policy); err != nil {
+		return fmt.Errorf("invalid policy %s: %s", r.Policy, err)
+	}
+	return nil
+}
+
+// Validate validates a project key rotation request
+func (r *RotateProjectKeyRequest) Validate() error {
+	if err := validateKeyBits(r.KeyBits); err != nil {
+		return err
+	}
+	if r.GraceHours < 0 {
+		return errors.New("grace period can not be negative")
+	}
+	if time.Duration(r.GraceHours)*time.Hour > MaxKeyRotationGracePeriod {
+		return fmt.Errorf("grace period can not exceed %d hours", int(MaxKeyRotationGracePeriod.Hours()))
+	}
+	return nil
+}
+
 // Validate validates a project creation request
 func (p *NewProjectRequest) Validate() error {
 	if p.Name == "" {
@@ -123,10 +217,17 @@ func (p *NewProjectRequest) Validate() error {
 	if p.Description == "" {
 		return errors.New("no project description provided")
 	}
-	if p.KeyBits != 512 &&
-		p.KeyBits != 1024 &&
-		p.KeyBits != 2048 &&
-		p.KeyBits != 4096 {
+	if _, err := policy.Parse(p.Policy); err != nil {
+		return fmt.Errorf("invalid policy %s: %s", p.Policy, err)
+	}
+	return validateKeyBits(p.KeyBits)
+}
+
+func validateKeyBits(bits int) error {
+	if bits != 512 &&
+		bits != 1024 &&
+		bits != 2048 &&
+		bits != 4096 {
 		return errors.New("invalid bits, must be one of { 512, 1024, 2048, 4096 }")
 	}
 	return nil
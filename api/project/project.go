@@ -1,7 +1,11 @@
 package project
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/adrianosela/padl/api/privilege"
 )
@@ -13,6 +17,40 @@ type Project struct {
 	Members         map[string]privilege.Level
 	ProjectKey      string
 	ServiceAccounts map[string]string
+	PreviousKeys    []RetiredKey
+	Policy          string             // secret threshold policy, empty means the default
+	Transfer        *OwnershipTransfer // pending ownership transfer, if any
+	Version         int                // incremented by every update, for optimistic concurrency control
+
+	// CreationID identifies this incarnation of the project, such that
+	// a project created with the name of a deleted one is told apart.
+	// Projects created before creation ids have none
+	CreationID string
+}
+
+// OwnershipTransfer is an owner's nomination of another user as
+// project owner. It takes effect when the nominee accepts it
+type OwnershipTransfer struct {
+	From    string    `json:"from"`
+	To      string    `json:"to"`
+	Expires time.Time `json:"expires"`
+}
+
+var (
+	// ErrLastOwner is returned when a change would leave a project without an owner
+	ErrLastOwner = errors.New("a project must keep at least one owner")
+
+	// ErrNoTransfer is returned when accepting an ownership transfer which
+	// does not exist, has expired, or was not made out to the caller
+	ErrNoTransfer = errors.New("no pending ownership transfer for user")
+)
+
+// RetiredKey is a project key which has been rotated out. It
+// remains usable for decryption until it expires, such that
+// padlfiles can be re-encrypted under the new project key
+type RetiredKey struct {
+	KeyID   string    `json:"key_id"`
+	Expires time.Time `json:"expires"`
 }
 
 // Summary is a name-description representation of a Project
@@ -31,7 +69,63 @@ func NewProject(name, description, creator, projectKey string) *Project {
 			creator: privilege.PrivilegeLvlOwner,
 		},
 		ServiceAccounts: make(map[string]string),
+		CreationID:      newCreationID(),
+	}
+}
+
+func newCreationID() string {
+	byt := make([]byte, 8)
+	if _, err := rand.Read(byt); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(byt)
+}
+
+// AuditLog returns the name of the project's audit log. Every incarnation
+// of a project has a log of its own, so a project created with the name of
+// a deleted one does not continue (nor see) the deleted project's log.
+// Project names have no ".", so no project is named as another's log
+func (p *Project) AuditLog() string {
+	if p.CreationID == "" {
+		return p.Name
+	}
+	return fmt.Sprintf("%s.%s", p.Name, p.CreationID)
+}
+
+// RotateKey replaces the project key with a new one, retiring the current key
+// for the given grace period. Previously retired keys which have expired are
+// dropped, and their ids are returned such that they can be deleted
+func (p *Project) RotateKey(newKeyID string, grace time.Duration) []string {
+	now := time.Now()
+	expired := []string{}
+	kept := []RetiredKey{}
+	for _, rk := range p.PreviousKeys {
+		if now.After(rk.Expires) {
+			expired = append(expired, rk.KeyID)
+			continue
+		}
+		kept = append(kept, rk)
+	}
+	p.PreviousKeys = append(kept, RetiredKey{
+		KeyID:   p.ProjectKey,
+		Expires: now.Add(grace),
+	})
+	p.ProjectKey = newKeyID
+	return expired
+}
+
+// CanDecryptWith checks whether a key is the current project key
+// or a retired project key which has not yet expired
+func (p *Project) CanDecryptWith(keyID string) bool {
+	if keyID == p.ProjectKey {
+		return true
 	}
+	for _, rk := range p.PreviousKeys {
+		if rk.KeyID == keyID {
+			return time.Now().Before(rk.Expires)
+		}
+	}
+	return false
 }
 
 // AddUser adds a user to the project with the specified priv level
@@ -43,15 +137,31 @@ func (p *Project) AddUser(email string, priv privilege.Level) error {
 	return nil
 }
 
-// ChangeUserPrivilege changes a user's level of privilege on the project
+// ChangeUserPrivilege changes a user's level of privilege on the project.
+// A project must always have an owner, so the last owner can not be demoted
 func (p *Project) ChangeUserPrivilege(email string, priv privilege.Level) error {
-	if _, ok := p.Members[email]; !ok {
+	current, ok := p.Members[email]
+	if !ok {
 		return errors.New("user not in project")
 	}
+	if current == privilege.PrivilegeLvlOwner && priv < privilege.PrivilegeLvlOwner && len(p.OwnerEmails()) == 1 {
+		return ErrLastOwner
+	}
 	p.Members[email] = priv
 	return nil
 }
 
+// OwnerEmails returns the emails of the project's owners
+func (p *Project) OwnerEmails() []string {
+	owners := []string{}
+	for email, lvl := range p.Members {
+		if lvl == privilege.PrivilegeLvlOwner {
+			owners = append(owners, email)
+		}
+	}
+	return owners
+}
+
 // HasUser checks whether a project has a user as a member
 func (p *Project) HasUser(email string) bool {
 	_, ok := p.Members[email]
@@ -64,11 +174,64 @@ func (p *Project) HasServiceAccount(name string) bool {
 	return ok
 }
 
-// RemoveUser removes a user from the project
-func (p *Project) RemoveUser(email string) {
-	if _, ok := p.Members[email]; ok {
-		delete(p.Members, email)
+// RemoveUser removes a user from the project. The last owner
+// can not be removed, and any transfer involving the user is dropped
+func (p *Project) RemoveUser(email string) error {
+	lvl, ok := p.Members[email]
+	if !ok {
+		return nil
+	}
+	if lvl == privilege.PrivilegeLvlOwner && len(p.OwnerEmails()) == 1 {
+		return ErrLastOwner
+	}
+	delete(p.Members, email)
+	if p.Transfer != nil && (p.Transfer.From == email || p.Transfer.To == email) {
+		p.Transfer = nil
+	}
+	return nil
+}
+
+// IsSoleOwner checks whether a user is the only owner of the project
+func (p *Project) IsSoleOwner(email string) bool {
+	owners := p.OwnerEmails()
+	return len(owners) == 1 && owners[0] == email
+}
+
+// NominateOwner makes a pending ownership transfer from an owner to
+// another user, replacing any previous one. The nominee need not be
+// a member of the project yet
+func (p *Project) NominateOwner(from, to string, ttl time.Duration) error {
+	if p.Members[from] != privilege.PrivilegeLvlOwner {
+		return errors.New("only owners can transfer ownership")
+	}
+	if p.Members[to] == privilege.PrivilegeLvlOwner {
+		return fmt.Errorf("user %s is already an owner", to)
+	}
+	p.Transfer = &OwnershipTransfer{
+		From:    from,
+		To:      to,
+		Expires: time.Now().Add(ttl),
+	}
+	return nil
+}
+
+// AcceptOwnership completes a pending ownership transfer to the given
+// user. The nominee becomes an owner and the nominating owner becomes
+// an editor. The nominating user is returned
+func (p *Project) AcceptOwnership(email string) (string, error) {
+	t := p.Transfer
+	if t == nil || t.To != email || time.Now().After(t.Expires) {
+		return "", ErrNoTransfer
+	}
+	// the nomination lapses if its owner has since been demoted
+	if p.Members[t.From] != privilege.PrivilegeLvlOwner {
+		p.Transfer = nil
+		return "", ErrNoTransfer
 	}
+	p.Members[t.To] = privilege.PrivilegeLvlOwner
+	p.Members[t.From] = privilege.PrivilegeLvlEditor
+	p.Transfer = nil
+	return t.From, nil
 }
 
 // SetServiceAccount sets a service account for a project
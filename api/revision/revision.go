@@ -0,0 +1,65 @@
+package revision
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/adrianosela/padl/lib/padlfile"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// MaxContentSize is the largest padlfile the server stores
+const MaxContentSize = 1 << 20
+
+// Revision is a version of a project's padlfile stored on the server.
+// Padlfiles only hold encrypted secrets, so neither does a revision
+type Revision struct {
+	Project string    `json:"project"`
+	Number  uint64    `json:"revision"` // starting at 1, incremented by every push
+	Author  string    `json:"author"`
+	Time    time.Time `json:"time"`
+	Hash    string    `json:"hash"`    // hex encoded sha256 of the content
+	Content string    `json:"content"` // yaml encoded padlfile
+}
+
+// NewRevision returns a new revision of a project's padlfile
+func NewRevision(project string, number uint64, author, content string) *Revision {
+	return &Revision{
+		Project: project,
+		Number:  number,
+		Author:  author,
+		Time:    time.Now().UTC().Truncate(time.Second),
+		Hash:    Hash(content),
+		Content: content,
+	}
+}
+
+// Hash returns the hex encoded sha256 hash of a padlfile's content
+func Hash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// Encode returns the content of a padlfile as stored in a revision.
+// Clients compare the hash of their local padlfile's content with that
+// of a revision to tell whether the padlfile changed since. The yaml
+// encoding is used for either padlfile format, since unlike json it
+// does not tell nil and empty lists apart
+func Encode(f *padlfile.File) (string, error) {
+	byt, err := yaml.Marshal(f)
+	if err != nil {
+		return "", fmt.Errorf("could not encode padlfile: %s", err)
+	}
+	return string(byt), nil
+}
+
+// File decodes the padlfile stored in a revision
+func (r *Revision) File() (*padlfile.File, error) {
+	var f padlfile.File
+	if err := yaml.Unmarshal([]byte(r.Content), &f); err != nil {
+		return nil, fmt.Errorf("could not decode padlfile revision %d: %s", r.Number, err)
+	}
+	return &f, nil
+}
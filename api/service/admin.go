@@ -0,0 +1,124 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/adrianosela/padl/api/auth"
+	"github.com/adrianosela/padl/api/fsck"
+	"github.com/adrianosela/padl/api/keystore"
+	"github.com/adrianosela/padl/api/payloads"
+	"github.com/gorilla/mux"
+)
+
+func (s *Service) addAdminEndpoints() {
+	s.Router.Methods(http.MethodPost).Path("/admin/signing-keys/rotate").Handler(s.Admin(s.rotateSigningKeyHandler))
+	s.Router.Methods(http.MethodDelete).Path("/admin/signing-keys/{kid}").Handler(s.Admin(s.deleteSigningKeyHandler))
+	s.Router.Methods(http.MethodPost).Path("/admin/fsck").Handler(s.Admin(s.fsckHandler))
+}
+
+// Admin wraps an HTTP handler function such that
+// only users listed as admins in the config can call it
+func (s *Service) Admin(h http.HandlerFunc) http.Handler {
+	return s.Auth(func(w http.ResponseWriter, r *http.Request) {
+		claims := GetClaims(r)
+		if !s.isAdmin(claims.Subject) {
+			writeError(w, http.StatusForbidden, "only admins can perform this operation")
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (s *Service) isAdmin(email string) bool {
+	for _, admin := range s.config.Auth.Admins {
+		if admin == email {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Service) rotateSigningKeyHandler(w http.ResponseWriter, r *http.Request) {
+	// the body is optional
+	var rotatePl payloads.RotateSigningKeyRequest
+	if r.ContentLength != 0 {
+		if err := unmarshalRequestBody(r, &rotatePl); err != nil {
+			writeError(w, http.StatusBadRequest, "could not unmarshal request body")
+			return
+		}
+	}
+	// validate payload
+	if err := rotatePl.Validate(); err != nil {
+		writeInvalid(w, err.Error())
+		return
+	}
+	kid, err := s.authenticator.RotateSigningKey(rotatePl.KeyBits)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not rotate signing key: %s", err))
+		return
+	}
+	byt, err := json.Marshal(&payloads.RotateSigningKeyResponse{KeyID: kid})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not marshal response: %s", err))
+		return
+	}
+	// send success
+	w.WriteHeader(http.StatusOK)
+	w.Write(byt)
+	return
+}
+
+func (s *Service) deleteSigningKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var kid string
+	if kid = mux.Vars(r)["kid"]; kid == "" {
+		writeError(w, http.StatusBadRequest, "no key id in request URL")
+		return
+	}
+	if err := s.authenticator.DeleteSigningKey(kid); err != nil {
+		status := http.StatusInternalServerError
+		switch err {
+		case auth.ErrActiveSigningKey:
+			status = http.StatusBadRequest
+		case keystore.ErrKeyNotFound:
+			status = http.StatusNotFound
+		}
+		writeError(w, status, fmt.Sprintf("could not delete signing key: %s", err))
+		return
+	}
+	// send success
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(fmt.Sprintf("deleted signing key %s", kid)))
+	return
+}
+
+func (s *Service) fsckHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetClaims(r)
+	// the body is optional
+	var fsckPl payloads.FsckRequest
+	if r.ContentLength != 0 {
+		if err := unmarshalRequestBody(r, &fsckPl); err != nil {
+			writeError(w, http.StatusBadRequest, "could not unmarshal request body")
+			return
+		}
+	}
+	problems, err := fsck.Check(s.database, s.keystore, fsckPl.Repair)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not check consistency: %s", err))
+		return
+	}
+	for _, pr := range problems {
+		if pr.Repaired {
+			log.Printf("fsck by %s repaired %s %s: %s", claims.Subject, pr.Kind, pr.Subject, pr.Detail)
+		}
+	}
+	byt, err := json.Marshal(&payloads.FsckResponse{Problems: problems})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not marshal response: %s", err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(byt)
+}
@@ -0,0 +1,140 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/adrianosela/padl/api/payloads"
+	"github.com/adrianosela/padl/api/privilege"
+	"github.com/adrianosela/padl/api/project"
+	"github.com/adrianosela/padl/api/store"
+	"github.com/adrianosela/padl/lib/audit"
+	"github.com/gorilla/mux"
+)
+
+func (s *Service) addAuditEndpoints() {
+	s.Router.Methods(http.MethodGet).Path("/project/{name}/audit").Handler(s.Auth(s.auditLogHandler))
+	s.Router.Methods(http.MethodGet).Path("/project/{name}/audit/verify").Handler(s.Auth(s.verifyAuditLogHandler))
+}
+
+func (s *Service) auditLogHandler(w http.ResponseWriter, r *http.Request) {
+	// filters from query params
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid filter: %s", err))
+		return
+	}
+	logName, ok := s.auditLogName(w, r)
+	if !ok {
+		return
+	}
+	events, err := s.database.ListAuditEvents(logName, filter)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not get audit log: %s", err))
+		return
+	}
+	byt, err := json.Marshal(&payloads.ListAuditEventsResponse{Events: events})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not marshal audit log: %s", err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(byt)
+}
+
+// verifyAuditLogHandler verifies a whole audit log against its head. Only
+// the server holds the keys of the log's hashes, so only it can verify them
+func (s *Service) verifyAuditLogHandler(w http.ResponseWriter, r *http.Request) {
+	logName, ok := s.auditLogName(w, r)
+	if !ok {
+		return
+	}
+	// the head is read first, such that events appended
+	// while the log is read are ahead of it
+	head, err := s.database.GetAuditHead(logName)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not get audit log head: %s", err))
+		return
+	}
+	events, err := s.database.ListAuditEvents(logName, nil)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not get audit log: %s", err))
+		return
+	}
+	resp := &payloads.VerifyAuditLogResponse{Events: len(events), Head: head}
+	if err = audit.Verify(events, head, s.auditKeys); err != nil {
+		resp.Error = err.Error()
+	}
+	byt, err := json.Marshal(resp)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not marshal verification result: %s", err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(byt)
+}
+
+// auditLogName gets the name of the audit log of the project in the
+// request URL, writing an error response if the caller may not read it
+func (s *Service) auditLogName(w http.ResponseWriter, r *http.Request) (string, bool) {
+	claims := GetClaims(r)
+	var name string
+	if name = mux.Vars(r)["name"]; name == "" {
+		writeError(w, http.StatusBadRequest, "no project Name in request URL")
+		return "", false
+	}
+	// the logs of deleted projects outlive them, only admins can see those,
+	// by the name of the log (which the project's deletion is logged with)
+	p, err := s.database.GetProject(name)
+	if err != nil && (err != store.ErrProjectNotFound || !s.isAdmin(claims.Subject)) {
+		writeError(w, lookupStatus(err), fmt.Sprintf("could not find project: %s", err))
+		return "", false
+	}
+	if p == nil {
+		return name, true
+	}
+	if p.Members[claims.Subject] < privilege.PrivilegeLvlOwner && !s.isAdmin(claims.Subject) {
+		writeError(w, http.StatusForbidden, "only owners can view a project's audit log")
+		return "", false
+	}
+	return p.AuditLog(), true
+}
+
+// parseAuditFilter reads the optional "since", "until" (RFC3339)
+// and "actor" query parameters of an audit log request
+func parseAuditFilter(r *http.Request) (*audit.Filter, error) {
+	q := r.URL.Query()
+	f := &audit.Filter{Actor: q.Get("actor")}
+	if since := q.Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return nil, fmt.Errorf("since must be an RFC3339 time: %s", err)
+		}
+		f.Since = t
+	}
+	if until := q.Get("until"); until != "" {
+		t, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			return nil, fmt.Errorf("until must be an RFC3339 time: %s", err)
+		}
+		f.Until = t
+	}
+	return f, nil
+}
+
+// appendAuditEvent appends an event to a project's audit log
+func (s *Service) appendAuditEvent(p *project.Project, actor, action, target, detail string) error {
+	return s.database.AppendAuditEvent(audit.NewEvent(p.AuditLog(), actor, action, target, detail), s.auditKeys.Active())
+}
+
+// recordAuditEvent appends an event to a project's audit log once its
+// change has been applied. The change can not be undone by then, so a
+// failure to record it is logged rather than failing the request
+func (s *Service) recordAuditEvent(p *project.Project, actor, action, target, detail string) {
+	if err := s.appendAuditEvent(p, actor, action, target, detail); err != nil {
+		log.Printf("[error] could not record audit event %s of project %s by %s: %s", action, p.Name, actor, err)
+	}
+}
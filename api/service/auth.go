@@ -1,60 +1,74 @@
 package service
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
+	"sort"
+	"strings"
 
 	"github.com/adrianosela/padl/api/auth"
 	"github.com/adrianosela/padl/api/kms"
 	"github.com/adrianosela/padl/api/payloads"
+	"github.com/adrianosela/padl/api/privilege"
+	"github.com/adrianosela/padl/api/project"
+	"github.com/adrianosela/padl/api/store"
 	"github.com/adrianosela/padl/api/user"
+	"github.com/adrianosela/padl/lib/audit"
+	"github.com/adrianosela/padl/lib/keys"
 )
 
 func (s *Service) addAuthEndpoints() {
 	s.Router.Methods(http.MethodPost).Path("/register").HandlerFunc(s.registrationHandler)
 	s.Router.Methods(http.MethodPost).Path("/login").HandlerFunc(s.loginHandler)
+	s.Router.Methods(http.MethodPost).Path("/token/refresh").HandlerFunc(s.refreshTokenHandler)
+	s.Router.Methods(http.MethodPost).Path("/logout").Handler(s.Auth(s.logoutHandler, auth.PadlAPIAudience, auth.ServiceAccountAudience))
+	s.Router.Methods(http.MethodPost).Path("/token/revoke").Handler(s.Auth(s.revokeTokenHandler))
+	s.Router.Methods(http.MethodPost).Path("/service-account/challenge").HandlerFunc(s.serviceAccountChallengeHandler)
+	s.Router.Methods(http.MethodPost).Path("/service-account/token").HandlerFunc(s.serviceAccountTokenHandler)
 	s.Router.Methods(http.MethodPost).Path("/rotate").Handler(s.Auth(s.rotateKeyHandler))
+	s.Router.Methods(http.MethodDelete).Path("/account").Handler(s.Auth(s.deleteAccountHandler))
 	s.Router.Methods(http.MethodGet).Path("/valid").Handler(s.Auth(s.validHandler))
+	s.Router.Methods(http.MethodGet).Path("/.well-known/jwks.json").HandlerFunc(s.jwksHandler)
 }
 
 func (s *Service) registrationHandler(w http.ResponseWriter, r *http.Request) {
 	var regPl *payloads.RegistrationRequest
 	if err := unmarshalRequestBody(r, &regPl); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("could not unmarshal request body"))
+		writeError(w, http.StatusBadRequest, "could not unmarshal request body")
 		return
 	}
 
 	// validate payload
 	if err := regPl.Validate(); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(err.Error()))
+		writeInvalid(w, err.Error())
 		return
 	}
 	// create padl pub key object and store it publicly
 	pub, err := kms.NewPublicKey(regPl.PubKey)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(err.Error()))
+		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 	if err := s.keystore.PutPubKey(pub); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(fmt.Sprintf("could not store user's public key: %s", err)))
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not store user's public key: %s", err))
 		return
 	}
 	// create new user object
 	usr, err := user.NewUser(regPl.Email, regPl.Password, pub.ID)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("could not create user: %s", err)))
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("could not create user: %s", err))
 		return
 	}
 	// save new user in db
 	if err := s.database.PutUser(usr); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("could not create new user: %s", err)))
+		if err == store.ErrUserExists {
+			writeError(w, http.StatusConflict, fmt.Sprintf("could not create new user: %s", err))
+			return
+		}
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not create new user: %s", err))
 		return
 	}
 	// return success
@@ -66,41 +80,41 @@ func (s *Service) registrationHandler(w http.ResponseWriter, r *http.Request) {
 func (s *Service) loginHandler(w http.ResponseWriter, r *http.Request) {
 	var loginPl *payloads.LoginRequest
 	if err := unmarshalRequestBody(r, &loginPl); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("could not unmarshal request body"))
+		writeError(w, http.StatusBadRequest, "could not unmarshal request body")
 		return
 	}
 	// validate payload
 	if err := loginPl.Validate(); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(err.Error()))
+		writeInvalid(w, err.Error())
 		return
 	}
 	if err := s.authenticator.Basic(loginPl.Email, loginPl.Password); err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Write([]byte("invalid username or password")) // do not expose reason
+		writeError(w, http.StatusUnauthorized, "invalid username or password") // do not expose reason
 		return
 	}
 
 	user, err := s.database.GetUser(loginPl.Email)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(fmt.Sprintf("unable to get user from the database: %s", err)))
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("unable to get user from the database: %s", err))
 		return
 	}
 
 	token, err := s.authenticator.GenerateJWT(user.Email, auth.PadlAPIAudience)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error())) // fixme: if this happens we want to know
+		writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	lr := &payloads.LoginResponse{Token: token}
+	refreshToken, err := s.authenticator.GenerateRefreshToken(user.Email)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	lr := &payloads.LoginResponse{Token: token, RefreshToken: refreshToken}
 	byt, err := json.Marshal(&lr)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error())) // fixme: if this happens we want to know
+		writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -110,49 +124,373 @@ func (s *Service) loginHandler(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
+func (s *Service) refreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var refreshPl *payloads.RefreshTokenRequest
+	if err := unmarshalRequestBody(r, &refreshPl); err != nil {
+		writeError(w, http.StatusBadRequest, "could not unmarshal request body")
+		return
+	}
+	// validate payload
+	if err := refreshPl.Validate(); err != nil {
+		writeInvalid(w, err.Error())
+		return
+	}
+	// exchange the refresh token for a new one
+	sub, refreshToken, err := s.authenticator.RotateRefreshToken(refreshPl.RefreshToken)
+	if err != nil {
+		if err == auth.ErrInvalidRefreshToken || err == auth.ErrRefreshTokenReused {
+			writeError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not refresh token: %s", err))
+		return
+	}
+	// the user may have been removed since the refresh token was issued
+	if _, err := s.database.GetUser(sub); err != nil {
+		writeError(w, http.StatusUnauthorized, auth.ErrInvalidRefreshToken.Error())
+		return
+	}
+
+	token, err := s.authenticator.GenerateJWT(sub, auth.PadlAPIAudience)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	byt, err := json.Marshal(&payloads.LoginResponse{Token: token, RefreshToken: refreshToken})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// return success
+	w.WriteHeader(http.StatusOK)
+	w.Write(byt)
+	return
+}
+
+func (s *Service) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetClaims(r)
+	// the body is optional
+	var logoutPl payloads.LogoutRequest
+	if r.ContentLength != 0 {
+		if err := unmarshalRequestBody(r, &logoutPl); err != nil {
+			writeError(w, http.StatusBadRequest, "could not unmarshal request body")
+			return
+		}
+	}
+	if logoutPl.RefreshToken != "" {
+		err := s.authenticator.RevokeRefreshTokenFamily(logoutPl.RefreshToken)
+		if err != nil && err != auth.ErrInvalidRefreshToken {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	if err := s.authenticator.RevokeJWT(claims); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	// send success
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(fmt.Sprintf("user %s logged out", claims.Subject)))
+	return
+}
+
+func (s *Service) revokeTokenHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetClaims(r)
+	var revokePl *payloads.RevokeTokenRequest
+	if err := unmarshalRequestBody(r, &revokePl); err != nil {
+		writeError(w, http.StatusBadRequest, "could not unmarshal request body")
+		return
+	}
+	// validate payload
+	if err := revokePl.Validate(); err != nil {
+		writeInvalid(w, err.Error())
+		return
+	}
+	// tokens which do not validate need not be revoked
+	revokeClaims, err := s.authenticator.ValidateJWT(revokePl.Token, auth.PadlAPIAudience, auth.ServiceAccountAudience)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid token: %s", err))
+		return
+	}
+	// check caller is authorized to revoke the token
+	allowed, err := s.canRevokeTokensFor(claims.Subject, revokeClaims.Subject)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not check revocation privileges: %s", err))
+		return
+	}
+	if !allowed {
+		writeError(w, http.StatusForbidden, "only the token's user, or an owner or editor of the service account's project, can revoke a token")
+		return
+	}
+	if err := s.authenticator.RevokeJWT(revokeClaims); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	// send success
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(fmt.Sprintf("revoked token %s for %s", revokeClaims.Id, revokeClaims.Subject)))
+	return
+}
+
+// canRevokeTokensFor returns true if the caller may revoke tokens issued
+// to the given subject. Users can revoke their own tokens, and owners and
+// editors (who can create service accounts) can revoke those of any of their
+// projects' service accounts, including ones which were since removed
+func (s *Service) canRevokeTokensFor(caller, sub string) (bool, error) {
+	if caller == sub {
+		return true, nil
+	}
+	_, svcProject, ok := parseServiceAccountEmail(sub)
+	if !ok {
+		return false, nil
+	}
+	usr, err := s.database.GetUser(caller)
+	if err != nil {
+		return false, err
+	}
+	projects, err := s.database.ListProjects(usr.Projects)
+	if err != nil {
+		return false, err
+	}
+	for _, p := range projects {
+		if p.Name == svcProject && p.Members[caller] >= privilege.PrivilegeLvlEditor {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *Service) serviceAccountChallengeHandler(w http.ResponseWriter, r *http.Request) {
+	var challengePl *payloads.ServiceAccountChallengeRequest
+	if err := unmarshalRequestBody(r, &challengePl); err != nil {
+		writeError(w, http.StatusBadRequest, "could not unmarshal request body")
+		return
+	}
+	// validate payload
+	if err := challengePl.Validate(); err != nil {
+		writeInvalid(w, err.Error())
+		return
+	}
+	p, err := s.database.GetProject(challengePl.Project)
+	if err != nil || !p.HasServiceAccount(challengePl.Name) {
+		writeError(w, http.StatusNotFound, "service account not found")
+		return
+	}
+	challenge, err := s.authenticator.GenerateChallenge(serviceAccountEmail(challengePl.Name, p.Name))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not generate challenge: %s", err))
+		return
+	}
+	byt, err := json.Marshal(&payloads.ServiceAccountChallengeResponse{Challenge: challenge})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	// return success
+	w.WriteHeader(http.StatusOK)
+	w.Write(byt)
+	return
+}
+
+func (s *Service) serviceAccountTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var tokenPl *payloads.ServiceAccountTokenRequest
+	if err := unmarshalRequestBody(r, &tokenPl); err != nil {
+		writeError(w, http.StatusBadRequest, "could not unmarshal request body")
+		return
+	}
+	// validate payload
+	if err := tokenPl.Validate(); err != nil {
+		writeInvalid(w, err.Error())
+		return
+	}
+	challengeClaims, err := s.authenticator.ValidateJWT(tokenPl.Challenge, auth.ChallengeAudience)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, "invalid challenge")
+		return
+	}
+	// get the service account's current public key
+	svcName, svcProject, ok := parseServiceAccountEmail(challengeClaims.Subject)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "invalid challenge")
+		return
+	}
+	p, err := s.database.GetProject(svcProject)
+	if err != nil || !p.HasServiceAccount(svcName) {
+		writeError(w, http.StatusUnauthorized, "service account not found")
+		return
+	}
+	pub, err := s.keystore.GetPubKey(p.ServiceAccounts[svcName])
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not get service account key: %s", err))
+		return
+	}
+	pubRSA, err := pub.PubRSA()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not materialize service account key: %s", err))
+		return
+	}
+	// check proof of possession of the private key
+	sig, err := base64.StdEncoding.DecodeString(tokenPl.Signature)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "signature is not base64 encoded")
+		return
+	}
+	if err = keys.VerifySignature([]byte(tokenPl.Challenge), sig, pubRSA); err != nil {
+		writeError(w, http.StatusUnauthorized, "invalid challenge signature")
+		return
+	}
+	// challenges are single use
+	if _, err = s.authenticator.ConsumeChallenge(tokenPl.Challenge); err != nil {
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	token, err := s.authenticator.GenerateJWT(challengeClaims.Subject, auth.ServiceAccountAudience)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	byt, err := json.Marshal(&payloads.ServiceAccountTokenResponse{Token: token})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	// return success
+	w.WriteHeader(http.StatusOK)
+	w.Write(byt)
+	return
+}
+
 func (s *Service) rotateKeyHandler(w http.ResponseWriter, r *http.Request) {
 	claims := GetClaims(r)
 	// unmarshal payload
 	var rotatePl *payloads.RotateKeyRequest
 	if err := unmarshalRequestBody(r, &rotatePl); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("could not unmarshal request body"))
+		writeError(w, http.StatusBadRequest, "could not unmarshal request body")
 		return
 	}
 	// validate payload
 	if err := rotatePl.Validate(); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(err.Error()))
+		writeInvalid(w, err.Error())
 		return
 	}
 	// create padl pub key object and store it publicly
 	pub, err := kms.NewPublicKey(rotatePl.PubKey)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(err.Error()))
+		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 	if err := s.keystore.PutPubKey(pub); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(fmt.Sprintf("could not store user's public key: %s", err)))
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not store user's public key: %s", err))
 		return
 	}
 	// update user in db
+	_, err = store.ModifyUser(s.database, claims.Subject, func(u *user.User) error {
+		u.KeyID = pub.ID
+		return nil
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("unable to update user in the database: %s", err))
+		return
+	}
+	// send success
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("rotated user's key successfully!"))
+	return
+}
+
+func (s *Service) deleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetClaims(r)
+	var deletePl *payloads.DeleteAccountRequest
+	if err := unmarshalRequestBody(r, &deletePl); err != nil {
+		writeError(w, http.StatusBadRequest, "could not unmarshal request body")
+		return
+	}
+	// validate payload
+	if err := deletePl.Validate(); err != nil {
+		writeInvalid(w, err.Error())
+		return
+	}
 	user, err := s.database.GetUser(claims.Subject)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(fmt.Sprintf("unable to get user from the database: %s", err)))
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("unable to get user from the database: %s", err))
+		return
+	}
+	// not a 401, the caller's token is valid
+	if err = user.CheckPassword(deletePl.Password); err != nil {
+		writeError(w, http.StatusForbidden, "incorrect password")
+		return
+	}
+	projects, err := s.database.ListProjects(user.Projects)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not get user's projects: %s", err))
+		return
+	}
+	// projects must keep an owner, so the account can not
+	// be deleted while it is the only owner of any project
+	soleOwned := []string{}
+	for _, p := range projects {
+		if p.IsSoleOwner(user.Email) {
+			soleOwned = append(soleOwned, p.Name)
+		}
+	}
+	if len(soleOwned) > 0 {
+		sort.Strings(soleOwned)
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("cannot delete the only owner of projects: %s. transfer their ownership or delete them first",
+			strings.Join(soleOwned, ", ")))
 		return
 	}
-	user.KeyID = pub.ID
-	if err := s.database.UpdateUser(user); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(fmt.Sprintf("unable to update user in the database: %s", err)))
+	// leave all projects, retrying concurrent modifications such
+	// that the account is not left a member of only some of them
+	for _, p := range projects {
+		_, err = store.ModifyProject(s.database, p.Name, func(proj *project.Project) error {
+			return proj.RemoveUser(user.Email)
+		})
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not remove user from project %s: %s", p.Name, err))
+			return
+		}
+		s.recordAuditEvent(p, claims.Subject, audit.ActionUserRemove, user.Email, "account deleted")
+	}
+	if err = s.database.DeleteUser(user.Email); err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not delete user: %s", err))
 		return
 	}
+	// refresh tokens of deleted users are rejected anyway, but
+	// the access token would remain usable until its expiry
+	if deletePl.RefreshToken != "" {
+		if err = s.authenticator.RevokeRefreshTokenFamily(deletePl.RefreshToken); err != nil && err != auth.ErrInvalidRefreshToken {
+			log.Printf("could not revoke refresh tokens of deleted user %s: %s", user.Email, err)
+		}
+	}
+	if err = s.authenticator.RevokeJWT(claims); err != nil {
+		log.Printf("could not revoke token of deleted user %s: %s", user.Email, err)
+	}
+	if err = s.keystore.DeletePubKey(user.KeyID); err != nil {
+		// fail open, just log
+		log.Printf("unable to delete public key %s of deleted user %s: %s", user.KeyID, user.Email, err)
+	}
+	// invitations are not carried over to a future account with the same email
+	if invs, err := s.database.ListUserInvitations(user.Email); err == nil {
+		for _, inv := range invs {
+			s.dropInvitation(inv.ID)
+		}
+	}
 	// send success
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("rotated user's key successfully!"))
+	w.Write([]byte(fmt.Sprintf("account %s deleted", user.Email)))
+}
+
+func (s *Service) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	byt, err := json.Marshal(s.authenticator.JWKS())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "could not marshal signing keys")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(byt)
 	return
 }
 
@@ -160,8 +498,7 @@ func (s *Service) validHandler(w http.ResponseWriter, r *http.Request) {
 	claims := GetClaims(r)
 	byt, err := json.Marshal(&claims)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("could not marshal claims"))
+		writeError(w, http.StatusInternalServerError, "could not marshal claims")
 		return
 	}
 	w.WriteHeader(http.StatusOK)
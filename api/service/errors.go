@@ -0,0 +1,64 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/adrianosela/padl/api/payloads"
+)
+
+// requestIDSize is the size in bytes of the random request ids
+const requestIDSize = 8
+
+// RequestID wraps an HTTP handler such that every
+// response carries the id of the request it is for
+func RequestID(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(payloads.RequestIDHeader, newRequestID())
+		h.ServeHTTP(w, r)
+	})
+}
+
+func newRequestID() string {
+	byt := make([]byte, requestIDSize)
+	if _, err := rand.Read(byt); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(byt)
+}
+
+// writeError responds with a JSON error envelope,
+// whose error code is that of the status code
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeErrorCode(w, status, payloads.ErrorCode(status), msg)
+}
+
+// writeInvalid responds to a request whose payload failed validation
+func writeInvalid(w http.ResponseWriter, msg string) {
+	writeErrorCode(w, http.StatusBadRequest, payloads.ErrorCodeInvalidRequest, msg)
+}
+
+func writeErrorCode(w http.ResponseWriter, status int, code, msg string) {
+	// the request id is set by the RequestID middleware, which
+	// does not run for requests which matched no route
+	id := w.Header().Get(payloads.RequestIDHeader)
+	if id == "" {
+		id = newRequestID()
+		w.Header().Set(payloads.RequestIDHeader, id)
+	}
+	// server side errors are logged, for the request id to refer to
+	if status >= http.StatusInternalServerError {
+		log.Printf("[error] request %s: %s", id, msg)
+	}
+	byt, err := json.Marshal(&payloads.ErrorResponse{Code: code, Message: msg, RequestID: id})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(byt)
+}
@@ -0,0 +1,307 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/adrianosela/padl/api/invitation"
+	"github.com/adrianosela/padl/api/payloads"
+	"github.com/adrianosela/padl/api/privilege"
+	"github.com/adrianosela/padl/api/project"
+	"github.com/adrianosela/padl/api/store"
+	"github.com/adrianosela/padl/lib/audit"
+	"github.com/gorilla/mux"
+)
+
+func (s *Service) addInvitationEndpoints() {
+	s.Router.Methods(http.MethodPost).Path("/project/{name}/invitations").Handler(s.Auth(s.inviteUserHandler))
+	s.Router.Methods(http.MethodGet).Path("/project/{name}/invitations").Handler(s.Auth(s.listProjectInvitationsHandler))
+	s.Router.Methods(http.MethodDelete).Path("/project/{name}/invitations/{id}").Handler(s.Auth(s.revokeInvitationHandler))
+
+	s.Router.Methods(http.MethodGet).Path("/account/invitations").Handler(s.Auth(s.listUserInvitationsHandler))
+	s.Router.Methods(http.MethodPost).Path("/account/invitations/{id}/accept").Handler(s.Auth(s.acceptInvitationHandler))
+	s.Router.Methods(http.MethodPost).Path("/account/invitations/{id}/decline").Handler(s.Auth(s.declineInvitationHandler))
+}
+
+func (s *Service) inviteUserHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetClaims(r)
+	var name string
+	if name = mux.Vars(r)["name"]; name == "" {
+		writeError(w, http.StatusBadRequest, "no project Name in request URL")
+		return
+	}
+	// read request body
+	var invitePl *payloads.InviteUserRequest
+	if err := unmarshalRequestBody(r, &invitePl); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("could not unmarshal request body: %s", err))
+		return
+	}
+	// validate payload data
+	if err := invitePl.Validate(); err != nil {
+		writeInvalid(w, fmt.Sprintf("could not validate request: %s", err))
+		return
+	}
+	// fetch project from database
+	p, err := s.database.GetProject(name)
+	if err != nil {
+		writeError(w, lookupStatus(err), fmt.Sprintf("could not find project: %s", err))
+		return
+	}
+	if p.Members[claims.Subject] < privilege.PrivilegeLvlOwner {
+		writeError(w, http.StatusForbidden, "only owners can invite users to a project")
+		return
+	}
+	// the invitee must have a padl account to accept with
+	exists, err := s.database.UserExists(invitePl.Email)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("problem getting users from db: %s", err))
+		return
+	}
+	if !exists {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("user %s does not exist", invitePl.Email))
+		return
+	}
+	if p.HasUser(invitePl.Email) {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("user %s is already in project %s", invitePl.Email, p.Name))
+		return
+	}
+	pending, err := s.pendingProjectInvitations(p.Name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not get invitations: %s", err))
+		return
+	}
+	for _, inv := range pending {
+		if inv.Email == invitePl.Email {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("user %s already has a pending invitation (%s) to project %s", inv.Email, inv.ID, p.Name))
+			return
+		}
+	}
+	lifetime := invitation.DefaultLifetime
+	if invitePl.ExpiresHours > 0 {
+		lifetime = time.Duration(invitePl.ExpiresHours) * time.Hour
+	}
+	inv, err := invitation.NewInvitation(p.Name, invitePl.Email, claims.Subject, privilege.Level(invitePl.PrivilegeLvl), lifetime)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not create invitation: %s", err))
+		return
+	}
+	if err := s.database.PutInvitation(inv); err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not store invitation: %s", err))
+		return
+	}
+	s.recordAuditEvent(p, claims.Subject, audit.ActionInvitationCreate, inv.Email, fmt.Sprintf("invitation %s, privilege level %d", inv.ID, inv.PrivilegeLvl))
+	byt, err := json.Marshal(inv)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not marshal response: %s", err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(byt)
+}
+
+func (s *Service) listProjectInvitationsHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetClaims(r)
+	var name string
+	if name = mux.Vars(r)["name"]; name == "" {
+		writeError(w, http.StatusBadRequest, "no project Name in request URL")
+		return
+	}
+	// fetch project from database
+	p, err := s.database.GetProject(name)
+	if err != nil {
+		writeError(w, lookupStatus(err), fmt.Sprintf("could not find project: %s", err))
+		return
+	}
+	if p.Members[claims.Subject] < privilege.PrivilegeLvlOwner {
+		writeError(w, http.StatusForbidden, "only owners can view a project's invitations")
+		return
+	}
+	invs, err := s.pendingProjectInvitations(p.Name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not get invitations: %s", err))
+		return
+	}
+	writeInvitations(w, invs)
+}
+
+func (s *Service) revokeInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetClaims(r)
+	var name, id string
+	if name = mux.Vars(r)["name"]; name == "" {
+		writeError(w, http.StatusBadRequest, "no project Name in request URL")
+		return
+	}
+	if id = mux.Vars(r)["id"]; id == "" {
+		writeError(w, http.StatusBadRequest, "no invitation id in request URL")
+		return
+	}
+	// fetch project from database
+	p, err := s.database.GetProject(name)
+	if err != nil {
+		writeError(w, lookupStatus(err), fmt.Sprintf("could not find project: %s", err))
+		return
+	}
+	if p.Members[claims.Subject] < privilege.PrivilegeLvlOwner {
+		writeError(w, http.StatusForbidden, "only owners can revoke a project's invitations")
+		return
+	}
+	inv, err := s.database.GetInvitation(id)
+	if err != nil || inv.Project != p.Name {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("invitation %s not found in project %s", id, p.Name))
+		return
+	}
+	if err := s.database.DeleteInvitation(inv.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not delete invitation: %s", err))
+		return
+	}
+	s.recordAuditEvent(p, claims.Subject, audit.ActionInvitationRevoke, inv.Email, fmt.Sprintf("invitation %s", inv.ID))
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(fmt.Sprintf("invitation of %s to project %s revoked", inv.Email, p.Name)))
+}
+
+func (s *Service) listUserInvitationsHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetClaims(r)
+	all, err := s.database.ListUserInvitations(claims.Subject)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not get invitations: %s", err))
+		return
+	}
+	writeInvitations(w, unexpired(all))
+}
+
+func (s *Service) acceptInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetClaims(r)
+	inv, ok := s.callerInvitation(w, r)
+	if !ok {
+		return
+	}
+	// fetch project from database
+	p, err := s.database.GetProject(inv.Project)
+	if err != nil {
+		if err == store.ErrProjectNotFound {
+			s.dropInvitation(inv.ID)
+		}
+		writeError(w, lookupStatus(err), fmt.Sprintf("could not find project: %s", err))
+		return
+	}
+	// an invitation is only as good as its inviter's ownership
+	if p.Members[inv.InvitedBy] < privilege.PrivilegeLvlOwner {
+		s.dropInvitation(inv.ID)
+		writeError(w, http.StatusForbidden, fmt.Sprintf("invitation %s is no longer valid, %s is no longer an owner of project %s", inv.ID, inv.InvitedBy, p.Name))
+		return
+	}
+	if err = p.AddUser(claims.Subject, inv.PrivilegeLvl); err != nil {
+		s.dropInvitation(inv.ID)
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("could not add user to project: %s", err))
+		return
+	}
+	// update project, before the user such that a conflict changes nothing
+	rb := &store.Rollback{}
+	if err := s.database.UpdateProject(p); err != nil {
+		writeError(w, updateStatus(err, http.StatusInternalServerError), fmt.Sprintf("could not update project: %s", err))
+		return
+	}
+	rb.Add("add user to project", func() error {
+		_, err := store.ModifyProject(s.database, p.Name, func(proj *project.Project) error {
+			return proj.RemoveUser(claims.Subject)
+		})
+		return err
+	})
+	if _, err := s.addUserProject(claims.Subject, p.Name); err != nil {
+		rollback(rb, "invitation acceptance")
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not update user: %s", err))
+		return
+	}
+	// the user is in, so a failure here only leaves a stale invitation
+	s.dropInvitation(inv.ID)
+	s.recordAuditEvent(p, claims.Subject, audit.ActionInvitationAccept, inv.InvitedBy, fmt.Sprintf("invitation %s, privilege level %d", inv.ID, inv.PrivilegeLvl))
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(fmt.Sprintf("you joined project %s with privilege level %d", p.Name, inv.PrivilegeLvl)))
+}
+
+func (s *Service) declineInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetClaims(r)
+	inv, ok := s.callerInvitation(w, r)
+	if !ok {
+		return
+	}
+	if err := s.database.DeleteInvitation(inv.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not delete invitation: %s", err))
+		return
+	}
+	// invitations to deleted projects are deleted along with them
+	p, err := s.database.GetProject(inv.Project)
+	if err == nil {
+		s.recordAuditEvent(p, claims.Subject, audit.ActionInvitationDecline, inv.InvitedBy, fmt.Sprintf("invitation %s", inv.ID))
+	} else if err != store.ErrProjectNotFound {
+		log.Printf("[error] could not record audit event %s of project %s by %s: %s", audit.ActionInvitationDecline, inv.Project, claims.Subject, err)
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(fmt.Sprintf("invitation to project %s declined", inv.Project)))
+}
+
+// callerInvitation gets the invitation in the request URL, writing an error
+// response if it does not exist, has expired, or is not for the caller
+func (s *Service) callerInvitation(w http.ResponseWriter, r *http.Request) (*invitation.Invitation, bool) {
+	claims := GetClaims(r)
+	var id string
+	if id = mux.Vars(r)["id"]; id == "" {
+		writeError(w, http.StatusBadRequest, "no invitation id in request URL")
+		return nil, false
+	}
+	inv, err := s.database.GetInvitation(id)
+	if err != nil && err != store.ErrInvitationNotFound {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not get invitation: %s", err))
+		return nil, false
+	}
+	// treat other users' invitations the same as ones which do not exist
+	if inv == nil || inv.Email != claims.Subject {
+		writeError(w, http.StatusNotFound, "invitation not found")
+		return nil, false
+	}
+	if inv.Expired() {
+		s.dropInvitation(inv.ID)
+		writeError(w, http.StatusGone, fmt.Sprintf("invitation to project %s expired %s", inv.Project, inv.ExpiresAt.Format(time.RFC3339)))
+		return nil, false
+	}
+	return inv, true
+}
+
+// pendingProjectInvitations returns the unexpired invitations to a project
+func (s *Service) pendingProjectInvitations(name string) ([]*invitation.Invitation, error) {
+	all, err := s.database.ListProjectInvitations(name)
+	if err != nil {
+		return nil, err
+	}
+	return unexpired(all), nil
+}
+
+// dropInvitation deletes an invitation which is no longer of use
+func (s *Service) dropInvitation(id string) {
+	if err := s.database.DeleteInvitation(id); err != nil && err != store.ErrInvitationNotFound {
+		log.Printf("unable to delete invitation %s: %s", id, err)
+	}
+}
+
+func unexpired(invs []*invitation.Invitation) []*invitation.Invitation {
+	pending := []*invitation.Invitation{}
+	for _, inv := range invs {
+		if !inv.Expired() {
+			pending = append(pending, inv)
+		}
+	}
+	return pending
+}
+
+func writeInvitations(w http.ResponseWriter, invs []*invitation.Invitation) {
+	byt, err := json.Marshal(&payloads.ListInvitationsResponse{Invitations: invs})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not marshal response: %s", err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(byt)
+}
@@ -1,15 +1,20 @@
 package service
 
 import (
+	"crypto/rsa"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"strings"
 
 	"github.com/adrianosela/padl/api/auth"
+	"github.com/adrianosela/padl/api/kms"
 	"github.com/adrianosela/padl/api/payloads"
+	"github.com/adrianosela/padl/api/project"
+	"github.com/adrianosela/padl/lib/audit"
 	"github.com/adrianosela/padl/lib/keys"
+	"github.com/adrianosela/padl/lib/secret"
 	"github.com/gorilla/mux"
 )
 
@@ -17,33 +22,31 @@ func (s *Service) addKeyEndpoints() {
 	s.Router.Methods(http.MethodGet).Path("/key/{kid}").HandlerFunc(s.getPubKeyHandler) // note no auth
 	s.Router.Methods(http.MethodPost).Path("/key/{kid}/decrypt").Handler(
 		s.Auth(s.decryptSecretHandler, []string{auth.ServiceAccountAudience, auth.PadlAPIAudience}...))
+	s.Router.Methods(http.MethodPost).Path("/key/{kid}/decrypt/batch").Handler(
+		s.Auth(s.decryptSecretsHandler, []string{auth.ServiceAccountAudience, auth.PadlAPIAudience}...))
 }
 
 func (s *Service) getPubKeyHandler(w http.ResponseWriter, r *http.Request) {
 	// get key id from request URL
 	var id string
 	if id = mux.Vars(r)["kid"]; id == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("no key id in request URL"))
+		writeError(w, http.StatusBadRequest, "no key id in request URL")
 		return
 	}
 	// get key from store, no need to check privs, pub keys are public
 	pub, err := s.keystore.GetPubKey(id)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("could not get key: %s", err)))
+		writeError(w, lookupStatus(err), fmt.Sprintf("could not get key: %s", err))
 		return
 	}
 	if pub == nil {
-		w.WriteHeader(http.StatusNotFound)
-		w.Write([]byte("key not found"))
+		writeError(w, http.StatusNotFound, "key not found")
 		return
 	}
 	// return success
 	pubByt, err := json.Marshal(&pub)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(fmt.Sprintf("could marshal response: %s", err)))
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could marshal response: %s", err))
 		return
 	}
 	w.WriteHeader(http.StatusOK)
@@ -56,90 +59,145 @@ func (s *Service) decryptSecretHandler(w http.ResponseWriter, r *http.Request) {
 	// get key id from request URL
 	var id string
 	if id = mux.Vars(r)["kid"]; id == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("no key id in request URL"))
+		writeError(w, http.StatusBadRequest, "no key id in request URL")
 		return
 	}
 	// get payload
 	var decryptPl *payloads.DecryptSecretRequest
 	if err := unmarshalRequestBody(r, &decryptPl); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("could not unmarshall request body"))
+		writeError(w, http.StatusBadRequest, "could not unmarshall request body")
 		return
 	}
 	// validate payload
 	if err := decryptPl.Validate(); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("could not validate decrypt secret request: %s", err)))
+		writeInvalid(w, fmt.Sprintf("could not validate decrypt secret request: %s", err))
 		return
 	}
-	// get key from store
-	key, err := s.keystore.GetPrivKey(id)
+	p, key, pkey, status, err := s.getDecryptionKey(id, claims.Subject)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("error attempting to get key: %s", err)))
+		writeError(w, status, err.Error())
 		return
 	}
-	// get owning project for the key
-	p, err := s.database.GetProject(key.Project)
+	// decrypt secret as per its wire format version
+	shard := &secret.EncryptedShard{
+		Value:   decryptPl.Secret,
+		KeyID:   key.ID,
+		Version: decryptPl.Version,
+	}
+	message, err := shard.Decrypt(pkey)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("could get project: %s", err)))
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("could not decrypt secret: %s", err))
 		return
 	}
-	ok := p.HasUser(claims.Subject)
-	if !ok {
-		svcAcctParts := strings.Split(claims.Subject, "@")
-		if len(svcAcctParts) < 2 {
-			ok = false
-		} else {
-			svcAcctDetails := strings.Split(svcAcctParts[0], ".")
-			if (len(svcAcctDetails) < 2) {
-				ok = false
-			} else {
-				ok = p.HasServiceAccount(svcAcctDetails[0])
-
-				if p.Name != svcAcctDetails[1] {
-					ok = false
-				} 
-			}
-		}
-	}
-	// treat not having visibility of a key the same as the key not existing
-	if key == nil || !ok {
-		w.WriteHeader(http.StatusNotFound)
-		w.Write([]byte("key not found"))
+	// the decrypted secret is only released once its key use is on record
+	if err := s.appendAuditEvent(p, claims.Subject, audit.ActionKeyDecrypt, key.ID, ""); err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not record audit event: %s", err))
 		return
 	}
-	// decode pem
-	pkey, err := keys.DecodePrivKeyPEM([]byte(key.PEM))
+	// send success
+	mbyt, err := json.Marshal(&payloads.DecryptSecretResponse{Message: base64.StdEncoding.EncodeToString(message.Value)})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("could not decode pem"))
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could marshal response: %s", err))
 		return
 	}
-	// decode secret
-	raw, err := base64.StdEncoding.DecodeString(decryptPl.Secret)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("secret is not base64 encoded"))
+	w.WriteHeader(http.StatusOK)
+	w.Write(mbyt)
+	return
+}
+
+func (s *Service) decryptSecretsHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetClaims(r)
+	// get key id from request URL
+	var id string
+	if id = mux.Vars(r)["kid"]; id == "" {
+		writeError(w, http.StatusBadRequest, "no key id in request URL")
+		return
+	}
+	// get payload
+	var batchPl *payloads.DecryptSecretsRequest
+	if err := unmarshalRequestBody(r, &batchPl); err != nil {
+		writeError(w, http.StatusBadRequest, "could not unmarshall request body")
+		return
+	}
+	// validate payload
+	if err := batchPl.Validate(); err != nil {
+		writeInvalid(w, fmt.Sprintf("could not validate decrypt secrets request: %s", err))
 		return
 	}
-	// decrypt secret
-	message, err := keys.DecryptMessage(raw, pkey)
+	// the key is authorized once for the whole batch
+	p, key, pkey, status, err := s.getDecryptionKey(id, claims.Subject)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("could not decrypt secret: %s", err)))
+		writeError(w, status, err.Error())
 		return
 	}
+	// a secret which can not be decrypted does not fail the others
+	resp := &payloads.DecryptSecretsResponse{Results: make([]*payloads.DecryptSecretsResult, len(batchPl.Secrets))}
+	decrypted := 0
+	for i, sec := range batchPl.Secrets {
+		shard := &secret.EncryptedShard{
+			Value:   sec.Secret,
+			KeyID:   key.ID,
+			Version: sec.Version,
+		}
+		message, err := shard.Decrypt(pkey)
+		if err != nil {
+			resp.Results[i] = &payloads.DecryptSecretsResult{Error: fmt.Sprintf("could not decrypt secret: %s", err)}
+			continue
+		}
+		resp.Results[i] = &payloads.DecryptSecretsResult{Message: base64.StdEncoding.EncodeToString(message.Value)}
+		decrypted++
+	}
+	// the decrypted secrets are only released once their key use is on record
+	if decrypted > 0 {
+		if err := s.appendAuditEvent(p, claims.Subject, audit.ActionKeyDecrypt, key.ID, fmt.Sprintf("batch of %d secrets", decrypted)); err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not record audit event: %s", err))
+			return
+		}
+	}
 	// send success
-	mbyt, err := json.Marshal(&payloads.DecryptSecretResponse{Message: base64.StdEncoding.EncodeToString(message)})
+	byt, err := json.Marshal(resp)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(fmt.Sprintf("could marshal response: %s", err)))
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could marshal response: %s", err))
 		return
 	}
 	w.WriteHeader(http.StatusOK)
-	w.Write(mbyt)
-	return
+	w.Write(byt)
+}
+
+// getDecryptionKey gets a private key which the subject (a project member or
+// service account) may decrypt with, along with its project. On failure, the
+// status code to respond with is returned along with the error
+func (s *Service) getDecryptionKey(id, sub string) (*project.Project, *kms.PrivateKey, *rsa.PrivateKey, int, error) {
+	// get key from store
+	key, err := s.keystore.GetPrivKey(id)
+	if err != nil {
+		return nil, nil, nil, lookupStatus(err), fmt.Errorf("error attempting to get key: %s", err)
+	}
+	if key == nil {
+		return nil, nil, nil, http.StatusNotFound, errors.New("key not found")
+	}
+	// get owning project for the key
+	p, err := s.database.GetProject(key.Project)
+	if err != nil {
+		return nil, nil, nil, lookupStatus(err), fmt.Errorf("could get project: %s", err)
+	}
+	ok := p.HasUser(sub)
+	if !ok {
+		svcName, svcProject, isSvc := parseServiceAccountEmail(sub)
+		ok = isSvc && svcProject == p.Name && p.HasServiceAccount(svcName)
+	}
+	// treat not having visibility of a key the same as the key not existing
+	if !ok {
+		return nil, nil, nil, http.StatusNotFound, errors.New("key not found")
+	}
+	// rotated out project keys are only usable during their grace period
+	if !p.CanDecryptWith(key.ID) {
+		return nil, nil, nil, http.StatusGone, fmt.Errorf("key %s has been rotated out of project %s", key.ID, p.Name)
+	}
+	// decode pem
+	pkey, err := keys.DecodePrivKeyPEM([]byte(key.PEM))
+	if err != nil {
+		return nil, nil, nil, http.StatusInternalServerError, errors.New("could not decode pem")
+	}
+	return p, key, pkey, http.StatusOK, nil
 }
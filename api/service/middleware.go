@@ -26,15 +26,23 @@ func (s *Service) Auth(h http.HandlerFunc, allowedAuds ...string) http.Handler {
 		authorization := r.Header.Get("Authorization")
 		tkStr := strings.TrimPrefix(authorization, "Bearer ")
 		if authorization == tkStr {
-			w.WriteHeader(http.StatusUnauthorized)
-			fmt.Fprint(w, "no access token in header")
+			writeError(w, http.StatusUnauthorized, "no access token in header")
 			return
 		}
 		// validate token
 		verifiedClaims, err := s.authenticator.ValidateJWT(tkStr, allowedAuds...)
 		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
-			fmt.Fprint(w, "invalid access token")
+			writeError(w, http.StatusUnauthorized, "invalid access token")
+			return
+		}
+		// check token has not been revoked
+		revoked, err := s.authenticator.IsRevoked(verifiedClaims)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not check token revocation: %s", err))
+			return
+		}
+		if revoked {
+			writeError(w, http.StatusUnauthorized, "access token has been revoked")
 			return
 		}
 
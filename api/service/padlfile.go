@@ -0,0 +1,203 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/adrianosela/padl/api/auth"
+	"github.com/adrianosela/padl/api/payloads"
+	"github.com/adrianosela/padl/api/privilege"
+	"github.com/adrianosela/padl/api/project"
+	"github.com/adrianosela/padl/api/revision"
+	"github.com/adrianosela/padl/api/store"
+	"github.com/adrianosela/padl/lib/audit"
+	"github.com/gorilla/mux"
+)
+
+func (s *Service) addPadlfileEndpoints() {
+	s.Router.Methods(http.MethodPut).Path("/project/{name}/padlfile").Handler(s.Auth(s.pushPadlfileHandler))
+	s.Router.Methods(http.MethodGet).Path("/project/{name}/padlfile").Handler(
+		s.Auth(s.fetchPadlfileHandler, []string{auth.ServiceAccountAudience, auth.PadlAPIAudience}...))
+	s.Router.Methods(http.MethodGet).Path("/project/{name}/padlfile/history").Handler(
+		s.Auth(s.padlfileHistoryHandler, []string{auth.ServiceAccountAudience, auth.PadlAPIAudience}...))
+}
+
+func (s *Service) pushPadlfileHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetClaims(r)
+	var name string
+	if name = mux.Vars(r)["name"]; name == "" {
+		writeError(w, http.StatusBadRequest, "no project Name in request URL")
+		return
+	}
+	// read request body
+	var pushPl *payloads.PushPadlfileRequest
+	if err := unmarshalRequestBody(r, &pushPl); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("could not unmarshal request body: %s", err))
+		return
+	}
+	// validate payload data
+	if err := pushPl.Validate(); err != nil {
+		writeInvalid(w, fmt.Sprintf("could not validate request: %s", err))
+		return
+	}
+	if pushPl.File.Data.Project != name {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("padlfile is for project %s, not %s", pushPl.File.Data.Project, name))
+		return
+	}
+	// fetch project from database
+	p, err := s.database.GetProject(name)
+	if err != nil {
+		writeError(w, lookupStatus(err), fmt.Sprintf("could not find project: %s", err))
+		return
+	}
+	if !p.HasUser(claims.Subject) || p.Members[claims.Subject] < privilege.PrivilegeLvlEditor {
+		writeError(w, http.StatusForbidden, "only editors and owners can push a project's padlfile")
+		return
+	}
+	// uploads are a compare-and-swap on the latest revision
+	current, err := s.latestPadlfileRevision(p.Name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not get latest padlfile revision: %s", err))
+		return
+	}
+	if pushPl.BaseRevision != current {
+		writeError(w, http.StatusConflict, fmt.Sprintf("padlfile is at revision %d but the pushed padlfile is based on revision %d", current, pushPl.BaseRevision))
+		return
+	}
+	pushPl.File.Revision = current + 1
+	content, err := revision.Encode(pushPl.File)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if len(content) > revision.MaxContentSize {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("padlfile can not exceed %d bytes", revision.MaxContentSize))
+		return
+	}
+	rev := revision.NewRevision(p.Name, pushPl.File.Revision, claims.Subject, content)
+	if err := s.database.PutPadlfileRevision(rev); err != nil {
+		// the loser of concurrent pushes of the same base revision
+		if err == store.ErrRevisionExists {
+			writeError(w, http.StatusConflict, fmt.Sprintf("padlfile revision %d was pushed concurrently", rev.Number))
+			return
+		}
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not store padlfile revision: %s", err))
+		return
+	}
+	s.recordAuditEvent(p, claims.Subject, audit.ActionPadlfilePush, fmt.Sprintf("revision %d", rev.Number), rev.Hash)
+	byt, err := json.Marshal(payloads.NewPadlfileRevisionResponse(rev))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not marshal response: %s", err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(byt)
+}
+
+func (s *Service) fetchPadlfileHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetClaims(r)
+	var name string
+	if name = mux.Vars(r)["name"]; name == "" {
+		writeError(w, http.StatusBadRequest, "no project Name in request URL")
+		return
+	}
+	// no revision means the latest revision
+	var number uint64
+	if q := r.URL.Query().Get("revision"); q != "" {
+		n, err := strconv.ParseUint(q, 10, 64)
+		if err != nil || n == 0 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid revision %q", q))
+			return
+		}
+		number = n
+	}
+	p, err := s.database.GetProject(name)
+	if err != nil {
+		writeError(w, lookupStatus(err), fmt.Sprintf("could not find project: %s", err))
+		return
+	}
+	if !canReadPadlfile(p, claims.Subject) {
+		writeError(w, http.StatusForbidden, "only project members and service accounts can fetch a project's padlfile")
+		return
+	}
+	rev, err := s.database.GetPadlfileRevision(p.Name, number)
+	if err != nil {
+		if err == store.ErrRevisionNotFound {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("project %s has no padlfile revision %s", p.Name, r.URL.Query().Get("revision")))
+			return
+		}
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not get padlfile revision: %s", err))
+		return
+	}
+	resp := payloads.NewPadlfileRevisionResponse(rev)
+	if resp.File, err = rev.File(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	byt, err := json.Marshal(resp)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not marshal response: %s", err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(byt)
+}
+
+func (s *Service) padlfileHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetClaims(r)
+	var name string
+	if name = mux.Vars(r)["name"]; name == "" {
+		writeError(w, http.StatusBadRequest, "no project Name in request URL")
+		return
+	}
+	p, err := s.database.GetProject(name)
+	if err != nil {
+		writeError(w, lookupStatus(err), fmt.Sprintf("could not find project: %s", err))
+		return
+	}
+	if !canReadPadlfile(p, claims.Subject) {
+		writeError(w, http.StatusForbidden, "only project members and service accounts can view a project's padlfile history")
+		return
+	}
+	revs, err := s.database.ListPadlfileRevisions(p.Name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not get padlfile revisions: %s", err))
+		return
+	}
+	resp := &payloads.PadlfileHistoryResponse{Revisions: []*payloads.PadlfileRevisionResponse{}}
+	for _, rev := range revs {
+		resp.Revisions = append(resp.Revisions, payloads.NewPadlfileRevisionResponse(rev))
+	}
+	byt, err := json.Marshal(resp)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not marshal response: %s", err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(byt)
+}
+
+// latestPadlfileRevision returns the number of the latest
+// revision of a project's padlfile, or 0 if it has none
+func (s *Service) latestPadlfileRevision(name string) (uint64, error) {
+	rev, err := s.database.GetPadlfileRevision(name, 0)
+	if err != nil {
+		if err == store.ErrRevisionNotFound {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return rev.Number, nil
+}
+
+// canReadPadlfile returns true if the subject is a member
+// or one of the service accounts of the given project
+func canReadPadlfile(p *project.Project, sub string) bool {
+	if p.HasUser(sub) {
+		return true
+	}
+	svcName, svcProject, isSvc := parseServiceAccountEmail(sub)
+	return isSvc && svcProject == p.Name && p.HasServiceAccount(svcName)
+}
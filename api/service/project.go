@@ -5,30 +5,63 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
+	"time"
 
-	"github.com/adrianosela/padl/api/auth"
 	"github.com/adrianosela/padl/api/kms"
 	"github.com/adrianosela/padl/api/payloads"
 	"github.com/adrianosela/padl/api/privilege"
 	"github.com/adrianosela/padl/api/project"
+	"github.com/adrianosela/padl/api/store"
+	"github.com/adrianosela/padl/lib/audit"
 	"github.com/adrianosela/padl/lib/padlfile"
+	"github.com/adrianosela/padl/lib/policy"
 	"github.com/gorilla/mux"
 )
 
 const (
 	defaultSvcAccountEmailDomain = "@padl.adrianosela.com"
+
+	// ownershipTransferLifetime is how long a nominee has
+	// to accept a project ownership transfer
+	ownershipTransferLifetime = time.Hour * 24 * 7
 )
 
+// serviceAccountEmail builds a service account email of the form
+// {name}.{project_name}@{padl_hostname} i.e. cicd.my-first-project@api.padl.com
+func serviceAccountEmail(name, projectName string) string {
+	return name + "." + projectName + defaultSvcAccountEmailDomain
+}
+
+// parseServiceAccountEmail returns the name and project of a service account
+// email. Neither service account nor project names can contain "."
+func parseServiceAccountEmail(email string) (string, string, bool) {
+	if !strings.HasSuffix(email, defaultSvcAccountEmailDomain) {
+		return "", "", false
+	}
+	parts := strings.Split(strings.TrimSuffix(email, defaultSvcAccountEmailDomain), ".")
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
 func (s *Service) addProjectEndpoints() {
 	s.Router.Methods(http.MethodPost).Path("/project").Handler(s.Auth(s.createProjectHandler))
 	s.Router.Methods(http.MethodGet).Path("/project/{name}").Handler(s.Auth(s.getProjectHandler))
 	s.Router.Methods(http.MethodGet).Path("/project/{name}/keys").Handler(s.Auth(s.getProjectKeysHandler))
 	s.Router.Methods(http.MethodDelete).Path("/project/{name}").Handler(s.Auth(s.deleteProjectHandler))
+	s.Router.Methods(http.MethodPost).Path("/project/{name}/rotate-key").Handler(s.Auth(s.rotateProjectKeyHandler))
+	s.Router.Methods(http.MethodPut).Path("/project/{name}/policy").Handler(s.Auth(s.setProjectPolicyHandler))
 	s.Router.Methods(http.MethodGet).Path("/projects").Handler(s.Auth(s.listProjectsHandler))
 
-	s.Router.Methods(http.MethodPost).Path("/project/{name}/user").Handler(s.Auth(s.addUserHandler))
+	s.Router.Methods(http.MethodPatch).Path("/project/{name}/user").Handler(s.Auth(s.setUserPrivilegeHandler))
 	s.Router.Methods(http.MethodDelete).Path("/project/{name}/user").Handler(s.Auth(s.removeUserHandler))
 
+	s.Router.Methods(http.MethodPost).Path("/project/{name}/transfer").Handler(s.Auth(s.nominateOwnerHandler))
+	s.Router.Methods(http.MethodPost).Path("/project/{name}/transfer/accept").Handler(s.Auth(s.acceptOwnershipHandler))
+	s.Router.Methods(http.MethodDelete).Path("/project/{name}/transfer").Handler(s.Auth(s.cancelOwnershipTransferHandler))
+
 	s.Router.Methods(http.MethodPost).Path("/project/{name}/service_account").Handler(s.Auth(s.createServiceAccountHandler))
 	s.Router.Methods(http.MethodDelete).Path("/project/{name}/service_account").Handler(s.Auth(s.removeServiceAccountHandler))
 }
@@ -38,70 +71,75 @@ func (s *Service) createProjectHandler(w http.ResponseWriter, r *http.Request) {
 	// get payload
 	var projPl *payloads.NewProjectRequest
 	if err := unmarshalRequestBody(r, &projPl); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("could not unmarshall request body"))
+		writeError(w, http.StatusBadRequest, "could not unmarshall request body")
 		return
 	}
 	// validate payload
 	if err := projPl.Validate(); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("could not validate new project request: %s", err)))
+		writeInvalid(w, fmt.Sprintf("could not validate new project request: %s", err))
 		return
 	}
 	// check name is unique before doing anything
 	exists, err := s.database.ProjectExists(projPl.Name)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(fmt.Sprintf("could not check if project exists %s", err)))
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not check if project exists %s", err))
 		return
 	}
 	if exists {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("provided project name is taken")))
+		writeError(w, http.StatusConflict, "provided project name is taken")
 		return
 	}
 	// create shared team key for project and save it
 	pKey, err := kms.NewPrivateKey(projPl.KeyBits, projPl.Name)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(fmt.Sprintf("could not create project key: %s", err)))
-		return
-	}
-	if err = s.keystore.PutPrivKey(pKey); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(fmt.Sprintf("could not save project key: %s", err)))
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not create project key: %s", err))
 		return
 	}
 	pub, err := pKey.Pub()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(fmt.Sprintf("could not extract public key: %s", err)))
-		return
-	}
-	if err = s.keystore.PutPubKey(pub); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(fmt.Sprintf("could not save project pub key: %s", err)))
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not extract public key: %s", err))
 		return
 	}
-	// create project object and save it
+	// every step from here on is undone if a later one fails, such that
+	// no orphaned keys or memberships are left behind. The project is
+	// saved before its keys, so that a key is never without its project
+	rb := &store.Rollback{}
 	project := project.NewProject(projPl.Name, projPl.Description, claims.Subject, pKey.ID)
+	project.Policy = canonicalPolicy(projPl.Policy)
 	if err := s.database.PutProject(project); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("could not save new project: %s", err)))
+		// the name may have been taken since it was checked
+		if err == store.ErrProjectExists {
+			writeError(w, http.StatusConflict, "provided project name is taken")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not save new project: %s", err))
 		return
 	}
+	rb.Add("save project", func() error { return s.database.DeleteProject(project.Name) })
+	if err = s.keystore.PutPrivKey(pKey); err != nil {
+		rollback(rb, "project creation")
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not save project key: %s", err))
+		return
+	}
+	rb.Add("save project key", func() error { return s.keystore.DeletePrivKey(pKey.ID) })
+	if err = s.keystore.PutPubKey(pub); err != nil {
+		rollback(rb, "project creation")
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not save project pub key: %s", err))
+		return
+	}
+	rb.Add("save project pub key", func() error { return s.keystore.DeletePubKey(pub.ID) })
 	// add project to user claims
-	user, err := s.database.GetUser(claims.Subject)
+	user, err := s.addUserProject(claims.Subject, project.Name)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-
-		w.Write([]byte(fmt.Sprintf("unable to get user from the database: %s", err)))
+		rollback(rb, "project creation")
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not update user: %s", err))
 		return
 	}
-	user.AddProject(project.Name)
-	if err := s.database.UpdateUser(user); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(fmt.Sprintf("could not update user: %s", err)))
+	rb.Add("add project to user", func() error { return s.removeUserProject(claims.Subject, project.Name) })
+	// the log is started along with the project, which is undone without it
+	if err := s.appendAuditEvent(project, claims.Subject, audit.ActionProjectCreate, pKey.ID, ""); err != nil {
+		rollback(rb, "project creation")
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not record audit event: %s", err))
 		return
 	}
 
@@ -113,13 +151,14 @@ func (s *Service) createProjectHandler(w http.ResponseWriter, r *http.Request) {
 			MemberKeys:  []string{user.KeyID},
 			ServiceKeys: []string{},
 			SharedKey:   pKey.ID,
+			OwnerKeys:   []string{user.KeyID},
+			Policy:      project.Policy,
 		},
 	}
 
 	byt, err := json.Marshal(&pf)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(fmt.Sprintf("could not marshal project json: %s", err)))
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not marshal project json: %s", err))
 		return
 	}
 	w.WriteHeader(http.StatusOK)
@@ -132,27 +171,23 @@ func (s *Service) getProjectHandler(w http.ResponseWriter, r *http.Request) {
 	// project name from request URL
 	var name string
 	if name = mux.Vars(r)["name"]; name == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("no project Name in request URL"))
+		writeError(w, http.StatusBadRequest, "no project Name in request URL")
 		return
 	}
 	p, err := s.database.GetProject(name)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("could not get project: %s", err)))
+		writeError(w, lookupStatus(err), fmt.Sprintf("could not get project: %s", err))
 		return
 	}
 
 	if _, ok := p.Members[claims.Subject]; !ok {
-		w.WriteHeader(http.StatusForbidden)
-		w.Write([]byte(fmt.Sprintf("requesting user not in project: %s", err)))
+		writeError(w, http.StatusForbidden, fmt.Sprintf("requesting user not in project: %s", err))
 		return
 	}
 
 	byt, err := json.Marshal(&p)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(fmt.Sprintf("could not marshal project json: %s", err)))
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not marshal project json: %s", err))
 		return
 	}
 
@@ -167,20 +202,17 @@ func (s *Service) getProjectKeysHandler(w http.ResponseWriter, r *http.Request)
 	// project name from request URL
 	var name string
 	if name = mux.Vars(r)["name"]; name == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("no project Name in request URL"))
+		writeError(w, http.StatusBadRequest, "no project Name in request URL")
 		return
 	}
 
 	p, err := s.database.GetProject(name)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("could not get project: %s", err)))
+		writeError(w, lookupStatus(err), fmt.Sprintf("could not get project: %s", err))
 		return
 	}
 	if _, ok := p.Members[claims.Subject]; !ok {
-		w.WriteHeader(http.StatusForbidden)
-		w.Write([]byte(fmt.Sprintf("requesting user not in project: %s", err)))
+		writeError(w, http.StatusForbidden, fmt.Sprintf("requesting user not in project: %s", err))
 		return
 	}
 
@@ -188,8 +220,7 @@ func (s *Service) getProjectKeysHandler(w http.ResponseWriter, r *http.Request)
 	for member := range p.Members {
 		user, err := s.database.GetUser(member)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(fmt.Sprintf("could not get a member user from db: %s", err)))
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not get a member user from db: %s", err))
 			return
 		}
 		memKeyIDs = append(memKeyIDs, user.KeyID)
@@ -200,17 +231,28 @@ func (s *Service) getProjectKeysHandler(w http.ResponseWriter, r *http.Request)
 		svcKeyIDs = append(svcKeyIDs, svcKeyID)
 	}
 
+	ownerKeyIDs := []string{}
+	for _, owner := range p.OwnerEmails() {
+		user, err := s.database.GetUser(owner)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not get an owner user from db: %s", err))
+			return
+		}
+		ownerKeyIDs = append(ownerKeyIDs, user.KeyID)
+	}
+
 	getProjectKeysResp := payloads.GetProjectKeysReponse{
 		Name:       p.Name,
 		MemberKeys: memKeyIDs,
 		DeployKeys: svcKeyIDs,
+		OwnerKeys:  ownerKeyIDs,
 		ProjectKey: p.ProjectKey,
+		Policy:     p.Policy,
 	}
 
 	byt, err := json.Marshal(&getProjectKeysResp)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(fmt.Sprintf("could not marshal project keys json: %s", err)))
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not marshal project keys json: %s", err))
 		return
 	}
 
@@ -224,32 +266,93 @@ func (s *Service) deleteProjectHandler(w http.ResponseWriter, r *http.Request) {
 	// name from GET params
 	var name string
 	if name = mux.Vars(r)["name"]; name == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("no project Name in request URL"))
+		writeError(w, http.StatusBadRequest, "no project Name in request URL")
 		return
 	}
 	// get project from db
 	p, err := s.database.GetProject(name)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("could not get project: %s", err)))
+		writeError(w, lookupStatus(err), fmt.Sprintf("could not get project: %s", err))
 		return
 	}
 	// check caller is owner, else reject request
 	if p.Members[claims.Subject] < privilege.PrivilegeLvlOwner {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("only owners can delete a project")))
+		writeError(w, http.StatusForbidden, "only owners can delete a project")
+		return
+	}
+	// every step up to the deletion of the project itself is undone
+	// if a later one fails, such that a failed deletion changes nothing
+	rb := &store.Rollback{}
+	// remove project from all users
+	for member := range p.Members {
+		member := member
+		if err = s.removeUserProject(member, p.Name); err != nil {
+			// members without an account have no projects to remove it from
+			if err == store.ErrUserNotFound {
+				continue
+			}
+			rollback(rb, "project deletion")
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not remove project from user %s: %s", member, err))
+			return
+		}
+		rb.Add(fmt.Sprintf("remove project from user %s", member), func() error {
+			_, err := s.addUserProject(member, p.Name)
+			return err
+		})
+	}
+	// drop pending invitations, a project created with
+	// the same name later on must not inherit them
+	invs, err := s.database.ListProjectInvitations(p.Name)
+	if err != nil {
+		rollback(rb, "project deletion")
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not get project invitations: %s", err))
+		return
+	}
+	for _, inv := range invs {
+		inv := inv
+		if err = s.database.DeleteInvitation(inv.ID); err != nil {
+			if err == store.ErrInvitationNotFound {
+				continue
+			}
+			rollback(rb, "project deletion")
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not delete invitation %s: %s", inv.ID, err))
+			return
+		}
+		rb.Add(fmt.Sprintf("delete invitation %s", inv.ID), func() error { return s.database.PutInvitation(inv) })
+	}
+	// likewise for stored padlfile revisions. A failed deletion may have
+	// deleted some of them, so restoring them skips those still stored
+	revs, err := s.database.ListPadlfileRevisions(p.Name)
+	if err != nil {
+		rollback(rb, "project deletion")
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not get padlfile revisions: %s", err))
 		return
 	}
-	// delete project's private key
-	if err = s.keystore.DeletePrivKey(p.ProjectKey); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(fmt.Sprintf("unable to delete project's private key: %s", err)))
+	rb.Add("delete padlfile revisions", func() error {
+		for _, rev := range revs {
+			if err := s.database.PutPadlfileRevision(rev); err != nil && err != store.ErrRevisionExists {
+				return err
+			}
+		}
+		return nil
+	})
+	if err = s.database.DeletePadlfileRevisions(p.Name); err != nil {
+		rollback(rb, "project deletion")
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not delete padlfile revisions: %s", err))
+		return
 	}
-	// delete project's public key
-	if err = s.keystore.DeletePubKey(p.ProjectKey); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(fmt.Sprintf("unable to delete project's public key: %s", err)))
+	// delete project
+	if err = s.database.DeleteProject(name); err != nil {
+		rollback(rb, "project deletion")
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("unable to delete project: %s", err))
+		return
+	}
+	// the keys go last, as a project whose keys were deleted could not
+	// be rolled back to. Keys which can not be deleted are only logged,
+	// the consistency check finds and deletes them as orphans
+	s.deleteProjectKey(p.ProjectKey)
+	for _, rk := range p.PreviousKeys {
+		s.deleteProjectKey(rk.KeyID)
 	}
 	// delete all service account public keys
 	for _, keyID := range p.ServiceAccounts {
@@ -258,165 +361,374 @@ func (s *Service) deleteProjectHandler(w http.ResponseWriter, r *http.Request) {
 			log.Printf("unable to delete project service account key %s: %s", keyID, err)
 		}
 	}
-	// remove project from all users
-	for member := range p.Members {
-		if u, err := s.database.GetUser(member); err == nil {
-			u.RemoveProject(p.Name)
-			s.database.UpdateUser(u) // note the ignored error here
-		}
-	}
-	// delete project
-	if err = s.database.DeleteProject(name); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(fmt.Sprintf("unable to delete project: %s", err)))
-	}
+	// the audit log outlives the project, for admins to see by its name
+	s.recordAuditEvent(p, claims.Subject, audit.ActionProjectDelete, "", "")
+	log.Printf("[info] project %s deleted by %s, its audit log is kept as %s", p.Name, claims.Subject, p.AuditLog())
 	// send success
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(fmt.Sprintf("project %s deleted successfully!", name)))
 }
 
-func (s *Service) addUserHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Service) rotateProjectKeyHandler(w http.ResponseWriter, r *http.Request) {
 	claims := GetClaims(r)
 	var name string
 	if name = mux.Vars(r)["name"]; name == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("no project Name in request URL"))
+		writeError(w, http.StatusBadRequest, "no project Name in request URL")
 		return
 	}
 	// read request body
-	var addUserPl *payloads.AddUserToProjectRequest
-	if err := unmarshalRequestBody(r, &addUserPl); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("could not unmarshal request body: %s", err)))
+	var rotatePl *payloads.RotateProjectKeyRequest
+	if err := unmarshalRequestBody(r, &rotatePl); err != nil {
+		writeError(w, http.StatusBadRequest, "could not unmarshall request body")
 		return
 	}
 	// validate payload data
-	if err := addUserPl.Validate(); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("could not validate request: %s", err)))
+	if err := rotatePl.Validate(); err != nil {
+		writeInvalid(w, fmt.Sprintf("could not validate request: %s", err))
 		return
 	}
-	exists, err := s.database.UserExists(addUserPl.Email)
+	// fetch project from database
+	p, err := s.database.GetProject(name)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(fmt.Sprintf("problem getting users from db: %s", err)))
+		writeError(w, lookupStatus(err), fmt.Sprintf("could not find project: %s", err))
 		return
 	}
-	if !exists {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("user %s does not exist", addUserPl.Email)))
+	// check caller is owner, else reject request
+	if p.Members[claims.Subject] < privilege.PrivilegeLvlOwner {
+		writeError(w, http.StatusForbidden, "only owners can rotate a project's key")
 		return
 	}
-	// fetch project from database
-	p, err := s.database.GetProject(name)
+	// create new shared team key for project and save it
+	pKey, err := kms.NewPrivateKey(rotatePl.KeyBits, p.Name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not create project key: %s", err))
+		return
+	}
+	if err = s.keystore.PutPrivKey(pKey); err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not save project key: %s", err))
+		return
+	}
+	pub, err := pKey.Pub()
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("could not find project: %s", err)))
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not extract public key: %s", err))
 		return
 	}
+	if err = s.keystore.PutPubKey(pub); err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not save project pub key: %s", err))
+		return
+	}
+	// retire the current key and save the project
+	grace := time.Duration(rotatePl.GraceHours) * time.Hour
+	if grace == 0 {
+		grace = payloads.DefaultKeyRotationGracePeriod
+	}
+	previous := p.ProjectKey
+	expired := p.RotateKey(pKey.ID, grace)
+	if err := s.database.UpdateProject(p); err != nil {
+		// the new key is of no use to anyone
+		s.deleteProjectKey(pKey.ID)
+		writeError(w, updateStatus(err, http.StatusInternalServerError), fmt.Sprintf("could not update project: %s", err))
+		return
+	}
+	// delete keys whose grace period is over
+	for _, keyID := range expired {
+		s.deleteProjectKey(keyID)
+	}
+	s.recordAuditEvent(p, claims.Subject, audit.ActionProjectRotateKey, pKey.ID, "previous key "+previous)
 
-	user, err := s.database.GetUser(addUserPl.Email)
+	byt, err := json.Marshal(&payloads.RotateProjectKeyResponse{
+		ProjectKey:         pKey.ID,
+		PreviousKey:        previous,
+		PreviousKeyExpires: p.PreviousKeys[len(p.PreviousKeys)-1].Expires,
+	})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(fmt.Sprintf("unable to get user from the database: %s", err)))
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not marshal response: %s", err))
 		return
 	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(byt)
+}
 
+func (s *Service) setProjectPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetClaims(r)
+	var name string
+	if name = mux.Vars(r)["name"]; name == "" {
+		writeError(w, http.StatusBadRequest, "no project Name in request URL")
+		return
+	}
+	// read request body
+	var policyPl *payloads.SetProjectPolicyRequest
+	if err := unmarshalRequestBody(r, &policyPl); err != nil {
+		writeError(w, http.StatusBadRequest, "could not unmarshall request body")
+		return
+	}
+	// validate payload data
+	if err := policyPl.Validate(); err != nil {
+		writeInvalid(w, fmt.Sprintf("could not validate request: %s", err))
+		return
+	}
+	// fetch project from database
+	p, err := s.database.GetProject(name)
+	if err != nil {
+		writeError(w, lookupStatus(err), fmt.Sprintf("could not find project: %s", err))
+		return
+	}
+	// check caller is owner, else reject request
 	if p.Members[claims.Subject] < privilege.PrivilegeLvlOwner {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("only owners can add users to a project")))
+		writeError(w, http.StatusForbidden, "only owners can set a project's policy")
 		return
 	}
-
-	user.AddProject(p.Name)
-	if err := s.database.UpdateUser(user); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(fmt.Sprintf("could not update user: %s", err)))
+	// update project
+	p.Policy = canonicalPolicy(policyPl.Policy)
+	if err := s.database.UpdateProject(p); err != nil {
+		writeError(w, updateStatus(err, http.StatusInternalServerError), fmt.Sprintf("could not update project: %s", err))
 		return
 	}
+	s.recordAuditEvent(p, claims.Subject, audit.ActionProjectSetPolicy, "", policyPl.Policy)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(fmt.Sprintf("project %s policy set to %s successfully!", p.Name, policyPl.Policy)))
+}
 
-	if err = p.AddUser(addUserPl.Email, privilege.Level(addUserPl.PrivilegeLvl)); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("could add user to project: %s", err)))
+// canonicalPolicy returns the canonical form of a (validated) policy
+// string. The default policy is stored as the empty string
+func canonicalPolicy(s string) string {
+	pol, err := policy.Parse(s)
+	if err != nil || pol.IsDefault() {
+		return ""
+	}
+	return pol.String()
+}
+
+// deleteProjectKey deletes both halves of a project key pair
+// from the keystore. It fails open, errors are only logged
+func (s *Service) deleteProjectKey(keyID string) {
+	if err := s.keystore.DeletePrivKey(keyID); err != nil {
+		log.Printf("unable to delete project private key %s: %s", keyID, err)
+	}
+	if err := s.keystore.DeletePubKey(keyID); err != nil {
+		log.Printf("unable to delete project public key %s: %s", keyID, err)
+	}
+}
+
+func (s *Service) setUserPrivilegeHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetClaims(r)
+	var name string
+	if name = mux.Vars(r)["name"]; name == "" {
+		writeError(w, http.StatusBadRequest, "no project Name in request URL")
+		return
+	}
+	// read request body
+	var privPl *payloads.SetUserPrivilegeRequest
+	if err := unmarshalRequestBody(r, &privPl); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("could not unmarshal request body: %s", err))
+		return
+	}
+	// validate payload data
+	if err := privPl.Validate(); err != nil {
+		writeInvalid(w, fmt.Sprintf("could not validate request: %s", err))
+		return
+	}
+	// fetch project from database
+	p, err := s.database.GetProject(name)
+	if err != nil {
+		writeError(w, lookupStatus(err), fmt.Sprintf("could not find project: %s", err))
+		return
+	}
+	callerLvl, ok := p.Members[claims.Subject]
+	if !ok || callerLvl < privilege.PrivilegeLvlEditor {
+		writeError(w, http.StatusForbidden, "only owners and editors can change a member's privilege")
+		return
+	}
+	targetLvl, ok := p.Members[privPl.Email]
+	if !ok {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("user %s is not in project %s", privPl.Email, p.Name))
+		return
+	}
+	newLvl := privilege.Level(privPl.PrivilegeLvl)
+	// no one can grant more privilege than they have, nor
+	// change the privilege of a member with more than they have
+	if newLvl > callerLvl || targetLvl > callerLvl {
+		writeError(w, http.StatusForbidden, "cannot change privilege above your own level")
+		return
+	}
+	if err = p.ChangeUserPrivilege(privPl.Email, newLvl); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("could not change user privilege: %s", err))
 		return
 	}
 	// update project
 	if err := s.database.UpdateProject(p); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("could not update project: %s", err)))
+		writeError(w, updateStatus(err, http.StatusInternalServerError), fmt.Sprintf("could not update project: %s", err))
 		return
 	}
+	s.recordAuditEvent(p, claims.Subject, audit.ActionUserSetPrivilege, privPl.Email, fmt.Sprintf("privilege level %d to %d", targetLvl, newLvl))
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(fmt.Sprintf("user %s added to project %s successfully!", addUserPl.Email, p.Name)))
-	return
+	w.Write([]byte(fmt.Sprintf("user %s privilege in project %s set to %d successfully!", privPl.Email, p.Name, newLvl)))
 }
 
 func (s *Service) removeUserHandler(w http.ResponseWriter, r *http.Request) {
 	claims := GetClaims(r)
 	var name string
 	if name = mux.Vars(r)["name"]; name == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("no project Name in request URL"))
+		writeError(w, http.StatusBadRequest, "no project Name in request URL")
 		return
 	}
 	// read request body
 	var rmUserPl *payloads.RemoveUserFromProjectRequest
 	if err := unmarshalRequestBody(r, &rmUserPl); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("could not unmarshall request body"))
+		writeError(w, http.StatusBadRequest, "could not unmarshall request body")
 		return
 	}
 	// validate payload data
 	if err := rmUserPl.Validate(); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("could not validate request: %s", err)))
+		writeInvalid(w, fmt.Sprintf("could not validate request: %s", err))
 		return
 	}
 	// fetch project from database
 	p, err := s.database.GetProject(name)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("could not find project: %s", err)))
+		writeError(w, lookupStatus(err), fmt.Sprintf("could not find project: %s", err))
 		return
 	}
 
-	user, err := s.database.GetUser(rmUserPl.Email)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(fmt.Sprintf("unable to get user from the database: %s", err)))
+	if p.Members[claims.Subject] < privilege.PrivilegeLvlOwner {
+		writeError(w, http.StatusForbidden, "only owners can remove users from a project")
 		return
 	}
 
-	if p.Members[claims.Subject] < privilege.PrivilegeLvlOwner {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("only owners can remove users from a project")))
+	// owners can leave a project, as long as another owner remains
+	if err = p.RemoveUser(rmUserPl.Email); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("could not remove user from project: %s", err))
 		return
 	}
 
-	user.RemoveProject(p.Name)
-	if err := s.database.UpdateUser(user); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(fmt.Sprintf("could not update user: %s", err)))
+	// update project, before the user such that a conflict changes nothing
+	if err := s.database.UpdateProject(p); err != nil {
+		writeError(w, updateStatus(err, http.StatusInternalServerError), fmt.Sprintf("could not update project: %s", err))
+		return
+	}
+	if err := s.removeUserProject(rmUserPl.Email, p.Name); err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not update user: %s", err))
 		return
 	}
+	s.recordAuditEvent(p, claims.Subject, audit.ActionUserRemove, rmUserPl.Email, "")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(fmt.Sprintf("user %s removed from project %s successfully!", rmUserPl.Email, p.Name)))
+	return
+}
 
-	if rmUserPl.Email == claims.Subject {
-		if p.HasUser(claims.Subject) && p.Members[claims.Subject] == privilege.PrivilegeLvlOwner {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("owners cannot remove themselves from projects"))
-			return
-		}
+func (s *Service) nominateOwnerHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetClaims(r)
+	var name string
+	if name = mux.Vars(r)["name"]; name == "" {
+		writeError(w, http.StatusBadRequest, "no project Name in request URL")
+		return
+	}
+	// read request body
+	var transferPl *payloads.TransferOwnershipRequest
+	if err := unmarshalRequestBody(r, &transferPl); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("could not unmarshal request body: %s", err))
+		return
+	}
+	// validate payload data
+	if err := transferPl.Validate(); err != nil {
+		writeInvalid(w, fmt.Sprintf("could not validate request: %s", err))
+		return
+	}
+	// the nominee must have a padl account to accept with
+	exists, err := s.database.UserExists(transferPl.Email)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("problem getting users from db: %s", err))
+		return
+	}
+	if !exists {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("user %s does not exist", transferPl.Email))
+		return
+	}
+	// fetch project from database
+	p, err := s.database.GetProject(name)
+	if err != nil {
+		writeError(w, lookupStatus(err), fmt.Sprintf("could not find project: %s", err))
+		return
+	}
+	if err = p.NominateOwner(claims.Subject, transferPl.Email, ownershipTransferLifetime); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("could not nominate owner: %s", err))
+		return
 	}
 	// update project
-	p.RemoveUser(rmUserPl.Email)
 	if err := s.database.UpdateProject(p); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("could not update project: %s", err)))
+		writeError(w, updateStatus(err, http.StatusInternalServerError), fmt.Sprintf("could not update project: %s", err))
 		return
 	}
+	s.recordAuditEvent(p, claims.Subject, audit.ActionOwnershipNominate, transferPl.Email, "")
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(fmt.Sprintf("user %s removed from project %s successfully!", rmUserPl.Email, p.Name)))
-	return
+	w.Write([]byte(fmt.Sprintf("user %s nominated as owner of project %s, the nomination expires %s",
+		transferPl.Email, p.Name, p.Transfer.Expires.Format(time.RFC3339))))
+}
+
+func (s *Service) acceptOwnershipHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetClaims(r)
+	var name string
+	if name = mux.Vars(r)["name"]; name == "" {
+		writeError(w, http.StatusBadRequest, "no project Name in request URL")
+		return
+	}
+	// fetch project from database
+	p, err := s.database.GetProject(name)
+	if err != nil {
+		writeError(w, lookupStatus(err), fmt.Sprintf("could not find project: %s", err))
+		return
+	}
+	from, err := p.AcceptOwnership(claims.Subject)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("could not accept ownership of project %s: %s", p.Name, err))
+		return
+	}
+	// update project, before the user such that a conflict changes nothing
+	if err := s.database.UpdateProject(p); err != nil {
+		writeError(w, updateStatus(err, http.StatusInternalServerError), fmt.Sprintf("could not update project: %s", err))
+		return
+	}
+	// the nominee may not have been a member yet
+	if _, err := s.addUserProject(claims.Subject, p.Name); err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not update user: %s", err))
+		return
+	}
+	s.recordAuditEvent(p, claims.Subject, audit.ActionOwnershipAccept, from, "")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(fmt.Sprintf("you are now an owner of project %s, %s is now an editor", p.Name, from)))
+}
+
+func (s *Service) cancelOwnershipTransferHandler(w http.ResponseWriter, r *http.Request) {
+	claims := GetClaims(r)
+	var name string
+	if name = mux.Vars(r)["name"]; name == "" {
+		writeError(w, http.StatusBadRequest, "no project Name in request URL")
+		return
+	}
+	// fetch project from database
+	p, err := s.database.GetProject(name)
+	if err != nil {
+		writeError(w, lookupStatus(err), fmt.Sprintf("could not find project: %s", err))
+		return
+	}
+	if p.Transfer == nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("project %s has no pending ownership transfer", p.Name))
+		return
+	}
+	// owners can cancel a transfer, and the nominee can decline it
+	nominee := p.Transfer.To
+	if p.Members[claims.Subject] < privilege.PrivilegeLvlOwner && claims.Subject != nominee {
+		writeError(w, http.StatusForbidden, "only owners and the nominee can cancel an ownership transfer")
+		return
+	}
+	p.Transfer = nil
+	if err := s.database.UpdateProject(p); err != nil {
+		writeError(w, updateStatus(err, http.StatusInternalServerError), fmt.Sprintf("could not update project: %s", err))
+		return
+	}
+	s.recordAuditEvent(p, claims.Subject, audit.ActionOwnershipCancel, nominee, "")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(fmt.Sprintf("ownership transfer of project %s to %s cancelled", p.Name, nominee)))
 }
 
 func (s *Service) createServiceAccountHandler(w http.ResponseWriter, r *http.Request) {
@@ -424,74 +736,60 @@ func (s *Service) createServiceAccountHandler(w http.ResponseWriter, r *http.Req
 	// get project name from request URL
 	var name string
 	if name = mux.Vars(r)["name"]; name == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("no project Name in request URL"))
+		writeError(w, http.StatusBadRequest, "no project Name in request URL")
 		return
 	}
 	// get payload data (svc acct name + pub key)
 	var dkeyPl *payloads.CreateServiceAccountRequest
 	if err := unmarshalRequestBody(r, &dkeyPl); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("could not unmarshall request body"))
+		writeError(w, http.StatusBadRequest, "could not unmarshall request body")
 		return
 	}
 	// validate payload data
 	if err := dkeyPl.Validate(); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("could not validate request: %s", err)))
+		writeInvalid(w, fmt.Sprintf("could not validate request: %s", err))
 		return
 	}
 	// fetch project from database
 	p, err := s.database.GetProject(name)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("could not find project: %s", err)))
+		writeError(w, lookupStatus(err), fmt.Sprintf("could not find project: %s", err))
 		return
 	}
 	// check if caller is authorized to create a service account for project
 	if _, ok := p.Members[claims.Subject]; !ok {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("User not in requested Project: %s", err)))
+		writeError(w, http.StatusForbidden, fmt.Sprintf("User not in requested Project: %s", p.Name))
 		return
 	}
 	if p.Members[claims.Subject] < privilege.PrivilegeLvlEditor {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("Only owners and editors can create service accounts")))
+		writeError(w, http.StatusForbidden, "Only owners and editors can create service accounts")
 		return
 	}
 	// create padl pub key object for svc account and store it publicly
 	pub, err := kms.NewPublicKey(dkeyPl.PubKey)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(err.Error()))
+		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 	if err := s.keystore.PutPubKey(pub); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(fmt.Sprintf("could not store service account's public key: %s", err)))
-		return
-	}
-	// build a service account email of the form {name}.{project_name}@{padl_hostname}
-	// i.e. cicd.my-first-project@api.padl.com
-	svcEmail := dkeyPl.ServiceAccountName + "." + p.Name + defaultSvcAccountEmailDomain
-	keyToken, err := s.authenticator.GenerateJWT(svcEmail, auth.ServiceAccountAudience)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("could not generate keyToken: %s", err)))
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not store service account's public key: %s", err))
 		return
 	}
 	// add service account to project object and save it
 	p.SetServiceAccount(dkeyPl.ServiceAccountName, pub.ID)
 	if err := s.database.UpdateProject(p); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("could not update project: %s", err)))
+		writeError(w, updateStatus(err, http.StatusInternalServerError), fmt.Sprintf("could not update project: %s", err))
 		return
 	}
+	s.recordAuditEvent(p, claims.Subject, audit.ActionServiceAccountCreate, dkeyPl.ServiceAccountName, "key "+pub.ID)
 	// marshall response
-	byt, err := json.Marshal(&payloads.CreateServiceAccountResponse{Token: keyToken})
+	byt, err := json.Marshal(&payloads.CreateServiceAccountResponse{
+		Project: p.Name,
+		Name:    dkeyPl.ServiceAccountName,
+		KeyID:   pub.ID,
+	})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(fmt.Sprintf("could not marshal project json: %s", err)))
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not marshal project json: %s", err))
 		return
 	}
 	// send success
@@ -504,50 +802,44 @@ func (s *Service) removeServiceAccountHandler(w http.ResponseWriter, r *http.Req
 	claims := GetClaims(r)
 	var name string
 	if name = mux.Vars(r)["name"]; name == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("no project Name in request URL"))
+		writeError(w, http.StatusBadRequest, "no project Name in request URL")
 		return
 	}
 	// get payload data
 	var deleteKeyPl payloads.DeleteServiceAccountRequest
 	if err := unmarshalRequestBody(r, &deleteKeyPl); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("could not unmarshall request body: %s", err)))
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("could not unmarshall request body: %s", err))
 		return
 	}
 	// validate payload data
 	if err := deleteKeyPl.Validate(); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("could not validate payload: %s", err)))
+		writeInvalid(w, fmt.Sprintf("could not validate payload: %s", err))
 		return
 	}
 	// fetch project from database
 	p, err := s.database.GetProject(name)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("could not find project: %s", err)))
+		writeError(w, lookupStatus(err), fmt.Sprintf("could not find project: %s", err))
 		return
 	}
 
 	if _, ok := p.Members[claims.Subject]; !ok {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("User not in requested Project: %s", p.Name)))
+		writeError(w, http.StatusForbidden, fmt.Sprintf("User not in requested Project: %s", p.Name))
 		return
 	}
 
 	if p.Members[claims.Subject] < privilege.PrivilegeLvlOwner {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("Only Owners can remove service accounts")))
+		writeError(w, http.StatusForbidden, "Only Owners can remove service accounts")
 		return
 	}
 
 	// update project
 	p.RemoveServiceAccount(deleteKeyPl.ServiceAccountName)
 	if err := s.database.UpdateProject(p); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("could not update project: %s", err)))
+		writeError(w, updateStatus(err, http.StatusInternalServerError), fmt.Sprintf("could not update project: %s", err))
 		return
 	}
+	s.recordAuditEvent(p, claims.Subject, audit.ActionServiceAccountRemove, deleteKeyPl.ServiceAccountName, "")
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(fmt.Sprintf("successfully removed service account from project %s", p.Name)))
 	return
@@ -558,15 +850,13 @@ func (s *Service) listProjectsHandler(w http.ResponseWriter, r *http.Request) {
 
 	user, err := s.database.GetUser(claims.Subject)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(fmt.Sprintf("could not get user from db: %s", err)))
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not get user from db: %s", err))
 		return
 	}
 
 	projects, err := s.database.ListProjects(user.Projects)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("could not get list of projects: %s", err)))
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not get list of projects: %s", err))
 		return
 	}
 
@@ -586,8 +876,7 @@ func (s *Service) listProjectsHandler(w http.ResponseWriter, r *http.Request) {
 	// marshall response
 	byt, err := json.Marshal(&listProjResp)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(fmt.Sprintf("could not marshal projects summary json: %s", err)))
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not marshal projects summary json: %s", err))
 		return
 	}
 	// send resp
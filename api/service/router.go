@@ -1,16 +1,27 @@
 package service
 
 import (
+	"fmt"
 	"log"
+	"net/http"
+	"time"
 
 	"github.com/adrianosela/padl/api/auth"
 	"github.com/adrianosela/padl/api/config"
 	"github.com/adrianosela/padl/api/keystore"
+	"github.com/adrianosela/padl/api/sqldb"
 	"github.com/adrianosela/padl/api/store"
+	"github.com/adrianosela/padl/lib/audit"
 	"github.com/adrianosela/padl/lib/keys"
 	"github.com/gorilla/mux"
+
+	bolt "go.etcd.io/bbolt"
 )
 
+// boltOpenTimeout is how long to wait for the lock on a bolt
+// data file which is already open by another process
+const boltOpenTimeout = time.Second * 5
+
 // Service holds the service configuration
 // necessary for endpoints to respond to requests
 type Service struct {
@@ -19,51 +30,162 @@ type Service struct {
 	config        *config.Config
 	database      store.Database
 	keystore      keystore.Keystore
+	auditKeys     *audit.Keyring
 	authenticator *auth.Authenticator
 }
 
 // NewPadlService returns an HTTP router multiplexer with
 // attached handler functions
 func NewPadlService(c *config.Config) *Service {
-	// initialize mongodb store
-	db, err := store.NewMongoDB(
-		c.MongoDB.ConnectionString,
-		c.MongoDB.Name,
-		c.MongoDB.UsersCollectionName,
-		c.MongoDB.ProjectsCollectionName,
-	)
+	db, ks, err := initStorage(c)
 	if err != nil {
-		log.Fatalf("could not initialize mongodb store: %s", err)
+		log.Fatalf("could not initialize storage: %s", err)
 	}
-
-	// initialize mongodb keystore
-	ks, err := keystore.NewMongoDBKeystore(
-		c.MongoDB.ConnectionString,
-		c.MongoDB.Name,
-		c.MongoDB.PrivKeysCollectionName,
-		c.MongoDB.PubKeysCollectionName,
-	)
+	if ks, err = sealKeystore(c, ks); err != nil {
+		log.Fatalf("could not initialize sealed keystore: %s", err)
+	}
+	auditKeys, err := auditKeyring(c)
 	if err != nil {
-		log.Fatalf("could not initialize mongodb keystore: %s", err)
+		log.Fatalf("could not derive audit log keys: %s", err)
 	}
 
 	priv, err := keys.DecodePrivKeyPEM([]byte(c.Auth.SigningKey))
 	if err != nil {
 		log.Fatalf("could not materialize jwt signing key: %s", err)
 	}
+	access, refresh, svcAccount, err := c.TokenLifetimes()
+	if err != nil {
+		log.Fatalf("could not configure token lifetimes: %s", err)
+	}
+	lifetimes := auth.Lifetimes{Access: access, Refresh: refresh, ServiceAccount: svcAccount}
 
 	svc := &Service{
 		Router:        mux.NewRouter(),
 		config:        c,
 		database:      db,
 		keystore:      ks,
-		authenticator: auth.NewAuthenticator(db, priv, "padl.adrianosela.com", "api"),
+		auditKeys:     auditKeys,
+		authenticator: auth.NewAuthenticator(db, priv, "padl.adrianosela.com", "api", lifetimes),
+	}
+
+	if err = svc.authenticator.LoadSigningKeys(ks); err != nil {
+		log.Fatalf("could not load jwt signing keys: %s", err)
 	}
 
 	svc.addDebugEndpoints()
 	svc.addAuthEndpoints()
 	svc.addProjectEndpoints()
 	svc.addKeyEndpoints()
+	svc.addAdminEndpoints()
+	svc.addAuditEndpoints()
+	svc.addInvitationEndpoints()
+	svc.addPadlfileEndpoints()
+
+	svc.Router.Use(RequestID)
+	svc.Router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("no such endpoint: %s %s", r.Method, r.URL.Path))
+	})
+	svc.Router.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Sprintf("method %s not allowed for %s", r.Method, r.URL.Path))
+	})
 
 	return svc
 }
+
+// initStorage initializes the database and keystore for the configured driver
+func initStorage(c *config.Config) (store.Database, keystore.Keystore, error) {
+	switch c.Store.Driver {
+	case config.DriverMongoDB:
+		db, err := store.NewMongoDB(
+			c.MongoDB.ConnectionString,
+			c.MongoDB.Name,
+			c.MongoDB.UsersCollectionName,
+			c.MongoDB.ProjectsCollectionName,
+			c.MongoDB.RefreshTokensCollectionName,
+			c.MongoDB.RevokedTokensCollectionName,
+			c.MongoDB.InvitationsCollectionName,
+			c.MongoDB.RevisionsCollectionName,
+			c.MongoDB.AuditEventsCollectionName,
+			c.MongoDB.AuditHeadsCollectionName,
+		)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not initialize mongodb store: %s", err)
+		}
+		ks, err := keystore.NewMongoDBKeystore(
+			c.MongoDB.ConnectionString,
+			c.MongoDB.Name,
+			c.MongoDB.PrivKeysCollectionName,
+			c.MongoDB.PubKeysCollectionName,
+		)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not initialize mongodb keystore: %s", err)
+		}
+		return db, ks, nil
+	case config.DriverPostgres, config.DriverSQLite:
+		driver := sqldb.DriverPostgres
+		if c.Store.Driver == config.DriverSQLite {
+			driver = sqldb.DriverSQLite
+		}
+		sdb, err := sqldb.Open(driver, c.Store.DSN)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not initialize %s store: %s", c.Store.Driver, err)
+		}
+		return store.NewSQLDatabase(sdb), keystore.NewSQLKeystore(sdb), nil
+	case config.DriverBolt:
+		bdb, err := bolt.Open(c.Store.DSN, 0600, &bolt.Options{Timeout: boltOpenTimeout})
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not open bolt data file %s: %s", c.Store.DSN, err)
+		}
+		db, err := store.NewBoltDB(bdb)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not initialize bolt store: %s", err)
+		}
+		ks, err := keystore.NewBoltKeystore(bdb)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not initialize bolt keystore: %s", err)
+		}
+		log.Printf("[info] using embedded data file %s", c.Store.DSN)
+		return db, ks, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported store driver %s", c.Store.Driver)
+	}
+}
+
+// sealKeystore wraps the keystore such that private keys are sealed
+// at rest, if any master keys are configured
+func sealKeystore(c *config.Config, ks keystore.Keystore) (keystore.Keystore, error) {
+	mks, active, err := c.MasterKeys()
+	if err != nil {
+		return nil, err
+	}
+	if len(mks) == 0 {
+		log.Println("[warn] no keystore master keys configured, private keys will be stored in plaintext")
+		return ks, nil
+	}
+	sks, err := keystore.NewSealedKeystore(ks, mks, active)
+	if err != nil {
+		return nil, err
+	}
+	if c.Keystore.RewrapOnStart {
+		n, err := sks.Rewrap()
+		if err != nil {
+			return nil, fmt.Errorf("could not rewrap private keys: %s", err)
+		}
+		log.Printf("[info] rewrapped %d private keys with master key version %d", n, active)
+	}
+	return sks, nil
+}
+
+// auditKeyring derives the keys of audit logs from the master keys of
+// the keystore. Without master keys audit events are not keyed, and
+// anyone with access to the database can rewrite the audit logs
+func auditKeyring(c *config.Config) (*audit.Keyring, error) {
+	mks, active, err := c.MasterKeys()
+	if err != nil {
+		return nil, err
+	}
+	if len(mks) == 0 {
+		log.Println("[warn] no keystore master keys configured, audit log hashes will not be keyed")
+	}
+	return audit.NewKeyring(mks, active)
+}
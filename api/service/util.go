@@ -3,7 +3,12 @@ package service
 import (
 	"encoding/json"
 	"io/ioutil"
+	"log"
 	"net/http"
+
+	"github.com/adrianosela/padl/api/keystore"
+	"github.com/adrianosela/padl/api/store"
+	"github.com/adrianosela/padl/api/user"
 )
 
 func unmarshalRequestBody(r *http.Request, intf interface{}) error {
@@ -18,3 +23,54 @@ func unmarshalRequestBody(r *http.Request, intf interface{}) error {
 	}
 	return nil
 }
+
+// updateStatus returns the status code for a failed user or project
+// update: 409 Conflict if the record was modified concurrently, such
+// that the client can retry the request, else the given status code
+func updateStatus(err error, otherwise int) int {
+	if err == store.ErrConflict {
+		return http.StatusConflict
+	}
+	return otherwise
+}
+
+// lookupStatus returns the status code for a failed lookup of a
+// record: 404 Not Found if there is no such record, else 500
+func lookupStatus(err error) int {
+	switch err {
+	case store.ErrUserNotFound, store.ErrProjectNotFound, store.ErrInvitationNotFound,
+		store.ErrRevisionNotFound, keystore.ErrKeyNotFound:
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// addUserProject adds a project to a user's list of projects, and
+// returns the updated user. Unlike the project's own members, the list
+// is bookkeeping which can not be left behind once the project is
+// updated, so concurrent modifications of the user are retried
+func (s *Service) addUserProject(email, name string) (*user.User, error) {
+	return store.ModifyUser(s.database, email, func(u *user.User) error {
+		u.AddProject(name)
+		return nil
+	})
+}
+
+// removeUserProject removes a project from a user's list of projects,
+// retrying on concurrent modifications of the user
+func (s *Service) removeUserProject(email, name string) error {
+	_, err := store.ModifyUser(s.database, email, func(u *user.User) error {
+		u.RemoveProject(name)
+		return nil
+	})
+	return err
+}
+
+// rollback undoes the completed steps of a failed operation. Steps
+// which can not be undone are logged, and are left for the admin
+// consistency check (fsck) to find and repair
+func rollback(rb *store.Rollback, op string) {
+	if err := rb.Run(); err != nil {
+		log.Printf("could not roll back %s: %s", op, err)
+	}
+}
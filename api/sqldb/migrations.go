@@ -0,0 +1,172 @@
+package sqldb
+
+import (
+	"fmt"
+	"log"
+)
+
+// migrations is the ordered list of schema changes for the padl
+// SQL backends. Each entry is applied exactly once, in its own
+// transaction. Entries must never be edited once released -
+// schema changes are made by appending a new entry.
+// Statements must be valid for both postgres and sqlite.
+var migrations = [][]string{
+	// 1: initial schema
+	{
+		`CREATE TABLE users (
+			email       TEXT PRIMARY KEY,
+			hashed_pass TEXT NOT NULL,
+			key_id      TEXT NOT NULL,
+			projects    TEXT NOT NULL
+		)`,
+		`CREATE TABLE projects (
+			name             TEXT PRIMARY KEY,
+			description      TEXT NOT NULL,
+			project_key      TEXT NOT NULL,
+			members          TEXT NOT NULL,
+			service_accounts TEXT NOT NULL
+		)`,
+		`CREATE TABLE priv_keys (
+			id      TEXT PRIMARY KEY,
+			project TEXT NOT NULL,
+			pem     TEXT NOT NULL
+		)`,
+		`CREATE TABLE pub_keys (
+			id  TEXT PRIMARY KEY,
+			pem TEXT NOT NULL
+		)`,
+	},
+	// 2: master key version for private keys sealed at rest
+	{
+		`ALTER TABLE priv_keys ADD COLUMN key_version INTEGER NOT NULL DEFAULT 0`,
+	},
+	// 3: retired project keys
+	{
+		`ALTER TABLE projects ADD COLUMN previous_keys TEXT NOT NULL DEFAULT '[]'`,
+	},
+	// 4: secret threshold policy
+	{
+		`ALTER TABLE projects ADD COLUMN policy TEXT NOT NULL DEFAULT ''`,
+	},
+	// 5: refresh tokens
+	{
+		`CREATE TABLE refresh_tokens (
+			id         TEXT PRIMARY KEY,
+			family     TEXT NOT NULL,
+			subject    TEXT NOT NULL,
+			expires_at BIGINT NOT NULL,
+			used       INTEGER NOT NULL DEFAULT 0
+		)`,
+		`CREATE INDEX refresh_tokens_family ON refresh_tokens (family)`,
+	},
+	// 6: token revocation list
+	{
+		`CREATE TABLE revoked_tokens (
+			id         TEXT PRIMARY KEY,
+			subject    TEXT NOT NULL,
+			expires_at BIGINT NOT NULL
+		)`,
+	},
+	// 7: audit log
+	{
+		`CREATE TABLE audit_events (
+			project   TEXT NOT NULL,
+			seq       BIGINT NOT NULL,
+			at        BIGINT NOT NULL,
+			actor     TEXT NOT NULL,
+			action    TEXT NOT NULL,
+			target    TEXT NOT NULL,
+			detail    TEXT NOT NULL,
+			prev_hash TEXT NOT NULL,
+			hash      TEXT NOT NULL,
+			PRIMARY KEY (project, seq)
+		)`,
+	},
+	// 8: pending ownership transfers
+	{
+		`ALTER TABLE projects ADD COLUMN transfer TEXT NOT NULL DEFAULT 'null'`,
+	},
+	// 9: project invitations
+	{
+		`CREATE TABLE invitations (
+			id         TEXT PRIMARY KEY,
+			project    TEXT NOT NULL,
+			email      TEXT NOT NULL,
+			privilege  INTEGER NOT NULL,
+			invited_by TEXT NOT NULL,
+			created_at BIGINT NOT NULL,
+			expires_at BIGINT NOT NULL
+		)`,
+		`CREATE INDEX invitations_project ON invitations (project)`,
+		`CREATE INDEX invitations_email ON invitations (email)`,
+	},
+	// 10: server-side padlfile revisions
+	{
+		`CREATE TABLE padlfile_revisions (
+			project    TEXT NOT NULL,
+			revision   BIGINT NOT NULL,
+			author     TEXT NOT NULL,
+			created_at BIGINT NOT NULL,
+			hash       TEXT NOT NULL,
+			content    TEXT NOT NULL,
+			PRIMARY KEY (project, revision)
+		)`,
+	},
+	// 11: record versions for optimistic concurrency control
+	{
+		`ALTER TABLE users ADD COLUMN version BIGINT NOT NULL DEFAULT 0`,
+		`ALTER TABLE projects ADD COLUMN version BIGINT NOT NULL DEFAULT 0`,
+	},
+	// 12: project creation ids
+	{
+		`ALTER TABLE projects ADD COLUMN creation_id TEXT NOT NULL DEFAULT ''`,
+	},
+	// 13: keyed audit logs and their heads
+	{
+		`ALTER TABLE audit_events ADD COLUMN key_version INTEGER NOT NULL DEFAULT 0`,
+		`CREATE TABLE audit_heads (
+			project TEXT PRIMARY KEY,
+			seq     BIGINT NOT NULL,
+			hash    TEXT NOT NULL
+		)`,
+	},
+}
+
+// migrate applies all migrations newer than the schema version
+// recorded in the schema_migrations table
+func (db *DB) migrate() error {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY
+	)`); err != nil {
+		return fmt.Errorf("could not create migrations table: %s", err)
+	}
+
+	var current int
+	if err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return fmt.Errorf("could not get current schema version: %s", err)
+	}
+
+	for i := current; i < len(migrations); i++ {
+		version := i + 1
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("could not begin migration %d: %s", version, err)
+		}
+		for _, stmt := range migrations[i] {
+			if _, err := tx.Exec(stmt); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("could not apply migration %d: %s", version, err)
+			}
+		}
+		if _, err := tx.Exec(db.rebind(`INSERT INTO schema_migrations (version) VALUES (?)`), version); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("could not record migration %d: %s", version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("could not commit migration %d: %s", version, err)
+		}
+		log.Printf("[info] applied schema migration %d", version)
+	}
+
+	return nil
+}
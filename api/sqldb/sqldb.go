@@ -0,0 +1,104 @@
+package sqldb
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	// registers the "postgres" database/sql driver
+	_ "github.com/lib/pq"
+	// registers the "sqlite3" database/sql driver
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	// DriverPostgres is the name of the PostgreSQL driver
+	DriverPostgres = "postgres"
+	// DriverSQLite is the name of the SQLite driver
+	DriverSQLite = "sqlite3"
+)
+
+// DB is a thin wrapper around a *sql.DB which rewrites
+// query placeholders for the dialect of the underlying driver.
+// Queries are written with "?" placeholders
+type DB struct {
+	*sql.DB
+	driver string
+}
+
+// Open opens a connection to a SQL database and brings
+// its schema up to date by running all pending migrations
+func Open(driver, dsn string) (*DB, error) {
+	if driver != DriverPostgres && driver != DriverSQLite {
+		return nil, fmt.Errorf("unsupported sql driver %s", driver)
+	}
+	conn, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if driver == DriverSQLite {
+		// sqlite only supports a single concurrent writer
+		conn.SetMaxOpenConns(1)
+	}
+	if err = conn.Ping(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	log.Printf("[info] successfully connected to %s", driver)
+
+	db := &DB{DB: conn, driver: driver}
+	if err = db.migrate(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("could not migrate schema: %s", err)
+	}
+	return db, nil
+}
+
+// Exec executes a query without returning any rows
+func (db *DB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.DB.Exec(db.rebind(query), args...)
+}
+
+// Query executes a query that returns rows
+func (db *DB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.DB.Query(db.rebind(query), args...)
+}
+
+// QueryRow executes a query that is expected to return at most one row
+func (db *DB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return db.DB.QueryRow(db.rebind(query), args...)
+}
+
+// rebind replaces "?" placeholders with the positional
+// "$N" placeholders expected by the postgres driver
+func (db *DB) rebind(query string) string {
+	if db.driver != DriverPostgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// ExpectAffected returns the given error if a statement affected no rows
+func ExpectAffected(res sql.Result, errIfNone error) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errIfNone
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package sqldb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// tempPath returns the path of a database file in a new temporary
+// directory, and a function which removes the directory
+func tempPath(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "sqldb")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %s", err)
+	}
+	return filepath.Join(dir, "padl.db"), func() { os.RemoveAll(dir) }
+}
+
+// openSQLite opens a sqlite database at a path
+func openSQLite(t *testing.T, path string) *DB {
+	db, err := Open(DriverSQLite, path)
+	if err != nil {
+		t.Fatalf("could not open sqlite database: %s", err)
+	}
+	return db
+}
+
+func schemaVersion(t *testing.T, db *DB) int {
+	var version int
+	assert.Nil(t, db.QueryRow(`SELECT MAX(version) FROM schema_migrations`).Scan(&version))
+	return version
+}
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		testName string
+		driver   string
+		query    string
+		expect   string
+	}{
+		{
+			testName: "postgres without placeholders",
+			driver:   DriverPostgres,
+			query:    `SELECT name FROM projects`,
+			expect:   `SELECT name FROM projects`,
+		},
+		{
+			testName: "postgres single placeholder",
+			driver:   DriverPostgres,
+			query:    `SELECT name FROM projects WHERE name = ?`,
+			expect:   `SELECT name FROM projects WHERE name = $1`,
+		},
+		{
+			testName: "postgres many placeholders",
+			driver:   DriverPostgres,
+			query:    `INSERT INTO pub_keys (id, pem) VALUES (?, ?)`,
+			expect:   `INSERT INTO pub_keys (id, pem) VALUES ($1, $2)`,
+		},
+		{
+			testName: "postgres more than nine placeholders",
+			driver:   DriverPostgres,
+			query:    `VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			expect:   `VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		},
+		{
+			testName: "sqlite is unchanged",
+			driver:   DriverSQLite,
+			query:    `INSERT INTO pub_keys (id, pem) VALUES (?, ?)`,
+			expect:   `INSERT INTO pub_keys (id, pem) VALUES (?, ?)`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			db := &DB{driver: test.driver}
+			assert.Equal(t, test.expect, db.rebind(test.query))
+		})
+	}
+}
+
+func TestOpenUnsupportedDriver(t *testing.T) {
+	_, err := Open("mysql", "")
+	assert.EqualError(t, err, "unsupported sql driver mysql")
+}
+
+func TestMigrate(t *testing.T) {
+	path, cleanup := tempPath(t)
+	defer cleanup()
+
+	db := openSQLite(t, path)
+	assert.Equal(t, len(migrations), schemaVersion(t, db))
+	// every table is usable after migrating
+	_, err := db.Exec(`INSERT INTO projects (name, description, project_key, members, service_accounts) VALUES (?, ?, ?, ?, ?)`,
+		"proj", "", "key", "{}", "{}")
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+
+	// migrations are only applied once
+	db = openSQLite(t, path)
+	defer db.Close()
+	assert.Equal(t, len(migrations), schemaVersion(t, db))
+	var count int
+	assert.Nil(t, db.QueryRow(`SELECT COUNT(*) FROM schema_migrations`).Scan(&count))
+	assert.Equal(t, len(migrations), count)
+	// columns added by later migrations have their defaults
+	var previousKeys, creationID string
+	assert.Nil(t, db.QueryRow(`SELECT previous_keys, creation_id FROM projects WHERE name = ?`, "proj").Scan(&previousKeys, &creationID))
+	assert.Equal(t, "[]", previousKeys)
+	assert.Equal(t, "", creationID)
+}
+
+func TestMigratePending(t *testing.T) {
+	path, cleanup := tempPath(t)
+	defer cleanup()
+
+	// a database created by an older release
+	all := migrations
+	migrations = all[:3]
+	db := openSQLite(t, path)
+	migrations = all
+	assert.Equal(t, 3, schemaVersion(t, db))
+	_, err := db.Exec(`INSERT INTO priv_keys (id, project, pem) VALUES (?, ?, ?)`, "key", "proj", "pem")
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+
+	// only the pending migrations are applied
+	db = openSQLite(t, path)
+	defer db.Close()
+	assert.Equal(t, len(migrations), schemaVersion(t, db))
+	var version int
+	assert.Nil(t, db.QueryRow(`SELECT key_version FROM priv_keys WHERE id = ?`, "key").Scan(&version))
+	assert.Equal(t, 0, version)
+}
+
+func TestMigrateFailure(t *testing.T) {
+	path, cleanup := tempPath(t)
+	defer cleanup()
+
+	// a failing migration is rolled back, and not recorded as applied
+	all := migrations
+	migrations = append(all[:1:1], []string{
+		`CREATE TABLE partial (id TEXT PRIMARY KEY)`,
+		`NOT A STATEMENT`,
+	})
+	_, err := Open(DriverSQLite, path)
+	migrations = all
+	assert.NotNil(t, err)
+
+	db := openSQLite(t, path)
+	defer db.Close()
+	assert.Equal(t, len(migrations), schemaVersion(t, db))
+	var count int
+	assert.Nil(t, db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = ?`, "partial").Scan(&count))
+	assert.Equal(t, 0, count)
+}
@@ -0,0 +1,586 @@
+package store
+
+import (
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/adrianosela/padl/api/invitation"
+	"github.com/adrianosela/padl/api/project"
+	"github.com/adrianosela/padl/api/revision"
+	"github.com/adrianosela/padl/api/token"
+	"github.com/adrianosela/padl/api/user"
+	"github.com/adrianosela/padl/lib/audit"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	usersBucket         = []byte("users")
+	projectsBucket      = []byte("projects")
+	refreshTokensBucket = []byte("refresh_tokens")
+	revokedTokensBucket = []byte("revoked_tokens")
+	invitationsBucket   = []byte("invitations")
+	revisionsBucket     = []byte("padlfile_revisions")
+	auditEventsBucket   = []byte("audit_events")
+	auditHeadsBucket    = []byte("audit_heads")
+)
+
+// BoltDB is an embedded, single-file implementation of the
+// Database interface. Every write runs in its own bolt
+// transaction, and is therefore atomic
+type BoltDB struct {
+	db *bolt.DB
+}
+
+// NewBoltDB is the constructor for BoltDB. It creates the
+// buckets it needs in the given bolt file if not present
+func NewBoltDB(db *bolt.DB) (*BoltDB, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{usersBucket, projectsBucket, refreshTokensBucket, revokedTokensBucket, invitationsBucket, revisionsBucket, auditEventsBucket, auditHeadsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &BoltDB{db: db}, nil
+}
+
+// PutUser adds a new user to the database
+func (db *BoltDB) PutUser(usr *user.User) error {
+	return db.db.Update(func(tx *bolt.Tx) error {
+		return boltInsert(tx.Bucket(usersBucket), usr.Email, usr, ErrUserExists)
+	})
+}
+
+// GetUser gets a user from the database
+func (db *BoltDB) GetUser(email string) (*user.User, error) {
+	var usr user.User
+	err := db.db.View(func(tx *bolt.Tx) error {
+		return boltGet(tx.Bucket(usersBucket), email, &usr, ErrUserNotFound)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &usr, nil
+}
+
+// UserExists returns true if a user with given email exists
+func (db *BoltDB) UserExists(email string) (bool, error) {
+	var exists bool
+	err := db.db.View(func(tx *bolt.Tx) error {
+		exists = tx.Bucket(usersBucket).Get([]byte(email)) != nil
+		return nil
+	})
+	return exists, err
+}
+
+// ListUserEmails returns the emails of all users in the database
+func (db *BoltDB) ListUserEmails() ([]string, error) {
+	return db.listKeys(usersBucket)
+}
+
+// UpdateUser updates a user in the database
+func (db *BoltDB) UpdateUser(usr *user.User) error {
+	next := *usr
+	next.Version++
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		return boltReplace(tx.Bucket(usersBucket), usr.Email, usr.Version, &next, ErrUserNotFound)
+	})
+	if err != nil {
+		return err
+	}
+	usr.Version = next.Version
+	return nil
+}
+
+// DeleteUser deletes a user from the database
+func (db *BoltDB) DeleteUser(email string) error {
+	return db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(usersBucket)
+		if b.Get([]byte(email)) == nil {
+			return ErrUserNotFound
+		}
+		return b.Delete([]byte(email))
+	})
+}
+
+// PutProject adds a new project to the database
+func (db *BoltDB) PutProject(p *project.Project) error {
+	return db.db.Update(func(tx *bolt.Tx) error {
+		return boltInsert(tx.Bucket(projectsBucket), p.Name, p, ErrProjectExists)
+	})
+}
+
+// GetProject gets a project from the database
+func (db *BoltDB) GetProject(name string) (*project.Project, error) {
+	var p project.Project
+	err := db.db.View(func(tx *bolt.Tx) error {
+		return boltGet(tx.Bucket(projectsBucket), name, &p, ErrProjectNotFound)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// UpdateProject updates a project in the database
+func (db *BoltDB) UpdateProject(p *project.Project) error {
+	next := *p
+	next.Version++
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		return boltReplace(tx.Bucket(projectsBucket), p.Name, p.Version, &next, ErrProjectNotFound)
+	})
+	if err != nil {
+		return err
+	}
+	p.Version = next.Version
+	return nil
+}
+
+// DeleteProject deletes a project from the database
+func (db *BoltDB) DeleteProject(name string) error {
+	return db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(projectsBucket)
+		if b.Get([]byte(name)) == nil {
+			return ErrProjectNotFound
+		}
+		return b.Delete([]byte(name))
+	})
+}
+
+// ProjectExists returns true if a project with that name already exists
+func (db *BoltDB) ProjectExists(name string) (bool, error) {
+	var exists bool
+	err := db.db.View(func(tx *bolt.Tx) error {
+		exists = tx.Bucket(projectsBucket).Get([]byte(name)) != nil
+		return nil
+	})
+	return exists, err
+}
+
+// ListProjects returns a list of requested (by name) projects
+func (db *BoltDB) ListProjects(names []string) ([]*project.Project, error) {
+	prjs := []*project.Project{}
+	err := db.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(projectsBucket)
+		for _, n := range names {
+			byt := b.Get([]byte(n))
+			if byt == nil {
+				continue
+			}
+			var p project.Project
+			if err := json.Unmarshal(byt, &p); err != nil {
+				return err
+			}
+			prjs = append(prjs, &p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return prjs, nil
+}
+
+// ListProjectNames returns the names of all projects in the database
+func (db *BoltDB) ListProjectNames() ([]string, error) {
+	return db.listKeys(projectsBucket)
+}
+
+// listKeys returns the (sorted) keys of a bucket
+func (db *BoltDB) listKeys(bucket []byte) ([]string, error) {
+	keys := []string{}
+	err := db.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).ForEach(func(k, _ []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// PutRefreshToken adds a refresh token to the database
+func (db *BoltDB) PutRefreshToken(rt *token.RefreshToken) error {
+	return db.db.Update(func(tx *bolt.Tx) error {
+		return boltPut(tx.Bucket(refreshTokensBucket), rt.ID, rt)
+	})
+}
+
+// GetRefreshToken gets a refresh token by id from the database
+func (db *BoltDB) GetRefreshToken(id string) (*token.RefreshToken, error) {
+	var rt token.RefreshToken
+	err := db.db.View(func(tx *bolt.Tx) error {
+		return boltGet(tx.Bucket(refreshTokensBucket), id, &rt, ErrRefreshTokenNotFound)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &rt, nil
+}
+
+// UseRefreshToken marks a refresh token as used, failing
+// with ErrRefreshTokenUsed if it had already been used
+func (db *BoltDB) UseRefreshToken(id string) error {
+	return db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(refreshTokensBucket)
+		var rt token.RefreshToken
+		if err := boltGet(b, id, &rt, ErrRefreshTokenNotFound); err != nil {
+			return err
+		}
+		if rt.Used {
+			return ErrRefreshTokenUsed
+		}
+		rt.Used = true
+		return boltPut(b, id, &rt)
+	})
+}
+
+// DeleteRefreshTokenFamily deletes all refresh tokens in a family
+func (db *BoltDB) DeleteRefreshTokenFamily(family string) error {
+	return db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(refreshTokensBucket)
+		ids := [][]byte{}
+		err := b.ForEach(func(k, v []byte) error {
+			var rt token.RefreshToken
+			if err := json.Unmarshal(v, &rt); err != nil {
+				return err
+			}
+			if rt.Family == family {
+				ids = append(ids, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// keys can not be deleted while iterating
+		for _, id := range ids {
+			if err := b.Delete(id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// RevokeToken adds a token to the revocation list, failing
+// with ErrTokenRevoked if it is already in the list
+func (db *BoltDB) RevokeToken(rt *token.RevokedToken) error {
+	return db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(revokedTokensBucket)
+		// prune entries for tokens which have expired anyway
+		expired := [][]byte{}
+		err := b.ForEach(func(k, v []byte) error {
+			var revoked token.RevokedToken
+			if err := json.Unmarshal(v, &revoked); err != nil {
+				return err
+			}
+			if revoked.Expired() {
+				expired = append(expired, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, id := range expired {
+			if err := b.Delete(id); err != nil {
+				return err
+			}
+		}
+		return boltInsert(b, rt.ID, rt, ErrTokenRevoked)
+	})
+}
+
+// IsTokenRevoked returns true if a token id is in the revocation list
+func (db *BoltDB) IsTokenRevoked(id string) (bool, error) {
+	var revoked bool
+	err := db.db.View(func(tx *bolt.Tx) error {
+		revoked = tx.Bucket(revokedTokensBucket).Get([]byte(id)) != nil
+		return nil
+	})
+	return revoked, err
+}
+
+// PutInvitation adds an invitation to the database,
+// dropping any invitations which have expired
+func (db *BoltDB) PutInvitation(inv *invitation.Invitation) error {
+	return db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(invitationsBucket)
+		expired := [][]byte{}
+		err := b.ForEach(func(k, v []byte) error {
+			var i invitation.Invitation
+			if err := json.Unmarshal(v, &i); err != nil {
+				return err
+			}
+			if i.Expired() {
+				expired = append(expired, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, id := range expired {
+			if err := b.Delete(id); err != nil {
+				return err
+			}
+		}
+		return boltPut(b, inv.ID, inv)
+	})
+}
+
+// GetInvitation gets an invitation by id from the database
+func (db *BoltDB) GetInvitation(id string) (*invitation.Invitation, error) {
+	var inv invitation.Invitation
+	err := db.db.View(func(tx *bolt.Tx) error {
+		return boltGet(tx.Bucket(invitationsBucket), id, &inv, ErrInvitationNotFound)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+// ListUserInvitations returns the invitations made out to a user
+func (db *BoltDB) ListUserInvitations(email string) ([]*invitation.Invitation, error) {
+	return db.listInvitations(func(inv *invitation.Invitation) bool { return inv.Email == email })
+}
+
+// ListProjectInvitations returns the invitations to join a project
+func (db *BoltDB) ListProjectInvitations(name string) ([]*invitation.Invitation, error) {
+	return db.listInvitations(func(inv *invitation.Invitation) bool { return inv.Project == name })
+}
+
+func (db *BoltDB) listInvitations(match func(*invitation.Invitation) bool) ([]*invitation.Invitation, error) {
+	invs := []*invitation.Invitation{}
+	err := db.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(invitationsBucket).ForEach(func(k, v []byte) error {
+			var inv invitation.Invitation
+			if err := json.Unmarshal(v, &inv); err != nil {
+				return err
+			}
+			if match(&inv) {
+				invs = append(invs, &inv)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return invs, nil
+}
+
+// DeleteInvitation deletes an invitation from the database
+func (db *BoltDB) DeleteInvitation(id string) error {
+	return db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(invitationsBucket)
+		if b.Get([]byte(id)) == nil {
+			return ErrInvitationNotFound
+		}
+		return b.Delete([]byte(id))
+	})
+}
+
+// PutPadlfileRevision adds a revision of a project's padlfile to the
+// database. Each project's revisions are a nested bucket keyed by
+// big-endian revision number, such that the last key is the latest
+func (db *BoltDB) PutPadlfileRevision(rev *revision.Revision) error {
+	return db.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket(revisionsBucket).CreateBucketIfNotExists([]byte(rev.Project))
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, rev.Number)
+		if b.Get(key) != nil {
+			return ErrRevisionExists
+		}
+		byt, err := json.Marshal(rev)
+		if err != nil {
+			return err
+		}
+		return b.Put(key, byt)
+	})
+}
+
+// GetPadlfileRevision gets a revision of a project's padlfile
+// from the database, revision 0 being the latest revision
+func (db *BoltDB) GetPadlfileRevision(project string, number uint64) (*revision.Revision, error) {
+	var rev revision.Revision
+	err := db.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(revisionsBucket).Bucket([]byte(project))
+		if b == nil {
+			return ErrRevisionNotFound
+		}
+		var v []byte
+		if number == 0 {
+			_, v = b.Cursor().Last()
+		} else {
+			key := make([]byte, 8)
+			binary.BigEndian.PutUint64(key, number)
+			v = b.Get(key)
+		}
+		if v == nil {
+			return ErrRevisionNotFound
+		}
+		return json.Unmarshal(v, &rev)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &rev, nil
+}
+
+// ListPadlfileRevisions returns the revisions of a project's padlfile, in order
+func (db *BoltDB) ListPadlfileRevisions(project string) ([]*revision.Revision, error) {
+	revs := []*revision.Revision{}
+	err := db.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(revisionsBucket).Bucket([]byte(project))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var rev revision.Revision
+			if err := json.Unmarshal(v, &rev); err != nil {
+				return err
+			}
+			revs = append(revs, &rev)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return revs, nil
+}
+
+// DeletePadlfileRevisions deletes all revisions of a project's padlfile
+func (db *BoltDB) DeletePadlfileRevisions(project string) error {
+	return db.db.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket(revisionsBucket).DeleteBucket([]byte(project))
+		if err == bolt.ErrBucketNotFound {
+			return nil
+		}
+		return err
+	})
+}
+
+// AppendAuditEvent links an event onto its project's audit log.
+// Each project's log is a nested bucket keyed by big-endian sequence
+// number, such that the last key holds the last event. The head of
+// the log is written in the same transaction
+func (db *BoltDB) AppendAuditEvent(e *audit.Event, k *audit.Key) error {
+	return db.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket(auditEventsBucket).CreateBucketIfNotExists([]byte(e.Project))
+		if err != nil {
+			return err
+		}
+		var prev *audit.Event
+		if _, v := b.Cursor().Last(); v != nil {
+			prev = &audit.Event{}
+			if err := json.Unmarshal(v, prev); err != nil {
+				return err
+			}
+		}
+		e.Link(prev, k)
+		byt, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, e.Seq)
+		if err = b.Put(key, byt); err != nil {
+			return err
+		}
+		return boltPut(tx.Bucket(auditHeadsBucket), e.Project, e.Head())
+	})
+}
+
+// ListAuditEvents returns a project's audit events matching a filter, in order
+func (db *BoltDB) ListAuditEvents(project string, f *audit.Filter) ([]*audit.Event, error) {
+	events := []*audit.Event{}
+	err := db.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(auditEventsBucket).Bucket([]byte(project))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var e audit.Event
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			if f.Match(&e) {
+				events = append(events, &e)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// GetAuditHead returns the head of a project's audit log, or nil if it has none
+func (db *BoltDB) GetAuditHead(project string) (*audit.Head, error) {
+	var head *audit.Head
+	err := db.db.View(func(tx *bolt.Tx) error {
+		byt := tx.Bucket(auditHeadsBucket).Get([]byte(project))
+		if byt == nil {
+			return nil
+		}
+		head = &audit.Head{}
+		return json.Unmarshal(byt, head)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return head, nil
+}
+
+// boltInsert writes a json-encoded value under a key which must not exist
+func boltInsert(b *bolt.Bucket, key string, v interface{}, errIfExists error) error {
+	if b.Get([]byte(key)) != nil {
+		return errIfExists
+	}
+	return boltPut(b, key, v)
+}
+
+// boltReplace writes a json-encoded value under a key which must exist,
+// and whose current value must be at the given version
+func boltReplace(b *bolt.Bucket, key string, version int, v interface{}, errIfNotFound error) error {
+	var stored struct{ Version int }
+	if err := boltGet(b, key, &stored, errIfNotFound); err != nil {
+		return err
+	}
+	if stored.Version != version {
+		return ErrConflict
+	}
+	return boltPut(b, key, v)
+}
+
+func boltPut(b *bolt.Bucket, key string, v interface{}) error {
+	byt, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(key), byt)
+}
+
+// boltGet reads a json-encoded value under a key onto v
+func boltGet(b *bolt.Bucket, key string, v interface{}, errIfNotFound error) error {
+	byt := b.Get([]byte(key))
+	if byt == nil {
+		return errIfNotFound
+	}
+	return json.Unmarshal(byt, v)
+}
@@ -1,17 +1,86 @@
 package store
 
 import (
+	"errors"
+
+	"github.com/adrianosela/padl/api/invitation"
 	"github.com/adrianosela/padl/api/project"
+	"github.com/adrianosela/padl/api/revision"
+	"github.com/adrianosela/padl/api/token"
 	"github.com/adrianosela/padl/api/user"
+	"github.com/adrianosela/padl/lib/audit"
 )
 
-// Database represents all database operations
-// for the padl API
+var (
+	// ErrUserExists is returned when PutUser() is called
+	// with a user whose email is already registered
+	ErrUserExists = errors.New("a padl account is already associated with that email")
+
+	// ErrUserNotFound is returned when GetUser(), UpdateUser() or
+	// DeleteUser() is called for an email that is not in the database
+	ErrUserNotFound = errors.New("user not found")
+
+	// ErrProjectExists is returned when PutProject() is called
+	// with a project whose name is already taken
+	ErrProjectExists = errors.New("project already exists in the DB")
+
+	// ErrProjectNotFound is returned when GetProject(), UpdateProject(),
+	// or DeleteProject() is called for a project not in the database
+	ErrProjectNotFound = errors.New("project not found")
+
+	// ErrConflict is returned when UpdateUser() or UpdateProject() is
+	// called with a record which was modified since it was read, i.e.
+	// whose version is not the one in the database
+	ErrConflict = errors.New("record was modified concurrently")
+
+	// ErrRefreshTokenNotFound is returned when GetRefreshToken() or
+	// UseRefreshToken() is called for a token not in the database
+	ErrRefreshTokenNotFound = errors.New("refresh token not found")
+
+	// ErrRefreshTokenUsed is returned when UseRefreshToken()
+	// is called for a token which has already been used
+	ErrRefreshTokenUsed = errors.New("refresh token already used")
+
+	// ErrTokenRevoked is returned when RevokeToken() is called
+	// for a token which is already in the revocation list
+	ErrTokenRevoked = errors.New("token already revoked")
+
+	// ErrInvitationNotFound is returned when GetInvitation() or
+	// DeleteInvitation() is called for an invitation not in the database
+	ErrInvitationNotFound = errors.New("invitation not found")
+
+	// ErrRevisionExists is returned when PutPadlfileRevision() is called
+	// with a revision number which a project's padlfile already has
+	ErrRevisionExists = errors.New("padlfile revision already exists")
+
+	// ErrRevisionNotFound is returned when GetPadlfileRevision()
+	// is called for a revision which is not in the database
+	ErrRevisionNotFound = errors.New("padlfile revision not found")
+
+	// ErrAuditLogContention is returned when AppendAuditEvent() keeps
+	// losing the race to append to a project's audit log
+	ErrAuditLogContention = errors.New("could not append to audit log under contention")
+)
+
+// maxAuditAppendAttempts is how many times appending an audit event
+// is attempted when other events are concurrently appended
+const maxAuditAppendAttempts = 5
+
+// maxUpdateAttempts is how many times a read-modify-write of a user or
+// project is attempted when the record is concurrently modified
+const maxUpdateAttempts = 5
+
+// Database represents all database operations for the padl API.
+// UpdateUser() and UpdateProject() only succeed if the version of the
+// given record is that in the database, and increment it on success.
+// RevokeToken() only succeeds for one of concurrent revocations of a token
 type Database interface {
 	PutUser(*user.User) error
 	GetUser(string) (*user.User, error)
 	UserExists(string) (bool, error)
+	ListUserEmails() ([]string, error)
 	UpdateUser(*user.User) error
+	DeleteUser(string) error
 
 	PutProject(*project.Project) error
 	GetProject(string) (*project.Project, error)
@@ -19,4 +88,76 @@ type Database interface {
 	DeleteProject(string) error
 	ProjectExists(string) (bool, error)
 	ListProjects([]string) ([]*project.Project, error)
+	ListProjectNames() ([]string, error)
+
+	PutRefreshToken(*token.RefreshToken) error
+	GetRefreshToken(string) (*token.RefreshToken, error)
+	UseRefreshToken(string) error
+	DeleteRefreshTokenFamily(string) error
+
+	RevokeToken(*token.RevokedToken) error
+	IsTokenRevoked(string) (bool, error)
+
+	PutInvitation(*invitation.Invitation) error
+	GetInvitation(string) (*invitation.Invitation, error)
+	ListUserInvitations(string) ([]*invitation.Invitation, error)
+	ListProjectInvitations(string) ([]*invitation.Invitation, error)
+	DeleteInvitation(string) error
+
+	PutPadlfileRevision(*revision.Revision) error
+	GetPadlfileRevision(string, uint64) (*revision.Revision, error)
+	ListPadlfileRevisions(string) ([]*revision.Revision, error)
+	DeletePadlfileRevisions(string) error
+
+	AppendAuditEvent(*audit.Event, *audit.Key) error
+	ListAuditEvents(string, *audit.Filter) ([]*audit.Event, error)
+	GetAuditHead(string) (*audit.Head, error)
+}
+
+// ModifyUser reads a user, applies a change to it and writes it back.
+// The change is re-applied to a fresh read of the user for as long as
+// the user is concurrently modified, up to maxUpdateAttempts times. An
+// error returned by the change aborts the modification
+func ModifyUser(db Database, email string, change func(*user.User) error) (*user.User, error) {
+	for i := 0; i < maxUpdateAttempts; i++ {
+		usr, err := db.GetUser(email)
+		if err != nil {
+			return nil, err
+		}
+		if err = change(usr); err != nil {
+			return nil, err
+		}
+		err = db.UpdateUser(usr)
+		if err == nil {
+			return usr, nil
+		}
+		if err != ErrConflict {
+			return nil, err
+		}
+	}
+	return nil, ErrConflict
+}
+
+// ModifyProject reads a project, applies a change to it and writes it
+// back. The change is re-applied to a fresh read of the project for as
+// long as the project is concurrently modified, up to maxUpdateAttempts
+// times. An error returned by the change aborts the modification
+func ModifyProject(db Database, name string, change func(*project.Project) error) (*project.Project, error) {
+	for i := 0; i < maxUpdateAttempts; i++ {
+		p, err := db.GetProject(name)
+		if err != nil {
+			return nil, err
+		}
+		if err = change(p); err != nil {
+			return nil, err
+		}
+		err = db.UpdateProject(p)
+		if err == nil {
+			return p, nil
+		}
+		if err != ErrConflict {
+			return nil, err
+		}
+	}
+	return nil, ErrConflict
 }
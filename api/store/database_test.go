@@ -0,0 +1,451 @@
+package store
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adrianosela/padl/api/invitation"
+	"github.com/adrianosela/padl/api/privilege"
+	"github.com/adrianosela/padl/api/project"
+	"github.com/adrianosela/padl/api/revision"
+	"github.com/adrianosela/padl/api/sqldb"
+	"github.com/adrianosela/padl/api/token"
+	"github.com/adrianosela/padl/api/user"
+	"github.com/adrianosela/padl/lib/audit"
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+)
+
+// backends are the Database implementations which do not need a
+// server, each opened empty along with a function which closes it
+var backends = []struct {
+	name string
+	open func(t *testing.T) (Database, func())
+	// concurrent is false for backends which are not safe for concurrent use
+	concurrent bool
+}{
+	{
+		name: "mock",
+		open: func(t *testing.T) (Database, func()) {
+			return NewMockDatabase(), func() {}
+		},
+	},
+	{
+		name: "bolt",
+		open: func(t *testing.T) (Database, func()) {
+			dir := tempDir(t)
+			bdb, err := bolt.Open(filepath.Join(dir, "padl.db"), 0600, nil)
+			if err != nil {
+				t.Fatalf("could not open bolt database: %s", err)
+			}
+			db, err := NewBoltDB(bdb)
+			if err != nil {
+				t.Fatalf("could not create bolt store: %s", err)
+			}
+			return db, func() { bdb.Close(); os.RemoveAll(dir) }
+		},
+		concurrent: true,
+	},
+	{
+		name: "sqlite",
+		open: func(t *testing.T) (Database, func()) {
+			dir := tempDir(t)
+			sdb, err := sqldb.Open(sqldb.DriverSQLite, filepath.Join(dir, "padl.db"))
+			if err != nil {
+				t.Fatalf("could not open sqlite database: %s", err)
+			}
+			return NewSQLDatabase(sdb), func() { sdb.Close(); os.RemoveAll(dir) }
+		},
+		concurrent: true,
+	},
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %s", err)
+	}
+	return dir
+}
+
+// TestDatabase runs the same behavior tests against every backend
+func TestDatabase(t *testing.T) {
+	tests := []struct {
+		testName string
+		run      func(*testing.T, Database)
+	}{
+		{testName: "users", run: testUsers},
+		{testName: "user conflicts", run: testUserConflicts},
+		{testName: "projects", run: testProjects},
+		{testName: "project conflicts", run: testProjectConflicts},
+		{testName: "refresh tokens", run: testRefreshTokens},
+		{testName: "revoked tokens", run: testRevokedTokens},
+		{testName: "invitations", run: testInvitations},
+		{testName: "padlfile revisions", run: testRevisions},
+		{testName: "audit events", run: testAuditEvents},
+	}
+
+	for _, backend := range backends {
+		for _, test := range tests {
+			t.Run(backend.name+"/"+test.testName, func(t *testing.T) {
+				db, close := backend.open(t)
+				defer close()
+				test.run(t, db)
+			})
+		}
+	}
+}
+
+func testUsers(t *testing.T, db Database) {
+	usr := &user.User{Email: "a@padl.io", HashedPass: "hash", KeyID: "key", Projects: []string{"proj"}}
+	assert.Nil(t, db.PutUser(usr))
+	assert.Equal(t, ErrUserExists, db.PutUser(usr))
+
+	got, err := db.GetUser("a@padl.io")
+	assert.Nil(t, err)
+	assert.Equal(t, usr, got)
+	exists, err := db.UserExists("a@padl.io")
+	assert.Nil(t, err)
+	assert.True(t, exists)
+
+	_, err = db.GetUser("b@padl.io")
+	assert.Equal(t, ErrUserNotFound, err)
+	exists, err = db.UserExists("b@padl.io")
+	assert.Nil(t, err)
+	assert.False(t, exists)
+
+	assert.Nil(t, db.PutUser(&user.User{Email: "b@padl.io", Projects: []string{}}))
+	emails, err := db.ListUserEmails()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a@padl.io", "b@padl.io"}, emails)
+
+	got.Projects = append(got.Projects, "other")
+	assert.Nil(t, db.UpdateUser(got))
+	assert.Equal(t, 1, got.Version)
+	got, err = db.GetUser("a@padl.io")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"proj", "other"}, got.Projects)
+	assert.Equal(t, 1, got.Version)
+
+	assert.Nil(t, db.DeleteUser("a@padl.io"))
+	assert.Equal(t, ErrUserNotFound, db.DeleteUser("a@padl.io"))
+	assert.Equal(t, ErrUserNotFound, db.UpdateUser(got))
+}
+
+func testUserConflicts(t *testing.T, db Database) {
+	assert.Nil(t, db.PutUser(&user.User{Email: "a@padl.io", Projects: []string{}}))
+
+	// two concurrent read-modify-writes of the same version
+	first, err := db.GetUser("a@padl.io")
+	assert.Nil(t, err)
+	second, err := db.GetUser("a@padl.io")
+	assert.Nil(t, err)
+	first.AddProject("first")
+	assert.Nil(t, db.UpdateUser(first))
+	second.AddProject("second")
+	assert.Equal(t, ErrConflict, db.UpdateUser(second))
+
+	// the loser changed nothing
+	got, err := db.GetUser("a@padl.io")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"first"}, got.Projects)
+
+	// and ModifyUser retries it on a fresh read
+	got, err = ModifyUser(db, "a@padl.io", func(u *user.User) error {
+		u.AddProject("second")
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"first", "second"}, got.Projects)
+}
+
+func testProjects(t *testing.T, db Database) {
+	p := project.NewProject("proj", "a project", "a@padl.io", "key")
+	assert.Nil(t, p.AddUser("b@padl.io", privilege.PrivilegeLvlReader))
+	p.SetServiceAccount("ci", "ci-key")
+	assert.Nil(t, db.PutProject(p))
+	assert.Equal(t, ErrProjectExists, db.PutProject(p))
+
+	got, err := db.GetProject("proj")
+	assert.Nil(t, err)
+	assert.Equal(t, p.Name, got.Name)
+	assert.Equal(t, p.Description, got.Description)
+	assert.Equal(t, p.ProjectKey, got.ProjectKey)
+	assert.Equal(t, p.Members, got.Members)
+	assert.Equal(t, p.ServiceAccounts, got.ServiceAccounts)
+	assert.Equal(t, p.CreationID, got.CreationID)
+	exists, err := db.ProjectExists("proj")
+	assert.Nil(t, err)
+	assert.True(t, exists)
+
+	_, err = db.GetProject("other")
+	assert.Equal(t, ErrProjectNotFound, err)
+	exists, err = db.ProjectExists("other")
+	assert.Nil(t, err)
+	assert.False(t, exists)
+
+	assert.Nil(t, db.PutProject(project.NewProject("other", "", "a@padl.io", "other-key")))
+	names, err := db.ListProjectNames()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"other", "proj"}, names)
+	projects, err := db.ListProjects([]string{"proj", "missing"})
+	assert.Nil(t, err)
+	if assert.Len(t, projects, 1) {
+		assert.Equal(t, "proj", projects[0].Name)
+	}
+	projects, err = db.ListProjects([]string{})
+	assert.Nil(t, err)
+	assert.Empty(t, projects)
+
+	got.Description = "changed"
+	assert.Nil(t, got.RemoveUser("b@padl.io"))
+	assert.Nil(t, db.UpdateProject(got))
+	assert.Equal(t, 1, got.Version)
+	got, err = db.GetProject("proj")
+	assert.Nil(t, err)
+	assert.Equal(t, "changed", got.Description)
+	assert.False(t, got.HasUser("b@padl.io"))
+	assert.Equal(t, 1, got.Version)
+
+	assert.Nil(t, db.DeleteProject("proj"))
+	assert.Equal(t, ErrProjectNotFound, db.DeleteProject("proj"))
+	assert.Equal(t, ErrProjectNotFound, db.UpdateProject(got))
+}
+
+func testProjectConflicts(t *testing.T, db Database) {
+	assert.Nil(t, db.PutProject(project.NewProject("proj", "", "a@padl.io", "key")))
+
+	// two concurrent read-modify-writes of the same version
+	first, err := db.GetProject("proj")
+	assert.Nil(t, err)
+	second, err := db.GetProject("proj")
+	assert.Nil(t, err)
+	first.Description = "first"
+	assert.Nil(t, db.UpdateProject(first))
+	second.Description = "second"
+	assert.Equal(t, ErrConflict, db.UpdateProject(second))
+
+	// the loser changed nothing
+	got, err := db.GetProject("proj")
+	assert.Nil(t, err)
+	assert.Equal(t, "first", got.Description)
+
+	// and ModifyProject retries it on a fresh read
+	got, err = ModifyProject(db, "proj", func(p *project.Project) error {
+		p.Description += " second"
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "first second", got.Description)
+	assert.Equal(t, 2, got.Version)
+}
+
+func testRefreshTokens(t *testing.T, db Database) {
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	for _, id := range []string{"one", "two"} {
+		assert.Nil(t, db.PutRefreshToken(&token.RefreshToken{ID: id, Family: "family", Subject: "a@padl.io", ExpiresAt: expiry}))
+	}
+
+	rt, err := db.GetRefreshToken("one")
+	assert.Nil(t, err)
+	assert.Equal(t, "family", rt.Family)
+	assert.Equal(t, "a@padl.io", rt.Subject)
+	assert.True(t, expiry.Equal(rt.ExpiresAt))
+	assert.False(t, rt.Used)
+	_, err = db.GetRefreshToken("missing")
+	assert.Equal(t, ErrRefreshTokenNotFound, err)
+
+	// a token can only be used once
+	assert.Nil(t, db.UseRefreshToken("one"))
+	assert.Equal(t, ErrRefreshTokenUsed, db.UseRefreshToken("one"))
+	assert.Equal(t, ErrRefreshTokenNotFound, db.UseRefreshToken("missing"))
+	rt, err = db.GetRefreshToken("one")
+	assert.Nil(t, err)
+	assert.True(t, rt.Used)
+
+	assert.Nil(t, db.DeleteRefreshTokenFamily("family"))
+	for _, id := range []string{"one", "two"} {
+		_, err = db.GetRefreshToken(id)
+		assert.Equal(t, ErrRefreshTokenNotFound, err)
+	}
+}
+
+func testRevokedTokens(t *testing.T, db Database) {
+	revoked, err := db.IsTokenRevoked("jti")
+	assert.Nil(t, err)
+	assert.False(t, revoked)
+
+	assert.Nil(t, db.RevokeToken(&token.RevokedToken{ID: "jti", Subject: "a@padl.io", ExpiresAt: time.Now().Add(time.Hour)}))
+	revoked, err = db.IsTokenRevoked("jti")
+	assert.Nil(t, err)
+	assert.True(t, revoked)
+	assert.Equal(t, ErrTokenRevoked, db.RevokeToken(&token.RevokedToken{ID: "jti", Subject: "a@padl.io", ExpiresAt: time.Now().Add(time.Hour)}))
+
+	// expired entries are pruned by later revocations
+	assert.Nil(t, db.RevokeToken(&token.RevokedToken{ID: "expired", Subject: "a@padl.io", ExpiresAt: time.Now().Add(-time.Hour)}))
+	assert.Nil(t, db.RevokeToken(&token.RevokedToken{ID: "other", Subject: "a@padl.io", ExpiresAt: time.Now().Add(time.Hour)}))
+	revoked, err = db.IsTokenRevoked("expired")
+	assert.Nil(t, err)
+	assert.False(t, revoked)
+	revoked, err = db.IsTokenRevoked("jti")
+	assert.Nil(t, err)
+	assert.True(t, revoked)
+}
+
+func testInvitations(t *testing.T, db Database) {
+	inv, err := invitation.NewInvitation("proj", "b@padl.io", "a@padl.io", privilege.PrivilegeLvlEditor, time.Hour)
+	assert.Nil(t, err)
+	assert.Nil(t, db.PutInvitation(inv))
+
+	got, err := db.GetInvitation(inv.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, inv.Project, got.Project)
+	assert.Equal(t, inv.Email, got.Email)
+	assert.Equal(t, inv.InvitedBy, got.InvitedBy)
+	assert.Equal(t, inv.PrivilegeLvl, got.PrivilegeLvl)
+	assert.Equal(t, inv.ExpiresAt.Unix(), got.ExpiresAt.Unix())
+	_, err = db.GetInvitation("missing")
+	assert.Equal(t, ErrInvitationNotFound, err)
+
+	invs, err := db.ListUserInvitations("b@padl.io")
+	assert.Nil(t, err)
+	if assert.Len(t, invs, 1) {
+		assert.Equal(t, inv.ID, invs[0].ID)
+	}
+	invs, err = db.ListProjectInvitations("proj")
+	assert.Nil(t, err)
+	if assert.Len(t, invs, 1) {
+		assert.Equal(t, inv.ID, invs[0].ID)
+	}
+	invs, err = db.ListUserInvitations("a@padl.io")
+	assert.Nil(t, err)
+	assert.Empty(t, invs)
+
+	// expired invitations are dropped by later invitations
+	expired, err := invitation.NewInvitation("proj", "c@padl.io", "a@padl.io", privilege.PrivilegeLvlReader, -time.Hour)
+	assert.Nil(t, err)
+	assert.Nil(t, db.PutInvitation(expired))
+	next, err := invitation.NewInvitation("proj", "d@padl.io", "a@padl.io", privilege.PrivilegeLvlReader, time.Hour)
+	assert.Nil(t, err)
+	assert.Nil(t, db.PutInvitation(next))
+	_, err = db.GetInvitation(expired.ID)
+	assert.Equal(t, ErrInvitationNotFound, err)
+
+	assert.Nil(t, db.DeleteInvitation(inv.ID))
+	assert.Equal(t, ErrInvitationNotFound, db.DeleteInvitation(inv.ID))
+}
+
+func testRevisions(t *testing.T, db Database) {
+	_, err := db.GetPadlfileRevision("proj", 0)
+	assert.Equal(t, ErrRevisionNotFound, err)
+
+	for i := uint64(1); i <= 3; i++ {
+		assert.Nil(t, db.PutPadlfileRevision(revision.NewRevision("proj", i, "a@padl.io", "content")))
+	}
+	// only one upload of a revision can succeed
+	assert.Equal(t, ErrRevisionExists, db.PutPadlfileRevision(revision.NewRevision("proj", 2, "b@padl.io", "other")))
+
+	latest, err := db.GetPadlfileRevision("proj", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), latest.Number)
+	second, err := db.GetPadlfileRevision("proj", 2)
+	assert.Nil(t, err)
+	assert.Equal(t, "a@padl.io", second.Author)
+	assert.Equal(t, revision.Hash("content"), second.Hash)
+	_, err = db.GetPadlfileRevision("proj", 4)
+	assert.Equal(t, ErrRevisionNotFound, err)
+
+	revs, err := db.ListPadlfileRevisions("proj")
+	assert.Nil(t, err)
+	if assert.Len(t, revs, 3) {
+		for i, rev := range revs {
+			assert.Equal(t, uint64(i+1), rev.Number)
+		}
+	}
+
+	assert.Nil(t, db.DeletePadlfileRevisions("proj"))
+	revs, err = db.ListPadlfileRevisions("proj")
+	assert.Nil(t, err)
+	assert.Empty(t, revs)
+}
+
+func testAuditEvents(t *testing.T, db Database) {
+	kr, err := audit.NewKeyring(map[int][]byte{1: bytes.Repeat([]byte{1}, 32)}, 1)
+	assert.Nil(t, err)
+
+	head, err := db.GetAuditHead("proj")
+	assert.Nil(t, err)
+	assert.Nil(t, head)
+
+	for _, actor := range []string{"a@padl.io", "b@padl.io", "a@padl.io"} {
+		assert.Nil(t, db.AppendAuditEvent(audit.NewEvent("proj", actor, audit.ActionProjectCreate, "", ""), kr.Active()))
+	}
+	assert.Nil(t, db.AppendAuditEvent(audit.NewEvent("other", "a@padl.io", audit.ActionProjectCreate, "", ""), nil))
+
+	events, err := db.ListAuditEvents("proj", nil)
+	assert.Nil(t, err)
+	assert.Len(t, events, 3)
+	head, err = db.GetAuditHead("proj")
+	assert.Nil(t, err)
+	if assert.NotNil(t, head) {
+		assert.Equal(t, uint64(3), head.Seq)
+	}
+	assert.Nil(t, audit.Verify(events, head, kr))
+	assert.NotNil(t, audit.Verify(events[:2], head, kr), "a log behind its head should fail verification")
+
+	events, err = db.ListAuditEvents("proj", &audit.Filter{Actor: "a@padl.io"})
+	assert.Nil(t, err)
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, uint64(1), events[0].Seq)
+		assert.Equal(t, uint64(3), events[1].Seq)
+		assert.Equal(t, 1, events[1].KeyVersion)
+	}
+
+	// every project has its own chain
+	events, err = db.ListAuditEvents("other", nil)
+	assert.Nil(t, err)
+	assert.Len(t, events, 1)
+	head, err = db.GetAuditHead("other")
+	assert.Nil(t, err)
+	assert.Nil(t, audit.Verify(events, head, kr))
+}
+
+// TestConcurrentRevokeToken checks that only one of concurrent
+// revocations of a token succeeds, as single use challenges rely on
+func TestConcurrentRevokeToken(t *testing.T) {
+	for _, backend := range backends {
+		if !backend.concurrent {
+			continue
+		}
+		t.Run(backend.name, func(t *testing.T) {
+			db, close := backend.open(t)
+			defer close()
+
+			errs := make([]error, 8)
+			var wg sync.WaitGroup
+			for i := range errs {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					errs[i] = db.RevokeToken(&token.RevokedToken{ID: "jti", Subject: "a@padl.io", ExpiresAt: time.Now().Add(time.Hour)})
+				}(i)
+			}
+			wg.Wait()
+
+			succeeded := 0
+			for _, err := range errs {
+				if err == nil {
+					succeeded++
+					continue
+				}
+				assert.Equal(t, ErrTokenRevoked, err)
+			}
+			assert.Equal(t, 1, succeeded)
+		})
+	}
+}
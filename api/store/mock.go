@@ -1,42 +1,73 @@
 package store
 
 import (
-	"errors"
+	"sort"
 
+	"github.com/adrianosela/padl/api/invitation"
+	"github.com/adrianosela/padl/api/privilege"
 	"github.com/adrianosela/padl/api/project"
+	"github.com/adrianosela/padl/api/revision"
+	"github.com/adrianosela/padl/api/token"
 	"github.com/adrianosela/padl/api/user"
+	"github.com/adrianosela/padl/lib/audit"
 )
 
 // MockDatabase is an in-memory database mock
 type MockDatabase struct {
-	users    map[string]*user.User
-	projects map[string]*project.Project
+	users         map[string]*user.User
+	projects      map[string]*project.Project
+	refreshTokens map[string]*token.RefreshToken
+	revokedTokens map[string]*token.RevokedToken
+	invitations   map[string]*invitation.Invitation
+	revisions     map[string][]*revision.Revision
+	auditEvents   map[string][]*audit.Event
+	auditHeads    map[string]*audit.Head
 }
 
 // NewMockDatabase is the constructor for MockDatabase
 func NewMockDatabase() *MockDatabase {
 	mdb := &MockDatabase{
-		users:    make(map[string]*user.User),
-		projects: make(map[string]*project.Project),
+		users:         make(map[string]*user.User),
+		projects:      make(map[string]*project.Project),
+		refreshTokens: make(map[string]*token.RefreshToken),
+		revokedTokens: make(map[string]*token.RevokedToken),
+		invitations:   make(map[string]*invitation.Invitation),
+		revisions:     make(map[string][]*revision.Revision),
+		auditEvents:   make(map[string][]*audit.Event),
+		auditHeads:    make(map[string]*audit.Head),
 	}
 	return mdb
 }
 
 // UpdateUser updates a user in the database
 func (db *MockDatabase) UpdateUser(usr *user.User) error {
-	if _, ok := db.users[usr.Email]; !ok {
-		return errors.New("User doesn't exist")
+	stored, ok := db.users[usr.Email]
+	if !ok {
+		return ErrUserNotFound
+	}
+	if stored.Version != usr.Version {
+		return ErrConflict
+	}
+	usr.Version++
+	db.users[usr.Email] = copyUser(usr)
+	return nil
+}
+
+// DeleteUser deletes a user from the database
+func (db *MockDatabase) DeleteUser(email string) error {
+	if _, ok := db.users[email]; !ok {
+		return ErrUserNotFound
 	}
-	db.users[usr.Email] = usr
+	delete(db.users, email)
 	return nil
 }
 
 // PutUser adds a new user to the database
 func (db *MockDatabase) PutUser(usr *user.User) error {
 	if _, ok := db.users[usr.Email]; ok {
-		return errors.New("a padl account is already associated with that email")
+		return ErrUserExists
 	}
-	db.users[usr.Email] = usr
+	db.users[usr.Email] = copyUser(usr)
 	return nil
 }
 
@@ -44,9 +75,19 @@ func (db *MockDatabase) PutUser(usr *user.User) error {
 func (db *MockDatabase) GetUser(email string) (*user.User, error) {
 	u, ok := db.users[email]
 	if !ok {
-		return nil, errors.New("user not found")
+		return nil, ErrUserNotFound
 	}
-	return u, nil
+	return copyUser(u), nil
+}
+
+// ListUserEmails returns the emails of all users in the database
+func (db *MockDatabase) ListUserEmails() ([]string, error) {
+	emails := []string{}
+	for email := range db.users {
+		emails = append(emails, email)
+	}
+	sort.Strings(emails)
+	return emails, nil
 }
 
 // UserExists returns true if an email exists in the
@@ -61,18 +102,18 @@ func (db *MockDatabase) UserExists(email string) (bool, error) {
 // PutProject puts a project in the database
 func (db *MockDatabase) PutProject(p *project.Project) error {
 	if _, ok := db.projects[p.Name]; ok {
-		return errors.New("project already exists in the DB")
+		return ErrProjectExists
 	}
-	db.projects[p.Name] = p
+	db.projects[p.Name] = copyProject(p)
 	return nil
 }
 
 // GetProject gets a project from the database
 func (db *MockDatabase) GetProject(name string) (*project.Project, error) {
 	if p, ok := db.projects[name]; ok {
-		return p, nil
+		return copyProject(p), nil
 	}
-	return nil, errors.New("project not found")
+	return nil, ErrProjectNotFound
 }
 
 // ProjectExists returns true if a name exists in the
@@ -86,10 +127,15 @@ func (db *MockDatabase) ProjectExists(name string) (bool, error) {
 
 // UpdateProject updates a project in the database
 func (db *MockDatabase) UpdateProject(p *project.Project) error {
-	if _, ok := db.projects[p.Name]; !ok {
-		return errors.New("project not found")
+	stored, ok := db.projects[p.Name]
+	if !ok {
+		return ErrProjectNotFound
+	}
+	if stored.Version != p.Version {
+		return ErrConflict
 	}
-	db.projects[p.Name] = p
+	p.Version++
+	db.projects[p.Name] = copyProject(p)
 	return nil
 }
 
@@ -98,17 +144,236 @@ func (db *MockDatabase) ListProjects(names []string) ([]*project.Project, error)
 	prjs := []*project.Project{}
 	for _, n := range names {
 		if p, ok := db.projects[n]; ok {
-			prjs = append(prjs, p)
+			prjs = append(prjs, copyProject(p))
 		}
 	}
 	return prjs, nil
 }
 
+// ListProjectNames returns the names of all projects in the database
+func (db *MockDatabase) ListProjectNames() ([]string, error) {
+	names := []string{}
+	for name := range db.projects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
 // DeleteProject deletes a project from the database
 func (db *MockDatabase) DeleteProject(projectName string) error {
 	if _, ok := db.projects[projectName]; !ok {
-		return errors.New("project not found")
+		return ErrProjectNotFound
 	}
 	delete(db.projects, projectName)
 	return nil
 }
+
+// PutRefreshToken adds a refresh token to the database
+func (db *MockDatabase) PutRefreshToken(rt *token.RefreshToken) error {
+	db.refreshTokens[rt.ID] = rt
+	return nil
+}
+
+// GetRefreshToken gets a refresh token by id from the database
+func (db *MockDatabase) GetRefreshToken(id string) (*token.RefreshToken, error) {
+	if rt, ok := db.refreshTokens[id]; ok {
+		return rt, nil
+	}
+	return nil, ErrRefreshTokenNotFound
+}
+
+// UseRefreshToken marks a refresh token as used, failing
+// with ErrRefreshTokenUsed if it had already been used
+func (db *MockDatabase) UseRefreshToken(id string) error {
+	rt, ok := db.refreshTokens[id]
+	if !ok {
+		return ErrRefreshTokenNotFound
+	}
+	if rt.Used {
+		return ErrRefreshTokenUsed
+	}
+	rt.Used = true
+	return nil
+}
+
+// DeleteRefreshTokenFamily deletes all refresh tokens in a family
+func (db *MockDatabase) DeleteRefreshTokenFamily(family string) error {
+	for id, rt := range db.refreshTokens {
+		if rt.Family == family {
+			delete(db.refreshTokens, id)
+		}
+	}
+	return nil
+}
+
+// RevokeToken adds a token to the revocation list, failing
+// with ErrTokenRevoked if it is already in the list
+func (db *MockDatabase) RevokeToken(rt *token.RevokedToken) error {
+	for id, revoked := range db.revokedTokens {
+		if revoked.Expired() {
+			delete(db.revokedTokens, id)
+		}
+	}
+	if _, ok := db.revokedTokens[rt.ID]; ok {
+		return ErrTokenRevoked
+	}
+	db.revokedTokens[rt.ID] = rt
+	return nil
+}
+
+// IsTokenRevoked returns true if a token id is in the revocation list
+func (db *MockDatabase) IsTokenRevoked(id string) (bool, error) {
+	_, ok := db.revokedTokens[id]
+	return ok, nil
+}
+
+// PutInvitation adds an invitation to the database,
+// dropping any invitations which have expired
+func (db *MockDatabase) PutInvitation(inv *invitation.Invitation) error {
+	for id, i := range db.invitations {
+		if i.Expired() {
+			delete(db.invitations, id)
+		}
+	}
+	db.invitations[inv.ID] = inv
+	return nil
+}
+
+// GetInvitation gets an invitation by id from the database
+func (db *MockDatabase) GetInvitation(id string) (*invitation.Invitation, error) {
+	if inv, ok := db.invitations[id]; ok {
+		return inv, nil
+	}
+	return nil, ErrInvitationNotFound
+}
+
+// ListUserInvitations returns the invitations made out to a user
+func (db *MockDatabase) ListUserInvitations(email string) ([]*invitation.Invitation, error) {
+	invs := []*invitation.Invitation{}
+	for _, inv := range db.invitations {
+		if inv.Email == email {
+			invs = append(invs, inv)
+		}
+	}
+	return invs, nil
+}
+
+// ListProjectInvitations returns the invitations to join a project
+func (db *MockDatabase) ListProjectInvitations(name string) ([]*invitation.Invitation, error) {
+	invs := []*invitation.Invitation{}
+	for _, inv := range db.invitations {
+		if inv.Project == name {
+			invs = append(invs, inv)
+		}
+	}
+	return invs, nil
+}
+
+// DeleteInvitation deletes an invitation from the database
+func (db *MockDatabase) DeleteInvitation(id string) error {
+	if _, ok := db.invitations[id]; !ok {
+		return ErrInvitationNotFound
+	}
+	delete(db.invitations, id)
+	return nil
+}
+
+// PutPadlfileRevision adds a revision of a project's padlfile to the database
+func (db *MockDatabase) PutPadlfileRevision(rev *revision.Revision) error {
+	for _, r := range db.revisions[rev.Project] {
+		if r.Number == rev.Number {
+			return ErrRevisionExists
+		}
+	}
+	db.revisions[rev.Project] = append(db.revisions[rev.Project], rev)
+	return nil
+}
+
+// GetPadlfileRevision gets a revision of a project's padlfile
+// from the database, revision 0 being the latest revision
+func (db *MockDatabase) GetPadlfileRevision(project string, number uint64) (*revision.Revision, error) {
+	var found *revision.Revision
+	for _, r := range db.revisions[project] {
+		if (number == 0 && (found == nil || r.Number > found.Number)) || r.Number == number {
+			found = r
+		}
+	}
+	if found == nil {
+		return nil, ErrRevisionNotFound
+	}
+	return found, nil
+}
+
+// ListPadlfileRevisions returns the revisions of a project's padlfile, in order
+func (db *MockDatabase) ListPadlfileRevisions(project string) ([]*revision.Revision, error) {
+	revs := append([]*revision.Revision{}, db.revisions[project]...)
+	sort.Slice(revs, func(i, j int) bool { return revs[i].Number < revs[j].Number })
+	return revs, nil
+}
+
+// DeletePadlfileRevisions deletes all revisions of a project's padlfile
+func (db *MockDatabase) DeletePadlfileRevisions(project string) error {
+	delete(db.revisions, project)
+	return nil
+}
+
+// AppendAuditEvent links an event onto its project's audit log
+func (db *MockDatabase) AppendAuditEvent(e *audit.Event, k *audit.Key) error {
+	events := db.auditEvents[e.Project]
+	var prev *audit.Event
+	if len(events) > 0 {
+		prev = events[len(events)-1]
+	}
+	e.Link(prev, k)
+	db.auditEvents[e.Project] = append(events, e)
+	db.auditHeads[e.Project] = e.Head()
+	return nil
+}
+
+// ListAuditEvents returns a project's audit events matching a filter, in order
+func (db *MockDatabase) ListAuditEvents(project string, f *audit.Filter) ([]*audit.Event, error) {
+	events := []*audit.Event{}
+	for _, e := range db.auditEvents[project] {
+		if f.Match(e) {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+// GetAuditHead returns the head of a project's audit log, or nil if it has none
+func (db *MockDatabase) GetAuditHead(project string) (*audit.Head, error) {
+	if head, ok := db.auditHeads[project]; ok {
+		h := *head
+		return &h, nil
+	}
+	return nil, nil
+}
+
+// users and projects are stored and returned as copies,
+// such that callers only modify them through updates
+
+func copyUser(usr *user.User) *user.User {
+	c := *usr
+	c.Projects = append([]string{}, usr.Projects...)
+	return &c
+}
+
+func copyProject(p *project.Project) *project.Project {
+	c := *p
+	c.Members = make(map[string]privilege.Level)
+	for email, lvl := range p.Members {
+		c.Members[email] = lvl
+	}
+	c.ServiceAccounts = make(map[string]string)
+	for name, keyID := range p.ServiceAccounts {
+		c.ServiceAccounts[name] = keyID
+	}
+	c.PreviousKeys = append([]project.RetiredKey(nil), p.PreviousKeys...)
+	if p.Transfer != nil {
+		t := *p.Transfer
+		c.Transfer = &t
+	}
+	return &c
+}
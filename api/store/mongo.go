@@ -3,9 +3,16 @@ package store
 import (
 	"context"
 	"log"
+	"sort"
+	"sync"
+	"time"
 
+	"github.com/adrianosela/padl/api/invitation"
 	"github.com/adrianosela/padl/api/project"
+	"github.com/adrianosela/padl/api/revision"
+	"github.com/adrianosela/padl/api/token"
 	"github.com/adrianosela/padl/api/user"
+	"github.com/adrianosela/padl/lib/audit"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -14,13 +21,23 @@ import (
 
 // MongoDB holds the MongoDB Collection
 type MongoDB struct {
-	usersCollection    *mongo.Collection
-	projectsCollection *mongo.Collection
+	usersCollection         *mongo.Collection
+	projectsCollection      *mongo.Collection
+	refreshTokensCollection *mongo.Collection
+	revokedTokensCollection *mongo.Collection
+	invitationsCollection   *mongo.Collection
+	revisionsCollection     *mongo.Collection
+	auditEventsCollection   *mongo.Collection
+	auditHeadsCollection    *mongo.Collection
+
+	// serializes audit log appends within this process, such
+	// that only appends from other processes can contend
+	auditMu sync.Mutex
 }
 
 // NewMongoDB initializes MongoDB connection
 // returns MongoDB object
-func NewMongoDB(connStr, dbName, usersCollName, projectsCollName string) (*MongoDB, error) {
+func NewMongoDB(connStr, dbName, usersCollName, projectsCollName, refreshTokensCollName, revokedTokensCollName, invitationsCollName, revisionsCollName, auditEventsCollName, auditHeadsCollName string) (*MongoDB, error) {
 	clientOptions := options.Client().ApplyURI(connStr)
 
 	client, err := mongo.Connect(context.TODO(), clientOptions)
@@ -35,9 +52,46 @@ func NewMongoDB(connStr, dbName, usersCollName, projectsCollName string) (*Mongo
 	log.Println("[info] successfully connected to MongoDB")
 
 	ds := &MongoDB{
-		usersCollection:    client.Database(dbName).Collection(usersCollName),
-		projectsCollection: client.Database(dbName).Collection(projectsCollName),
+		usersCollection:         client.Database(dbName).Collection(usersCollName),
+		projectsCollection:      client.Database(dbName).Collection(projectsCollName),
+		refreshTokensCollection: client.Database(dbName).Collection(refreshTokensCollName),
+		revokedTokensCollection: client.Database(dbName).Collection(revokedTokensCollName),
+		invitationsCollection:   client.Database(dbName).Collection(invitationsCollName),
+		revisionsCollection:     client.Database(dbName).Collection(revisionsCollName),
+		auditEventsCollection:   client.Database(dbName).Collection(auditEventsCollName),
+		auditHeadsCollection:    client.Database(dbName).Collection(auditHeadsCollName),
 	}
+
+	// a unique (project, seq) index guarantees that
+	// concurrent appends can not fork an audit log
+	_, err = ds.auditEventsCollection.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "project", Value: 1}, {Key: "seq", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// a unique id index guarantees that only one of
+	// concurrent revocations of a token succeeds
+	_, err = ds.revokedTokensCollection.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// a unique (project, number) index guarantees that only one
+	// of concurrent uploads of the same padlfile revision succeeds
+	_, err = ds.revisionsCollection.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "project", Value: 1}, {Key: "number", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	return ds, nil
 }
 
@@ -66,19 +120,40 @@ func (db *MongoDB) GetUser(email string) (*user.User, error) {
 
 // UpdateUser updates a user in the database
 func (db *MongoDB) UpdateUser(user *user.User) error {
-	query := bson.D{{Key: "email", Value: user.Email}}
+	query := bson.D{{Key: "email", Value: user.Email}, {Key: "version", Value: versionQuery(user.Version)}}
 
-	update := bson.M{
-		"$set": bson.M{
-			"hashedpass": user.HashedPass,
-			"keyid":      user.KeyID,
-			"projects":   user.Projects,
-		},
+	next := *user
+	next.Version++
+	res, err := db.usersCollection.ReplaceOne(context.TODO(), query, &next)
+	if err != nil {
+		return err
 	}
-	_, err := db.usersCollection.UpdateOne(context.TODO(), query, update)
+	if res.MatchedCount == 0 {
+		// no document at that version, tell a missing user apart from a newer version
+		exists, err := db.UserExists(user.Email)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrUserNotFound
+		}
+		return ErrConflict
+	}
+	user.Version = next.Version
+
+	return nil
+}
+
+// DeleteUser deletes a user from the database
+func (db *MongoDB) DeleteUser(email string) error {
+	query := bson.D{{Key: "email", Value: email}}
+	res, err := db.usersCollection.DeleteOne(context.TODO(), query)
 	if err != nil {
 		return err
 	}
+	if res.DeletedCount == 0 {
+		return ErrUserNotFound
+	}
 
 	return nil
 }
@@ -100,6 +175,11 @@ func (db *MongoDB) UserExists(email string) (bool, error) {
 	return true, nil
 }
 
+// ListUserEmails returns the emails of all users in the database
+func (db *MongoDB) ListUserEmails() ([]string, error) {
+	return listDistinct(db.usersCollection, "email")
+}
+
 // PutProject adds a new project to the database
 func (db *MongoDB) PutProject(project *project.Project) error {
 	_, err := db.projectsCollection.InsertOne(context.TODO(), project)
@@ -125,20 +205,26 @@ func (db *MongoDB) GetProject(projectName string) (*project.Project, error) {
 
 // UpdateProject updates a project in the database
 func (db *MongoDB) UpdateProject(project *project.Project) error {
-	query := bson.D{{Key: "name", Value: project.Name}}
-
-	update := bson.M{
-		"$set": bson.M{
-			"description":     project.Description,
-			"members":         project.Members,
-			"projectkey":      project.ProjectKey,
-			"serviceAccounts": project.ServiceAccounts,
-		},
-	}
-	_, err := db.projectsCollection.UpdateOne(context.TODO(), query, update)
+	query := bson.D{{Key: "name", Value: project.Name}, {Key: "version", Value: versionQuery(project.Version)}}
+
+	next := *project
+	next.Version++
+	res, err := db.projectsCollection.ReplaceOne(context.TODO(), query, &next)
 	if err != nil {
 		return err
 	}
+	if res.MatchedCount == 0 {
+		// no document at that version, tell a missing project apart from a newer version
+		exists, err := db.ProjectExists(project.Name)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrProjectNotFound
+		}
+		return ErrConflict
+	}
+	project.Version = next.Version
 
 	return nil
 }
@@ -199,3 +285,375 @@ func (db *MongoDB) ListProjects(projectNames []string) ([]*project.Project, erro
 
 	return projects, nil
 }
+
+// ListProjectNames returns the names of all projects in the database
+func (db *MongoDB) ListProjectNames() ([]string, error) {
+	return listDistinct(db.projectsCollection, "name")
+}
+
+// listDistinct returns the (sorted) distinct string values of
+// a field across all documents of a collection
+func listDistinct(coll *mongo.Collection, field string) ([]string, error) {
+	vals, err := coll.Distinct(context.TODO(), field, bson.D{})
+	if err != nil {
+		return nil, err
+	}
+	strs := []string{}
+	for _, v := range vals {
+		if str, ok := v.(string); ok {
+			strs = append(strs, str)
+		}
+	}
+	sort.Strings(strs)
+	return strs, nil
+}
+
+// PutRefreshToken adds a refresh token to the database
+func (db *MongoDB) PutRefreshToken(rt *token.RefreshToken) error {
+	_, err := db.refreshTokensCollection.InsertOne(context.TODO(), rt)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetRefreshToken gets a refresh token by id from the database
+func (db *MongoDB) GetRefreshToken(id string) (*token.RefreshToken, error) {
+	query := bson.D{{Key: "id", Value: id}}
+
+	var rt token.RefreshToken
+	err := db.refreshTokensCollection.FindOne(context.TODO(), query).Decode(&rt)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrRefreshTokenNotFound
+		}
+		return nil, err
+	}
+
+	return &rt, nil
+}
+
+// UseRefreshToken marks a refresh token as used, failing
+// with ErrRefreshTokenUsed if it had already been used
+func (db *MongoDB) UseRefreshToken(id string) error {
+	// the used flag is part of the filter such that
+	// only one of two concurrent uses can succeed
+	query := bson.D{{Key: "id", Value: id}, {Key: "used", Value: false}}
+	update := bson.M{"$set": bson.M{"used": true}}
+
+	res, err := db.refreshTokensCollection.UpdateOne(context.TODO(), query, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		if _, err := db.GetRefreshToken(id); err != nil {
+			return err
+		}
+		return ErrRefreshTokenUsed
+	}
+
+	return nil
+}
+
+// DeleteRefreshTokenFamily deletes all refresh tokens in a family
+func (db *MongoDB) DeleteRefreshTokenFamily(family string) error {
+	query := bson.D{{Key: "family", Value: family}}
+	_, err := db.refreshTokensCollection.DeleteMany(context.TODO(), query)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RevokeToken adds a token to the revocation list, failing
+// with ErrTokenRevoked if it is already in the list
+func (db *MongoDB) RevokeToken(rt *token.RevokedToken) error {
+	// prune entries for tokens which have expired anyway
+	prune := bson.M{"expiresat": bson.M{"$lt": time.Now()}}
+	if _, err := db.revokedTokensCollection.DeleteMany(context.TODO(), prune); err != nil {
+		return err
+	}
+
+	_, err := db.revokedTokensCollection.InsertOne(context.TODO(), rt)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrTokenRevoked
+		}
+		return err
+	}
+
+	return nil
+}
+
+// IsTokenRevoked returns true if a token id is in the revocation list
+func (db *MongoDB) IsTokenRevoked(id string) (bool, error) {
+	query := bson.D{{Key: "id", Value: id}}
+
+	n, err := db.revokedTokensCollection.CountDocuments(context.TODO(), query)
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// PutInvitation adds an invitation to the database,
+// dropping any invitations which have expired
+func (db *MongoDB) PutInvitation(inv *invitation.Invitation) error {
+	prune := bson.M{"expiresat": bson.M{"$lt": time.Now()}}
+	if _, err := db.invitationsCollection.DeleteMany(context.TODO(), prune); err != nil {
+		return err
+	}
+
+	_, err := db.invitationsCollection.InsertOne(context.TODO(), inv)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetInvitation gets an invitation by id from the database
+func (db *MongoDB) GetInvitation(id string) (*invitation.Invitation, error) {
+	query := bson.D{{Key: "id", Value: id}}
+
+	var inv invitation.Invitation
+	err := db.invitationsCollection.FindOne(context.TODO(), query).Decode(&inv)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrInvitationNotFound
+		}
+		return nil, err
+	}
+
+	return &inv, nil
+}
+
+// ListUserInvitations returns the invitations made out to a user
+func (db *MongoDB) ListUserInvitations(email string) ([]*invitation.Invitation, error) {
+	return db.listInvitations(bson.D{{Key: "email", Value: email}})
+}
+
+// ListProjectInvitations returns the invitations to join a project
+func (db *MongoDB) ListProjectInvitations(name string) ([]*invitation.Invitation, error) {
+	return db.listInvitations(bson.D{{Key: "project", Value: name}})
+}
+
+func (db *MongoDB) listInvitations(query bson.D) ([]*invitation.Invitation, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdat", Value: 1}})
+	cur, err := db.invitationsCollection.Find(context.TODO(), query, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	invs := []*invitation.Invitation{}
+	for cur.Next(context.TODO()) {
+		var inv invitation.Invitation
+		if err := cur.Decode(&inv); err != nil {
+			return nil, err
+		}
+		invs = append(invs, &inv)
+	}
+
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+
+	return invs, nil
+}
+
+// DeleteInvitation deletes an invitation from the database
+func (db *MongoDB) DeleteInvitation(id string) error {
+	query := bson.D{{Key: "id", Value: id}}
+	res, err := db.invitationsCollection.DeleteOne(context.TODO(), query)
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrInvitationNotFound
+	}
+
+	return nil
+}
+
+// versionQuery matches documents at the given version. Documents
+// written before versioning was introduced have no version field,
+// and are treated as being at version 0
+func versionQuery(version int) interface{} {
+	if version == 0 {
+		return bson.M{"$in": bson.A{0, nil}}
+	}
+	return version
+}
+
+// PutPadlfileRevision adds a revision of a project's padlfile to the database
+func (db *MongoDB) PutPadlfileRevision(rev *revision.Revision) error {
+	_, err := db.revisionsCollection.InsertOne(context.TODO(), rev)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrRevisionExists
+		}
+		return err
+	}
+
+	return nil
+}
+
+// GetPadlfileRevision gets a revision of a project's padlfile
+// from the database, revision 0 being the latest revision
+func (db *MongoDB) GetPadlfileRevision(project string, number uint64) (*revision.Revision, error) {
+	query := bson.D{{Key: "project", Value: project}}
+	opts := options.FindOne().SetSort(bson.D{{Key: "number", Value: -1}})
+	if number != 0 {
+		query = append(query, bson.E{Key: "number", Value: number})
+	}
+
+	var rev revision.Revision
+	err := db.revisionsCollection.FindOne(context.TODO(), query, opts).Decode(&rev)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrRevisionNotFound
+		}
+		return nil, err
+	}
+	rev.Time = rev.Time.UTC()
+
+	return &rev, nil
+}
+
+// ListPadlfileRevisions returns the revisions of a project's padlfile, in order
+func (db *MongoDB) ListPadlfileRevisions(project string) ([]*revision.Revision, error) {
+	query := bson.D{{Key: "project", Value: project}}
+	opts := options.Find().SetSort(bson.D{{Key: "number", Value: 1}})
+	cur, err := db.revisionsCollection.Find(context.TODO(), query, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	revs := []*revision.Revision{}
+	for cur.Next(context.TODO()) {
+		var rev revision.Revision
+		if err := cur.Decode(&rev); err != nil {
+			return nil, err
+		}
+		rev.Time = rev.Time.UTC()
+		revs = append(revs, &rev)
+	}
+
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+
+	return revs, nil
+}
+
+// DeletePadlfileRevisions deletes all revisions of a project's padlfile
+func (db *MongoDB) DeletePadlfileRevisions(project string) error {
+	query := bson.D{{Key: "project", Value: project}}
+	_, err := db.revisionsCollection.DeleteMany(context.TODO(), query)
+	return err
+}
+
+// AppendAuditEvent links an event onto its project's audit log. The
+// head of the log is moved once the event is in, and never backwards
+func (db *MongoDB) AppendAuditEvent(e *audit.Event, k *audit.Key) error {
+	db.auditMu.Lock()
+	defer db.auditMu.Unlock()
+
+	for i := 0; i < maxAuditAppendAttempts; i++ {
+		var prev *audit.Event
+		query := bson.D{{Key: "project", Value: e.Project}}
+		opts := options.FindOne().SetSort(bson.D{{Key: "seq", Value: -1}})
+
+		var last audit.Event
+		err := db.auditEventsCollection.FindOne(context.TODO(), query, opts).Decode(&last)
+		if err == nil {
+			prev = &last
+		} else if err != mongo.ErrNoDocuments {
+			return err
+		}
+
+		e.Link(prev, k)
+		_, err = db.auditEventsCollection.InsertOne(context.TODO(), e)
+		if err == nil {
+			return db.putAuditHead(e.Head())
+		}
+		// the loser of a race links onto the winner
+		if !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+	}
+	return ErrAuditLogContention
+}
+
+// putAuditHead moves the head of a log forward. A head which is
+// already ahead is not matched, and fails to be inserted again
+func (db *MongoDB) putAuditHead(head *audit.Head) error {
+	query := bson.D{{Key: "_id", Value: head.Project}, {Key: "seq", Value: bson.D{{Key: "$lt", Value: head.Seq}}}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "seq", Value: head.Seq}, {Key: "hash", Value: head.Hash}}}}
+	_, err := db.auditHeadsCollection.UpdateOne(context.TODO(), query, update, options.Update().SetUpsert(true))
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+	return nil
+}
+
+// ListAuditEvents returns a project's audit events matching a filter, in order
+func (db *MongoDB) ListAuditEvents(project string, f *audit.Filter) ([]*audit.Event, error) {
+	query := bson.M{"project": project}
+	if f != nil {
+		at := bson.M{}
+		if !f.Since.IsZero() {
+			at["$gte"] = f.Since
+		}
+		if !f.Until.IsZero() {
+			at["$lte"] = f.Until
+		}
+		if len(at) > 0 {
+			query["time"] = at
+		}
+		if f.Actor != "" {
+			query["actor"] = f.Actor
+		}
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "seq", Value: 1}})
+	cur, err := db.auditEventsCollection.Find(context.TODO(), query, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	events := []*audit.Event{}
+	for cur.Next(context.TODO()) {
+		var e audit.Event
+		if err := cur.Decode(&e); err != nil {
+			return nil, err
+		}
+		e.Time = e.Time.UTC()
+		events = append(events, &e)
+	}
+
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// GetAuditHead returns the head of a project's audit log, or nil if it has none
+func (db *MongoDB) GetAuditHead(project string) (*audit.Head, error) {
+	var doc struct {
+		Seq  uint64 `bson:"seq"`
+		Hash string `bson:"hash"`
+	}
+	query := bson.D{{Key: "_id", Value: project}}
+	if err := db.auditHeadsCollection.FindOne(context.TODO(), query).Decode(&doc); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &audit.Head{Project: project, Seq: doc.Seq, Hash: doc.Hash}, nil
+}
@@ -0,0 +1,41 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Rollback collects the compensating actions of the completed steps of
+// a multi-step operation, which may span the database and the keystore.
+// If a later step fails, running the rollback undoes the completed steps
+// such that the operation as a whole either happens or does not
+type Rollback struct {
+	steps []rollbackStep
+}
+
+type rollbackStep struct {
+	desc string
+	undo func() error
+}
+
+// Add records the compensating action of a completed step
+func (rb *Rollback) Add(desc string, undo func() error) {
+	rb.steps = append(rb.steps, rollbackStep{desc: desc, undo: undo})
+}
+
+// Run undoes the completed steps in reverse order. Every step is undone
+// even if undoing another one fails, the returned error lists the steps
+// which could not be undone. A rollback can only be run once
+func (rb *Rollback) Run() error {
+	failed := []string{}
+	for i := len(rb.steps) - 1; i >= 0; i-- {
+		if err := rb.steps[i].undo(); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", rb.steps[i].desc, err))
+		}
+	}
+	rb.steps = nil
+	if len(failed) > 0 {
+		return fmt.Errorf("could not undo %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
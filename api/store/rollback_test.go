@@ -0,0 +1,67 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRollbackRun(t *testing.T) {
+	tests := []struct {
+		testName  string
+		failing   map[string]bool
+		expectErr string
+	}{
+		{
+			testName: "positive test - all steps undone",
+		},
+		{
+			testName:  "negative test - one step fails",
+			failing:   map[string]bool{"b": true},
+			expectErr: "could not undo b: undo b failed",
+		},
+		{
+			testName:  "negative test - several steps fail",
+			failing:   map[string]bool{"a": true, "c": true},
+			expectErr: "could not undo c: undo c failed; a: undo a failed",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			undone := []string{}
+			rb := &Rollback{}
+			for _, step := range []string{"a", "b", "c"} {
+				step := step
+				rb.Add(step, func() error {
+					undone = append(undone, step)
+					if test.failing[step] {
+						return fmt.Errorf("undo %s failed", step)
+					}
+					return nil
+				})
+			}
+			err := rb.Run()
+			// every step is undone, in reverse order, even if some fail
+			assert.Equal(t, []string{"c", "b", "a"}, undone)
+			if test.expectErr != "" {
+				assert.EqualError(t, err, test.expectErr)
+			} else {
+				assert.Nil(t, err)
+			}
+			// a rollback only runs once
+			assert.Nil(t, rb.Run())
+			assert.Len(t, undone, 3)
+		})
+	}
+}
+
+func TestRollbackRunEmpty(t *testing.T) {
+	assert.Nil(t, (&Rollback{}).Run())
+
+	rb := &Rollback{}
+	rb.Add("step", func() error { return errors.New("boom") })
+	assert.EqualError(t, rb.Run(), "could not undo step: boom")
+}
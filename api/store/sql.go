@@ -0,0 +1,649 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrianosela/padl/api/invitation"
+	"github.com/adrianosela/padl/api/privilege"
+	"github.com/adrianosela/padl/api/project"
+	"github.com/adrianosela/padl/api/revision"
+	"github.com/adrianosela/padl/api/sqldb"
+	"github.com/adrianosela/padl/api/token"
+	"github.com/adrianosela/padl/api/user"
+	"github.com/adrianosela/padl/lib/audit"
+)
+
+// SQLDatabase is a SQL (postgres or sqlite) implementation
+// of the Database interface
+type SQLDatabase struct {
+	db *sqldb.DB
+
+	// serializes audit log appends within this process, such
+	// that only appends from other processes can contend
+	auditMu sync.Mutex
+}
+
+// NewSQLDatabase is the constructor for SQLDatabase
+func NewSQLDatabase(db *sqldb.DB) *SQLDatabase {
+	return &SQLDatabase{db: db}
+}
+
+// PutUser adds a new user to the database
+func (db *SQLDatabase) PutUser(usr *user.User) error {
+	projects, err := json.Marshal(usr.Projects)
+	if err != nil {
+		return fmt.Errorf("could not marshal user projects: %s", err)
+	}
+	res, err := db.db.Exec(
+		`INSERT INTO users (email, hashed_pass, key_id, projects, version) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (email) DO NOTHING`,
+		usr.Email, usr.HashedPass, usr.KeyID, string(projects), usr.Version)
+	if err != nil {
+		return err
+	}
+	return sqldb.ExpectAffected(res, ErrUserExists)
+}
+
+// GetUser gets a user from the database
+func (db *SQLDatabase) GetUser(email string) (*user.User, error) {
+	var usr user.User
+	var projects string
+	err := db.db.QueryRow(
+		`SELECT email, hashed_pass, key_id, projects, version FROM users WHERE email = ?`, email,
+	).Scan(&usr.Email, &usr.HashedPass, &usr.KeyID, &projects, &usr.Version)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	if err = json.Unmarshal([]byte(projects), &usr.Projects); err != nil {
+		return nil, fmt.Errorf("could not unmarshal user projects: %s", err)
+	}
+	return &usr, nil
+}
+
+// UserExists returns true if a user with given email exists
+func (db *SQLDatabase) UserExists(email string) (bool, error) {
+	var count int
+	if err := db.db.QueryRow(`SELECT COUNT(*) FROM users WHERE email = ?`, email).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// ListUserEmails returns the emails of all users in the database
+func (db *SQLDatabase) ListUserEmails() ([]string, error) {
+	return db.listStrings(`SELECT email FROM users ORDER BY email`)
+}
+
+// UpdateUser updates a user in the database
+func (db *SQLDatabase) UpdateUser(usr *user.User) error {
+	projects, err := json.Marshal(usr.Projects)
+	if err != nil {
+		return fmt.Errorf("could not marshal user projects: %s", err)
+	}
+	res, err := db.db.Exec(
+		`UPDATE users SET hashed_pass = ?, key_id = ?, projects = ?, version = version + 1 WHERE email = ? AND version = ?`,
+		usr.HashedPass, usr.KeyID, string(projects), usr.Email, usr.Version)
+	if err != nil {
+		return err
+	}
+	if err = sqldb.ExpectAffected(res, ErrConflict); err != ErrConflict {
+		if err == nil {
+			usr.Version++
+		}
+		return err
+	}
+	// no row at that version, tell a missing user apart from a newer version
+	exists, err := db.UserExists(usr.Email)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrUserNotFound
+	}
+	return ErrConflict
+}
+
+// DeleteUser deletes a user from the database
+func (db *SQLDatabase) DeleteUser(email string) error {
+	res, err := db.db.Exec(`DELETE FROM users WHERE email = ?`, email)
+	if err != nil {
+		return err
+	}
+	return sqldb.ExpectAffected(res, ErrUserNotFound)
+}
+
+// PutProject adds a new project to the database
+func (db *SQLDatabase) PutProject(p *project.Project) error {
+	members, svcAccts, prevKeys, transfer, err := marshalProjectFields(p)
+	if err != nil {
+		return err
+	}
+	res, err := db.db.Exec(
+		`INSERT INTO projects (name, description, project_key, members, service_accounts, previous_keys, policy, transfer, version, creation_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT (name) DO NOTHING`,
+		p.Name, p.Description, p.ProjectKey, members, svcAccts, prevKeys, p.Policy, transfer, p.Version, p.CreationID)
+	if err != nil {
+		return err
+	}
+	return sqldb.ExpectAffected(res, ErrProjectExists)
+}
+
+// GetProject gets a project from the database
+func (db *SQLDatabase) GetProject(name string) (*project.Project, error) {
+	p, err := scanProject(db.db.QueryRow(
+		`SELECT name, description, project_key, members, service_accounts, previous_keys, policy, transfer, version, creation_id FROM projects WHERE name = ?`, name))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrProjectNotFound
+		}
+		return nil, err
+	}
+	return p, nil
+}
+
+// UpdateProject updates a project in the database
+func (db *SQLDatabase) UpdateProject(p *project.Project) error {
+	members, svcAccts, prevKeys, transfer, err := marshalProjectFields(p)
+	if err != nil {
+		return err
+	}
+	res, err := db.db.Exec(
+		`UPDATE projects SET description = ?, project_key = ?, members = ?, service_accounts = ?, previous_keys = ?, policy = ?,
+		transfer = ?, version = version + 1 WHERE name = ? AND version = ?`,
+		p.Description, p.ProjectKey, members, svcAccts, prevKeys, p.Policy, transfer, p.Name, p.Version)
+	if err != nil {
+		return err
+	}
+	if err = sqldb.ExpectAffected(res, ErrConflict); err != ErrConflict {
+		if err == nil {
+			p.Version++
+		}
+		return err
+	}
+	// no row at that version, tell a missing project apart from a newer version
+	exists, err := db.ProjectExists(p.Name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrProjectNotFound
+	}
+	return ErrConflict
+}
+
+// DeleteProject deletes a project from the database
+func (db *SQLDatabase) DeleteProject(name string) error {
+	res, err := db.db.Exec(`DELETE FROM projects WHERE name = ?`, name)
+	if err != nil {
+		return err
+	}
+	return sqldb.ExpectAffected(res, ErrProjectNotFound)
+}
+
+// ProjectExists returns true if a project with that name already exists
+func (db *SQLDatabase) ProjectExists(name string) (bool, error) {
+	var count int
+	if err := db.db.QueryRow(`SELECT COUNT(*) FROM projects WHERE name = ?`, name).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// ListProjects returns all projects in the db based on provided project names
+func (db *SQLDatabase) ListProjects(names []string) ([]*project.Project, error) {
+	projects := []*project.Project{}
+	if len(names) == 0 {
+		return projects, nil
+	}
+	args := make([]interface{}, len(names))
+	for i, n := range names {
+		args[i] = n
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", ")
+	rows, err := db.db.Query(
+		`SELECT name, description, project_key, members, service_accounts, previous_keys, policy, transfer, version, creation_id FROM projects WHERE name IN (`+placeholders+`)`,
+		args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p, err := scanProject(rows)
+		if err != nil {
+			return nil, err
+		}
+		projects = append(projects, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return projects, nil
+}
+
+// ListProjectNames returns the names of all projects in the database
+func (db *SQLDatabase) ListProjectNames() ([]string, error) {
+	return db.listStrings(`SELECT name FROM projects ORDER BY name`)
+}
+
+// listStrings returns the single string column of a query's rows
+func (db *SQLDatabase) listStrings(query string) ([]string, error) {
+	rows, err := db.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	strs := []string{}
+	for rows.Next() {
+		var str string
+		if err := rows.Scan(&str); err != nil {
+			return nil, err
+		}
+		strs = append(strs, str)
+	}
+	return strs, rows.Err()
+}
+
+// PutRefreshToken adds a refresh token to the database
+func (db *SQLDatabase) PutRefreshToken(rt *token.RefreshToken) error {
+	_, err := db.db.Exec(
+		`INSERT INTO refresh_tokens (id, family, subject, expires_at, used) VALUES (?, ?, ?, ?, ?)`,
+		rt.ID, rt.Family, rt.Subject, rt.ExpiresAt.Unix(), boolToInt(rt.Used))
+	return err
+}
+
+// GetRefreshToken gets a refresh token by id from the database
+func (db *SQLDatabase) GetRefreshToken(id string) (*token.RefreshToken, error) {
+	var rt token.RefreshToken
+	var expiresAt int64
+	var used int
+	err := db.db.QueryRow(
+		`SELECT id, family, subject, expires_at, used FROM refresh_tokens WHERE id = ?`, id,
+	).Scan(&rt.ID, &rt.Family, &rt.Subject, &expiresAt, &used)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrRefreshTokenNotFound
+		}
+		return nil, err
+	}
+	rt.ExpiresAt = time.Unix(expiresAt, 0)
+	rt.Used = used != 0
+	return &rt, nil
+}
+
+// UseRefreshToken marks a refresh token as used, failing
+// with ErrRefreshTokenUsed if it had already been used
+func (db *SQLDatabase) UseRefreshToken(id string) error {
+	// the used flag is part of the filter such that
+	// only one of two concurrent uses can succeed
+	res, err := db.db.Exec(`UPDATE refresh_tokens SET used = 1 WHERE id = ? AND used = 0`, id)
+	if err != nil {
+		return err
+	}
+	if err = sqldb.ExpectAffected(res, ErrRefreshTokenUsed); err != ErrRefreshTokenUsed {
+		return err
+	}
+	if _, err := db.GetRefreshToken(id); err != nil {
+		return err
+	}
+	return ErrRefreshTokenUsed
+}
+
+// DeleteRefreshTokenFamily deletes all refresh tokens in a family
+func (db *SQLDatabase) DeleteRefreshTokenFamily(family string) error {
+	_, err := db.db.Exec(`DELETE FROM refresh_tokens WHERE family = ?`, family)
+	return err
+}
+
+// RevokeToken adds a token to the revocation list, failing with
+// ErrTokenRevoked if it is already in the list. The id primary key
+// guarantees that only one of concurrent revocations succeeds
+func (db *SQLDatabase) RevokeToken(rt *token.RevokedToken) error {
+	// prune entries for tokens which have expired anyway
+	if _, err := db.db.Exec(`DELETE FROM revoked_tokens WHERE expires_at < ?`, time.Now().Unix()); err != nil {
+		return err
+	}
+	res, err := db.db.Exec(
+		`INSERT INTO revoked_tokens (id, subject, expires_at) VALUES (?, ?, ?) ON CONFLICT DO NOTHING`,
+		rt.ID, rt.Subject, rt.ExpiresAt.Unix())
+	if err != nil {
+		return err
+	}
+	return sqldb.ExpectAffected(res, ErrTokenRevoked)
+}
+
+// IsTokenRevoked returns true if a token id is in the revocation list
+func (db *SQLDatabase) IsTokenRevoked(id string) (bool, error) {
+	var n int
+	if err := db.db.QueryRow(`SELECT COUNT(*) FROM revoked_tokens WHERE id = ?`, id).Scan(&n); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// PutInvitation adds an invitation to the database,
+// dropping any invitations which have expired
+func (db *SQLDatabase) PutInvitation(inv *invitation.Invitation) error {
+	if _, err := db.db.Exec(`DELETE FROM invitations WHERE expires_at < ?`, time.Now().Unix()); err != nil {
+		return err
+	}
+	_, err := db.db.Exec(
+		`INSERT INTO invitations (id, project, email, privilege, invited_by, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		inv.ID, inv.Project, inv.Email, int(inv.PrivilegeLvl), inv.InvitedBy, inv.CreatedAt.Unix(), inv.ExpiresAt.Unix())
+	return err
+}
+
+// GetInvitation gets an invitation by id from the database
+func (db *SQLDatabase) GetInvitation(id string) (*invitation.Invitation, error) {
+	inv, err := scanInvitation(db.db.QueryRow(
+		`SELECT id, project, email, privilege, invited_by, created_at, expires_at FROM invitations WHERE id = ?`, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrInvitationNotFound
+		}
+		return nil, err
+	}
+	return inv, nil
+}
+
+// ListUserInvitations returns the invitations made out to a user
+func (db *SQLDatabase) ListUserInvitations(email string) ([]*invitation.Invitation, error) {
+	return db.listInvitations(`email = ?`, email)
+}
+
+// ListProjectInvitations returns the invitations to join a project
+func (db *SQLDatabase) ListProjectInvitations(name string) ([]*invitation.Invitation, error) {
+	return db.listInvitations(`project = ?`, name)
+}
+
+func (db *SQLDatabase) listInvitations(where string, arg interface{}) ([]*invitation.Invitation, error) {
+	rows, err := db.db.Query(
+		`SELECT id, project, email, privilege, invited_by, created_at, expires_at FROM invitations WHERE `+where+` ORDER BY created_at`, arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invs := []*invitation.Invitation{}
+	for rows.Next() {
+		inv, err := scanInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+		invs = append(invs, inv)
+	}
+	return invs, rows.Err()
+}
+
+// DeleteInvitation deletes an invitation from the database
+func (db *SQLDatabase) DeleteInvitation(id string) error {
+	res, err := db.db.Exec(`DELETE FROM invitations WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	return sqldb.ExpectAffected(res, ErrInvitationNotFound)
+}
+
+// PutPadlfileRevision adds a revision of a project's padlfile to the
+// database. The (project, revision) primary key guarantees that only
+// one of concurrent uploads of the same revision succeeds
+func (db *SQLDatabase) PutPadlfileRevision(rev *revision.Revision) error {
+	res, err := db.db.Exec(
+		`INSERT INTO padlfile_revisions (project, revision, author, created_at, hash, content)
+		VALUES (?, ?, ?, ?, ?, ?) ON CONFLICT DO NOTHING`,
+		rev.Project, int64(rev.Number), rev.Author, rev.Time.Unix(), rev.Hash, rev.Content)
+	if err != nil {
+		return err
+	}
+	return sqldb.ExpectAffected(res, ErrRevisionExists)
+}
+
+// GetPadlfileRevision gets a revision of a project's padlfile
+// from the database, revision 0 being the latest revision
+func (db *SQLDatabase) GetPadlfileRevision(project string, number uint64) (*revision.Revision, error) {
+	query := `SELECT project, revision, author, created_at, hash, content FROM padlfile_revisions WHERE project = ?`
+	args := []interface{}{project}
+	if number == 0 {
+		query += ` ORDER BY revision DESC LIMIT 1`
+	} else {
+		query += ` AND revision = ?`
+		args = append(args, int64(number))
+	}
+	rev, err := scanRevision(db.db.QueryRow(query, args...))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrRevisionNotFound
+		}
+		return nil, err
+	}
+	return rev, nil
+}
+
+// ListPadlfileRevisions returns the revisions of a project's padlfile, in order
+func (db *SQLDatabase) ListPadlfileRevisions(project string) ([]*revision.Revision, error) {
+	rows, err := db.db.Query(
+		`SELECT project, revision, author, created_at, hash, content FROM padlfile_revisions WHERE project = ? ORDER BY revision`, project)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revs := []*revision.Revision{}
+	for rows.Next() {
+		rev, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		revs = append(revs, rev)
+	}
+	return revs, rows.Err()
+}
+
+// DeletePadlfileRevisions deletes all revisions of a project's padlfile
+func (db *SQLDatabase) DeletePadlfileRevisions(project string) error {
+	_, err := db.db.Exec(`DELETE FROM padlfile_revisions WHERE project = ?`, project)
+	return err
+}
+
+// AppendAuditEvent links an event onto its project's audit log. The
+// (project, seq) primary key guarantees that concurrent appends can
+// not fork the chain, the loser of a race links onto the winner. The
+// head of the log is moved once the event is in, and never backwards
+func (db *SQLDatabase) AppendAuditEvent(e *audit.Event, k *audit.Key) error {
+	db.auditMu.Lock()
+	defer db.auditMu.Unlock()
+
+	for i := 0; i < maxAuditAppendAttempts; i++ {
+		prev, err := db.lastAuditEvent(e.Project)
+		if err != nil {
+			return err
+		}
+		e.Link(prev, k)
+		res, err := db.db.Exec(
+			`INSERT INTO audit_events (project, seq, at, actor, action, target, detail, prev_hash, hash, key_version)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT DO NOTHING`,
+			e.Project, e.Seq, e.Time.UnixNano(), e.Actor, e.Action, e.Target, e.Detail, e.PrevHash, e.Hash, e.KeyVersion)
+		if err != nil {
+			return err
+		}
+		err = sqldb.ExpectAffected(res, ErrAuditLogContention)
+		if err == nil {
+			_, err = db.db.Exec(
+				`INSERT INTO audit_heads (project, seq, hash) VALUES (?, ?, ?)
+				ON CONFLICT (project) DO UPDATE SET seq = excluded.seq, hash = excluded.hash
+				WHERE audit_heads.seq < excluded.seq`,
+				e.Project, e.Seq, e.Hash)
+		}
+		if err != ErrAuditLogContention {
+			return err
+		}
+	}
+	return ErrAuditLogContention
+}
+
+// ListAuditEvents returns a project's audit events matching a filter, in order
+func (db *SQLDatabase) ListAuditEvents(project string, f *audit.Filter) ([]*audit.Event, error) {
+	query := `SELECT project, seq, at, actor, action, target, detail, prev_hash, hash, key_version
+		FROM audit_events WHERE project = ?`
+	args := []interface{}{project}
+	if f != nil {
+		if !f.Since.IsZero() {
+			query += ` AND at >= ?`
+			args = append(args, f.Since.UnixNano())
+		}
+		if !f.Until.IsZero() {
+			query += ` AND at <= ?`
+			args = append(args, f.Until.UnixNano())
+		}
+		if f.Actor != "" {
+			query += ` AND actor = ?`
+			args = append(args, f.Actor)
+		}
+	}
+	rows, err := db.db.Query(query+` ORDER BY seq`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*audit.Event{}
+	for rows.Next() {
+		e, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// GetAuditHead returns the head of a project's audit log, or nil if it has none
+func (db *SQLDatabase) GetAuditHead(project string) (*audit.Head, error) {
+	head := &audit.Head{Project: project}
+	err := db.db.QueryRow(`SELECT seq, hash FROM audit_heads WHERE project = ?`, project).Scan(&head.Seq, &head.Hash)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return head, nil
+}
+
+// lastAuditEvent returns the last event in a project's
+// audit log, or nil if the log is empty
+func (db *SQLDatabase) lastAuditEvent(project string) (*audit.Event, error) {
+	e, err := scanAuditEvent(db.db.QueryRow(
+		`SELECT project, seq, at, actor, action, target, detail, prev_hash, hash, key_version
+		FROM audit_events WHERE project = ? ORDER BY seq DESC LIMIT 1`, project))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return e, nil
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// scanner is satisfied by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanProject(row scanner) (*project.Project, error) {
+	var p project.Project
+	var members, svcAccts, prevKeys, transfer string
+	if err := row.Scan(&p.Name, &p.Description, &p.ProjectKey, &members, &svcAccts, &prevKeys, &p.Policy, &transfer, &p.Version, &p.CreationID); err != nil {
+		return nil, err
+	}
+	p.Members = make(map[string]privilege.Level)
+	if err := json.Unmarshal([]byte(members), &p.Members); err != nil {
+		return nil, fmt.Errorf("could not unmarshal project members: %s", err)
+	}
+	p.ServiceAccounts = make(map[string]string)
+	if err := json.Unmarshal([]byte(svcAccts), &p.ServiceAccounts); err != nil {
+		return nil, fmt.Errorf("could not unmarshal project service accounts: %s", err)
+	}
+	if err := json.Unmarshal([]byte(prevKeys), &p.PreviousKeys); err != nil {
+		return nil, fmt.Errorf("could not unmarshal project previous keys: %s", err)
+	}
+	if err := json.Unmarshal([]byte(transfer), &p.Transfer); err != nil {
+		return nil, fmt.Errorf("could not unmarshal project ownership transfer: %s", err)
+	}
+	return &p, nil
+}
+
+func scanAuditEvent(row scanner) (*audit.Event, error) {
+	var e audit.Event
+	var at int64
+	if err := row.Scan(&e.Project, &e.Seq, &at, &e.Actor, &e.Action, &e.Target, &e.Detail, &e.PrevHash, &e.Hash, &e.KeyVersion); err != nil {
+		return nil, err
+	}
+	e.Time = time.Unix(0, at).UTC()
+	return &e, nil
+}
+
+func scanInvitation(row scanner) (*invitation.Invitation, error) {
+	var inv invitation.Invitation
+	var lvl int
+	var createdAt, expiresAt int64
+	if err := row.Scan(&inv.ID, &inv.Project, &inv.Email, &lvl, &inv.InvitedBy, &createdAt, &expiresAt); err != nil {
+		return nil, err
+	}
+	inv.PrivilegeLvl = privilege.Level(lvl)
+	inv.CreatedAt = time.Unix(createdAt, 0)
+	inv.ExpiresAt = time.Unix(expiresAt, 0)
+	return &inv, nil
+}
+
+func scanRevision(row scanner) (*revision.Revision, error) {
+	var rev revision.Revision
+	var number, createdAt int64
+	if err := row.Scan(&rev.Project, &number, &rev.Author, &createdAt, &rev.Hash, &rev.Content); err != nil {
+		return nil, err
+	}
+	rev.Number = uint64(number)
+	rev.Time = time.Unix(createdAt, 0).UTC()
+	return &rev, nil
+}
+
+// marshalProjectFields json-encodes the project fields which are
+// stored as text columns
+func marshalProjectFields(p *project.Project) (string, string, string, string, error) {
+	members, err := json.Marshal(p.Members)
+	if err != nil {
+		return "", "", "", "", fmt.Errorf("could not marshal project members: %s", err)
+	}
+	svcAccts, err := json.Marshal(p.ServiceAccounts)
+	if err != nil {
+		return "", "", "", "", fmt.Errorf("could not marshal project service accounts: %s", err)
+	}
+	prevKeys := []project.RetiredKey{}
+	if p.PreviousKeys != nil {
+		prevKeys = p.PreviousKeys
+	}
+	prevKeysByt, err := json.Marshal(prevKeys)
+	if err != nil {
+		return "", "", "", "", fmt.Errorf("could not marshal project previous keys: %s", err)
+	}
+	transfer, err := json.Marshal(p.Transfer)
+	if err != nil {
+		return "", "", "", "", fmt.Errorf("could not marshal project ownership transfer: %s", err)
+	}
+	return string(members), string(svcAccts), string(prevKeysByt), string(transfer), nil
+}
@@ -0,0 +1,52 @@
+package token
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// refreshTokenSize is the size in bytes of the random refresh tokens
+const refreshTokenSize = 32
+
+// RefreshToken is the server-side record of a refresh token. Only the hash
+// of the token itself is stored. Refresh tokens are single use - every use
+// rotates the token for a new one in the same family. A family starts at
+// login, so that all tokens descending from a login can be revoked at once
+type RefreshToken struct {
+	ID        string    // hex encoded sha256 hash of the token
+	Family    string    // id of the login the token descends from
+	Subject   string    // email of the user
+	ExpiresAt time.Time // expiry of this token (not of the family)
+	Used      bool      // set once the token has been rotated
+}
+
+// NewRefreshToken returns a new random refresh token,
+// along with the record to store for it
+func NewRefreshToken(sub, family string, lifetime time.Duration) (string, *RefreshToken, error) {
+	byt := make([]byte, refreshTokenSize)
+	if _, err := rand.Read(byt); err != nil {
+		return "", nil, fmt.Errorf("could not generate refresh token: %s", err)
+	}
+	raw := base64.RawURLEncoding.EncodeToString(byt)
+	return raw, &RefreshToken{
+		ID:        HashRefreshToken(raw),
+		Family:    family,
+		Subject:   sub,
+		ExpiresAt: time.Now().Add(lifetime),
+	}, nil
+}
+
+// HashRefreshToken returns the id under which a refresh token is stored
+func HashRefreshToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// Expired returns true if the refresh token is past its expiry
+func (rt *RefreshToken) Expired() bool {
+	return time.Now().After(rt.ExpiresAt)
+}
@@ -0,0 +1,18 @@
+package token
+
+import "time"
+
+// RevokedToken is an entry in the revocation list. A JWT is revoked by its
+// id (jti) and need only be listed until its own expiry, after which it
+// would be rejected regardless
+type RevokedToken struct {
+	ID        string    // jti of the revoked token
+	Subject   string    // subject of the revoked token
+	ExpiresAt time.Time // expiry of the revoked token
+}
+
+// Expired returns true if the revoked token is past its expiry,
+// meaning that the entry can be pruned from the revocation list
+func (rt *RevokedToken) Expired() bool {
+	return time.Now().After(rt.ExpiresAt)
+}
@@ -12,6 +12,7 @@ type User struct {
 	HashedPass string
 	KeyID      string
 	Projects   []string
+	Version    int // incremented by every update, for optimistic concurrency control
 }
 
 // NewUser takes in user email, password, and public key id
@@ -2,8 +2,10 @@ package commands
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/adrianosela/padl/cli/config"
 	"github.com/adrianosela/padl/lib/keymgr"
@@ -43,6 +45,51 @@ var AccountCmds = cli.Command{
 			Before: createConfigIfDoesNotExist,
 			Action: loginAccountHandler,
 		},
+		{
+			Name:   "logout",
+			Usage:  "revoke the current padl token and remove it from the configuration",
+			Action: logoutAccountHandler,
+		},
+		{
+			Name:  "delete",
+			Usage: "delete your account, leaving all your projects",
+			Flags: []cli.Flag{
+				passwordFlag,
+			},
+			Action: deleteAccountHandler,
+		},
+		{
+			Name:  "invites",
+			Usage: "manage your invitations to join projects",
+			Subcommands: []cli.Command{
+				{
+					Name:  "list",
+					Usage: "list your pending invitations",
+					Flags: []cli.Flag{
+						jsonFlag,
+					},
+					Action: listInvitationsHandler,
+				},
+				{
+					Name:  "accept",
+					Usage: "accept an invitation, joining its project",
+					Flags: []cli.Flag{
+						asMandatory(idFlag),
+					},
+					Before: invitationValidator,
+					Action: acceptInvitationHandler,
+				},
+				{
+					Name:  "decline",
+					Usage: "decline an invitation",
+					Flags: []cli.Flag{
+						asMandatory(idFlag),
+					},
+					Before: invitationValidator,
+					Action: declineInvitationHandler,
+				},
+			},
+		},
 		{
 			Name:  "rotate-key",
 			Usage: "create a fresh user key and publish the public key",
@@ -51,6 +98,31 @@ var AccountCmds = cli.Command{
 			},
 			Action: rotateKeyHandler,
 		},
+		{
+			Name:   "passphrase",
+			Usage:  "change the passphrase of your private keys, an empty passphrase removes it",
+			Action: changePassphraseHandler,
+		},
+		{
+			Name:  "export-key",
+			Usage: "export a private key as it is saved, i.e. encrypted if it has a passphrase",
+			Flags: []cli.Flag{
+				asMandatoryIf(idFlag, "there is more than one key"),
+				withUsage(pathFlag, "file to export to, standard output if not set"),
+			},
+			Action: exportKeyHandler,
+		},
+		{
+			Name:  "import-key",
+			Usage: "import a private key exported from another machine",
+			Flags: []cli.Flag{
+				asMandatory(withUsage(pathFlag, "file to import from")),
+			},
+			Before: func(ctx *cli.Context) error {
+				return assertSet(ctx, pathFlag)
+			},
+			Action: importKeyHandler,
+		},
 		{
 			Name:  "show",
 			Usage: "show account details based on padl token",
@@ -64,11 +136,11 @@ var AccountCmds = cli.Command{
 
 func createConfigIfDoesNotExist(ctx *cli.Context) error {
 	cPath := ctx.GlobalString(name(ConfigFlag))
-	if _, err := config.GetConfig(cPath); err == nil {
+	if _, err := config.GetConfig(cPath, profileName(ctx)); err == nil {
 		return nil
 	}
 	c := &config.Config{HostURL: defaultHostURL}
-	if err := config.SetConfig(c, cPath); err != nil {
+	if err := config.SetConfig(c, cPath, profileName(ctx)); err != nil {
 		return fmt.Errorf("could not set configuration: %s", err)
 	}
 	return nil
@@ -106,7 +178,7 @@ func createAccountHandler(ctx *cli.Context) error {
 	}
 
 	// save private key in filesystem
-	keyMgr, err := keymgr.NewFSManager(config.GetDefaultPath())
+	keyMgr, err := getFSKeyManager(ctx)
 	if err != nil {
 		return fmt.Errorf("could not establish key manager: %s", err)
 	}
@@ -130,7 +202,7 @@ func rotateKeyHandler(ctx *cli.Context) error {
 		return fmt.Errorf("could not generate key pair: %s", err)
 	}
 	// save private key in filesystem
-	keyMgr, err := keymgr.NewFSManager(config.GetDefaultPath())
+	keyMgr, err := getFSKeyManager(ctx)
 	if err != nil {
 		return fmt.Errorf("could not establish key manager: %s", err)
 	}
@@ -145,6 +217,114 @@ func rotateKeyHandler(ctx *cli.Context) error {
 	return nil
 }
 
+func changePassphraseHandler(ctx *cli.Context) error {
+	keyMgr, err := getFSKeyManager(ctx)
+	if err != nil {
+		return fmt.Errorf("could not establish key manager: %s", err)
+	}
+	ids, err := keyMgr.ListPrivs()
+	if err != nil {
+		return err
+	}
+	// unlock every key before changing any of them
+	privs := make(map[string]string)
+	for _, id := range ids {
+		if privs[id], err = keyMgr.GetPriv(id); err != nil {
+			return err
+		}
+	}
+	passphrase, err := promptNewPassphrase("Enter the new passphrase of your private keys (empty for none):")
+	if err != nil {
+		return fmt.Errorf("could not read new passphrase: %s", err)
+	}
+	keyMgr.Lock = func() (string, error) { return passphrase, nil }
+	for _, id := range ids {
+		if err = keyMgr.PutPriv(id, privs[id]); err != nil {
+			return fmt.Errorf("could not save private key %s: %s", id, err)
+		}
+	}
+	if passphrase == "" {
+		fmt.Printf("removed the passphrase of %d private keys\n", len(ids))
+		return nil
+	}
+	fmt.Printf("changed the passphrase of %d private keys\n", len(ids))
+	return nil
+}
+
+func exportKeyHandler(ctx *cli.Context) error {
+	keyMgr, err := getFSKeyManager(ctx)
+	if err != nil {
+		return fmt.Errorf("could not establish key manager: %s", err)
+	}
+	id := ctx.String(name(idFlag))
+	if id == "" {
+		ids, err := keyMgr.ListPrivs()
+		if err != nil {
+			return err
+		}
+		if len(ids) != 1 {
+			return fmt.Errorf("found %d private keys, select one with --%s: %s", len(ids), name(idFlag), strings.Join(ids, ", "))
+		}
+		id = ids[0]
+	}
+	blob, err := keyMgr.ExportPriv(id)
+	if err != nil {
+		return err
+	}
+	path := ctx.String(name(pathFlag))
+	if path == "" {
+		fmt.Print(blob)
+		return nil
+	}
+	if err = ioutil.WriteFile(path, []byte(blob), 0600); err != nil {
+		return fmt.Errorf("could not write private key: %s", err)
+	}
+	if !keymgr.IsEncrypted(blob) {
+		fmt.Fprintln(os.Stderr, "[warning] the exported private key is not encrypted, set a passphrase with \"padl account passphrase\"")
+	}
+	fmt.Printf("exported private key %s to %s\n", id, path)
+	return nil
+}
+
+func importKeyHandler(ctx *cli.Context) error {
+	path := ctx.String(name(pathFlag))
+	byt, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read private key %s: %s", path, err)
+	}
+	keyMgr, err := getFSKeyManager(ctx)
+	if err != nil {
+		return fmt.Errorf("could not establish key manager: %s", err)
+	}
+	blob := string(byt)
+	pem := blob
+	if keymgr.IsEncrypted(blob) {
+		passphrase, err := readPassphrase(ctx, "Enter the passphrase of the imported private key:")
+		if err != nil {
+			return fmt.Errorf("could not read passphrase: %s", err)
+		}
+		if pem, err = keymgr.DecryptPriv(blob, passphrase); err != nil {
+			return fmt.Errorf("could not decrypt private key %s: %s", path, err)
+		}
+	}
+	priv, err := keys.DecodePrivKeyPEM([]byte(pem))
+	if err != nil {
+		return fmt.Errorf("could not materialize private key %s: %s", path, err)
+	}
+	id := keys.GetFingerprint(&priv.PublicKey)
+	// encrypted keys keep their passphrase, others are encrypted like new keys
+	if keymgr.IsEncrypted(blob) {
+		err = keyMgr.ImportPriv(id, blob)
+	} else {
+		err = keyMgr.PutPriv(id, pem)
+	}
+	if err != nil {
+		return fmt.Errorf("could not save private key: %s", err)
+	}
+	fmt.Printf("imported private key %s\n", id)
+	return nil
+}
+
 func loginAccountHandler(ctx *cli.Context) error {
 	c, err := getClient(ctx)
 	if err != nil {
@@ -167,19 +347,20 @@ func loginAccountHandler(ctx *cli.Context) error {
 		}
 	}
 
-	tk, err := c.Login(email, pass)
+	lr, err := c.Login(email, pass)
 	if err != nil {
 		return fmt.Errorf("could not log in: %s", err)
 	}
 
-	conf, err := config.GetConfig(path)
+	conf, err := config.GetConfig(path, profileName(ctx))
 	if err != nil {
 		return fmt.Errorf("could not get config from file system: %s", err)
 	}
 
 	conf.User = email
-	conf.Token = tk
-	if err = config.SetConfig(conf, path); err != nil {
+	conf.Token = lr.Token
+	conf.RefreshToken = lr.RefreshToken
+	if err = config.SetConfig(conf, path, profileName(ctx)); err != nil {
 		return fmt.Errorf("could not write config to file system: %s", err)
 	}
 
@@ -187,6 +368,74 @@ func loginAccountHandler(ctx *cli.Context) error {
 	return nil
 }
 
+func deleteAccountHandler(ctx *cli.Context) error {
+	c, err := getClient(ctx)
+	if err != nil {
+		return fmt.Errorf("could not initialize client: %s", err)
+	}
+
+	path := ctx.GlobalString(name(ConfigFlag))
+	conf, err := config.GetConfig(path, profileName(ctx))
+	if err != nil {
+		return fmt.Errorf("could not get config from file system: %s", err)
+	}
+	if conf.Token == "" {
+		return fmt.Errorf("not logged in")
+	}
+
+	pass := ctx.String(name(passwordFlag))
+	if pass == "" {
+		if pass, err = promptText(fmt.Sprintf("Enter the password of %s to confirm account deletion:", conf.User), true); err != nil {
+			return fmt.Errorf("could not read user password")
+		}
+	}
+
+	if err = c.DeleteAccount(pass); err != nil {
+		return fmt.Errorf("error deleting account: %s", err)
+	}
+
+	conf.Token = ""
+	conf.RefreshToken = ""
+	if err = config.SetConfig(conf, path, profileName(ctx)); err != nil {
+		return fmt.Errorf("could not write config to file system: %s", err)
+	}
+
+	fmt.Printf("account %s deleted successfully!\n", conf.User)
+	return nil
+}
+
+func logoutAccountHandler(ctx *cli.Context) error {
+	c, err := getClient(ctx)
+	if err != nil {
+		return fmt.Errorf("could not initialize client: %s", err)
+	}
+
+	path := ctx.GlobalString(name(ConfigFlag))
+	conf, err := config.GetConfig(path, profileName(ctx))
+	if err != nil {
+		return fmt.Errorf("could not get config from file system: %s", err)
+	}
+	if conf.Token == "" {
+		return fmt.Errorf("not logged in")
+	}
+
+	// the token is removed locally even if revoking it fails,
+	// e.g. because it had already expired
+	revokeErr := c.Logout()
+
+	conf.Token = ""
+	conf.RefreshToken = ""
+	if err = config.SetConfig(conf, path, profileName(ctx)); err != nil {
+		return fmt.Errorf("could not write config to file system: %s", err)
+	}
+	if revokeErr != nil {
+		return fmt.Errorf("removed token from configuration, but could not revoke it: %s", revokeErr)
+	}
+
+	fmt.Printf("user %s logged out successfully!\n", conf.User)
+	return nil
+}
+
 func showAccountHandler(ctx *cli.Context) error {
 	c, err := getClient(ctx)
 	if err != nil {
@@ -0,0 +1,164 @@
+package commands
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+
+	"github.com/olekukonko/tablewriter"
+	cli "gopkg.in/urfave/cli.v1"
+)
+
+// AdminCmds - manage a padl server (admins only)
+var AdminCmds = cli.Command{
+	Name:  "admin",
+	Usage: "Manage the padl server (admins only)",
+	Subcommands: []cli.Command{
+		{
+			Name:  "signing-key",
+			Usage: "manage the keys which sign padl tokens",
+			Subcommands: []cli.Command{
+				{
+					Name:  "list",
+					Usage: "list the public keys which verify padl tokens",
+					Flags: []cli.Flag{
+						jsonFlag,
+					},
+					Action: listSigningKeysHandler,
+				},
+				{
+					Name:  "rotate",
+					Usage: "replace the active signing key, previous keys remain valid for verification",
+					Flags: []cli.Flag{
+						withDefaultInt(signingKeyBitsFlag, 4096),
+						jsonFlag,
+					},
+					Action: rotateSigningKeyHandler,
+				},
+				{
+					Name:  "delete",
+					Usage: "delete a retired signing key, invalidating tokens signed with it",
+					Flags: []cli.Flag{
+						asMandatory(idFlag),
+					},
+					Before: deleteSigningKeyValidator,
+					Action: deleteSigningKeyHandler,
+				},
+			},
+		},
+		{
+			Name:  "fsck",
+			Usage: "check the consistency of the server's users, projects and keys",
+			Flags: []cli.Flag{
+				repairFlag,
+				jsonFlag,
+			},
+			Action: fsckHandler,
+		},
+	},
+}
+
+func deleteSigningKeyValidator(ctx *cli.Context) error {
+	return assertSet(ctx, idFlag)
+}
+
+func listSigningKeysHandler(ctx *cli.Context) error {
+	c, err := getClient(ctx)
+	if err != nil {
+		return fmt.Errorf("could not initialize client: %s", err)
+	}
+
+	set, err := c.GetJWKS()
+	if err != nil {
+		return fmt.Errorf("error getting signing keys: %s", err)
+	}
+
+	if ctx.Bool(name(jsonFlag)) {
+		return printJSON(set)
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Key ID", "Algorithm", "Bits"})
+	for _, k := range set.Keys {
+		n, err := base64.RawURLEncoding.DecodeString(k.Modulus)
+		if err != nil {
+			return fmt.Errorf("could not decode modulus of key %s: %s", k.KeyID, err)
+		}
+		table.Append([]string{k.KeyID, k.Algorithm, fmt.Sprintf("%d", len(n)*8)})
+	}
+	table.Render()
+	return nil
+}
+
+func rotateSigningKeyHandler(ctx *cli.Context) error {
+	c, err := getClient(ctx)
+	if err != nil {
+		return fmt.Errorf("could not initialize client: %s", err)
+	}
+
+	resp, err := c.RotateSigningKey(ctx.Int(name(signingKeyBitsFlag)))
+	if err != nil {
+		return fmt.Errorf("error rotating signing key: %s", err)
+	}
+
+	if ctx.Bool(name(jsonFlag)) {
+		return printJSON(resp)
+	}
+
+	fmt.Printf("signing key rotated successfully, new key id: %s\n", resp.KeyID)
+	return nil
+}
+
+func deleteSigningKeyHandler(ctx *cli.Context) error {
+	c, err := getClient(ctx)
+	if err != nil {
+		return fmt.Errorf("could not initialize client: %s", err)
+	}
+
+	kid := ctx.String(name(idFlag))
+	if err = c.DeleteSigningKey(kid); err != nil {
+		return fmt.Errorf("error deleting signing key: %s", err)
+	}
+
+	fmt.Printf("signing key %s deleted successfully!\n", kid)
+	return nil
+}
+
+func fsckHandler(ctx *cli.Context) error {
+	c, err := getClient(ctx)
+	if err != nil {
+		return fmt.Errorf("could not initialize client: %s", err)
+	}
+
+	resp, err := c.Fsck(ctx.Bool(name(repairFlag)))
+	if err != nil {
+		return fmt.Errorf("error checking consistency: %s", err)
+	}
+
+	if ctx.Bool(name(jsonFlag)) {
+		return printJSON(resp)
+	}
+
+	if len(resp.Problems) == 0 {
+		fmt.Println("no problems found")
+		return nil
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Kind", "Subject", "Detail", "Repaired"})
+	for _, pr := range resp.Problems {
+		repaired := "no"
+		if pr.Repaired {
+			repaired = "yes"
+		} else if pr.RepairError != "" {
+			repaired = fmt.Sprintf("failed: %s", pr.RepairError)
+		}
+		table.Append([]string{pr.Kind, pr.Subject, pr.Detail, repaired})
+	}
+	table.Render()
+
+	if !ctx.Bool(name(repairFlag)) {
+		fmt.Printf("%d problem(s) found, run with --%s to repair those which can be\n", len(resp.Problems), name(repairFlag))
+	}
+	return nil
+}
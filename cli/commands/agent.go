@@ -0,0 +1,119 @@
+package commands
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/adrianosela/padl/api/client"
+	"github.com/adrianosela/padl/lib/agent"
+	"github.com/adrianosela/padl/lib/keymgr"
+	"github.com/adrianosela/padl/lib/keys"
+	"github.com/adrianosela/padl/lib/padlfile"
+	"github.com/adrianosela/padl/lib/secretsmgr"
+	cli "gopkg.in/urfave/cli.v1"
+)
+
+// AgentCmds - run a padl agent
+var AgentCmds = cli.Command{
+	Name:  "agent",
+	Usage: "Run an agent holding your keys and token, which decrypts secrets for padl commands and local apps",
+	Flags: []cli.Flag{
+		socketFlag,
+	},
+	Action: agentHandler,
+}
+
+func agentHandler(ctx *cli.Context) error {
+	// get client, its token is kept fresh for the lifetime of the agent
+	pc, err := getClient(ctx)
+	if err != nil {
+		return fmt.Errorf("could not get client: %s", err)
+	}
+	claims, err := pc.Valid()
+	if err != nil {
+		return fmt.Errorf("could not validate token: %s", err)
+	}
+	// get all the user's keys, as padlfiles which have not been pulled
+	// since the user's key was rotated are still encrypted for older keys
+	keyMgr, err := getFSKeyManager(ctx)
+	if err != nil {
+		return fmt.Errorf("could not establish key manager: %s", err)
+	}
+	ids, err := keyMgr.ListPrivs()
+	if err != nil {
+		return err
+	}
+	privs := []*rsa.PrivateKey{}
+	for _, id := range ids {
+		pem, err := keyMgr.GetPriv(id)
+		if err != nil {
+			return err
+		}
+		priv, err := keys.DecodePrivKeyPEM([]byte(pem))
+		if err != nil {
+			return fmt.Errorf("could not materialize private key %s: %s", id, err)
+		}
+		privs = append(privs, priv)
+	}
+	if len(privs) == 0 {
+		return fmt.Errorf("no private keys found")
+	}
+
+	sock := ctx.String(name(socketFlag))
+	if sock == "" {
+		dir, err := ioutil.TempDir("", "padl-agent-")
+		if err != nil {
+			return fmt.Errorf("could not create socket directory: %s", err)
+		}
+		defer os.RemoveAll(dir)
+		sock = filepath.Join(dir, "agent.sock")
+	}
+	l, err := agent.Listen(sock)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(sock)
+
+	// the socket is removed on exit
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		l.Close()
+	}()
+
+	fmt.Printf("%s=%s; export %s;\n", agent.SockEnvVar, sock, agent.SockEnvVar)
+	fmt.Printf("echo padl agent pid %d serving %d keys of %s;\n", os.Getpid(), len(privs), claims.Subject)
+	agent.NewServer(pc, claims.Subject, privs).Serve(l)
+	return nil
+}
+
+// getAgent returns a client of the agent whose socket is set in the
+// environment, or nil if there is none or the command was given keys
+// (or a service account) of its own to decrypt with
+func getAgent(ctx *cli.Context) *agent.Client {
+	sock := os.Getenv(agent.SockEnvVar)
+	if sock == "" || ctx.String(name(privateKeyFlag)) != "" ||
+		len(ctx.StringSlice(name(extraKeyFlag))) > 0 || ctx.String(name(serviceAccountFlag)) != "" {
+		return nil
+	}
+	return agent.NewClient(sock)
+}
+
+// decryptPadlfileSecrets decrypts the secrets of a padlfile with
+// the agent if one is in use, and with the command's keys otherwise
+func decryptPadlfileSecrets(ctx *cli.Context, pc *client.Padl, keyMgr keymgr.Manager, view *padlfile.File) (map[string]string, error) {
+	if ac := getAgent(ctx); ac != nil {
+		return ac.Decrypt(view.Data.SharedKey, view.Data.Variables)
+	}
+	privs, err := getPrivateKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return secretsmgr.NewSecretsMgr(pc, keyMgr, view).DecryptPadlFileSecrets(privs...)
+}
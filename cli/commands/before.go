@@ -4,8 +4,6 @@ import (
 	"fmt"
 	"os"
 
-	"github.com/adrianosela/padl/cli/config"
-	"github.com/adrianosela/padl/lib/keymgr"
 	"github.com/adrianosela/padl/lib/padlfile"
 	cli "gopkg.in/urfave/cli.v1"
 )
@@ -17,10 +15,21 @@ func checkCanModifyPadlFile(ctx *cli.Context) error {
 		return err
 	}
 
+	// look for a key of the selected environment's members, if it exists yet
+	memberKeys := pf.Data.MemberKeys
+	if view, err := pf.ForEnv(ctx.String(name(envFlag))); err == nil {
+		memberKeys = view.Data.MemberKeys
+	}
+
+	// the agent holds the user's keys
+	if getAgent(ctx) != nil {
+		return nil
+	}
+
 	// check if there was a private key provided
 	if key := ctx.String(name(privateKeyFlag)); key == "" {
 		// if not, then look for one in the file system
-		key, kid, err := getUserKey(config.GetDefaultPath(), pf.Data.MemberKeys)
+		key, kid, err := getUserKey(ctx, memberKeys)
 		if err != nil {
 			return err
 		}
@@ -33,23 +42,32 @@ func checkCanModifyPadlFile(ctx *cli.Context) error {
 	return nil
 }
 
-func getUserKey(keyMgrPath string, keyIDs []string) (string, string, error) {
+func getUserKey(ctx *cli.Context, keyIDs []string) (string, string, error) {
 	// init new key manager at the config path
-	mgr, err := keymgr.NewFSManager(keyMgrPath)
+	mgr, err := getFSKeyManager(ctx)
 	if err != nil {
 		return "", "", err
 	}
-	// get any private key that matches a ids given
-	key, id := "", ""
+	// get any private key that matches a ids given, only
+	// the key found is unlocked if it is encrypted
+	ids, err := mgr.ListPrivs()
+	if err != nil {
+		return "", "", err
+	}
+	have := make(map[string]bool)
+	for _, id := range ids {
+		have[id] = true
+	}
 	for _, k := range keyIDs {
-		if key, err = mgr.GetPriv(k); err == nil {
-			id = k
-			break
+		if have[k] {
+			key, err := mgr.GetPriv(k)
+			if err != nil {
+				return "", "", err
+			}
+			return key, k, nil
 		}
 	}
-	if key == "" {
-		fmt.Println("error: no valid decryption key found")
-		os.Exit(1)
-	}
-	return key, id, nil
+	fmt.Println("error: no valid decryption key found")
+	os.Exit(1)
+	return "", "", nil
 }
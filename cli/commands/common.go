@@ -2,8 +2,10 @@ package commands
 
 import (
 	"bufio"
+	"crypto/rsa"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"strings"
 	"syscall"
@@ -11,25 +13,104 @@ import (
 	"github.com/adrianosela/padl/api/client"
 	"github.com/adrianosela/padl/api/privilege"
 	"github.com/adrianosela/padl/cli/config"
+	"github.com/adrianosela/padl/lib/keys"
 	"github.com/olekukonko/tablewriter"
 	"golang.org/x/crypto/ssh/terminal"
 	cli "gopkg.in/urfave/cli.v1"
 )
 
 func getClient(ctx *cli.Context) (*client.Padl, error) {
-	config, err := config.GetConfig(ctx.GlobalString("config"))
+	path := ctx.GlobalString("config")
 	authToken := ctx.GlobalString("auth-token")
 	hostURL := ctx.GlobalString("host-url")
+	conf, err := config.GetConfig(path, profileName(ctx))
+	if err != nil {
+		// no configuration is needed if the host url is given e.g.
+		// on CI systems authenticating with service accounts
+		if hostURL == "" {
+			return nil, err
+		}
+		return client.NewPadlClient(hostURL, authToken, nil)
+	}
 	if hostURL == "" {
-		hostURL = config.HostURL
+		hostURL = conf.HostURL
+	}
+	c, err := client.NewPadlClient(hostURL, authToken, nil)
+	if err != nil {
+		return nil, err
 	}
+	// only the configured token can be refreshed, as
+	// the refresh token belongs to the configured login
 	if authToken == "" {
-		authToken = config.Token
+		c.AuthToken = conf.Token
+		c.RefreshToken = conf.RefreshToken
+		c.OnRefresh = func(authToken, refreshToken string) {
+			conf.Token = authToken
+			conf.RefreshToken = refreshToken
+			if err := config.SetConfig(conf, path, profileName(ctx)); err != nil {
+				fmt.Fprintf(os.Stderr, "[warn] could not save refreshed token: %s\n", err)
+			}
+		}
 	}
+	return c, nil
+}
+
+// profileName returns the name of the configuration profile
+// to use, the empty string being the default profile
+func profileName(ctx *cli.Context) string {
+	return ctx.GlobalString(name(ProfileFlag))
+}
+
+// useServiceAccount makes the client authenticate as the service
+// account given with the service account flag, if any, rather
+// than as the configured user
+func useServiceAccount(ctx *cli.Context, pc *client.Padl) error {
+	path := ctx.String(name(serviceAccountFlag))
+	if path == "" {
+		return nil
+	}
+	sa, err := readServiceAccount(path)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	pc.ServiceAccount = sa
+	pc.AuthToken, pc.RefreshToken, pc.OnRefresh = "", "", nil
+	return nil
+}
+
+// readServiceAccount reads a service account credentials file
+func readServiceAccount(path string) (*client.ServiceAccount, error) {
+	byt, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read service account credentials %s: %s", path, err)
+	}
+	var sa client.ServiceAccount
+	if err = json.Unmarshal(byt, &sa); err != nil {
+		return nil, fmt.Errorf("could not unmarshal service account credentials %s: %s", path, err)
+	}
+	return &sa, nil
+}
+
+// getPrivateKeys returns the user's private key followed
+// by any extra (other members') private keys provided
+func getPrivateKeys(ctx *cli.Context) ([]*rsa.PrivateKey, error) {
+	priv, err := keys.DecodePrivKeyPEM([]byte(ctx.String(name(privateKeyFlag))))
+	if err != nil {
+		return nil, fmt.Errorf("could not materialize user private key: %s", err)
+	}
+	privs := []*rsa.PrivateKey{priv}
+	for _, path := range ctx.StringSlice(name(extraKeyFlag)) {
+		pem, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not read private key %s: %s", path, err)
+		}
+		extra, err := keys.DecodePrivKeyPEM(pem)
+		if err != nil {
+			return nil, fmt.Errorf("could not materialize private key %s: %s", path, err)
+		}
+		privs = append(privs, extra)
 	}
-	return client.NewPadlClient(hostURL, authToken, nil)
+	return privs, nil
 }
 
 func printJSON(i interface{}) error {
@@ -1,6 +1,7 @@
 package commands
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
@@ -33,6 +34,24 @@ var ConfigCmds = cli.Command{
 			},
 			Action: configShowHandler,
 		},
+		{
+			Name:      "use",
+			Usage:     "make a configuration profile the default profile",
+			ArgsUsage: "PROFILE",
+			Flags: []cli.Flag{
+				ConfigFlag,
+			},
+			Before: configUseValidator,
+			Action: configUseHandler,
+		},
+		{
+			Name:  "list",
+			Usage: "list configuration profiles",
+			Flags: []cli.Flag{
+				ConfigFlag,
+			},
+			Action: configListHandler,
+		},
 	},
 }
 
@@ -43,22 +62,27 @@ func configSetValidator(ctx *cli.Context) error {
 func configSetHandler(ctx *cli.Context) error {
 	if err := config.SetConfig(&config.Config{
 		HostURL: ctx.String(name(urlFlag)),
-	}, ctx.String(name(pathFlag))); err != nil {
+	}, ctx.String(name(ConfigFlag)), profileName(ctx)); err != nil {
 		return fmt.Errorf("could not set configuration: %s", err)
 	}
 	return nil
 }
 
 func configShowHandler(ctx *cli.Context) error {
-	path := ctx.String(name(pathFlag))
-	c, err := config.GetConfig(path)
+	path := ctx.String(name(ConfigFlag))
+	f, err := config.ReadFile(path)
 	if err != nil {
 		return fmt.Errorf("could not retrive configuration from %s: %s", path, err)
 	}
+	profile := f.Selected(profileName(ctx))
+	c, ok := f.Profiles[profile]
+	if !ok {
+		return fmt.Errorf("profile %s does not exist", profile)
+	}
 	table := tablewriter.NewWriter(os.Stdout)
+	table.Append([]string{"PROFILE", profile})
 	table.Append([]string{"HOST_URL", c.HostURL})
 	if c.User != "" {
-
 		table.Append([]string{"USER", c.User})
 	}
 	if c.Token != "" {
@@ -67,3 +91,39 @@ func configShowHandler(ctx *cli.Context) error {
 	table.Render()
 	return nil
 }
+
+func configUseValidator(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return errors.New("a profile name must be given")
+	}
+	return nil
+}
+
+func configUseHandler(ctx *cli.Context) error {
+	profile := ctx.Args().First()
+	if err := config.UseProfile(ctx.String(name(ConfigFlag)), profile); err != nil {
+		return fmt.Errorf("could not use profile %s: %s", profile, err)
+	}
+	fmt.Printf("using profile %s\n", profile)
+	return nil
+}
+
+func configListHandler(ctx *cli.Context) error {
+	path := ctx.String(name(ConfigFlag))
+	f, err := config.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not retrive configuration from %s: %s", path, err)
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Profile", "Host URL", "User", "Default"})
+	for _, profile := range f.ProfileNames() {
+		c := f.Profiles[profile]
+		def := ""
+		if profile == f.DefaultProfile {
+			def = "*"
+		}
+		table.Append([]string{profile, c.HostURL, c.User, def})
+	}
+	table.Render()
+	return nil
+}
@@ -1,93 +1,200 @@
 package commands
 
 import (
-	"bufio"
 	"fmt"
 	"os"
 	"os/exec"
+	"os/signal"
+	"syscall"
 
-	"github.com/adrianosela/padl/cli/config"
+	"github.com/adrianosela/padl/api/client"
 	"github.com/adrianosela/padl/lib/keymgr"
-	"github.com/adrianosela/padl/lib/keys"
 	"github.com/adrianosela/padl/lib/padlfile"
-	"github.com/adrianosela/padl/lib/secretsmgr"
+	"github.com/adrianosela/padl/lib/secretfiles"
 	cli "gopkg.in/urfave/cli.v1"
 )
 
+// secretsDirEnvVar is the environment variable holding the
+// path of the directory of the secrets mounted as files
+const secretsDirEnvVar = "PADL_SECRETS_DIR"
+
 // RunCmds - run a command with injected secrets
 var RunCmds = cli.Command{
 	Name:    "run",
 	Aliases: []string{"r"},
 	Usage:   "Run a command with secrets in the environment",
+	// everything after the command name is an argument of the command
+	SkipArgReorder: true,
+	ArgsUsage:      "[--] COMMAND [ARGS...]",
 	Flags: []cli.Flag{
-		asMandatory(nameFlag),
 		withDefault(fmtFlag, "yaml"),
 		privateKeyFlag, // set by BeforeFunc
 		pathFlag,
+		extraKeyFlag,
+		serviceAccountFlag,
+		envFlag,
+		execFlag,
 	},
-	Before: checkCanModifyPadlFile,
+	Before: runValidator,
 	Action: runHandler,
 }
 
+func runValidator(ctx *cli.Context) error {
+	path := ctx.String(name(serviceAccountFlag))
+	if path == "" {
+		return checkCanModifyPadlFile(ctx)
+	}
+	// service accounts decrypt with their own key
+	sa, err := readServiceAccount(path)
+	if err != nil {
+		return err
+	}
+	if ctx.String(name(privateKeyFlag)) == "" {
+		return ctx.Set(name(privateKeyFlag), sa.PrivateKey)
+	}
+	return nil
+}
+
 func runHandler(ctx *cli.Context) error {
 	format := ctx.String(name(fmtFlag))
-	priv := ctx.String(name(privateKeyFlag))
 	path := padlfilePath(ctx.String(name(pathFlag)), format)
-	// get client
-	pc, err := getClient(ctx)
-	if err != nil {
-		return fmt.Errorf("could not get client: %s", err)
-	}
 	// read padlfile
 	pf, err := padlfile.ReadPadlfile(path)
 	if err != nil {
 		return fmt.Errorf("could not read padlfile: %s", err)
 	}
-	// get key manager
-	keyMgr, err := keymgr.NewFSManager(config.GetDefaultPath())
+	// only the secrets of the given environment are injected
+	view, err := pf.ForEnv(ctx.String(name(envFlag)))
 	if err != nil {
-		return fmt.Errorf("could not establish key manager: %s", err)
+		return err
 	}
-	secMgr := secretsmgr.NewSecretsMgr(pc, keyMgr, pf)
-	// decrypted secret and print it
-	rsa, err := keys.DecodePrivKeyPEM([]byte(priv))
-	if err != nil {
-		return fmt.Errorf("could not materialize user private key: %s", err)
+	// the client and keys are only needed without an agent
+	var pc *client.Padl
+	var keyMgr keymgr.Manager
+	if getAgent(ctx) == nil {
+		if pc, err = getClient(ctx); err != nil {
+			return fmt.Errorf("could not get client: %s", err)
+		}
+		if err = useServiceAccount(ctx, pc); err != nil {
+			return err
+		}
+		if keyMgr, err = getKeyManager(ctx); err != nil {
+			return fmt.Errorf("could not establish key manager: %s", err)
+		}
 	}
-
-	secretsMap, err := secMgr.DecryptPadlFileSecrets(rsa)
+	// decrypt secrets
+	secretsMap, err := decryptPadlfileSecrets(ctx, pc, keyMgr, view)
 	if err != nil {
 		return fmt.Errorf("could not decrypt padlfile secrets: %s", err)
 	}
 
-	var cmd *exec.Cmd
-	if len(os.Args) > 3 {
-		cmd = exec.Command(os.Args[2], os.Args[3:]...)
-	} else if len(os.Args) > 2 {
-		cmd = exec.Command(os.Args[2])
-	} else {
+	argv := ctx.Args()
+	if len(argv) == 0 {
 		return fmt.Errorf("no command provided")
 	}
+	bin, err := exec.LookPath(argv[0])
+	if err != nil {
+		return fmt.Errorf("could not find command %s: %s", argv[0], err)
+	}
 
 	// copy parent environment
-	cmd.Env = os.Environ()
+	env := os.Environ()
+	if len(view.Data.Files) > 0 {
+		if ctx.Bool(name(execFlag)) {
+			return fmt.Errorf("--exec can not be used with secrets mounted as files, which padl removes when the command exits")
+		}
+		dir, err := mountSecretFiles(view.Data.Files, secretsMap)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := dir.Remove(); err != nil {
+				fmt.Fprintf(os.Stderr, "[warning] %s\n", err)
+			}
+		}()
+		env = append(env, fmt.Sprintf("%s=%s", secretsDirEnvVar, dir.Path()))
+	}
 	// attach decrypted secret to the cmd's environment
 	for k, v := range secretsMap {
-		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, v))
+		env = append(env, fmt.Sprintf("%s=%s", k, v))
 	}
 
-	return runCmdAndPipeStdout(cmd)
+	if ctx.Bool(name(execFlag)) {
+		return execCmd(bin, argv, env)
+	}
+	return superviseCmd(bin, argv, env)
 }
 
-func runCmdAndPipeStdout(cmd *exec.Cmd) error {
-	stdout, err := cmd.StdoutPipe()
+// mountSecretFiles writes the secrets mounted as files to a new secrets
+// directory, replacing their values in the secrets map with their paths
+func mountSecretFiles(files, secretsMap map[string]string) (*secretfiles.Dir, error) {
+	dir, err := secretfiles.NewDir()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	scanner := bufio.NewScanner(stdout)
-	cmd.Start()
-	for scanner.Scan() {
-		fmt.Println(scanner.Text())
+	for varName, fileName := range files {
+		secret, ok := secretsMap[varName]
+		if !ok {
+			dir.Remove()
+			return nil, fmt.Errorf("secret %s mounted as file %s not in padlfile", varName, fileName)
+		}
+		if secretsMap[varName], err = dir.Write(fileName, []byte(secret)); err != nil {
+			dir.Remove()
+			return nil, err
+		}
 	}
-	return nil
+	return dir, nil
+}
+
+// superviseCmd runs a command as a child process with the standard
+// streams of padl, such that a terminal is passed through as is.
+// The signals padl receives are forwarded to the command (except those
+// sent by the terminal, which the command got too), and padl exits with
+// the command's exit status
+func superviseCmd(bin string, argv, env []string) error {
+	cmd := exec.Command(bin, argv[1:]...)
+	cmd.Env = env
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, forwardedSignals...)
+	defer signal.Stop(sigs)
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("could not start command: %s", err)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case sig := <-sigs:
+				if !fromTerminal(sig) {
+					cmd.Process.Signal(sig)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	err := cmd.Wait()
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*exec.ExitError); !ok {
+		return fmt.Errorf("could not run command: %s", err)
+	}
+	return cli.NewExitError("", exitStatus(cmd.ProcessState))
+}
+
+// exitStatus returns the exit status of a process, following the shell
+// convention of 128 + the signal number for processes killed by a signal
+func exitStatus(ps *os.ProcessState) int {
+	if ws, ok := ps.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return 128 + int(ws.Signal())
+	}
+	return ps.ExitCode()
 }
@@ -0,0 +1,118 @@
+//go:build !windows
+// +build !windows
+
+package commands
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	cli "gopkg.in/urfave/cli.v1"
+)
+
+// shArgv returns the argv of a shell running a script
+func shArgv(t *testing.T, script string) (string, []string) {
+	bin, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no sh in PATH")
+	}
+	return bin, []string{"sh", "-c", script}
+}
+
+func TestExitStatus(t *testing.T) {
+	tests := []struct {
+		testName   string
+		script     string
+		expectCode int
+	}{
+		{
+			testName:   "success",
+			script:     "exit 0",
+			expectCode: 0,
+		},
+		{
+			testName:   "exit status",
+			script:     "exit 3",
+			expectCode: 3,
+		},
+		{
+			testName:   "killed by SIGTERM",
+			script:     "kill -TERM $$",
+			expectCode: 128 + int(syscall.SIGTERM),
+		},
+		{
+			testName:   "killed by SIGKILL",
+			script:     "kill -KILL $$",
+			expectCode: 128 + int(syscall.SIGKILL),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			bin, argv := shArgv(t, test.script)
+			cmd := exec.Command(bin, argv[1:]...)
+			cmd.Run()
+			assert.Equal(t, test.expectCode, exitStatus(cmd.ProcessState))
+		})
+	}
+}
+
+func TestSuperviseCmd(t *testing.T) {
+	tests := []struct {
+		testName   string
+		script     string
+		expectErr  bool
+		expectCode int
+	}{
+		{
+			testName:  "success",
+			script:    "exit 0",
+			expectErr: false,
+		},
+		{
+			testName:   "exit status",
+			script:     "exit 3",
+			expectErr:  true,
+			expectCode: 3,
+		},
+		{
+			testName:   "killed by a signal",
+			script:     "kill -HUP $$",
+			expectErr:  true,
+			expectCode: 128 + int(syscall.SIGHUP),
+		},
+		{
+			// the command signals padl (its parent), which must forward
+			// the signal for the command to exit
+			testName:   "forwarded signal",
+			script:     `trap "exit 7" TERM; kill -TERM $PPID; while :; do sleep 0.1; done`,
+			expectErr:  true,
+			expectCode: 7,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			bin, argv := shArgv(t, test.script)
+			err := superviseCmd(bin, argv, os.Environ())
+			if !test.expectErr {
+				assert.Nil(t, err)
+				return
+			}
+			exitErr, ok := err.(*cli.ExitError)
+			if !assert.True(t, ok, "expected an exit error, got %v", err) {
+				return
+			}
+			assert.Equal(t, test.expectCode, exitErr.ExitCode())
+		})
+	}
+}
+
+func TestFromTerminal(t *testing.T) {
+	// only an interrupt can be sent by the terminal
+	assert.False(t, fromTerminal(syscall.SIGTERM))
+	assert.False(t, fromTerminal(syscall.SIGHUP))
+}
@@ -0,0 +1,43 @@
+//go:build !windows
+// +build !windows
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// forwardedSignals are the signals padl forwards to the commands it runs
+var forwardedSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP}
+
+// execCmd replaces the padl process with a command. It only returns on error
+func execCmd(bin string, argv, env []string) error {
+	if err := syscall.Exec(bin, argv, env); err != nil {
+		return fmt.Errorf("could not exec command: %s", err)
+	}
+	return nil
+}
+
+// fromTerminal returns true if a signal was (most likely) sent by the
+// terminal, which sends it to its whole foreground process group. Commands
+// share padl's process group (so they can read from the terminal), such
+// that they already received the signal and must not get it twice
+func fromTerminal(sig os.Signal) bool {
+	if sig != syscall.SIGINT {
+		return false
+	}
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		// no controlling terminal
+		return false
+	}
+	defer tty.Close()
+	var pgrp int32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, tty.Fd(), uintptr(syscall.TIOCGPGRP), uintptr(unsafe.Pointer(&pgrp))); errno != 0 {
+		return false
+	}
+	return int(pgrp) == syscall.Getpgrp()
+}
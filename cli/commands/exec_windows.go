@@ -0,0 +1,23 @@
+//go:build windows
+// +build windows
+
+package commands
+
+import (
+	"errors"
+	"os"
+)
+
+// forwardedSignals are the signals padl forwards to the commands it runs
+var forwardedSignals = []os.Signal{os.Interrupt}
+
+// execCmd is not supported on windows, which can not replace a process
+func execCmd(bin string, argv, env []string) error {
+	return errors.New("--exec is not supported on windows")
+}
+
+// fromTerminal returns true if a signal was sent by the console, which
+// sends Ctrl+C to every process attached to it (including the command)
+func fromTerminal(sig os.Signal) bool {
+	return sig == os.Interrupt
+}
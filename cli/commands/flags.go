@@ -20,6 +20,20 @@ var (
 		Name:  "config, c",
 		Usage: "override default config file path",
 	}
+	// ProfileFlag is the flag for the configuration profile to use
+	ProfileFlag = cli.StringFlag{
+		Name:   "profile",
+		Usage:  "use this configuration profile rather than the default profile",
+		EnvVar: "PADL_PROFILE",
+	}
+	// PassphraseFDFlag is the flag for a file descriptor
+	// to read the passphrase of private keys from
+	PassphraseFDFlag = cli.IntFlag{
+		Name:   "passphrase-fd",
+		Usage:  "read the passphrase of your private keys from this file descriptor, e.g. 3 for 3<passphrase.txt",
+		Value:  -1,
+		EnvVar: "PADL_KEY_PASSPHRASE_FD",
+	}
 	// VerboseFlag enables/disables process logs
 	VerboseFlag = cli.BoolFlag{
 		Name:  "verbose, vv",
@@ -71,14 +85,34 @@ var (
 		Name:  "bits",
 		Usage: "key bit size - one of { 512, 1024, 2048, 4096 }",
 	}
+	signingKeyBitsFlag = cli.IntFlag{
+		Name:  "bits",
+		Usage: "key bit size - one of { 2048, 4096 }",
+	}
+	graceHoursFlag = cli.IntFlag{
+		Name:  "grace-hours",
+		Usage: "hours the previous project key remains usable for decryption (0 for server default)",
+	}
+	rekeyFlag = cli.BoolFlag{
+		Name:  "rekey",
+		Usage: "rotate the project key and re-encrypt all secrets under the new key",
+	}
 	privFlag = cli.IntFlag{
 		Name:  "privilege",
 		Usage: "privilege level - { Reader: 0, Editor:1, Owner:2 }",
 	}
+	expiresHoursFlag = cli.IntFlag{
+		Name:  "expires-hours",
+		Usage: "hours until the invitation expires (0 for server default)",
+	}
 	secretFlag = cli.StringFlag{
 		Name:  "secret",
 		Usage: "secret to decrypt",
 	}
+	secretFileFlag = cli.StringFlag{
+		Name:  "file",
+		Usage: "mount the secret as a file with this name when running commands, rather than setting it in the environment",
+	}
 	jsonFlag = cli.BoolFlag{
 		Name:  "json, j",
 		Usage: "print raw json -- don't pretty print",
@@ -87,6 +121,72 @@ var (
 		Name:  "fmt",
 		Usage: "preferred padlfile format - one of { \"yaml\", \"json\" }",
 	}
+	policyFlag = cli.StringFlag{
+		Name:  "policy",
+		Usage: "secret threshold policy - one of { \"server+member\", \"break-glass\", \"server+2-owners\" } or \"[server+]N-members|owners\"",
+	}
+	envFlag = cli.StringFlag{
+		Name:   "env",
+		EnvVar: "PADL_ENV",
+		Usage:  "padlfile environment to use e.g. \"prod\", the top-level variables if not set",
+	}
+	envMembersFlag = cli.StringFlag{
+		Name:  "members",
+		Usage: "members with access to the environment - one of { \"all\", \"owners\" }",
+	}
+	envServiceAccountFlag = cli.StringSliceFlag{
+		Name:  "allow-service-account",
+		Usage: "name of a service account with access to the environment (repeatable), all service accounts have access if none are given",
+	}
+	extraKeyFlag = cli.StringSliceFlag{
+		Name:  "extra-key",
+		Usage: "path to another member's private key, for secrets whose policy requires more than one (repeatable)",
+	}
+	serviceAccountFlag = cli.StringFlag{
+		Name:   "service-account",
+		EnvVar: "PADL_SERVICE_ACCOUNT",
+		Usage:  "path to a service account credentials file, to authenticate as the service account",
+	}
+	sinceFlag = cli.StringFlag{
+		Name:  "since",
+		Usage: "only show events after an RFC3339 time, or a duration ago e.g. \"24h\"",
+	}
+	untilFlag = cli.StringFlag{
+		Name:  "until",
+		Usage: "only show events before an RFC3339 time, or a duration ago e.g. \"1h\"",
+	}
+	actorFlag = cli.StringFlag{
+		Name:  "actor",
+		Usage: "only show events by a user or service account email",
+	}
+	verifyFlag = cli.BoolFlag{
+		Name:  "verify",
+		Usage: "verify the integrity of the whole audit log",
+	}
+	jsonlFlag = cli.BoolFlag{
+		Name:  "jsonl",
+		Usage: "export events as JSON lines, one event per line",
+	}
+	revisionFlag = cli.IntFlag{
+		Name:  "revision",
+		Usage: "padlfile revision on the server (0 for the latest)",
+	}
+	forceFlag = cli.BoolFlag{
+		Name:  "force",
+		Usage: "overwrite the local padlfile even if it has changes not pushed to the server",
+	}
+	repairFlag = cli.BoolFlag{
+		Name:  "repair",
+		Usage: "repair the problems found",
+	}
+	socketFlag = cli.StringFlag{
+		Name:  "socket",
+		Usage: "path of the agent's unix socket, in a new private directory if not set",
+	}
+	execFlag = cli.BoolFlag{
+		Name:  "exec",
+		Usage: "replace the padl process with the command, rather than running it as a child process",
+	}
 	privateKeyFlag = cli.StringFlag{
 		Name:  "private-key, k",
 		Usage: "provide a (user's) private key to decrypt",
@@ -110,6 +210,11 @@ func withDefaultInt(f cli.IntFlag, def int) cli.IntFlag {
 	return f
 }
 
+func withUsage(f cli.StringFlag, usage string) cli.StringFlag {
+	f.Usage = usage
+	return f
+}
+
 func asMandatory(f cli.StringFlag) cli.StringFlag {
 	f.Usage = fmt.Sprintf("%s %s", mandatoryTag, f.Usage)
 	return f
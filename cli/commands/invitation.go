@@ -0,0 +1,135 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/adrianosela/padl/api/invitation"
+	"github.com/olekukonko/tablewriter"
+	cli "gopkg.in/urfave/cli.v1"
+)
+
+func inviteUserValidator(ctx *cli.Context) error {
+	return assertSet(ctx, projectFlag, emailFlag)
+}
+
+func revokeInvitationValidator(ctx *cli.Context) error {
+	return assertSet(ctx, projectFlag, idFlag)
+}
+
+func invitationValidator(ctx *cli.Context) error {
+	return assertSet(ctx, idFlag)
+}
+
+func inviteUserHandler(ctx *cli.Context) error {
+	c, err := getClient(ctx)
+	if err != nil {
+		return fmt.Errorf("could not initialize client: %s", err)
+	}
+
+	projectName := ctx.String(name(projectFlag))
+	email := ctx.String(name(emailFlag))
+	privLevel := ctx.Int(name(privFlag))
+
+	inv, err := c.InviteUser(projectName, email, privLevel, ctx.Int(name(expiresHoursFlag)))
+	if err != nil {
+		return fmt.Errorf("error inviting user: %s", err)
+	}
+	fmt.Printf("user %s invited to project %s successfully! (invitation %s expires %s)\n",
+		email, projectName, inv.ID, inv.ExpiresAt.Format(time.RFC3339))
+	fmt.Printf("they can accept with \"padl account invites accept --id %s\"\n", inv.ID)
+	return nil
+}
+
+func listProjectInvitationsHandler(ctx *cli.Context) error {
+	c, err := getClient(ctx)
+	if err != nil {
+		return fmt.Errorf("could not initialize client: %s", err)
+	}
+
+	invs, err := c.ListProjectInvitations(ctx.String(name(projectFlag)))
+	if err != nil {
+		return fmt.Errorf("error fetching invitations: %s", err)
+	}
+	return printInvitations(ctx, invs)
+}
+
+func revokeInvitationHandler(ctx *cli.Context) error {
+	c, err := getClient(ctx)
+	if err != nil {
+		return fmt.Errorf("could not initialize client: %s", err)
+	}
+
+	projectName := ctx.String(name(projectFlag))
+	id := ctx.String(name(idFlag))
+
+	if err := c.RevokeInvitation(projectName, id); err != nil {
+		return fmt.Errorf("error revoking invitation: %s", err)
+	}
+	fmt.Printf("invitation %s to project %s revoked successfully!\n", id, projectName)
+	return nil
+}
+
+func listInvitationsHandler(ctx *cli.Context) error {
+	c, err := getClient(ctx)
+	if err != nil {
+		return fmt.Errorf("could not initialize client: %s", err)
+	}
+
+	invs, err := c.ListInvitations()
+	if err != nil {
+		return fmt.Errorf("error fetching invitations: %s", err)
+	}
+	return printInvitations(ctx, invs)
+}
+
+func acceptInvitationHandler(ctx *cli.Context) error {
+	c, err := getClient(ctx)
+	if err != nil {
+		return fmt.Errorf("could not initialize client: %s", err)
+	}
+
+	id := ctx.String(name(idFlag))
+	if err := c.AcceptInvitation(id); err != nil {
+		return fmt.Errorf("error accepting invitation: %s", err)
+	}
+	fmt.Printf("invitation %s accepted successfully!\n", id)
+	return nil
+}
+
+func declineInvitationHandler(ctx *cli.Context) error {
+	c, err := getClient(ctx)
+	if err != nil {
+		return fmt.Errorf("could not initialize client: %s", err)
+	}
+
+	id := ctx.String(name(idFlag))
+	if err := c.DeclineInvitation(id); err != nil {
+		return fmt.Errorf("error declining invitation: %s", err)
+	}
+	fmt.Printf("invitation %s declined successfully!\n", id)
+	return nil
+}
+
+func printInvitations(ctx *cli.Context, invs []*invitation.Invitation) error {
+	if ctx.Bool(name(jsonFlag)) {
+		return printJSON(&invs)
+	}
+
+	if len(invs) == 0 {
+		fmt.Println("no pending invitations :)")
+		return nil
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetAlignment(tablewriter.ALIGN_CENTER)
+	table.SetHeader([]string{"ID", "PROJECT", "EMAIL", "PRIVILEGE", "INVITED BY", "EXPIRES"})
+	for _, inv := range invs {
+		table.Append([]string{inv.ID, inv.Project, inv.Email, strconv.Itoa(int(inv.PrivilegeLvl)),
+			inv.InvitedBy, inv.ExpiresAt.Format(time.RFC3339)})
+	}
+	table.Render()
+	return nil
+}
@@ -2,12 +2,16 @@ package commands
 
 import (
 	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/adrianosela/padl/cli/config"
+	"github.com/adrianosela/padl/api/client"
 	"github.com/adrianosela/padl/lib/keymgr"
-	"github.com/adrianosela/padl/lib/keys"
 	"github.com/adrianosela/padl/lib/padlfile"
 	"github.com/adrianosela/padl/lib/secretsmgr"
+	"github.com/olekukonko/tablewriter"
 	cli "gopkg.in/urfave/cli.v1"
 )
 
@@ -24,10 +28,49 @@ var PadlfileCmds = cli.Command{
 				withDefault(fmtFlag, "yaml"),
 				privateKeyFlag, // set by BeforeFunc
 				pathFlag,
+				rekeyFlag,
+				withDefaultInt(bitsFlag, 2048),
+				graceHoursFlag,
+				extraKeyFlag,
+				envFlag,
 			},
 			Before: padlfilePullValidator,
 			Action: padlfilePullHandler,
 		},
+		{
+			Name:  "push",
+			Usage: "upload padlfile to the server as the project's next padlfile revision",
+			Flags: []cli.Flag{
+				withDefault(fmtFlag, "yaml"),
+				pathFlag,
+			},
+			Action: padlfilePushHandler,
+		},
+		{
+			Name:  "fetch",
+			Usage: "download a revision of the project's padlfile from the server",
+			Flags: []cli.Flag{
+				withDefault(fmtFlag, "yaml"),
+				pathFlag,
+				projectFlag,
+				revisionFlag,
+				forceFlag,
+				serviceAccountFlag,
+			},
+			Action: padlfileFetchHandler,
+		},
+		{
+			Name:  "history",
+			Usage: "list the revisions of the project's padlfile stored on the server",
+			Flags: []cli.Flag{
+				withDefault(fmtFlag, "yaml"),
+				pathFlag,
+				projectFlag,
+				serviceAccountFlag,
+				jsonFlag,
+			},
+			Action: padlfileHistoryHandler,
+		},
 		{
 			Name:  "secret",
 			Usage: "manage secrets for project",
@@ -38,9 +81,11 @@ var PadlfileCmds = cli.Command{
 					Flags: []cli.Flag{
 						asMandatory(nameFlag),
 						asMandatory(secretFlag),
+						secretFileFlag,
 						withDefault(fmtFlag, "yaml"),
 						privateKeyFlag, // set by BeforeFunc
 						pathFlag,
+						envFlag,
 					},
 					Before: padlfileSetSecretValidator,
 					Action: padlfileSetSecretHandler,
@@ -53,6 +98,8 @@ var PadlfileCmds = cli.Command{
 						withDefault(fmtFlag, "yaml"),
 						privateKeyFlag, // set by BeforeFunc
 						pathFlag,
+						extraKeyFlag,
+						envFlag,
 					},
 					Before: padlfileShowSecretValidator,
 					Action: padlfileShowSecretHandler,
@@ -65,6 +112,7 @@ var PadlfileCmds = cli.Command{
 						withDefault(fmtFlag, "yaml"),
 						privateKeyFlag, // set by BeforeFunc
 						pathFlag,
+						envFlag,
 					},
 					Before: func(ctx *cli.Context) error {
 						if err := checkCanModifyPadlFile(ctx); err != nil {
@@ -76,10 +124,51 @@ var PadlfileCmds = cli.Command{
 				},
 			},
 		},
+		{
+			Name:  "env",
+			Usage: "manage padlfile environments",
+			Subcommands: []cli.Command{
+				{
+					Name:  "set",
+					Usage: "create an environment or change who has access to it, re-encrypting its secrets",
+					Flags: []cli.Flag{
+						asMandatory(envFlag),
+						envMembersFlag,
+						envServiceAccountFlag,
+						withDefault(fmtFlag, "yaml"),
+						privateKeyFlag, // set by BeforeFunc
+						pathFlag,
+						extraKeyFlag,
+					},
+					Before: padlfileSetEnvValidator,
+					Action: padlfileSetEnvHandler,
+				},
+				{
+					Name:  "list",
+					Usage: "list the environments in a padlfile",
+					Flags: []cli.Flag{
+						withDefault(fmtFlag, "yaml"),
+						pathFlag,
+					},
+					Action: padlfileListEnvsHandler,
+				},
+			},
+		},
 	},
 }
 
 func padlfilePullValidator(ctx *cli.Context) error {
+	// rotating the project key affects the secrets of all environments
+	if ctx.Bool(name(rekeyFlag)) && ctx.String(name(envFlag)) != "" {
+		return fmt.Errorf("--%s can not be used with --%s, all environments must be re-encrypted", name(rekeyFlag), name(envFlag))
+	}
+	return checkCanModifyPadlFile(ctx)
+}
+
+func padlfileSetEnvValidator(ctx *cli.Context) error {
+	if err := assertSet(ctx, envFlag); err != nil {
+		return err
+	}
 	return checkCanModifyPadlFile(ctx)
 }
 
@@ -87,7 +176,13 @@ func padlfileSetSecretValidator(ctx *cli.Context) error {
 	if err := checkCanModifyPadlFile(ctx); err != nil {
 		return err
 	}
-	return assertSet(ctx, nameFlag, secretFlag)
+	if err := assertSet(ctx, nameFlag, secretFlag); err != nil {
+		return err
+	}
+	if ctx.IsSet(name(secretFileFlag)) {
+		return padlfile.ValidateFileName(ctx.String(name(secretFileFlag)))
+	}
+	return nil
 }
 
 func padlfileShowSecretValidator(ctx *cli.Context) error {
@@ -104,7 +199,6 @@ func padlfileRemoveSecretValidator(ctx *cli.Context) error {
 func padlfilePullHandler(ctx *cli.Context) error {
 	format := ctx.String(name(fmtFlag))
 	path := padlfilePath(ctx.String(name(pathFlag)), format)
-	priv := ctx.String(name(privateKeyFlag))
 
 	// get client
 	pc, err := getClient(ctx)
@@ -117,20 +211,52 @@ func padlfilePullHandler(ctx *cli.Context) error {
 		return fmt.Errorf("could not read padlfile: %s", err)
 	}
 	// get key panager
-	keyMgr, err := keymgr.NewFSManager(config.GetDefaultPath())
+	keyMgr, err := getKeyManager(ctx)
 	if err != nil {
 		return fmt.Errorf("could not establish key manager: %s", err)
 	}
-	secMgr := secretsmgr.NewSecretsMgr(pc, keyMgr, pf)
 
-	// decrypted secret and print it
-	rsa, err := keys.DecodePrivKeyPEM([]byte(priv))
-	if err != nil {
-		return fmt.Errorf("could not materialize user private key: %s", err)
+	// the top-level variables and every environment, unless one is given
+	envs := []string{ctx.String(name(envFlag))}
+	if envs[0] == "" {
+		envs = append(envs, pf.EnvNames()...)
 	}
-	decrypted, err := secMgr.DecryptPadlFileSecrets(rsa)
-	if err != nil {
-		return fmt.Errorf("could not decrypt padlfile secrets before pull: %s", err)
+	if err = pullPadlfileEnvs(ctx, pc, keyMgr, pf, envs); err != nil {
+		return err
+	}
+	if err = pf.Write(path); err != nil {
+		return fmt.Errorf("could not write padlfile: %s", err)
+	}
+
+	fmt.Println("padlfile updated!")
+	return nil
+}
+
+// pullPadlfileEnvs re-encrypts the secrets of the given environments
+// of a padlfile with the project's current keys, rotating the project
+// key first if requested. The empty name is the top-level variables
+func pullPadlfileEnvs(ctx *cli.Context, pc *client.Padl, keyMgr keymgr.Manager, pf *padlfile.File, envs []string) error {
+	// decrypt secrets as per the policy they were encrypted under
+	decrypted := make(map[string]map[string]string)
+	for _, env := range envs {
+		view, err := pf.ForEnv(env)
+		if err != nil {
+			return err
+		}
+		if decrypted[env], err = decryptPadlfileSecrets(ctx, pc, keyMgr, view); err != nil {
+			return fmt.Errorf("could not decrypt %s secrets before pull: %s", envDescription(env), err)
+		}
+	}
+
+	// secrets are decrypted before rotating, such that a padlfile
+	// which can not be decrypted is never left behind a rotation
+	if ctx.Bool(name(rekeyFlag)) {
+		resp, err := pc.RotateProjectKey(pf.Data.Project, ctx.Int(name(bitsFlag)), ctx.Int(name(graceHoursFlag)))
+		if err != nil {
+			return fmt.Errorf("could not rotate project key: %s", err)
+		}
+		fmt.Printf("project key rotated, previous key %s remains usable until %s\n",
+			resp.PreviousKey, resp.PreviousKeyExpires.Format(time.RFC3339))
 	}
 
 	projKeys, err := pc.GetProjectKeys(pf.Data.Project)
@@ -138,26 +264,71 @@ func padlfilePullHandler(ctx *cli.Context) error {
 		return fmt.Errorf("could not get project keys: %s", err)
 	}
 
-	// set new fields in padlfile before encrypting again
-	pf.Data.SharedKey = projKeys.ProjectKey
-	pf.Data.MemberKeys = projKeys.MemberKeys
-	pf.Data.ServiceKeys = projKeys.DeployKeys
-	pf.Data.Variables = decrypted
+	for _, env := range envs {
+		view, err := pf.ForEnv(env)
+		if err != nil {
+			return err
+		}
+		// set new fields in padlfile before encrypting again
+		view.Data.SharedKey = projKeys.ProjectKey
+		view.Data.MemberKeys = projKeys.MemberKeys
+		view.Data.ServiceKeys = projKeys.DeployKeys
+		view.Data.OwnerKeys = projKeys.OwnerKeys
+		view.Data.Policy = projKeys.Policy
+		if env != "" {
+			if err = restrictEnvKeys(pc, view, pf.Data.Environments[env], projKeys.OwnerKeys); err != nil {
+				return fmt.Errorf("could not set keys of %s: %s", envDescription(env), err)
+			}
+		}
+		view.Data.Variables = decrypted[env]
 
-	encrypted, err := secMgr.EncryptPadlfileSecrets()
-	if err != nil {
-		return fmt.Errorf("could not encrypt padlfile secrets after pull: %s", err)
-	}
+		encrypted, err := secretsmgr.NewSecretsMgr(pc, keyMgr, view).EncryptPadlfileSecrets()
+		if err != nil {
+			return fmt.Errorf("could not encrypt %s secrets after pull: %s", envDescription(env), err)
+		}
 
-	pf.Data.Variables = encrypted
-	if err = pf.Write(path); err != nil {
-		return fmt.Errorf("could not write padlfile: %s", err)
+		view.Data.Variables = encrypted
+		if err = pf.SetEnv(env, view); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	fmt.Println("padlfile updated!")
+// restrictEnvKeys narrows the member and service account keys of an
+// environment down to the members and service accounts with access to it
+func restrictEnvKeys(pc *client.Padl, view *padlfile.File, env *padlfile.Environment, ownerKeys []string) error {
+	if err := env.ValidateMembers(); err != nil {
+		return err
+	}
+	if env.Members == padlfile.EnvMembersOwners {
+		view.Data.MemberKeys = ownerKeys
+	}
+	if len(env.ServiceAccounts) == 0 {
+		return nil
+	}
+	p, err := pc.GetProject(view.Data.Project)
+	if err != nil {
+		return fmt.Errorf("could not get project: %s", err)
+	}
+	view.Data.ServiceKeys = []string{}
+	for _, svcName := range env.ServiceAccounts {
+		keyID, ok := p.ServiceAccounts[svcName]
+		if !ok {
+			return fmt.Errorf("service account %s not in project %s", svcName, p.Name)
+		}
+		view.Data.ServiceKeys = append(view.Data.ServiceKeys, keyID)
+	}
 	return nil
 }
 
+func envDescription(env string) string {
+	if env == "" {
+		return "top-level"
+	}
+	return fmt.Sprintf("environment %s", env)
+}
+
 func padlfileSetSecretHandler(ctx *cli.Context) error {
 	sName := ctx.String(name(nameFlag))
 	plaintext := ctx.String(name(secretFlag))
@@ -176,17 +347,31 @@ func padlfileSetSecretHandler(ctx *cli.Context) error {
 	}
 
 	// get key panager
-	keyMgr, err := keymgr.NewFSManager(config.GetDefaultPath())
+	keyMgr, err := getKeyManager(ctx)
 	if err != nil {
 		return fmt.Errorf("could not establish key manager: %s", err)
 	}
-	secMgr := secretsmgr.NewSecretsMgr(pc, keyMgr, pf)
+	// secrets set in a new environment create it
+	env := ctx.String(name(envFlag))
+	if env != "" {
+		pf.AddEnv(env)
+	}
+	view, err := pf.ForEnv(env)
+	if err != nil {
+		return err
+	}
+	secMgr := secretsmgr.NewSecretsMgr(pc, keyMgr, view)
 	// encrypt secret and add to padlfile
 	encrypted, err := secMgr.EncryptSecret(plaintext)
 	if err != nil {
 		return fmt.Errorf("could not encrypt secret %s: %s", sName, err)
 	}
-	pf.Data.Variables[sName] = encrypted
+	view.Data.Variables[sName] = encrypted
+	if ctx.IsSet(name(secretFileFlag)) {
+		if err = view.SetFile(sName, ctx.String(name(secretFileFlag))); err != nil {
+			return err
+		}
+	}
 	if err = pf.Write(path); err != nil {
 		return fmt.Errorf("could not write padlfile: %s", err)
 	}
@@ -197,7 +382,6 @@ func padlfileSetSecretHandler(ctx *cli.Context) error {
 func padlfileShowSecretHandler(ctx *cli.Context) error {
 	sName := ctx.String(name(nameFlag))
 	format := ctx.String(name(fmtFlag))
-	priv := ctx.String(name(privateKeyFlag))
 	path := padlfilePath(ctx.String(name(pathFlag)), format)
 
 	// get client
@@ -211,21 +395,35 @@ func padlfileShowSecretHandler(ctx *cli.Context) error {
 		return fmt.Errorf("could not read padlfile: %s", err)
 	}
 
-	if _, ok := pf.Data.Variables[sName]; !ok {
-		return fmt.Errorf("secret %s not in padlfile", sName)
+	env := ctx.String(name(envFlag))
+	view, err := pf.ForEnv(env)
+	if err != nil {
+		return err
+	}
+	if _, ok := view.Data.Variables[sName]; !ok {
+		return fmt.Errorf("secret %s not in %s variables of padlfile", sName, envDescription(env))
+	}
+	// the agent holds the keys
+	if ac := getAgent(ctx); ac != nil {
+		decrypted, err := ac.Decrypt(view.Data.SharedKey, map[string]string{sName: view.Data.Variables[sName]})
+		if err != nil {
+			return fmt.Errorf("could not decrypt secret %s: %s", sName, err)
+		}
+		fmt.Println(decrypted[sName])
+		return nil
 	}
 	// get key panager
-	keyMgr, err := keymgr.NewFSManager(config.GetDefaultPath())
+	keyMgr, err := getKeyManager(ctx)
 	if err != nil {
 		return fmt.Errorf("could not establish key manager: %s", err)
 	}
-	secMgr := secretsmgr.NewSecretsMgr(pc, keyMgr, pf)
+	secMgr := secretsmgr.NewSecretsMgr(pc, keyMgr, view)
 	// decrypted secret and print it
-	rsa, err := keys.DecodePrivKeyPEM([]byte(priv))
+	privs, err := getPrivateKeys(ctx)
 	if err != nil {
-		return fmt.Errorf("could not materialize user private key: %s", err)
+		return err
 	}
-	decrypted, err := secMgr.DecryptSecret(pf.Data.Variables[sName], rsa)
+	decrypted, err := secMgr.DecryptSecret(view.Data.Variables[sName], privs...)
 	if err != nil {
 		return fmt.Errorf("could not decrypt secret %s: %s", sName, err)
 	}
@@ -244,11 +442,16 @@ func padlfileRemoveSecretHandler(ctx *cli.Context) error {
 		return fmt.Errorf("could not read padlfile: %s", err)
 	}
 
-	if _, ok := pf.Data.Variables[sName]; !ok {
-		return fmt.Errorf("secret %s not in padlfile", sName)
+	env := ctx.String(name(envFlag))
+	view, err := pf.ForEnv(env)
+	if err != nil {
+		return err
+	}
+	if _, ok := view.Data.Variables[sName]; !ok {
+		return fmt.Errorf("secret %s not in %s variables of padlfile", sName, envDescription(env))
 	}
 	// delete var
-	delete(pf.Data.Variables, sName)
+	view.RemoveVariable(sName)
 	// write padlfile
 	if err = pf.Write(path); err != nil {
 		return fmt.Errorf("could not write padlfile: %s", err)
@@ -256,3 +459,77 @@ func padlfileRemoveSecretHandler(ctx *cli.Context) error {
 	fmt.Println("padlfile updated!")
 	return nil
 }
+
+func padlfileSetEnvHandler(ctx *cli.Context) error {
+	env := ctx.String(name(envFlag))
+	format := ctx.String(name(fmtFlag))
+	path := padlfilePath(ctx.String(name(pathFlag)), format)
+
+	// get client
+	pc, err := getClient(ctx)
+	if err != nil {
+		return fmt.Errorf("could not get client: %s", err)
+	}
+	// read padlfile
+	pf, err := padlfile.ReadPadlfile(path)
+	if err != nil {
+		return fmt.Errorf("could not read padlfile: %s", err)
+	}
+	e := pf.AddEnv(env)
+	if ctx.IsSet(name(envMembersFlag)) {
+		e.Members = ctx.String(name(envMembersFlag))
+	}
+	if ctx.IsSet(name(envServiceAccountFlag)) {
+		e.ServiceAccounts = ctx.StringSlice(name(envServiceAccountFlag))
+	}
+	if err = e.ValidateMembers(); err != nil {
+		return err
+	}
+	// get key manager
+	keyMgr, err := getKeyManager(ctx)
+	if err != nil {
+		return fmt.Errorf("could not establish key manager: %s", err)
+	}
+	// secrets already in the environment are re-encrypted for the new set of keys
+	if err = pullPadlfileEnvs(ctx, pc, keyMgr, pf, []string{env}); err != nil {
+		return err
+	}
+	if err = pf.Write(path); err != nil {
+		return fmt.Errorf("could not write padlfile: %s", err)
+	}
+	fmt.Println("padlfile updated!")
+	return nil
+}
+
+func padlfileListEnvsHandler(ctx *cli.Context) error {
+	format := ctx.String(name(fmtFlag))
+	path := padlfilePath(ctx.String(name(pathFlag)), format)
+
+	// read padlfile
+	pf, err := padlfile.ReadPadlfile(path)
+	if err != nil {
+		return fmt.Errorf("could not read padlfile: %s", err)
+	}
+	if len(pf.Data.Environments) == 0 {
+		fmt.Println("no environments in padlfile")
+		return nil
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetAlignment(tablewriter.ALIGN_CENTER)
+	table.SetHeader([]string{"ENVIRONMENT", "MEMBERS", "SERVICE ACCOUNTS", "SECRETS"})
+	for _, env := range pf.EnvNames() {
+		e := pf.Data.Environments[env]
+		members := e.Members
+		if members == "" {
+			members = padlfile.EnvMembersAll
+		}
+		svcAccts := strings.Join(e.ServiceAccounts, ", ")
+		if svcAccts == "" {
+			svcAccts = "all"
+		}
+		table.Append([]string{env, members, svcAccts, strconv.Itoa(len(e.Variables))})
+	}
+	table.Render()
+	return nil
+}
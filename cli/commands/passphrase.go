@@ -0,0 +1,177 @@
+package commands
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/adrianosela/padl/cli/config"
+	"github.com/adrianosela/padl/lib/agent"
+	"github.com/adrianosela/padl/lib/keymgr"
+	"golang.org/x/crypto/ssh/terminal"
+	cli "gopkg.in/urfave/cli.v1"
+)
+
+// passphraseEnvVar holds the passphrase of the user's private
+// keys, for non-interactive use e.g. on CI systems
+const passphraseEnvVar = "PADL_KEY_PASSPHRASE"
+
+// getKeyManager returns the key manager of secrets commands, which gets
+// public keys through the agent if one is in use, and the key manager of
+// the cli otherwise
+func getKeyManager(ctx *cli.Context) (keymgr.Manager, error) {
+	if ac := getAgent(ctx); ac != nil {
+		return agent.NewKeyManager(ac), nil
+	}
+	return getFSKeyManager(ctx)
+}
+
+// getFSKeyManager returns the key manager of the cli. The passphrase of
+// encrypted private keys is prompted for (once), unless it is given in
+// the environment or through a file descriptor, and so is the passphrase
+// to encrypt new private keys with. Keys are namespaced per server
+func getFSKeyManager(ctx *cli.Context) (*keymgr.FSManager, error) {
+	path := config.GetDefaultPath()
+	hostURL, legacy, err := keysServer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m, err := keymgr.NewFSManager(keymgr.ServerPath(path, hostURL))
+	if err != nil {
+		return nil, err
+	}
+	if legacy {
+		if err = m.Adopt(path); err != nil {
+			return nil, fmt.Errorf("could not move keys to %s: %s", keymgr.ServerPath(path, hostURL), err)
+		}
+	}
+	m.Unlock = oncePassphrase(func() (string, error) {
+		return readPassphrase(ctx, "Enter the passphrase of your private key:")
+	})
+	m.Lock = oncePassphrase(func() (string, error) {
+		return readNewPassphrase(ctx, "Enter a passphrase to encrypt your private key with (empty for none):")
+	})
+	return m, nil
+}
+
+// keysServer returns the url of the server whose keys to use, and whether
+// it is the server of the default profile, the keys of which were saved
+// without a namespace before there were profiles
+func keysServer(ctx *cli.Context) (string, bool, error) {
+	hostURL := ctx.GlobalString(name(HostURLFlag))
+	f, err := config.ReadFile(ctx.GlobalString(name(ConfigFlag)))
+	if err != nil {
+		// no configuration is needed if the host url is given
+		if hostURL == "" {
+			return "", false, err
+		}
+		return hostURL, false, nil
+	}
+	profile := f.Selected(profileName(ctx))
+	c, ok := f.Profiles[profile]
+	if !ok {
+		if hostURL == "" {
+			return "", false, fmt.Errorf("profile %s does not exist", profile)
+		}
+		return hostURL, false, nil
+	}
+	if hostURL == "" {
+		hostURL = c.HostURL
+	}
+	return hostURL, profile == config.DefaultProfile && hostURL == c.HostURL, nil
+}
+
+// oncePassphrase returns a passphrase function which only gets the
+// passphrase from the given function once it has succeeded
+func oncePassphrase(get keymgr.PassphraseFunc) keymgr.PassphraseFunc {
+	var passphrase *string
+	return func() (string, error) {
+		if passphrase != nil {
+			return *passphrase, nil
+		}
+		p, err := get()
+		if err != nil {
+			return "", err
+		}
+		passphrase = &p
+		return p, nil
+	}
+}
+
+// readPassphrase reads the passphrase of existing private keys from the
+// environment, the passphrase file descriptor, or the terminal, in order
+func readPassphrase(ctx *cli.Context, prompt string) (string, error) {
+	if p, ok, err := passphraseFromEnvOrFD(ctx); ok || err != nil {
+		return p, err
+	}
+	if !terminal.IsTerminal(int(syscall.Stdin)) {
+		return "", fmt.Errorf("no passphrase given, set %s or --%s", passphraseEnvVar, name(PassphraseFDFlag))
+	}
+	return promptText(prompt, true)
+}
+
+// readNewPassphrase reads a passphrase for new private keys like
+// readPassphrase, but asks for it twice at the terminal. New keys
+// are not encrypted if there is no passphrase and no terminal
+func readNewPassphrase(ctx *cli.Context, prompt string) (string, error) {
+	if p, ok, err := passphraseFromEnvOrFD(ctx); ok || err != nil {
+		return p, err
+	}
+	if !terminal.IsTerminal(int(syscall.Stdin)) {
+		return "", nil
+	}
+	return promptNewPassphrase(prompt)
+}
+
+func promptNewPassphrase(prompt string) (string, error) {
+	p, err := promptText(prompt, true)
+	if err != nil {
+		return "", err
+	}
+	if p == "" {
+		return "", nil
+	}
+	confirm, err := promptText("Enter the passphrase again:", true)
+	if err != nil {
+		return "", err
+	}
+	if p != confirm {
+		return "", errors.New("passphrases do not match")
+	}
+	return p, nil
+}
+
+var fdPassphrase struct {
+	sync.Once
+	passphrase string
+	err        error
+}
+
+// passphraseFromEnvOrFD returns the passphrase set in the environment,
+// or read from the passphrase file descriptor, if either is given. Like
+// passphrases entered at the terminal, surrounding whitespace is trimmed
+func passphraseFromEnvOrFD(ctx *cli.Context) (string, bool, error) {
+	if p, ok := os.LookupEnv(passphraseEnvVar); ok {
+		return strings.TrimSpace(p), true, nil
+	}
+	fd := ctx.GlobalInt(name(PassphraseFDFlag))
+	if fd < 0 {
+		return "", false, nil
+	}
+	// the file descriptor can only be read once
+	fdPassphrase.Do(func() {
+		f := os.NewFile(uintptr(fd), "passphrase")
+		defer f.Close()
+		line, err := bufio.NewReader(f).ReadString('\n')
+		if err != nil && line == "" {
+			fdPassphrase.err = fmt.Errorf("could not read passphrase from file descriptor %d: %s", fd, err)
+			return
+		}
+		fdPassphrase.passphrase = strings.TrimSpace(line)
+	})
+	return fdPassphrase.passphrase, true, fdPassphrase.err
+}
@@ -1,13 +1,19 @@
 package commands
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/olekukonko/tablewriter"
 
+	"github.com/adrianosela/padl/api/client"
+	"github.com/adrianosela/padl/lib/audit"
 	"github.com/adrianosela/padl/lib/keys"
+	"github.com/adrianosela/padl/lib/policy"
 	cli "gopkg.in/urfave/cli.v1"
 )
 
@@ -26,6 +32,7 @@ var ProjectCmds = cli.Command{
 				withDefaultInt(bitsFlag, 2048),
 				pathFlag,
 				withDefault(fmtFlag, "yaml"),
+				policyFlag,
 			},
 			Before: createProjectValidator,
 			Action: createProjectHandler,
@@ -49,6 +56,76 @@ var ProjectCmds = cli.Command{
 			Before: getProjectValidator,
 			Action: getProjectHandler,
 		},
+		{
+			Name:  "rotate-key",
+			Usage: "replace a padl project's shared key",
+			Flags: []cli.Flag{
+				asMandatory(projectFlag),
+				withDefaultInt(bitsFlag, 2048),
+				graceHoursFlag,
+				jsonFlag,
+			},
+			Before: rotateProjectKeyValidator,
+			Action: rotateProjectKeyHandler,
+		},
+		{
+			Name:  "set-policy",
+			Usage: "set the threshold policy for a padl project's secrets",
+			Flags: []cli.Flag{
+				asMandatory(projectFlag),
+				asMandatory(policyFlag),
+			},
+			Before: setProjectPolicyValidator,
+			Action: setProjectPolicyHandler,
+		},
+		{
+			Name:  "audit",
+			Usage: "view, verify or export a padl project's audit log",
+			Flags: []cli.Flag{
+				asMandatory(projectFlag),
+				sinceFlag,
+				untilFlag,
+				actorFlag,
+				verifyFlag,
+				jsonlFlag,
+			},
+			Before: projectAuditValidator,
+			Action: projectAuditHandler,
+		},
+		{
+			Name:  "transfer",
+			Usage: "transfer ownership of a padl project",
+			Subcommands: []cli.Command{
+				{
+					Name:  "nominate",
+					Usage: "nominate a user as owner, you become an editor once they accept",
+					Flags: []cli.Flag{
+						asMandatory(projectFlag),
+						asMandatory(emailFlag),
+					},
+					Before: nominateOwnerValidator,
+					Action: nominateOwnerHandler,
+				},
+				{
+					Name:  "accept",
+					Usage: "accept your nomination as owner of a project",
+					Flags: []cli.Flag{
+						asMandatory(projectFlag),
+					},
+					Before: ownershipTransferValidator,
+					Action: acceptOwnershipHandler,
+				},
+				{
+					Name:  "cancel",
+					Usage: "cancel (or as the nominee, decline) a pending ownership transfer",
+					Flags: []cli.Flag{
+						asMandatory(projectFlag),
+					},
+					Before: ownershipTransferValidator,
+					Action: cancelOwnershipTransferHandler,
+				},
+			},
+		},
 		{
 			Name:  "list",
 			Usage: "get all your padl projects",
@@ -90,15 +167,48 @@ var ProjectCmds = cli.Command{
 			Usage: "manage users for project",
 			Subcommands: []cli.Command{
 				{
-					Name:  "add",
-					Usage: "add a user to a project",
+					Name:    "invite",
+					Aliases: []string{"add"},
+					Usage:   "invite a user to a project, they join once they accept",
 					Flags: []cli.Flag{
 						asMandatory(projectFlag),
 						asMandatory(emailFlag),
 						withDefaultInt(privFlag, 0),
+						expiresHoursFlag,
+					},
+					Before: inviteUserValidator,
+					Action: inviteUserHandler,
+				},
+				{
+					Name:  "invitations",
+					Usage: "list a project's pending invitations",
+					Flags: []cli.Flag{
+						asMandatory(projectFlag),
+						jsonFlag,
 					},
-					Before: addUserValidator,
-					Action: addUserHandler,
+					Before: getProjectValidator,
+					Action: listProjectInvitationsHandler,
+				},
+				{
+					Name:  "revoke-invite",
+					Usage: "revoke a pending invitation to a project",
+					Flags: []cli.Flag{
+						asMandatory(projectFlag),
+						asMandatory(idFlag),
+					},
+					Before: revokeInvitationValidator,
+					Action: revokeInvitationHandler,
+				},
+				{
+					Name:  "set-privilege",
+					Usage: "change a project member's privilege level",
+					Flags: []cli.Flag{
+						asMandatory(projectFlag),
+						asMandatory(emailFlag),
+						asMandatoryInt(privFlag),
+					},
+					Before: setUserPrivilegeValidator,
+					Action: setUserPrivilegeHandler,
 				},
 				{
 					Name:  "remove",
@@ -115,7 +225,7 @@ var ProjectCmds = cli.Command{
 	},
 }
 
-func addUserValidator(ctx *cli.Context) error {
+func setUserPrivilegeValidator(ctx *cli.Context) error {
 	return assertSet(ctx, projectFlag, emailFlag, privFlag)
 }
 
@@ -135,6 +245,33 @@ func deleteProjectValidator(ctx *cli.Context) error {
 	return assertSet(ctx, projectFlag)
 }
 
+func rotateProjectKeyValidator(ctx *cli.Context) error {
+	return assertSet(ctx, projectFlag)
+}
+
+func setProjectPolicyValidator(ctx *cli.Context) error {
+	return assertSet(ctx, projectFlag, policyFlag)
+}
+
+func projectAuditValidator(ctx *cli.Context) error {
+	if err := assertSet(ctx, projectFlag); err != nil {
+		return err
+	}
+	// a filtered log is not a chain, only the whole log can be verified
+	if ctx.Bool(name(verifyFlag)) && (ctx.IsSet(name(sinceFlag)) || ctx.IsSet(name(untilFlag)) || ctx.IsSet(name(actorFlag))) {
+		return fmt.Errorf("\"%s\" can not be combined with filters", name(verifyFlag))
+	}
+	return nil
+}
+
+func nominateOwnerValidator(ctx *cli.Context) error {
+	return assertSet(ctx, projectFlag, emailFlag)
+}
+
+func ownershipTransferValidator(ctx *cli.Context) error {
+	return assertSet(ctx, projectFlag)
+}
+
 func getProjectValidator(ctx *cli.Context) error {
 	return assertSet(ctx, projectFlag)
 }
@@ -153,6 +290,7 @@ func createProjectHandler(ctx *cli.Context) error {
 	bits := ctx.Int(name(bitsFlag))
 	descr := ctx.String(name(descriptionFlag))
 	format := ctx.String(name(fmtFlag))
+	policy := ctx.String(name(policyFlag))
 
 	path := padlfilePath(ctx.String(name(pathFlag)), format)
 
@@ -160,7 +298,7 @@ func createProjectHandler(ctx *cli.Context) error {
 		return fmt.Errorf("invalid file extension, must be one of { \".yaml\", \".json\" }")
 	}
 
-	pf, err := c.CreateProject(pname, descr, bits)
+	pf, err := c.CreateProject(pname, descr, bits, policy)
 	if err != nil {
 		return fmt.Errorf("error creating project: %s", err)
 	}
@@ -195,14 +333,203 @@ func getProjectHandler(ctx *cli.Context) error {
 	table.Append([]string{"NAME", project.Name})
 	table.Append([]string{"DESCRIPTION", project.Description})
 	table.Append([]string{"KEY", project.ProjectKey})
+	table.Append([]string{"POLICY", projectPolicy(project.Policy)})
+	if t := project.Transfer; t != nil {
+		table.Append([]string{"PENDING TRANSFER", fmt.Sprintf("%s to %s (until %s)", t.From, t.To, t.Expires.Format(time.RFC3339))})
+	}
 
 	tablePrivsMap(table, "MEMBERS", project.Members)
 	tableStringsMap(table, "SERVICE ACCOUNTS", project.ServiceAccounts)
 
+	for i, rk := range project.PreviousKeys {
+		header := ""
+		if i == 0 {
+			header = "PREVIOUS KEYS"
+		}
+		table.Append([]string{header, fmt.Sprintf("%s (until %s)", rk.KeyID, rk.Expires.Format(time.RFC3339))})
+	}
+
+	table.Render()
+	return nil
+}
+
+func rotateProjectKeyHandler(ctx *cli.Context) error {
+	c, err := getClient(ctx)
+	if err != nil {
+		return fmt.Errorf("could not initialize client: %s", err)
+	}
+
+	projectName := ctx.String(name(projectFlag))
+	bits := ctx.Int(name(bitsFlag))
+	graceHours := ctx.Int(name(graceHoursFlag))
+
+	resp, err := c.RotateProjectKey(projectName, bits, graceHours)
+	if err != nil {
+		return fmt.Errorf("error rotating project key: %s", err)
+	}
+
+	if ctx.Bool(name(jsonFlag)) {
+		return printJSON(resp)
+	}
+
+	fmt.Printf("project %s key rotated successfully!\n", projectName)
+	fmt.Printf("new key: %s\n", resp.ProjectKey)
+	fmt.Printf("previous key %s remains usable until %s\n", resp.PreviousKey, resp.PreviousKeyExpires.Format(time.RFC3339))
+	fmt.Println("run \"padl file pull\" on every padlfile for the project to re-encrypt its secrets before then")
+	return nil
+}
+
+func setProjectPolicyHandler(ctx *cli.Context) error {
+	c, err := getClient(ctx)
+	if err != nil {
+		return fmt.Errorf("could not initialize client: %s", err)
+	}
+
+	projectName := ctx.String(name(projectFlag))
+	policy := ctx.String(name(policyFlag))
+
+	if err = c.SetProjectPolicy(projectName, policy); err != nil {
+		return fmt.Errorf("error setting project policy: %s", err)
+	}
+	fmt.Printf("project %s policy set to %s successfully!\n", projectName, policy)
+	fmt.Println("run \"padl file pull\" on every padlfile for the project to re-encrypt its secrets under the new policy")
+	return nil
+}
+
+func projectAuditHandler(ctx *cli.Context) error {
+	c, err := getClient(ctx)
+	if err != nil {
+		return fmt.Errorf("could not initialize client: %s", err)
+	}
+
+	projectName := ctx.String(name(projectFlag))
+
+	// hashes are keyed with keys only the server holds, so the server verifies the log
+	if ctx.Bool(name(verifyFlag)) {
+		result, err := c.VerifyAuditLog(projectName)
+		if err != nil {
+			return fmt.Errorf("error verifying audit log: %s", err)
+		}
+		if result.Error != "" {
+			return fmt.Errorf("audit log of project %s failed verification: %s", projectName, result.Error)
+		}
+		if result.Events == 0 {
+			fmt.Printf("audit log of project %s is empty\n", projectName)
+			return nil
+		}
+		fmt.Printf("audit log of project %s verified successfully! (%d events)\n", projectName, result.Events)
+		if result.Head != nil {
+			fmt.Printf("head: event %d, hash %s\n", result.Head.Seq, result.Head.Hash)
+			fmt.Println("record the head hash to detect a rewrite of the log up to this event")
+		}
+		return nil
+	}
+
+	filter := &audit.Filter{Actor: ctx.String(name(actorFlag))}
+	if filter.Since, err = parseAuditTime(ctx.String(name(sinceFlag))); err != nil {
+		return fmt.Errorf("invalid \"%s\": %s", name(sinceFlag), err)
+	}
+	if filter.Until, err = parseAuditTime(ctx.String(name(untilFlag))); err != nil {
+		return fmt.Errorf("invalid \"%s\": %s", name(untilFlag), err)
+	}
+
+	events, err := c.GetAuditLog(projectName, filter)
+	if err != nil {
+		return fmt.Errorf("error fetching audit log: %s", err)
+	}
+
+	if ctx.Bool(name(jsonlFlag)) {
+		enc := json.NewEncoder(os.Stdout)
+		for _, e := range events {
+			if err = enc.Encode(e); err != nil {
+				return fmt.Errorf("could not encode event %d: %s", e.Seq, err)
+			}
+		}
+		return nil
+	}
+
+	if len(events) == 0 {
+		fmt.Println("no audit events to show :(")
+		return nil
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetAlignment(tablewriter.ALIGN_CENTER)
+	table.SetHeader([]string{"SEQ", "TIME", "ACTOR", "ACTION", "TARGET", "DETAIL"})
+	for _, e := range events {
+		table.Append([]string{strconv.FormatUint(e.Seq, 10), e.Time.Format(time.RFC3339), e.Actor, e.Action, e.Target, e.Detail})
+	}
 	table.Render()
 	return nil
 }
 
+// parseAuditTime parses an RFC3339 time, or a duration
+// before now. The empty string yields the zero time
+func parseAuditTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func nominateOwnerHandler(ctx *cli.Context) error {
+	c, err := getClient(ctx)
+	if err != nil {
+		return fmt.Errorf("could not initialize client: %s", err)
+	}
+
+	projectName := ctx.String(name(projectFlag))
+	email := ctx.String(name(emailFlag))
+
+	if err = c.NominateOwner(projectName, email); err != nil {
+		return fmt.Errorf("error nominating owner: %s", err)
+	}
+	fmt.Printf("user %s nominated as owner of project %s successfully!\n", email, projectName)
+	fmt.Printf("they can accept with \"padl project transfer accept --project %s\"\n", projectName)
+	return nil
+}
+
+func acceptOwnershipHandler(ctx *cli.Context) error {
+	c, err := getClient(ctx)
+	if err != nil {
+		return fmt.Errorf("could not initialize client: %s", err)
+	}
+
+	projectName := ctx.String(name(projectFlag))
+
+	if err = c.AcceptOwnership(projectName); err != nil {
+		return fmt.Errorf("error accepting ownership: %s", err)
+	}
+	fmt.Printf("you are now an owner of project %s!\n", projectName)
+	return nil
+}
+
+func cancelOwnershipTransferHandler(ctx *cli.Context) error {
+	c, err := getClient(ctx)
+	if err != nil {
+		return fmt.Errorf("could not initialize client: %s", err)
+	}
+
+	projectName := ctx.String(name(projectFlag))
+
+	if err = c.CancelOwnershipTransfer(projectName); err != nil {
+		return fmt.Errorf("error cancelling ownership transfer: %s", err)
+	}
+	fmt.Printf("ownership transfer of project %s cancelled successfully!\n", projectName)
+	return nil
+}
+
+// projectPolicy returns the policy to display for a project
+func projectPolicy(p string) string {
+	if p == "" {
+		return policy.ServerMember
+	}
+	return p
+}
+
 func projectListHandler(ctx *cli.Context) error {
 	c, err := getClient(ctx)
 	if err != nil {
@@ -253,26 +580,25 @@ func projectAddServiceAccountHandler(ctx *cli.Context) error {
 		return fmt.Errorf("error creating service account: %s", err)
 	}
 
-	data := struct {
-		PrivateKey string `json:"private_key"`
-		JWT        string `json:"jwt"`
-	}{
+	creds := &client.ServiceAccount{
+		Project:    resp.Project,
+		Name:       resp.Name,
 		PrivateKey: string(keys.EncodePrivKeyPEM(priv)),
-		JWT:        resp.Token,
 	}
 
 	if ctx.Bool(name(jsonFlag)) {
-		return printJSON(data)
+		return printJSON(creds)
 	}
 
 	fmt.Println("---------------------- IMPORTANT NOTE ----------------------")
-	fmt.Println(">> Both the RSA private key and and auth token are secret <<")
-	fmt.Println(">> If either is disclosed you MUST delete the svc account <<")
+	fmt.Println(">>   The credentials below contain a secret private key   <<")
+	fmt.Println(">>  If they are disclosed you MUST delete the svc account  <<")
 	fmt.Println("------------------------------------------------------------")
-	fmt.Printf("\nSERVICE ACCOUNT PRIVATE KEY:\n%s", data.PrivateKey)
-	fmt.Printf("\nSERVICE ACCOUNT AUTH TOKEN:\n%s\n", data.JWT)
+	fmt.Println("\nsave the credentials to a file, and pass its path to \"padl run\"")
+	fmt.Println("with --service-account or the PADL_SERVICE_ACCOUNT variable")
+	fmt.Println("\nSERVICE ACCOUNT CREDENTIALS:")
 
-	return nil
+	return printJSON(creds)
 }
 
 func projectRemoveServiceAccountHandler(ctx *cli.Context) error {
@@ -292,7 +618,7 @@ func projectRemoveServiceAccountHandler(ctx *cli.Context) error {
 	return nil
 }
 
-func addUserHandler(ctx *cli.Context) error {
+func setUserPrivilegeHandler(ctx *cli.Context) error {
 	c, err := getClient(ctx)
 	if err != nil {
 		return fmt.Errorf("could not initialize client: %s", err)
@@ -302,10 +628,10 @@ func addUserHandler(ctx *cli.Context) error {
 	email := ctx.String(name(emailFlag))
 	privLevel := ctx.Int(name(privFlag))
 
-	if err := c.AddUserToProject(projectName, email, privLevel); err != nil {
-		return fmt.Errorf("error adding user: %s", err)
+	if err := c.SetUserPrivilege(projectName, email, privLevel); err != nil {
+		return fmt.Errorf("error setting user privilege: %s", err)
 	}
-	fmt.Printf("user %s added to project %s successfully!\n", email, projectName)
+	fmt.Printf("user %s privilege in project %s set to %d successfully!\n", email, projectName, privLevel)
 	return nil
 }
 
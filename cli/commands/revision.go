@@ -0,0 +1,189 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/adrianosela/padl/api/client"
+	"github.com/adrianosela/padl/api/payloads"
+	"github.com/adrianosela/padl/api/revision"
+	"github.com/adrianosela/padl/lib/padlfile"
+	"github.com/olekukonko/tablewriter"
+	cli "gopkg.in/urfave/cli.v1"
+)
+
+func padlfilePushHandler(ctx *cli.Context) error {
+	format := ctx.String(name(fmtFlag))
+	path := padlfilePath(ctx.String(name(pathFlag)), format)
+
+	// get client
+	pc, err := getClient(ctx)
+	if err != nil {
+		return fmt.Errorf("could not get client: %s", err)
+	}
+	// read padlfile
+	pf, err := padlfile.ReadPadlfile(path)
+	if err != nil {
+		return fmt.Errorf("could not read padlfile: %s", err)
+	}
+	// nothing to push if the padlfile is the revision it is based on
+	if pf.Revision != 0 && checkPushed(pc, pf) == nil {
+		fmt.Printf("padlfile has no changes since revision %d\n", pf.Revision)
+		return nil
+	}
+	rev, err := pc.PushPadlfile(pf)
+	if err != nil {
+		if errors.Is(err, client.ErrPadlfileConflict) {
+			return fmt.Errorf("%s\nfetch the latest revision with \"padl file fetch\" (into another --path, or with --force to discard your changes) and re-apply your changes to it", err)
+		}
+		return fmt.Errorf("could not push padlfile: %s", err)
+	}
+	// the local padlfile is now based on the pushed revision
+	pf.Revision = rev.Revision
+	if err = pf.Write(path); err != nil {
+		return fmt.Errorf("could not write padlfile: %s", err)
+	}
+
+	fmt.Printf("padlfile pushed as revision %d!\n", rev.Revision)
+	return nil
+}
+
+func padlfileFetchHandler(ctx *cli.Context) error {
+	format := ctx.String(name(fmtFlag))
+	path := padlfilePath(ctx.String(name(pathFlag)), format)
+
+	// get client
+	pc, err := getClient(ctx)
+	if err != nil {
+		return fmt.Errorf("could not get client: %s", err)
+	}
+	if err = useServiceAccount(ctx, pc); err != nil {
+		return err
+	}
+	local, err := readLocalPadlfile(path)
+	if err != nil {
+		return err
+	}
+	projectName, err := padlfileProject(ctx, local)
+	if err != nil {
+		return err
+	}
+	// refuse to silently drop changes which were never pushed
+	if local != nil && !ctx.Bool(name(forceFlag)) {
+		if err = checkPushed(pc, local); err != nil {
+			return fmt.Errorf("%s, use --%s to overwrite it", err, name(forceFlag))
+		}
+	}
+	if ctx.Int(name(revisionFlag)) < 0 {
+		return fmt.Errorf("invalid revision %d", ctx.Int(name(revisionFlag)))
+	}
+	rev, err := pc.FetchPadlfile(projectName, uint64(ctx.Int(name(revisionFlag))))
+	if err != nil {
+		return fmt.Errorf("could not fetch padlfile: %s", err)
+	}
+	if err = rev.File.Write(path); err != nil {
+		return fmt.Errorf("could not write padlfile: %s", err)
+	}
+
+	fmt.Printf("padlfile revision %d (pushed by %s at %s) fetched!\n", rev.Revision, rev.Author, rev.Time.Format(time.RFC3339))
+	return nil
+}
+
+func padlfileHistoryHandler(ctx *cli.Context) error {
+	format := ctx.String(name(fmtFlag))
+	path := padlfilePath(ctx.String(name(pathFlag)), format)
+
+	// get client
+	pc, err := getClient(ctx)
+	if err != nil {
+		return fmt.Errorf("could not get client: %s", err)
+	}
+	if err = useServiceAccount(ctx, pc); err != nil {
+		return err
+	}
+	local, err := readLocalPadlfile(path)
+	if err != nil {
+		return err
+	}
+	projectName, err := padlfileProject(ctx, local)
+	if err != nil {
+		return err
+	}
+	revs, err := pc.PadlfileHistory(projectName)
+	if err != nil {
+		return fmt.Errorf("could not get padlfile history: %s", err)
+	}
+	return printPadlfileHistory(ctx, revs)
+}
+
+// readLocalPadlfile reads the padlfile at the given path,
+// returning a nil padlfile if there is none
+func readLocalPadlfile(path string) (*padlfile.File, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+	pf, err := padlfile.ReadPadlfile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read padlfile: %s", err)
+	}
+	return pf, nil
+}
+
+// padlfileProject returns the project given with the project
+// flag, which must match that of the local padlfile if any
+func padlfileProject(ctx *cli.Context, local *padlfile.File) (string, error) {
+	projectName := ctx.String(name(projectFlag))
+	if local == nil {
+		if projectName == "" {
+			return "", fmt.Errorf("no local padlfile, --%s must be set", name(projectFlag))
+		}
+		return projectName, nil
+	}
+	if projectName != "" && projectName != local.Data.Project {
+		return "", fmt.Errorf("local padlfile is for project %s, not %s", local.Data.Project, projectName)
+	}
+	return local.Data.Project, nil
+}
+
+// checkPushed returns an error if the local padlfile differs from
+// the server revision it is based on, i.e. if it has unpushed changes
+func checkPushed(pc *client.Padl, local *padlfile.File) error {
+	if local.Revision == 0 {
+		return errors.New("local padlfile was never pushed")
+	}
+	base, err := pc.FetchPadlfile(local.Data.Project, local.Revision)
+	if err != nil {
+		return fmt.Errorf("could not fetch padlfile revision %d: %s", local.Revision, err)
+	}
+	content, err := revision.Encode(local)
+	if err != nil {
+		return err
+	}
+	if revision.Hash(content) != base.Hash {
+		return fmt.Errorf("local padlfile has changes not pushed since revision %d", local.Revision)
+	}
+	return nil
+}
+
+func printPadlfileHistory(ctx *cli.Context, revs []*payloads.PadlfileRevisionResponse) error {
+	if ctx.Bool(name(jsonFlag)) {
+		return printJSON(&revs)
+	}
+
+	if len(revs) == 0 {
+		fmt.Println("no padlfile revisions on the server")
+		return nil
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetAlignment(tablewriter.ALIGN_CENTER)
+	table.SetHeader([]string{"REVISION", "AUTHOR", "TIME", "HASH"})
+	for _, rev := range revs {
+		table.Append([]string{strconv.FormatUint(rev.Revision, 10), rev.Author, rev.Time.Format(time.RFC3339), rev.Hash[:12]})
+	}
+	table.Render()
+	return nil
+}
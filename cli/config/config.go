@@ -7,17 +7,33 @@ import (
 	"io/ioutil"
 	"os"
 	"os/user"
+	"sort"
+	"strings"
 )
 
 const (
 	defaultConfigDirName = ".padl"
+
+	// DefaultProfile is the name of the profile of configuration
+	// files written before profiles, and of the first profile set
+	DefaultProfile = "default"
 )
 
-// Config is the padl cli configuration
+// Config is the padl cli configuration of a profile, i.e. of one server
 type Config struct {
 	HostURL string `json:"host_url"`
 	User    string `json:"user,omitempty"`
 	Token   string `json:"auth_token,omitempty"`
+
+	// RefreshToken is single use, it is replaced
+	// every time the auth token is refreshed
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// File is the padl cli configuration file, holding named profiles
+type File struct {
+	DefaultProfile string             `json:"default_profile"`
+	Profiles       map[string]*Config `json:"profiles"`
 }
 
 // GetDefaultPath returns the best place to save / look-for a config directory.
@@ -32,52 +48,148 @@ func GetDefaultPath() string {
 	return fmt.Sprintf("%s/%s", usr.HomeDir, defaultConfigDirName)
 }
 
-// SetConfig writes a configuration file to the given path
-func SetConfig(c *Config, path string) error {
+// ProfileNames returns the names of the profiles in order
+func (f *File) ProfileNames() []string {
+	names := []string{}
+	for name := range f.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Selected returns the name of the given profile, or
+// the name of the default profile if none is given
+func (f *File) Selected(profile string) string {
+	if profile != "" {
+		return profile
+	}
+	if f.DefaultProfile != "" {
+		return f.DefaultProfile
+	}
+	return DefaultProfile
+}
+
+// ValidateProfileName checks that a profile name is not empty and
+// only has letters, digits, dashes, underscores and periods
+func ValidateProfileName(profile string) error {
+	if profile == "" || strings.Trim(profile, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_.") != "" {
+		return fmt.Errorf("invalid profile name %q, must only have letters, digits, \"-\", \"_\" and \".\"", profile)
+	}
+	return nil
+}
+
+// SetConfig writes the configuration of a profile to the configuration
+// file at the given path. The empty profile is the default profile. The
+// first profile set becomes the default profile
+func SetConfig(c *Config, path, profile string) error {
 	if c == nil {
 		return errors.New("config cannot be nil")
 	}
 	if c.HostURL == "" {
 		return errors.New("url cannot be empty")
 	}
+	f, err := ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		f = &File{Profiles: make(map[string]*Config)}
+	}
+	profile = f.Selected(profile)
+	if err = ValidateProfileName(profile); err != nil {
+		return err
+	}
+	if f.DefaultProfile == "" {
+		f.DefaultProfile = profile
+	}
+	f.Profiles[profile] = c
+	return WriteFile(f, path)
+}
+
+// UseProfile makes an existing profile the default profile
+func UseProfile(path, profile string) error {
+	f, err := ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if _, ok := f.Profiles[profile]; !ok {
+		return fmt.Errorf("profile %s does not exist", profile)
+	}
+	f.DefaultProfile = profile
+	return WriteFile(f, path)
+}
+
+// WriteFile writes a configuration file to the given path
+func WriteFile(f *File, path string) error {
 	if path == "" {
 		path = GetDefaultPath()
 	}
 	if _, err := os.Stat(path); os.IsNotExist(err) {
-		if err := os.Mkdir(path, 755); err != nil {
+		if err := os.Mkdir(path, 0700); err != nil {
 			return fmt.Errorf("could not create new directory %s: %s", path, err)
 		}
+	} else if err = os.Chmod(path, 0700); err != nil {
+		// directories created by earlier versions had the wrong permissions
+		return fmt.Errorf("could not set permissions of %s: %s", path, err)
 	}
-	f, err := os.Create(fmt.Sprintf("%s/config.json", path))
+	byt, err := json.Marshal(f)
 	if err != nil {
-		return fmt.Errorf("could not create new file %s/config: %s", path, err)
+		return fmt.Errorf("could not marshal configuration file: %s", err)
 	}
-	byt, err := json.Marshal(c)
+	// the configuration holds the user's tokens
+	fd, err := os.OpenFile(fmt.Sprintf("%s/config.json", path), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
-		return fmt.Errorf("could not marshal configuration file: %s", err)
+		return fmt.Errorf("could not create new file %s/config: %s", path, err)
 	}
-	if _, err := f.Write(byt); err != nil {
+	defer fd.Close()
+	if err = fd.Chmod(0600); err != nil {
+		return fmt.Errorf("could not set permissions of %s/config: %s", path, err)
+	}
+	if _, err := fd.Write(byt); err != nil {
 		return fmt.Errorf("could not write configuration file: %s", err)
 	}
 	return nil
 }
 
-// GetConfig returns the configuration at a given path
-func GetConfig(path string) (*Config, error) {
-	if path == "" {
-		path = GetDefaultPath()
+// GetConfig returns the configuration of a profile in the configuration
+// file at the given path. The empty profile is the default profile
+func GetConfig(path, profile string) (*Config, error) {
+	f, err := ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	profile = f.Selected(profile)
+	c, ok := f.Profiles[profile]
+	if !ok {
+		return nil, fmt.Errorf("profile %s does not exist", profile)
 	}
-	return readFSConfig(path)
+	return c, nil
 }
 
-func readFSConfig(path string) (*Config, error) {
+// ReadFile reads the configuration file at the given path. A configuration
+// file written before profiles is read as a file with only the default profile
+func ReadFile(path string) (*File, error) {
+	if path == "" {
+		path = GetDefaultPath()
+	}
 	dat, err := ioutil.ReadFile(fmt.Sprintf("%s/config.json", path))
 	if err != nil {
-		return nil, fmt.Errorf("could not read configuration file %s: %s", path, err)
+		return nil, fmt.Errorf("could not read configuration file %s: %w", path, err)
+	}
+	var f File
+	if err = json.Unmarshal(dat, &f); err != nil {
+		return nil, fmt.Errorf("could not unmarshal config: %s", err)
+	}
+	if f.Profiles != nil {
+		return &f, nil
 	}
 	var c *Config
 	if err = json.Unmarshal(dat, &c); err != nil {
 		return nil, fmt.Errorf("could not unmarshal config: %s", err)
 	}
-	return c, nil
+	return &File{
+		DefaultProfile: DefaultProfile,
+		Profiles:       map[string]*Config{DefaultProfile: c},
+	}, nil
 }
@@ -12,9 +12,11 @@ var version string // injected at build-time
 
 var appflags = []cli.Flag{
 	commands.ConfigFlag,
+	commands.ProfileFlag,
 	commands.HostURLFlag,
 	commands.TokenFlag,
 	commands.VerboseFlag,
+	commands.PassphraseFDFlag,
 }
 
 var appcmds = []cli.Command{
@@ -24,6 +26,8 @@ var appcmds = []cli.Command{
 	commands.PadlfileCmds,
 	// commands.KMSCmds,
 	commands.RunCmds,
+	commands.AgentCmds,
+	commands.AdminCmds,
 }
 
 func main() {
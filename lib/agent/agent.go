@@ -0,0 +1,33 @@
+package agent
+
+// SockEnvVar is the environment variable holding the path of the
+// socket of a running agent, which padl commands use when set
+const SockEnvVar = "PADL_AGENT_SOCK"
+
+// DecryptRequest is the body of a request to decrypt the variables of
+// a padlfile (or padlfile environment), whose shared key is SharedKey
+type DecryptRequest struct {
+	SharedKey string            `json:"shared_key"`
+	Variables map[string]string `json:"variables"`
+}
+
+// DecryptResponse holds the decrypted variables, and why
+// each of the variables which could not be decrypted was not
+type DecryptResponse struct {
+	Variables map[string]string `json:"variables"`
+	Errors    map[string]string `json:"errors,omitempty"`
+}
+
+// PublicKeyResponse is the body of a response to a public key request
+type PublicKeyResponse struct {
+	ID  string `json:"id"`
+	PEM string `json:"pem"`
+}
+
+// StatusResponse describes a running agent
+type StatusResponse struct {
+	HostURL string   `json:"host_url"`
+	User    string   `json:"user,omitempty"`
+	KeyIDs  []string `json:"key_ids"`
+	PID     int      `json:"pid"`
+}
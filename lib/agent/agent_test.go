@@ -0,0 +1,104 @@
+package agent
+
+import (
+	"crypto/rsa"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/adrianosela/padl/lib/keymgr"
+	"github.com/adrianosela/padl/lib/keys"
+	"github.com/adrianosela/padl/lib/padlfile"
+	"github.com/adrianosela/padl/lib/policy"
+	"github.com/adrianosela/padl/lib/secretsmgr"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestKey(t *testing.T, km keymgr.Manager) (*rsa.PrivateKey, string) {
+	priv, pub, err := keys.GenerateRSAKeyPair(2048)
+	assert.Nil(t, err)
+	id := keys.GetFingerprint(pub)
+	assert.Nil(t, km.PutPub(id, string(keys.EncodePubKeyPEM(pub))))
+	return priv, id
+}
+
+func TestDecrypt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agent")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// secrets which do not require the server, such that no padl client is needed
+	km := keymgr.NewMemManager()
+	priv1, id1 := newTestKey(t, km)
+	priv2, id2 := newTestKey(t, km)
+	_, shared := newTestKey(t, km)
+	pf := &padlfile.File{Data: padlfile.Body{
+		Variables:  map[string]string{"DB": "db-secret", "API": "api-secret"},
+		MemberKeys: []string{id1, id2},
+		SharedKey:  shared,
+		Policy:     policy.BreakGlass,
+	}}
+	encrypted, err := secretsmgr.NewSecretsMgr(nil, km, pf).EncryptPadlfileSecrets()
+	assert.Nil(t, err)
+	encrypted["BAD"] = "not a secret"
+
+	sock := filepath.Join(dir, "agent.sock")
+	l, err := Listen(sock)
+	assert.Nil(t, err)
+	defer l.Close()
+	go NewServer(nil, "user@padl.io", []*rsa.PrivateKey{priv1, priv2}).Serve(l)
+
+	fi, err := os.Stat(sock)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	_, err = Listen(sock)
+	assert.NotNil(t, err, "a socket with an agent listening should not be replaced")
+
+	c := NewClient(sock)
+	status, err := c.Status()
+	assert.Nil(t, err)
+	assert.Equal(t, "user@padl.io", status.User)
+	assert.ElementsMatch(t, []string{id1, id2}, status.KeyIDs)
+
+	decrypted, err := c.Decrypt(shared, encrypted)
+	assert.Equal(t, map[string]string{"DB": "db-secret", "API": "api-secret"}, decrypted)
+	var errs secretsmgr.DecryptErrors
+	assert.True(t, errors.As(err, &errs))
+	assert.Contains(t, errs, "BAD")
+
+	_, err = c.GetPub(id1)
+	assert.NotNil(t, err, "public keys which are not cached should be fetched from the server")
+}
+
+func TestKeyManager(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agent")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	sock := filepath.Join(dir, "agent.sock")
+	l, err := Listen(sock)
+	assert.Nil(t, err)
+	defer l.Close()
+	srv := NewServer(nil, "user@padl.io", nil)
+	_, id := newTestKey(t, srv.keyMgr)
+	go srv.Serve(l)
+
+	var km keymgr.Manager = NewKeyManager(NewClient(sock))
+	// public keys cached by the agent are served from its cache
+	cached, err := srv.keyMgr.GetPub(id)
+	assert.Nil(t, err)
+	pem, err := km.GetPub(id)
+	assert.Nil(t, err)
+	assert.Equal(t, cached, pem)
+	_, err = km.GetPub("unknown")
+	assert.NotNil(t, err)
+
+	// the agent's private keys never leave it
+	_, err = km.GetPriv(id)
+	assert.Equal(t, ErrPrivNotSupported, err)
+	_, err = km.ListPrivs()
+	assert.Equal(t, ErrPrivNotSupported, err)
+	assert.Equal(t, ErrPrivNotSupported, km.PutPriv(id, "pem"))
+}
@@ -0,0 +1,112 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+
+	"github.com/adrianosela/padl/api/payloads"
+	"github.com/adrianosela/padl/lib/secretsmgr"
+)
+
+// the host of agent requests is irrelevant, as they are sent to the socket
+const agentURL = "http://padl-agent"
+
+// Client is a client of a padl agent
+type Client struct {
+	SockPath   string
+	HTTPClient *http.Client
+}
+
+// NewClient is the constructor of a client of the agent on the given socket
+func NewClient(sockPath string) *Client {
+	dialer := &net.Dialer{}
+	return &Client{
+		SockPath: sockPath,
+		HTTPClient: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return dialer.DialContext(ctx, "unix", sockPath)
+				},
+			},
+		},
+	}
+}
+
+// Status gets the status of the agent
+func (c *Client) Status() (*StatusResponse, error) {
+	var status StatusResponse
+	if err := c.do(http.MethodGet, "/status", nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Decrypt decrypts the variables of a padlfile with the agent's keys. If any
+// variable can not be decrypted, the others are returned along with
+// secretsmgr.DecryptErrors, as if they had been decrypted locally
+func (c *Client) Decrypt(sharedKey string, variables map[string]string) (map[string]string, error) {
+	var resp DecryptResponse
+	if err := c.do(http.MethodPost, "/decrypt", &DecryptRequest{SharedKey: sharedKey, Variables: variables}, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Variables == nil {
+		resp.Variables = make(map[string]string)
+	}
+	if len(resp.Errors) == 0 {
+		return resp.Variables, nil
+	}
+	errs := make(secretsmgr.DecryptErrors)
+	for varName, msg := range resp.Errors {
+		errs[varName] = errors.New(msg)
+	}
+	return resp.Variables, errs
+}
+
+// GetPub gets a public key through the agent, which caches it
+func (c *Client) GetPub(kid string) (string, error) {
+	var resp PublicKeyResponse
+	if err := c.do(http.MethodGet, fmt.Sprintf("/key/%s", kid), nil, &resp); err != nil {
+		return "", err
+	}
+	return resp.PEM, nil
+}
+
+func (c *Client) do(method, path string, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return fmt.Errorf("could not marshal request: %s", err)
+		}
+	}
+	req, err := http.NewRequest(method, agentURL+path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("could not build agent request: %s", err)
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not reach agent on %s: %s", c.SockPath, err)
+	}
+	defer resp.Body.Close()
+	respByt, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("could not read agent response: %s", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e payloads.ErrorResponse
+		if err = json.Unmarshal(respByt, &e); err != nil || e.Message == "" {
+			return fmt.Errorf("agent responded with status %d", resp.StatusCode)
+		}
+		return fmt.Errorf("agent error: %s", e.Message)
+	}
+	if err = json.Unmarshal(respByt, out); err != nil {
+		return fmt.Errorf("could not unmarshal agent response: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrPrivNotSupported is returned by the private key operations of a
+// KeyManager, as the private keys of an agent never leave it
+var ErrPrivNotSupported = errors.New("private keys are held by the agent, and are not available to commands")
+
+// KeyManager is a key manager whose public keys are fetched through
+// the agent, which caches them for the lifetime of the agent
+type KeyManager struct {
+	client *Client
+}
+
+// NewKeyManager is the KeyManager constructor
+func NewKeyManager(c *Client) *KeyManager {
+	return &KeyManager{client: c}
+}
+
+// PutPriv is not supported
+func (m *KeyManager) PutPriv(id string, blob string) error {
+	return ErrPrivNotSupported
+}
+
+// GetPriv is not supported
+func (m *KeyManager) GetPriv(id string) (string, error) {
+	return "", ErrPrivNotSupported
+}
+
+// ListPrivs is not supported
+func (m *KeyManager) ListPrivs() ([]string, error) {
+	return nil, ErrPrivNotSupported
+}
+
+// PutPub does nothing, as the agent caches the public keys it fetches
+func (m *KeyManager) PutPub(id string, blob string) error {
+	return nil
+}
+
+// GetPub gets a public key through the agent
+func (m *KeyManager) GetPub(id string) (string, error) {
+	pem, err := m.client.GetPub(id)
+	if err != nil {
+		return "", fmt.Errorf("could not get public key %s: %s", id, err)
+	}
+	return pem, nil
+}
@@ -0,0 +1,18 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package agent
+
+import (
+	"net"
+	"syscall"
+)
+
+// listenUnix creates a unix socket which only the current user can
+// connect to. The umask is set while the socket is created, rather than
+// its permissions after, such that it is never accessible to others
+func listenUnix(path string) (net.Listener, error) {
+	umask := syscall.Umask(0177)
+	defer syscall.Umask(umask)
+	return net.Listen("unix", path)
+}
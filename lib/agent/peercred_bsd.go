@@ -0,0 +1,39 @@
+//go:build darwin || freebsd
+// +build darwin freebsd
+
+package agent
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// checkPeer checks that the process on the other end
+// of a unix socket connection runs as the current user
+func checkPeer(conn net.Conn) error {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return fmt.Errorf("not a unix socket connection")
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return fmt.Errorf("could not get socket of connection: %s", err)
+	}
+	var cred *unix.Xucred
+	var credErr error
+	if err = raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptXucred(int(fd), unix.SOL_LOCAL, unix.LOCAL_PEERCRED)
+	}); err != nil {
+		return fmt.Errorf("could not get peer credentials: %s", err)
+	}
+	if credErr != nil {
+		return fmt.Errorf("could not get peer credentials: %s", credErr)
+	}
+	if int(cred.Uid) != os.Getuid() {
+		return fmt.Errorf("peer process runs as user %d", cred.Uid)
+	}
+	return nil
+}
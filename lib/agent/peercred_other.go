@@ -0,0 +1,21 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package agent
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+)
+
+// listenUnix refuses to create the socket of an agent where the
+// processes connecting to it can not be identified
+func listenUnix(path string) (net.Listener, error) {
+	return nil, fmt.Errorf("the agent is not supported on %s, as it can not identify the users of processes connecting to it", runtime.GOOS)
+}
+
+// checkPeer rejects every connection, as no agent listens on this platform
+func checkPeer(conn net.Conn) error {
+	return fmt.Errorf("peer credentials are not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,197 @@
+package agent
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/adrianosela/padl/api/client"
+	"github.com/adrianosela/padl/api/payloads"
+	"github.com/adrianosela/padl/lib/keymgr"
+	"github.com/adrianosela/padl/lib/keys"
+	"github.com/adrianosela/padl/lib/padlfile"
+	"github.com/adrianosela/padl/lib/secretsmgr"
+	"github.com/gorilla/mux"
+)
+
+// maxRequestSize caps the size of the request bodies the agent reads
+const maxRequestSize = 8 << 20
+
+// Server is an agent holding a user's unlocked private keys and padl
+// client, which serves decryption requests over a unix socket such that
+// they need not be loaded for every command. Fetched public keys are
+// cached in memory for the lifetime of the agent
+type Server struct {
+	client *client.Padl
+	user   string
+	privs  []*rsa.PrivateKey
+	keyMgr *keymgr.MemManager
+
+	// the client is not safe for concurrent use when it
+	// refreshes its token, so requests are served one at a time
+	mutex sync.Mutex
+}
+
+// NewServer is the Server constructor. The client may be
+// nil if none of the secrets decrypted require the server
+func NewServer(pc *client.Padl, user string, privs []*rsa.PrivateKey) *Server {
+	return &Server{
+		client: pc,
+		user:   user,
+		privs:  privs,
+		keyMgr: keymgr.NewMemManager(),
+	}
+}
+
+// Listen creates the unix socket of an agent at the given path, which
+// only the current user can connect to, on platforms where the users of
+// connecting processes are identified. A socket left behind by an
+// agent which is no longer running is replaced
+func Listen(path string) (net.Listener, error) {
+	if _, err := os.Stat(path); err == nil {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("an agent is already listening on %s", path)
+		}
+		if err = os.Remove(path); err != nil {
+			return nil, fmt.Errorf("could not remove stale socket %s: %s", path, err)
+		}
+	}
+	l, err := listenUnix(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not listen on %s: %s", path, err)
+	}
+	return &peerListener{Listener: l}, nil
+}
+
+// peerListener only accepts connections of processes of the
+// current user, where the operating system identifies peers
+type peerListener struct {
+	net.Listener
+}
+
+func (l *peerListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if err = checkPeer(conn); err != nil {
+			log.Printf("[warning] rejected connection: %s", err)
+			conn.Close()
+			continue
+		}
+		return conn, nil
+	}
+}
+
+// Serve serves agent requests on a listener returned by Listen
+func (s *Server) Serve(l net.Listener) error {
+	return http.Serve(l, s.Handler())
+}
+
+// Handler returns the http handler of the agent's API
+func (s *Server) Handler() http.Handler {
+	r := mux.NewRouter()
+	r.Methods(http.MethodGet).Path("/status").HandlerFunc(s.statusHandler)
+	r.Methods(http.MethodPost).Path("/decrypt").HandlerFunc(s.decryptHandler)
+	r.Methods(http.MethodGet).Path("/key/{kid}").HandlerFunc(s.publicKeyHandler)
+	return r
+}
+
+func (s *Server) statusHandler(w http.ResponseWriter, r *http.Request) {
+	ids := []string{}
+	for _, priv := range s.privs {
+		ids = append(ids, keys.GetFingerprint(&priv.PublicKey))
+	}
+	sort.Strings(ids)
+	status := &StatusResponse{User: s.user, KeyIDs: ids, PID: os.Getpid()}
+	if s.client != nil {
+		status.HostURL = s.client.HostURL
+	}
+	writeJSON(w, status)
+}
+
+func (s *Server) decryptHandler(w http.ResponseWriter, r *http.Request) {
+	var req DecryptRequest
+	byt, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "could not read request body")
+		return
+	}
+	if err = json.Unmarshal(byt, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "request is not of the correct format")
+		return
+	}
+	pf := &padlfile.File{Data: padlfile.Body{SharedKey: req.SharedKey, Variables: req.Variables}}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	decrypted, err := secretsmgr.NewSecretsMgr(s.client, s.keyMgr, pf).DecryptPadlFileSecrets(s.privs...)
+	resp := &DecryptResponse{Variables: decrypted}
+	if err != nil {
+		var errs secretsmgr.DecryptErrors
+		if !errors.As(err, &errs) {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not decrypt secrets: %s", err))
+			return
+		}
+		resp.Errors = make(map[string]string)
+		for varName, err := range errs {
+			resp.Errors[varName] = err.Error()
+		}
+	}
+	writeJSON(w, resp)
+}
+
+func (s *Server) publicKeyHandler(w http.ResponseWriter, r *http.Request) {
+	kid := mux.Vars(r)["kid"]
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if pem, err := s.keyMgr.GetPub(kid); err == nil {
+		writeJSON(w, &PublicKeyResponse{ID: kid, PEM: pem})
+		return
+	}
+	if s.client == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("public key %s not found", kid))
+		return
+	}
+	pub, err := s.client.GetPublicKey(kid)
+	if err != nil {
+		if errors.Is(err, client.ErrNotFound) {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("public key %s not found", kid))
+			return
+		}
+		writeError(w, http.StatusBadGateway, fmt.Sprintf("could not get public key %s from padl server: %s", kid, err))
+		return
+	}
+	s.keyMgr.PutPub(pub.ID, pub.PEM)
+	writeJSON(w, &PublicKeyResponse{ID: pub.ID, PEM: pub.PEM})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	byt, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "could not marshal response")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(byt)
+}
+
+// writeError responds with the error envelope of the padl API
+func writeError(w http.ResponseWriter, status int, msg string) {
+	byt, _ := json.Marshal(&payloads.ErrorResponse{Code: payloads.ErrorCode(status), Message: msg})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(byt)
+}
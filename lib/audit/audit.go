@@ -0,0 +1,241 @@
+package audit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"time"
+)
+
+const (
+	// ActionProjectCreate is recorded when a project is created
+	ActionProjectCreate = "project.create"
+	// ActionProjectDelete is recorded when a project is deleted
+	ActionProjectDelete = "project.delete"
+	// ActionProjectRotateKey is recorded when a project's shared key is rotated
+	ActionProjectRotateKey = "project.rotate_key"
+	// ActionProjectSetPolicy is recorded when a project's policy is changed
+	ActionProjectSetPolicy = "project.set_policy"
+	// ActionUserAdd was recorded when a user was added to a project
+	// without an invitation, which is no longer possible
+	ActionUserAdd = "user.add"
+	// ActionUserSetPrivilege is recorded when a member's privilege level is changed
+	ActionUserSetPrivilege = "user.set_privilege"
+	// ActionUserRemove is recorded when a user is removed from a project
+	ActionUserRemove = "user.remove"
+	// ActionInvitationCreate is recorded when a user is invited to a project
+	ActionInvitationCreate = "invitation.create"
+	// ActionInvitationAccept is recorded when an invitee joins a project
+	ActionInvitationAccept = "invitation.accept"
+	// ActionInvitationDecline is recorded when an invitee declines an invitation
+	ActionInvitationDecline = "invitation.decline"
+	// ActionInvitationRevoke is recorded when an owner revokes an invitation
+	ActionInvitationRevoke = "invitation.revoke"
+	// ActionOwnershipNominate is recorded when an owner nominates a new owner
+	ActionOwnershipNominate = "ownership.nominate"
+	// ActionOwnershipAccept is recorded when a nominee accepts project ownership
+	ActionOwnershipAccept = "ownership.accept"
+	// ActionOwnershipCancel is recorded when a pending ownership transfer is cancelled
+	ActionOwnershipCancel = "ownership.cancel"
+	// ActionServiceAccountCreate is recorded when a service account is created
+	ActionServiceAccountCreate = "service_account.create"
+	// ActionServiceAccountRemove is recorded when a service account is removed
+	ActionServiceAccountRemove = "service_account.remove"
+	// ActionKeyDecrypt is recorded when a project key is used to decrypt a secret
+	ActionKeyDecrypt = "key.decrypt"
+	// ActionPadlfilePush is recorded when a new padlfile revision is stored
+	ActionPadlfilePush = "padlfile.push"
+)
+
+// Event is an entry in a project's audit log. Each event's hash covers
+// all of its fields, including the hash of the event before it, such
+// that altering, removing or reordering any event breaks the chain.
+// Hashes are keyed with a key only the server holds, such that the
+// chain can not be rewritten by anyone with access to the database
+type Event struct {
+	Project    string    `json:"project"`
+	Seq        uint64    `json:"seq"`  // position in the chain, starting at 1
+	Time       time.Time `json:"time"` // millisecond precision
+	Actor      string    `json:"actor"`
+	Action     string    `json:"action"`
+	Target     string    `json:"target,omitempty"` // e.g. user email or key id
+	Detail     string    `json:"detail,omitempty"`
+	PrevHash   string    `json:"prev_hash"` // empty for the first event
+	Hash       string    `json:"hash"`
+	KeyVersion int       `json:"key_version,omitempty"` // version of the key of the hash, 0 for none
+}
+
+// Head is the last event of an audit log, stored apart from the
+// log such that the removal of its newest events is detected
+type Head struct {
+	Project string `json:"project"`
+	Seq     uint64 `json:"seq"`
+	Hash    string `json:"hash"`
+}
+
+// Filter selects events from an audit log.
+// Zero valued fields match all events
+type Filter struct {
+	Since time.Time
+	Until time.Time
+	Actor string
+}
+
+// NewEvent returns a new (unlinked) event
+func NewEvent(project, actor, action, target, detail string) *Event {
+	return &Event{
+		Project: project,
+		// storage backends do not all keep nanoseconds, the
+		// hash must be computed over the time as it is stored
+		Time:   time.Now().UTC().Truncate(time.Millisecond),
+		Actor:  actor,
+		Action: action,
+		Target: target,
+		Detail: detail,
+	}
+}
+
+// Link makes the event the successor of prev in the chain and computes
+// its hash, keyed with the given key. A nil prev makes the event the
+// first in the chain, and a nil key leaves the hash unkeyed
+func (e *Event) Link(prev *Event, k *Key) {
+	e.Seq = 1
+	e.PrevHash = ""
+	if prev != nil {
+		e.Seq = prev.Seq + 1
+		e.PrevHash = prev.Hash
+	}
+	e.KeyVersion = 0
+	var secret []byte
+	if k != nil {
+		e.KeyVersion = k.Version
+		secret = k.Secret
+	}
+	e.Hash = e.ComputeHash(secret)
+}
+
+// Head returns the head of a log which ends with the event
+func (e *Event) Head() *Head {
+	return &Head{Project: e.Project, Seq: e.Seq, Hash: e.Hash}
+}
+
+// ComputeHash returns the hex encoded HMAC-SHA256 of the event's fields
+// keyed with the given secret, or their plain sha256 hash if it is empty.
+// Every field is length-prefixed so that no two events share an encoding
+func (e *Event) ComputeHash(secret []byte) string {
+	var h hash.Hash
+	if len(secret) == 0 {
+		h = sha256.New()
+	} else {
+		h = hmac.New(sha256.New, secret)
+	}
+	num := make([]byte, 8)
+	binary.BigEndian.PutUint64(num, e.Seq)
+	h.Write(num)
+	binary.BigEndian.PutUint64(num, uint64(e.Time.UnixNano()))
+	h.Write(num)
+	for _, f := range []string{e.Project, e.Actor, e.Action, e.Target, e.Detail, e.PrevHash} {
+		binary.BigEndian.PutUint64(num, uint64(len(f)))
+		h.Write(num)
+		h.Write([]byte(f))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Match returns true if the event is selected by the filter
+func (f *Filter) Match(e *Event) bool {
+	if f == nil {
+		return true
+	}
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && e.Time.After(f.Until) {
+		return false
+	}
+	if f.Actor != "" && e.Actor != f.Actor {
+		return false
+	}
+	return true
+}
+
+// IsZero returns true if the filter matches all events
+func (f *Filter) IsZero() bool {
+	return f == nil || (f.Since.IsZero() && f.Until.IsZero() && f.Actor == "")
+}
+
+// Verify checks that the given events are a whole audit log, i.e. an
+// unbroken chain which starts with the first event of its chain, such
+// that the removal of its oldest events is detected too, and which
+// reaches the head of the log, such that the removal of its newest events
+// is detected. Every event's hash must be correct and link to the event
+// before it. The events must be in order. A nil head is only accepted
+// for logs of unkeyed events, which were appended before heads were kept
+func Verify(events []*Event, head *Head, kr *Keyring) error {
+	if len(events) > 0 {
+		if first := events[0]; first.Seq != 1 {
+			return fmt.Errorf("chain broken: log starts at event %d rather than event 1", first.Seq)
+		}
+	}
+	if err := VerifySegment(events, kr); err != nil {
+		return err
+	}
+	if head == nil {
+		for _, e := range events {
+			if e.KeyVersion != 0 {
+				return fmt.Errorf("chain broken: log of keyed events has no head")
+			}
+		}
+		return nil
+	}
+	// the head is written after the event, so the log may be
+	// ahead of it if writing the head failed, but never behind
+	if head.Seq > uint64(len(events)) {
+		return fmt.Errorf("chain truncated: log ends at event %d, but its head is event %d", len(events), head.Seq)
+	}
+	if head.Seq == 0 || events[head.Seq-1].Hash != head.Hash {
+		return fmt.Errorf("chain broken: event %d is not the head of the log", head.Seq)
+	}
+	return nil
+}
+
+// VerifySegment checks that the given events are an unbroken segment of
+// a chain, e.g. the events of a log since a given time. The link of the
+// segment's first event to the (absent) event before it can not be
+// checked, so the removal of the events before the segment goes unnoticed
+func VerifySegment(events []*Event, kr *Keyring) error {
+	for i, e := range events {
+		k, err := kr.Key(e.KeyVersion)
+		if err != nil {
+			return fmt.Errorf("event %d can not be verified: %s", e.Seq, err)
+		}
+		if e.Hash != e.ComputeHash(k.Secret) {
+			return fmt.Errorf("event %d has been altered: hash mismatch", e.Seq)
+		}
+		if i == 0 {
+			if e.Seq == 1 && e.PrevHash != "" {
+				return fmt.Errorf("event 1 has been altered: first event links to a previous event")
+			}
+			continue
+		}
+		prev := events[i-1]
+		if e.Project != prev.Project {
+			return fmt.Errorf("event %d belongs to project %s, not %s", e.Seq, e.Project, prev.Project)
+		}
+		if e.Seq != prev.Seq+1 {
+			return fmt.Errorf("chain broken: event %d follows event %d", e.Seq, prev.Seq)
+		}
+		if e.PrevHash != prev.Hash {
+			return fmt.Errorf("chain broken: event %d does not link to event %d", e.Seq, prev.Seq)
+		}
+		// unkeyed hashes can be recomputed by anyone, so once
+		// keyed, a chain must stay keyed
+		if e.KeyVersion == 0 && prev.KeyVersion != 0 {
+			return fmt.Errorf("event %d has been altered: unkeyed event follows keyed event %d", e.Seq, prev.Seq)
+		}
+	}
+	return nil
+}
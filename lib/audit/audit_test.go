@@ -0,0 +1,293 @@
+package audit
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testKeyring returns a keyring of a master key made of a repeated byte
+func testKeyring(t *testing.T, b byte) *Keyring {
+	kr, err := NewKeyring(map[int][]byte{1: bytes.Repeat([]byte{b}, 32)}, 1)
+	if err != nil {
+		t.Fatalf("could not create keyring: %s", err)
+	}
+	return kr
+}
+
+func buildChain(n int, k *Key) []*Event {
+	events := []*Event{}
+	var prev *Event
+	for i := 0; i < n; i++ {
+		e := NewEvent("my-project", "user@padl.io", ActionKeyDecrypt, "kid", "")
+		e.Link(prev, k)
+		events = append(events, e)
+		prev = e
+	}
+	return events
+}
+
+// head returns the head of a log which ends with the last event
+func head(events []*Event) *Head {
+	return events[len(events)-1].Head()
+}
+
+func TestLink(t *testing.T) {
+	k := testKeyring(t, 1).Active()
+	events := buildChain(3, k)
+	assert.Equal(t, uint64(1), events[0].Seq)
+	assert.Equal(t, "", events[0].PrevHash)
+	assert.Equal(t, uint64(3), events[2].Seq)
+	assert.Equal(t, events[1].Hash, events[2].PrevHash)
+	assert.Equal(t, 1, events[2].KeyVersion)
+	assert.Equal(t, events[2].ComputeHash(k.Secret), events[2].Hash)
+	assert.Equal(t, &Head{Project: "my-project", Seq: 3, Hash: events[2].Hash}, events[2].Head())
+}
+
+func TestComputeHash(t *testing.T) {
+	a := NewEvent("proj", "ab", "c", "", "")
+	b := NewEvent("proj", "a", "bc", "", "")
+	b.Time = a.Time
+	// fields are length-prefixed, moving bytes between them changes the hash
+	assert.NotEqual(t, a.ComputeHash(nil), b.ComputeHash(nil))
+	// keyed hashes can only be computed with the key
+	k1, k2 := testKeyring(t, 1).Active(), testKeyring(t, 2).Active()
+	assert.NotEqual(t, a.ComputeHash(nil), a.ComputeHash(k1.Secret))
+	assert.NotEqual(t, a.ComputeHash(k1.Secret), a.ComputeHash(k2.Secret))
+}
+
+func TestNewKeyring(t *testing.T) {
+	mk := bytes.Repeat([]byte{1}, 32)
+
+	kr, err := NewKeyring(nil, 0)
+	assert.Nil(t, err)
+	assert.Nil(t, kr.Active(), "without master keys events should not be keyed")
+	assert.Nil(t, (*Keyring)(nil).Active())
+
+	kr, err = NewKeyring(map[int][]byte{1: mk, 2: bytes.Repeat([]byte{2}, 32)}, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, kr.Active().Version)
+	k, err := kr.Key(1)
+	assert.Nil(t, err)
+	assert.NotEqual(t, mk, k.Secret, "keys should be derived from, not equal to, master keys")
+	_, err = kr.Key(3)
+	assert.EqualError(t, err, "key version 3 is not loaded")
+	k, err = kr.Key(0)
+	assert.Nil(t, err)
+	assert.Empty(t, k.Secret)
+
+	_, err = NewKeyring(map[int][]byte{1: mk}, 2)
+	assert.NotNil(t, err)
+	_, err = NewKeyring(map[int][]byte{0: mk}, 0)
+	assert.NotNil(t, err)
+}
+
+func TestVerify(t *testing.T) {
+	kr := testKeyring(t, 1)
+
+	tests := []struct {
+		testName string
+		// tamper modifies a keyed chain of 4 events and its head
+		tamper    func([]*Event, *Head) ([]*Event, *Head)
+		keyring   *Keyring
+		expectErr string
+	}{
+		{
+			testName: "positive test - untouched chain",
+			tamper:   func(e []*Event, h *Head) ([]*Event, *Head) { return e, h },
+			keyring:  kr,
+		},
+		{
+			testName: "positive test - empty chain",
+			tamper:   func(e []*Event, h *Head) ([]*Event, *Head) { return nil, nil },
+			keyring:  kr,
+		},
+		{
+			testName: "positive test - chain ahead of its head",
+			tamper:   func(e []*Event, h *Head) ([]*Event, *Head) { return e, e[2].Head() },
+			keyring:  kr,
+		},
+		{
+			testName: "positive test - unkeyed chain without a head",
+			tamper: func(e []*Event, h *Head) ([]*Event, *Head) {
+				return buildChain(4, nil), nil
+			},
+			keyring: kr,
+		},
+		{
+			testName: "positive test - keyed events after unkeyed events",
+			tamper: func(e []*Event, h *Head) ([]*Event, *Head) {
+				e = buildChain(2, nil)
+				next := NewEvent("my-project", "user@padl.io", ActionKeyDecrypt, "kid", "")
+				next.Link(e[1], kr.Active())
+				e = append(e, next)
+				return e, head(e)
+			},
+			keyring: kr,
+		},
+		{
+			testName:  "negative test - removed oldest events",
+			tamper:    func(e []*Event, h *Head) ([]*Event, *Head) { return e[2:], h },
+			keyring:   kr,
+			expectErr: "chain broken: log starts at event 3 rather than event 1",
+		},
+		{
+			testName:  "negative test - removed newest events",
+			tamper:    func(e []*Event, h *Head) ([]*Event, *Head) { return e[:2], h },
+			keyring:   kr,
+			expectErr: "chain truncated: log ends at event 2, but its head is event 4",
+		},
+		{
+			testName:  "negative test - removed all events",
+			tamper:    func(e []*Event, h *Head) ([]*Event, *Head) { return nil, h },
+			keyring:   kr,
+			expectErr: "chain truncated: log ends at event 0, but its head is event 4",
+		},
+		{
+			testName:  "negative test - removed head",
+			tamper:    func(e []*Event, h *Head) ([]*Event, *Head) { return e, nil },
+			keyring:   kr,
+			expectErr: "chain broken: log of keyed events has no head",
+		},
+		{
+			testName: "negative test - head of another chain",
+			tamper: func(e []*Event, h *Head) ([]*Event, *Head) {
+				h.Hash = "abc"
+				return e, h
+			},
+			keyring:   kr,
+			expectErr: "chain broken: event 4 is not the head of the log",
+		},
+		{
+			testName: "negative test - altered field",
+			tamper: func(e []*Event, h *Head) ([]*Event, *Head) {
+				e[1].Actor = "someone@else.io"
+				return e, h
+			},
+			keyring:   kr,
+			expectErr: "event 2 has been altered: hash mismatch",
+		},
+		{
+			testName: "negative test - removed event",
+			tamper: func(e []*Event, h *Head) ([]*Event, *Head) {
+				return append(e[:1], e[2:]...), h
+			},
+			keyring:   kr,
+			expectErr: "chain broken: event 3 follows event 1",
+		},
+		{
+			testName: "negative test - rewritten event",
+			tamper: func(e []*Event, h *Head) ([]*Event, *Head) {
+				e[1].Detail = "rewritten"
+				e[1].Hash = e[1].ComputeHash(kr.Active().Secret)
+				return e, h
+			},
+			keyring:   kr,
+			expectErr: "chain broken: event 3 does not link to event 2",
+		},
+		{
+			testName: "negative test - rewritten chain with another key",
+			tamper: func(e []*Event, h *Head) ([]*Event, *Head) {
+				e = buildChain(4, testKeyring(t, 2).Active())
+				return e, head(e)
+			},
+			keyring:   kr,
+			expectErr: "event 1 has been altered: hash mismatch",
+		},
+		{
+			testName: "negative test - rewritten chain without a key",
+			tamper: func(e []*Event, h *Head) ([]*Event, *Head) {
+				e[3].Detail = "rewritten"
+				e[3].Link(e[2], nil)
+				return e, head(e)
+			},
+			keyring:   kr,
+			expectErr: "event 4 has been altered: unkeyed event follows keyed event 3",
+		},
+		{
+			testName:  "negative test - key version not loaded",
+			tamper:    func(e []*Event, h *Head) ([]*Event, *Head) { return e, h },
+			keyring:   nil,
+			expectErr: "event 1 can not be verified: key version 1 is not loaded",
+		},
+		{
+			testName: "negative test - first event with a previous hash",
+			tamper: func(e []*Event, h *Head) ([]*Event, *Head) {
+				e[0].PrevHash = "abc"
+				e[0].Hash = e[0].ComputeHash(kr.Active().Secret)
+				return e[:1], e[0].Head()
+			},
+			keyring:   kr,
+			expectErr: "event 1 has been altered: first event links to a previous event",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			chain := buildChain(4, kr.Active())
+			events, h := test.tamper(chain, head(chain))
+			err := Verify(events, h, test.keyring)
+			if test.expectErr != "" {
+				assert.EqualError(t, err, test.expectErr)
+			} else {
+				assert.Nil(t, err)
+			}
+		})
+	}
+}
+
+func TestVerifySegment(t *testing.T) {
+	tests := []struct {
+		testName  string
+		tamper    func([]*Event) []*Event
+		expectErr string
+	}{
+		{
+			testName: "positive test - whole chain",
+			tamper:   func(e []*Event) []*Event { return e },
+		},
+		{
+			testName: "positive test - contiguous segment",
+			tamper:   func(e []*Event) []*Event { return e[2:] },
+		},
+		{
+			testName: "negative test - removed event within segment",
+			tamper: func(e []*Event) []*Event {
+				return append(e[1:2], e[3:]...)
+			},
+			expectErr: "chain broken: event 4 follows event 2",
+		},
+		{
+			testName: "negative test - altered field",
+			tamper: func(e []*Event) []*Event {
+				e[3].Target = "other"
+				return e[2:]
+			},
+			expectErr: "event 4 has been altered: hash mismatch",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			kr := testKeyring(t, 1)
+			err := VerifySegment(test.tamper(buildChain(4, kr.Active())), kr)
+			if test.expectErr != "" {
+				assert.EqualError(t, err, test.expectErr)
+			} else {
+				assert.Nil(t, err)
+			}
+		})
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	e := NewEvent("proj", "user@padl.io", ActionUserAdd, "other@padl.io", "")
+	assert.True(t, (*Filter)(nil).Match(e))
+	assert.True(t, (&Filter{Actor: "user@padl.io"}).Match(e))
+	assert.False(t, (&Filter{Actor: "other@padl.io"}).Match(e))
+	assert.True(t, (&Filter{Since: e.Time.Add(-time.Minute), Until: e.Time}).Match(e))
+	assert.False(t, (&Filter{Since: e.Time.Add(time.Minute)}).Match(e))
+	assert.False(t, (&Filter{Until: e.Time.Add(-time.Minute)}).Match(e))
+}
@@ -0,0 +1,75 @@
+package audit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+)
+
+// keyLabel separates the keys of audit logs from the
+// other uses of the master keys they are derived from
+const keyLabel = "padl audit log key"
+
+// Key is a versioned key which event hashes are keyed with
+type Key struct {
+	Version int
+	Secret  []byte
+}
+
+// Keyring holds the keys of audit logs by version, and the version which
+// new events are keyed with. Version 0 is no key: events appended without
+// keys have plain sha256 hashes, which anyone can recompute. A nil Keyring
+// holds no keys
+type Keyring struct {
+	keys   map[int][]byte
+	active int
+}
+
+// NewKeyring derives the keys of audit logs from master keys by version.
+// New events are keyed with the active version. Verifying an event
+// requires the version of the master key it was keyed with, so versions
+// must be kept for as long as the events keyed with them are verified
+func NewKeyring(masterKeys map[int][]byte, active int) (*Keyring, error) {
+	kr := &Keyring{keys: make(map[int][]byte)}
+	if len(masterKeys) == 0 {
+		return kr, nil
+	}
+	if _, ok := masterKeys[active]; !ok {
+		return nil, fmt.Errorf("active master key version %d is not loaded", active)
+	}
+	for v, mk := range masterKeys {
+		if v <= 0 {
+			return nil, fmt.Errorf("master key version %d is not positive", v)
+		}
+		if len(mk) == 0 {
+			return nil, fmt.Errorf("master key version %d is empty", v)
+		}
+		mac := hmac.New(sha256.New, mk)
+		mac.Write([]byte(keyLabel))
+		kr.keys[v] = mac.Sum(nil)
+	}
+	kr.active = active
+	return kr, nil
+}
+
+// Active returns the key which new events are keyed
+// with, or nil if new events are not keyed
+func (kr *Keyring) Active() *Key {
+	if kr == nil || kr.active == 0 {
+		return nil
+	}
+	return &Key{Version: kr.active, Secret: kr.keys[kr.active]}
+}
+
+// Key returns the key of a version. Version 0 is the empty key
+func (kr *Keyring) Key(version int) (*Key, error) {
+	if version == 0 {
+		return &Key{}, nil
+	}
+	if kr != nil {
+		if secret, ok := kr.keys[version]; ok {
+			return &Key{Version: version, Secret: secret}, nil
+		}
+	}
+	return nil, fmt.Errorf("key version %d is not loaded", version)
+}
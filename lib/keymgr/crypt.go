@@ -0,0 +1,123 @@
+package keymgr
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	encryptedPEMType = "PADL ENCRYPTED PRIVATE KEY"
+
+	kdfHeader       = "Kdf"
+	kdfParamsHeader = "Kdf-Params"
+	saltHeader      = "Salt"
+	kdfScrypt       = "scrypt"
+
+	// scrypt parameters of encrypted keys, as recommended for
+	// interactive logins. Keys with other parameters are rejected
+	// rather than derived, such that a key file can not stall the cli
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+
+	saltSize = 16
+	keySize  = 32 // AES-256
+)
+
+// ErrIncorrectPassphrase is returned when decrypting a
+// private key with a passphrase other than its own
+var ErrIncorrectPassphrase = errors.New("incorrect passphrase")
+
+// PassphraseFunc returns a passphrase to encrypt or decrypt private keys
+// with, e.g. by prompting the user or reading an environment variable
+type PassphraseFunc func() (string, error)
+
+// IsEncrypted returns whether a private key blob is passphrase encrypted
+func IsEncrypted(blob string) bool {
+	block, _ := pem.Decode([]byte(blob))
+	return block != nil && block.Type == encryptedPEMType
+}
+
+// EncryptPriv encrypts a private key blob (e.g. a PEM encoded private key)
+// with AES-256-GCM, under a key derived from the passphrase with scrypt
+func EncryptPriv(blob, passphrase string) (string, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("could not generate salt: %s", err)
+	}
+	gcm, err := newGCM(passphrase, salt, scryptN, scryptR, scryptP)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("could not generate nonce: %s", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{
+		Type: encryptedPEMType,
+		Headers: map[string]string{
+			kdfHeader:       kdfScrypt,
+			kdfParamsHeader: fmt.Sprintf("N=%d,r=%d,p=%d", scryptN, scryptR, scryptP),
+			saltHeader:      base64.StdEncoding.EncodeToString(salt),
+		},
+		Bytes: gcm.Seal(nonce, nonce, []byte(blob), nil),
+	})), nil
+}
+
+// DecryptPriv decrypts a private key blob encrypted with EncryptPriv
+func DecryptPriv(blob, passphrase string) (string, error) {
+	block, _ := pem.Decode([]byte(blob))
+	if block == nil || block.Type != encryptedPEMType {
+		return "", errors.New("not an encrypted private key")
+	}
+	if kdf := block.Headers[kdfHeader]; kdf != kdfScrypt {
+		return "", fmt.Errorf("unsupported key derivation function %q", kdf)
+	}
+	var n, r, p int
+	if _, err := fmt.Sscanf(block.Headers[kdfParamsHeader], "N=%d,r=%d,p=%d", &n, &r, &p); err != nil {
+		return "", fmt.Errorf("invalid key derivation parameters: %s", err)
+	}
+	if n != scryptN || r != scryptR || p != scryptP {
+		return "", fmt.Errorf("unsupported key derivation parameters N=%d,r=%d,p=%d", n, r, p)
+	}
+	salt, err := base64.StdEncoding.DecodeString(block.Headers[saltHeader])
+	if err != nil {
+		return "", fmt.Errorf("invalid salt: %s", err)
+	}
+	gcm, err := newGCM(passphrase, salt, n, r, p)
+	if err != nil {
+		return "", err
+	}
+	if len(block.Bytes) < gcm.NonceSize() {
+		return "", errors.New("encrypted private key is too short")
+	}
+	nonce, ciphertext := block.Bytes[:gcm.NonceSize()], block.Bytes[gcm.NonceSize():]
+	plain, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", ErrIncorrectPassphrase
+	}
+	return string(plain), nil
+}
+
+func newGCM(passphrase string, salt []byte, n, r, p int) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, n, r, p, keySize)
+	if err != nil {
+		return nil, fmt.Errorf("could not derive key from passphrase: %s", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("could not create cipher: %s", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("could not create cipher: %s", err)
+	}
+	return gcm, nil
+}
@@ -4,44 +4,134 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strings"
 )
 
-// FSManager is a File System key manager
+// FSManager is a File System key manager. Private keys are
+// only readable by their owner, and may be passphrase encrypted
 type FSManager struct {
 	basePath string
+
+	// Unlock, if set, is called for the passphrase of encrypted private keys
+	Unlock PassphraseFunc
+	// Lock, if set, is called for the passphrase to encrypt the private keys
+	// which are saved with. Keys are saved unencrypted if it returns ""
+	Lock PassphraseFunc
 }
 
 // NewFSManager is the FSManager constructor
 func NewFSManager(path string) (*FSManager, error) {
 	bPath := fmt.Sprintf("%s/keys", path)
-	if _, err := os.Stat(bPath); os.IsNotExist(err) {
-		if err := os.Mkdir(bPath, 755); err != nil {
-			return nil, fmt.Errorf("could not create new directory %s: %s", path, err)
+	dirs := []struct {
+		path string
+		mode os.FileMode
+	}{
+		{bPath, 0755},
+		{fmt.Sprintf("%s/privs", bPath), 0700},
+		{fmt.Sprintf("%s/pubs", bPath), 0755},
+	}
+	// directories and keys created by earlier versions had the wrong
+	// permissions, with private keys readable by anyone
+	for _, dir := range dirs {
+		if err := os.MkdirAll(dir.path, dir.mode); err != nil {
+			return nil, fmt.Errorf("could not create new directory %s: %s", dir.path, err)
+		}
+		if err := os.Chmod(dir.path, dir.mode); err != nil {
+			return nil, fmt.Errorf("could not set permissions of %s: %s", dir.path, err)
 		}
-		path = fmt.Sprintf("%s/privs", bPath)
-		if err := os.Mkdir(path, 755); err != nil {
-			return nil, fmt.Errorf("could not create new directory %s: %s", path, err)
+	}
+	m := &FSManager{basePath: bPath}
+	if err := m.restrictPrivs(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *FSManager) restrictPrivs() error {
+	dir := fmt.Sprintf("%s/privs", m.basePath)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("could not list private keys in file system: %s", err)
+	}
+	for _, f := range files {
+		if f.Mode().Perm()&0077 == 0 {
+			continue
 		}
-		path = fmt.Sprintf("%s/pubs", bPath)
-		if err := os.Mkdir(path, 755); err != nil {
-			return nil, fmt.Errorf("could not create new directory %s: %s", path, err)
+		if err = os.Chmod(fmt.Sprintf("%s/%s", dir, f.Name()), 0600); err != nil {
+			return fmt.Errorf("could not set permissions of %s: %s", f.Name(), err)
 		}
 	}
-	return &FSManager{
-		basePath: bPath,
-	}, nil
+	return nil
 }
 
-// PutPriv saves a private key by id
+// PutPriv saves a private key by id, encrypted with the passphrase Lock
+// returns (if set). An existing key file is replaced atomically
 func (m *FSManager) PutPriv(id string, blob string) error {
-	if err := ioutil.WriteFile(fmt.Sprintf("%s/privs/%s.priv", m.basePath, id), []byte(blob), 0644); err != nil {
+	if m.Lock != nil {
+		passphrase, err := m.Lock()
+		if err != nil {
+			return fmt.Errorf("could not get passphrase: %s", err)
+		}
+		if passphrase != "" {
+			if blob, err = EncryptPriv(blob, passphrase); err != nil {
+				return fmt.Errorf("could not encrypt private key: %s", err)
+			}
+		}
+	}
+	return m.writePriv(id, blob)
+}
+
+// ImportPriv saves a private key blob by id as is, e.g. one
+// exported from another machine, whether it is encrypted or not
+func (m *FSManager) ImportPriv(id string, blob string) error {
+	return m.writePriv(id, blob)
+}
+
+func (m *FSManager) writePriv(id string, blob string) error {
+	tmp, err := ioutil.TempFile(fmt.Sprintf("%s/privs", m.basePath), ".tmp-")
+	if err != nil {
+		return fmt.Errorf("could not write private key to file system: %s", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write([]byte(blob))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("could not write private key to file system: %s", err)
+	}
+	if err = os.Rename(tmp.Name(), fmt.Sprintf("%s/privs/%s.priv", m.basePath, id)); err != nil {
 		return fmt.Errorf("could not write private key to file system: %s", err)
 	}
 	return nil
 }
 
-// GetPriv gets a private key by id
+// GetPriv gets a private key by id, decrypting it with
+// the passphrase Unlock returns if it is encrypted
 func (m *FSManager) GetPriv(id string) (string, error) {
+	blob, err := m.ExportPriv(id)
+	if err != nil {
+		return "", err
+	}
+	if !IsEncrypted(blob) {
+		return blob, nil
+	}
+	if m.Unlock == nil {
+		return "", fmt.Errorf("private key %s is encrypted", id)
+	}
+	passphrase, err := m.Unlock()
+	if err != nil {
+		return "", fmt.Errorf("could not get passphrase: %s", err)
+	}
+	if blob, err = DecryptPriv(blob, passphrase); err != nil {
+		return "", fmt.Errorf("could not decrypt private key %s: %s", id, err)
+	}
+	return blob, nil
+}
+
+// ExportPriv gets a private key by id as it is saved, i.e.
+// without decrypting it if it is passphrase encrypted
+func (m *FSManager) ExportPriv(id string) (string, error) {
 	dat, err := ioutil.ReadFile(fmt.Sprintf("%s/privs/%s.priv", m.basePath, id))
 	if err != nil {
 		return "", fmt.Errorf("could not read private key from file system: %s", err)
@@ -49,6 +139,21 @@ func (m *FSManager) GetPriv(id string) (string, error) {
 	return string(dat), nil
 }
 
+// ListPrivs lists the ids of all private keys
+func (m *FSManager) ListPrivs() ([]string, error) {
+	files, err := ioutil.ReadDir(fmt.Sprintf("%s/privs", m.basePath))
+	if err != nil {
+		return nil, fmt.Errorf("could not list private keys in file system: %s", err)
+	}
+	ids := []string{}
+	for _, f := range files {
+		if strings.HasSuffix(f.Name(), ".priv") {
+			ids = append(ids, strings.TrimSuffix(f.Name(), ".priv"))
+		}
+	}
+	return ids, nil
+}
+
 // PutPub saves a public key by id
 func (m *FSManager) PutPub(id string, blob string) error {
 	if err := ioutil.WriteFile(fmt.Sprintf("%s/pubs/%s.pub", m.basePath, id), []byte(blob), 0644); err != nil {
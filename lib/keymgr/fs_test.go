@@ -0,0 +1,87 @@
+package keymgr
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func passphrase(p string) PassphraseFunc {
+	return func() (string, error) { return p, nil }
+}
+
+func TestFSManagerPermissions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keymgr")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// directories and keys as created by earlier versions
+	assert.Nil(t, os.MkdirAll(fmt.Sprintf("%s/keys/privs", dir), 755))
+	assert.Nil(t, os.MkdirAll(fmt.Sprintf("%s/keys/pubs", dir), 755))
+	assert.Nil(t, ioutil.WriteFile(fmt.Sprintf("%s/keys/privs/old.priv", dir), []byte(testBlob), 0644))
+
+	m, err := NewFSManager(dir)
+	assert.Nil(t, err)
+	assert.Nil(t, m.PutPriv("new", testBlob))
+	for path, mode := range map[string]os.FileMode{
+		"keys":                0755,
+		"keys/privs":          0700,
+		"keys/pubs":           0755,
+		"keys/privs/old.priv": 0600,
+		"keys/privs/new.priv": 0600,
+	} {
+		fi, err := os.Stat(fmt.Sprintf("%s/%s", dir, path))
+		assert.Nil(t, err)
+		assert.Equal(t, mode, fi.Mode().Perm(), path)
+	}
+	ids, err := m.ListPrivs()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"new", "old"}, ids)
+}
+
+func TestFSManagerEncryptedPrivs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keymgr")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	m, err := NewFSManager(dir)
+	assert.Nil(t, err)
+	m.Lock = passphrase("hunter2")
+	assert.Nil(t, m.PutPriv("id", testBlob))
+
+	stored, err := m.ExportPriv("id")
+	assert.Nil(t, err)
+	assert.True(t, IsEncrypted(stored))
+
+	_, err = m.GetPriv("id")
+	assert.EqualError(t, err, "private key id is encrypted")
+	m.Unlock = passphrase("hunter3")
+	_, err = m.GetPriv("id")
+	assert.EqualError(t, err, "could not decrypt private key id: incorrect passphrase")
+	m.Unlock = func() (string, error) { return "", errors.New("no terminal") }
+	_, err = m.GetPriv("id")
+	assert.EqualError(t, err, "could not get passphrase: no terminal")
+	m.Unlock = passphrase("hunter2")
+	blob, err := m.GetPriv("id")
+	assert.Nil(t, err)
+	assert.Equal(t, testBlob, blob)
+
+	// an empty passphrase saves keys unencrypted
+	m.Lock = passphrase("")
+	assert.Nil(t, m.PutPriv("id", blob))
+	stored, err = m.ExportPriv("id")
+	assert.Nil(t, err)
+	assert.Equal(t, testBlob, stored)
+
+	// imported keys are saved as is
+	encrypted, err := EncryptPriv(testBlob, "other")
+	assert.Nil(t, err)
+	assert.Nil(t, m.ImportPriv("imported", encrypted))
+	stored, err = m.ExportPriv("imported")
+	assert.Nil(t, err)
+	assert.Equal(t, encrypted, stored)
+}
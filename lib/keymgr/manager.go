@@ -4,6 +4,7 @@ package keymgr
 type Manager interface {
 	PutPriv(string, string) error
 	GetPriv(string) (string, error)
+	ListPrivs() ([]string, error)
 	PutPub(string, string) error
 	GetPub(string) (string, error)
 }
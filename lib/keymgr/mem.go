@@ -0,0 +1,72 @@
+package keymgr
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// MemManager is an in-memory key manager, safe for concurrent use
+type MemManager struct {
+	sync.RWMutex
+	privs map[string]string
+	pubs  map[string]string
+}
+
+// NewMemManager is the MemManager constructor
+func NewMemManager() *MemManager {
+	return &MemManager{
+		privs: make(map[string]string),
+		pubs:  make(map[string]string),
+	}
+}
+
+// PutPriv saves a private key by id
+func (m *MemManager) PutPriv(id string, blob string) error {
+	m.Lock()
+	defer m.Unlock()
+	m.privs[id] = blob
+	return nil
+}
+
+// GetPriv gets a private key by id
+func (m *MemManager) GetPriv(id string) (string, error) {
+	m.RLock()
+	defer m.RUnlock()
+	blob, ok := m.privs[id]
+	if !ok {
+		return "", fmt.Errorf("private key %s not found", id)
+	}
+	return blob, nil
+}
+
+// ListPrivs lists the ids of all private keys
+func (m *MemManager) ListPrivs() ([]string, error) {
+	m.RLock()
+	defer m.RUnlock()
+	ids := []string{}
+	for id := range m.privs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// PutPub saves a public key by id
+func (m *MemManager) PutPub(id string, blob string) error {
+	m.Lock()
+	defer m.Unlock()
+	m.pubs[id] = blob
+	return nil
+}
+
+// GetPub gets a public key by id
+func (m *MemManager) GetPub(id string) (string, error) {
+	m.RLock()
+	defer m.RUnlock()
+	blob, ok := m.pubs[id]
+	if !ok {
+		return "", fmt.Errorf("public key %s not found", id)
+	}
+	return blob, nil
+}
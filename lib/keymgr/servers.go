@@ -0,0 +1,67 @@
+package keymgr
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// ServerPath returns the directory under path for the keys of the padl
+// server at the given url. Keys are namespaced per server, as the ids
+// (fingerprints) of keys of different servers may collide
+func ServerPath(path, hostURL string) string {
+	return fmt.Sprintf("%s/servers/%s", path, Namespace(hostURL))
+}
+
+// Namespace returns the name of the namespace of the keys of the padl
+// server at the given url, i.e. its host and port, with any characters
+// other than letters, digits, "-" and "." replaced with "_"
+func Namespace(hostURL string) string {
+	host := hostURL
+	if u, err := url.Parse(hostURL); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '.':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		}
+		return '_'
+	}, host)
+}
+
+// Adopt moves the keys of the key manager at path, i.e. keys saved before
+// keys were namespaced per server, to this key manager. Keys this key
+// manager already has are left where they are
+func (m *FSManager) Adopt(path string) error {
+	src := fmt.Sprintf("%s/keys", path)
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		return nil
+	}
+	for _, dir := range []string{"privs", "pubs"} {
+		files, err := ioutil.ReadDir(fmt.Sprintf("%s/%s", src, dir))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return fmt.Errorf("could not list keys in %s: %s", src, err)
+		}
+		for _, f := range files {
+			dst := fmt.Sprintf("%s/%s/%s", m.basePath, dir, f.Name())
+			if _, err := os.Stat(dst); err == nil {
+				continue
+			}
+			if err = os.Rename(fmt.Sprintf("%s/%s/%s", src, dir, f.Name()), dst); err != nil {
+				return fmt.Errorf("could not move key %s: %s", f.Name(), err)
+			}
+		}
+		// only removed if all keys were moved
+		os.Remove(fmt.Sprintf("%s/%s", src, dir))
+	}
+	os.Remove(src)
+	return m.restrictPrivs()
+}
@@ -0,0 +1,58 @@
+package keymgr
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamespace(t *testing.T) {
+	tests := map[string]string{
+		"https://api.padl.io":          "api.padl.io",
+		"http://localhost:8080":        "localhost_8080",
+		"http://LOCALHOST:8080/prefix": "localhost_8080",
+		"localhost:8080":               "localhost_8080",
+	}
+	for hostURL, ns := range tests {
+		assert.Equal(t, ns, Namespace(hostURL), hostURL)
+	}
+	assert.NotEqual(t, ServerPath("/home/me/.padl", "https://a.padl.io"), ServerPath("/home/me/.padl", "https://b.padl.io"))
+}
+
+func TestFSManagerAdopt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keymgr")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	legacy, err := NewFSManager(dir)
+	assert.Nil(t, err)
+	assert.Nil(t, legacy.PutPriv("old", testBlob))
+	assert.Nil(t, legacy.PutPub("old", "old pub"))
+	assert.Nil(t, legacy.PutPriv("both", "legacy"))
+
+	m, err := NewFSManager(ServerPath(dir, "https://api.padl.io"))
+	assert.Nil(t, err)
+	assert.Nil(t, m.PutPriv("both", testBlob))
+	assert.Nil(t, m.Adopt(dir))
+
+	priv, err := m.GetPriv("old")
+	assert.Nil(t, err)
+	assert.Equal(t, testBlob, priv)
+	pub, err := m.GetPub("old")
+	assert.Nil(t, err)
+	assert.Equal(t, "old pub", pub)
+	// keys the manager had are kept
+	priv, err = m.GetPriv("both")
+	assert.Nil(t, err)
+	assert.Equal(t, testBlob, priv)
+	_, err = os.Stat(fmt.Sprintf("%s/keys/privs/both.priv", dir))
+	assert.Nil(t, err)
+	_, err = os.Stat(fmt.Sprintf("%s/keys/pubs", dir))
+	assert.True(t, os.IsNotExist(err))
+
+	// nothing to adopt
+	assert.Nil(t, m.Adopt(fmt.Sprintf("%s/nothing", dir)))
+}
@@ -0,0 +1,116 @@
+package keys
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// AESKeySize is the size in bytes of AES-256 keys, such as
+	// the data keys generated for every envelope
+	AESKeySize = 32
+
+	// wrappedKeyLenSize is the size in bytes of the length prefix
+	// for the RSA-wrapped data key in an envelope
+	wrappedKeyLenSize = 2
+)
+
+// ErrMalformedEnvelope is returned when a ciphertext is too short
+// or otherwise not laid out as an envelope
+var ErrMalformedEnvelope = errors.New("malformed envelope")
+
+// EncryptEnvelope encrypts a plaintext message of any length with a random
+// AES-256-GCM data key, and wraps the data key with an RSA public key.
+// The output is laid out as len(wrapped key) | wrapped key | nonce | ciphertext
+func EncryptEnvelope(plaintxt []byte, pub *rsa.PublicKey) ([]byte, error) {
+	dataKey, err := GenerateAESKey()
+	if err != nil {
+		return nil, fmt.Errorf("could not generate data key: %s", err)
+	}
+	wrapped, err := EncryptMessage(dataKey, pub)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := SealAESGCM(plaintxt, dataKey, nil)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, wrappedKeyLenSize, wrappedKeyLenSize+len(wrapped)+len(sealed))
+	binary.BigEndian.PutUint16(out, uint16(len(wrapped)))
+	out = append(out, wrapped...)
+	return append(out, sealed...), nil
+}
+
+// DecryptEnvelope unwraps the data key of an envelope with an
+// RSA private key and uses it to decrypt the enclosed message
+func DecryptEnvelope(envelope []byte, priv *rsa.PrivateKey) ([]byte, error) {
+	if len(envelope) < wrappedKeyLenSize {
+		return nil, ErrMalformedEnvelope
+	}
+	wrappedLen := int(binary.BigEndian.Uint16(envelope))
+	if len(envelope) < wrappedKeyLenSize+wrappedLen {
+		return nil, ErrMalformedEnvelope
+	}
+	wrapped := envelope[wrappedKeyLenSize : wrappedKeyLenSize+wrappedLen]
+	dataKey, err := DecryptMessage(wrapped, priv)
+	if err != nil {
+		return nil, err
+	}
+	return OpenAESGCM(envelope[wrappedKeyLenSize+wrappedLen:], dataKey, nil)
+}
+
+// GenerateAESKey generates a random AES-256 key
+func GenerateAESKey() ([]byte, error) {
+	key := make([]byte, AESKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// SealAESGCM encrypts a message with a symmetric key using AES-GCM,
+// authenticating (but not encrypting) the optional additional data.
+// The output is laid out as nonce | ciphertext
+func SealAESGCM(plaintxt, key, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("could not generate nonce: %s", err)
+	}
+	return gcm.Seal(nonce, nonce, plaintxt, additionalData), nil
+}
+
+// OpenAESGCM decrypts a message sealed with SealAESGCM. The additional
+// data must match that which the message was sealed with
+func OpenAESGCM(sealed, key, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, ErrMalformedEnvelope
+	}
+	nonce, ciphertxt := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertxt, additionalData)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("could not initialize AES cipher: %s", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("could not initialize GCM: %s", err)
+	}
+	return gcm, nil
+}
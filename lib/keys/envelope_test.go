@@ -0,0 +1,79 @@
+package keys
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptEnvelope(t *testing.T) {
+	pub, err := DecodePubKeyPEM(pubA)
+	assert.Nil(t, err)
+
+	// positive test - message larger than a single RSA-OAEP block
+	encrypted, err := EncryptEnvelope(bytes.Repeat([]byte("A"), 4096), pub)
+	assert.Nil(t, err)
+	assert.NotNil(t, encrypted)
+
+	// negative test - bad key
+	encrypted, err = EncryptEnvelope([]byte("secretmsg"), &rsa.PublicKey{})
+	assert.NotNil(t, err)
+	assert.Nil(t, encrypted)
+}
+
+func TestDecryptEnvelope(t *testing.T) {
+	// preconditions
+	pub, err := DecodePubKeyPEM(pubA)
+	assert.Nil(t, err)
+	priv, err := DecodePrivKeyPEM(privA)
+	assert.Nil(t, err)
+	otherPriv, err := DecodePrivKeyPEM(privB)
+	assert.Nil(t, err)
+	secret := bytes.Repeat([]byte("secretmsg"), 1000)
+	encrypted, err := EncryptEnvelope(secret, pub)
+	assert.Nil(t, err)
+
+	// positive test
+	decrypted, err := DecryptEnvelope(encrypted, priv)
+	assert.Nil(t, err)
+	assert.Equal(t, secret, decrypted)
+
+	// negative test - wrong key
+	decrypted, err = DecryptEnvelope(encrypted, otherPriv)
+	assert.NotNil(t, err)
+	assert.Nil(t, decrypted)
+
+	// negative test - tampered ciphertext
+	tampered := append([]byte{}, encrypted...)
+	tampered[len(tampered)-1] ^= 0xff
+	decrypted, err = DecryptEnvelope(tampered, priv)
+	assert.NotNil(t, err)
+	assert.Nil(t, decrypted)
+
+	// negative test - truncated envelope
+	decrypted, err = DecryptEnvelope(encrypted[:10], priv)
+	assert.Equal(t, ErrMalformedEnvelope, err)
+	assert.Nil(t, decrypted)
+}
+
+func TestSealOpenAESGCM(t *testing.T) {
+	key := bytes.Repeat([]byte{0x01}, 32)
+	sealed, err := SealAESGCM([]byte("secretmsg"), key, []byte("aad"))
+	assert.Nil(t, err)
+
+	opened, err := OpenAESGCM(sealed, key, []byte("aad"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("secretmsg"), opened)
+
+	// negative test - wrong key
+	opened, err = OpenAESGCM(sealed, bytes.Repeat([]byte{0x02}, 32), []byte("aad"))
+	assert.NotNil(t, err)
+	assert.Nil(t, opened)
+
+	// negative test - wrong additional data
+	opened, err = OpenAESGCM(sealed, key, []byte("other"))
+	assert.NotNil(t, err)
+	assert.Nil(t, opened)
+}
@@ -1,6 +1,7 @@
 package keys
 
 import (
+	"crypto"
 	"crypto/md5"
 	"crypto/rand"
 	"crypto/rsa"
@@ -103,3 +104,15 @@ func DecryptMessageWithPEMKey(cyphertxt []byte, priv []byte) ([]byte, error) {
 	}
 	return DecryptMessage(cyphertxt, k)
 }
+
+// SignMessage signs a message with a private key (RSA-PSS with SHA-512)
+func SignMessage(msg []byte, priv *rsa.PrivateKey) ([]byte, error) {
+	digest := sha512.Sum512(msg)
+	return rsa.SignPSS(rand.Reader, priv, crypto.SHA512, digest[:], nil)
+}
+
+// VerifySignature verifies a message signature produced by SignMessage
+func VerifySignature(msg, sig []byte, pub *rsa.PublicKey) error {
+	digest := sha512.Sum512(msg)
+	return rsa.VerifyPSS(pub, crypto.SHA512, digest[:], sig, nil)
+}
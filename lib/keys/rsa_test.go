@@ -223,3 +223,45 @@ func TestDecryptMessageWithPEMKey(t *testing.T) {
 	assert.NotNil(t, err)
 	assert.Nil(t, decrypted)
 }
+
+func TestSignMessage(t *testing.T) {
+	// preconditions
+	priv, err := DecodePrivKeyPEM(privA)
+	assert.Nil(t, err)
+	assert.NotNil(t, priv)
+
+	// positive test
+	sig, err := SignMessage([]byte("challenge"), priv)
+	assert.Nil(t, err)
+	assert.NotNil(t, sig)
+
+	// negative test
+	sig, err = SignMessage([]byte("challenge"), &rsa.PrivateKey{})
+	assert.NotNil(t, err)
+	assert.Nil(t, sig)
+}
+
+func TestVerifySignature(t *testing.T) {
+	// preconditions
+	priv, err := DecodePrivKeyPEM(privA)
+	assert.Nil(t, err)
+	assert.NotNil(t, priv)
+	otherPriv, err := DecodePrivKeyPEM(privB)
+	assert.Nil(t, err)
+	assert.NotNil(t, otherPriv)
+	sig, err := SignMessage([]byte("challenge"), priv)
+	assert.Nil(t, err)
+	assert.NotNil(t, sig)
+
+	// positive test
+	err = VerifySignature([]byte("challenge"), sig, &priv.PublicKey)
+	assert.Nil(t, err)
+
+	// negative test - different message
+	err = VerifySignature([]byte("another challenge"), sig, &priv.PublicKey)
+	assert.NotNil(t, err)
+
+	// negative test - different key
+	err = VerifySignature([]byte("challenge"), sig, &otherPriv.PublicKey)
+	assert.NotNil(t, err)
+}
@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	yaml "gopkg.in/yaml.v2"
@@ -14,16 +15,172 @@ import (
 
 // Body represents the body of a Padlfile
 type Body struct {
-	Project     string            `json:"project_id" yaml:"project_id"`     // id of the project for this padlfile
-	Variables   map[string]string `json:"variables" yaml:"variables"`       // map of ENV_VAR secret
-	MemberKeys  []string          `json:"user_keys" yaml:"user_keys"`       // project member key ids
-	ServiceKeys []string          `json:"service_keys" yaml:"service_keys"` // service account key ids
-	SharedKey   string            `json:"shared_key" yaml:"shared_key"`     // shared project key id
+	Project     string            `json:"project_id" yaml:"project_id"`                     // id of the project for this padlfile
+	Variables   map[string]string `json:"variables" yaml:"variables"`                       // map of ENV_VAR secret
+	MemberKeys  []string          `json:"user_keys" yaml:"user_keys"`                       // project member key ids
+	ServiceKeys []string          `json:"service_keys" yaml:"service_keys"`                 // service account key ids
+	SharedKey   string            `json:"shared_key" yaml:"shared_key"`                     // shared project key id
+	OwnerKeys   []string          `json:"owner_keys,omitempty" yaml:"owner_keys,omitempty"` // project owner key ids
+	Policy      string            `json:"policy,omitempty" yaml:"policy,omitempty"`         // secret threshold policy, empty means "server+member"
+	Files       map[string]string `json:"files,omitempty" yaml:"files,omitempty"`           // map of ENV_VAR file name, for secrets mounted as files
+
+	Environments map[string]*Environment `json:"environments,omitempty" yaml:"environments,omitempty"` // named secret sets e.g. "prod"
+}
+
+const (
+	// EnvMembersAll gives all project members access to an environment
+	EnvMembersAll = "all"
+	// EnvMembersOwners gives only project owners access to an environment
+	EnvMembersOwners = "owners"
+)
+
+// Environment is a named set of secrets in a project, e.g. "prod". Its
+// secrets are encrypted independently of the project's top-level variables,
+// for all project members and service accounts unless its access is
+// restricted to owners and/or to a list of service accounts
+type Environment struct {
+	Variables       map[string]string `json:"variables" yaml:"variables"`                                   // map of ENV_VAR secret
+	Members         string            `json:"members,omitempty" yaml:"members,omitempty"`                   // one of { "all", "owners" }, empty means "all"
+	ServiceAccounts []string          `json:"service_accounts,omitempty" yaml:"service_accounts,omitempty"` // names of the service accounts with access, empty means all
+	MemberKeys      []string          `json:"user_keys" yaml:"user_keys"`                                   // member key ids with access
+	ServiceKeys     []string          `json:"service_keys" yaml:"service_keys"`                             // service account key ids with access
+	SharedKey       string            `json:"shared_key" yaml:"shared_key"`                                 // shared project key id
+	OwnerKeys       []string          `json:"owner_keys,omitempty" yaml:"owner_keys,omitempty"`             // project owner key ids
+	Policy          string            `json:"policy,omitempty" yaml:"policy,omitempty"`                     // secret threshold policy
+	Files           map[string]string `json:"files,omitempty" yaml:"files,omitempty"`                       // map of ENV_VAR file name, for secrets mounted as files
 }
 
 // File represents the entire contents of a Padlfile
 type File struct {
-	Data Body `json:"data" yaml:"data"`
+	Data     Body   `json:"data" yaml:"data"`
+	Revision uint64 `json:"revision,omitempty" yaml:"revision,omitempty"` // server revision of the padlfile, 0 if never pushed
+}
+
+// ValidateMembers checks that an environment's members selector is valid
+func (e *Environment) ValidateMembers() error {
+	switch e.Members {
+	case "", EnvMembersAll, EnvMembersOwners:
+		return nil
+	}
+	return fmt.Errorf("invalid environment members %q, must be one of { \"%s\", \"%s\" }", e.Members, EnvMembersAll, EnvMembersOwners)
+}
+
+// EnvNames returns the names of the padlfile's environments in order
+func (f *File) EnvNames() []string {
+	names := []string{}
+	for name := range f.Data.Environments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AddEnv adds an environment to the padlfile, with the same keys as the
+// project's top-level variables, unless an environment by that name exists.
+// The named environment is returned
+func (f *File) AddEnv(name string) *Environment {
+	if e, ok := f.Data.Environments[name]; ok {
+		return e
+	}
+	if f.Data.Environments == nil {
+		f.Data.Environments = make(map[string]*Environment)
+	}
+	e := &Environment{
+		Variables:   make(map[string]string),
+		MemberKeys:  f.Data.MemberKeys,
+		ServiceKeys: f.Data.ServiceKeys,
+		SharedKey:   f.Data.SharedKey,
+		OwnerKeys:   f.Data.OwnerKeys,
+		Policy:      f.Data.Policy,
+	}
+	f.Data.Environments[name] = e
+	return e
+}
+
+// ForEnv returns a padlfile holding the variables and keys of the named
+// environment, such that its secrets can be managed like the top-level
+// ones. Changes to its variables are reflected in the environment, changes
+// to its keys must be applied with SetEnv. The empty name selects
+// the top-level variables, i.e. the padlfile itself
+func (f *File) ForEnv(name string) (*File, error) {
+	if name == "" {
+		return f, nil
+	}
+	e, ok := f.Data.Environments[name]
+	if !ok {
+		return nil, fmt.Errorf("environment %s not in padlfile", name)
+	}
+	if e.Variables == nil {
+		e.Variables = make(map[string]string)
+	}
+	if e.Files == nil {
+		e.Files = make(map[string]string)
+	}
+	return &File{
+		Data: Body{
+			Project:     f.Data.Project,
+			Variables:   e.Variables,
+			MemberKeys:  e.MemberKeys,
+			ServiceKeys: e.ServiceKeys,
+			SharedKey:   e.SharedKey,
+			OwnerKeys:   e.OwnerKeys,
+			Policy:      e.Policy,
+			Files:       e.Files,
+		},
+	}, nil
+}
+
+// SetEnv copies the variables and keys of a padlfile returned
+// by ForEnv back onto the named environment
+func (f *File) SetEnv(name string, view *File) error {
+	if name == "" {
+		return nil // view is the padlfile itself
+	}
+	e, ok := f.Data.Environments[name]
+	if !ok {
+		return fmt.Errorf("environment %s not in padlfile", name)
+	}
+	e.Variables = view.Data.Variables
+	e.MemberKeys = view.Data.MemberKeys
+	e.ServiceKeys = view.Data.ServiceKeys
+	e.SharedKey = view.Data.SharedKey
+	e.OwnerKeys = view.Data.OwnerKeys
+	e.Policy = view.Data.Policy
+	e.Files = view.Data.Files
+	return nil
+}
+
+// SetFile marks a variable as a secret which is mounted as a file
+// with the given name, rather than set in the environment
+func (f *File) SetFile(variable, fileName string) error {
+	if err := ValidateFileName(fileName); err != nil {
+		return err
+	}
+	for v, fn := range f.Data.Files {
+		if fn == fileName && v != variable {
+			return fmt.Errorf("file name %s is already used by secret %s", fileName, v)
+		}
+	}
+	if f.Data.Files == nil {
+		f.Data.Files = make(map[string]string)
+	}
+	f.Data.Files[variable] = fileName
+	return nil
+}
+
+// RemoveVariable removes a variable, and its file name if it is a file
+func (f *File) RemoveVariable(variable string) {
+	delete(f.Data.Variables, variable)
+	delete(f.Data.Files, variable)
+}
+
+// ValidateFileName checks that the file name of a
+// secret is a plain file name, rather than a path
+func ValidateFileName(fileName string) error {
+	if fileName == "" || fileName == "." || fileName == ".." || strings.ContainsAny(fileName, "/\\\x00") {
+		return fmt.Errorf("invalid file name %q, must be a file name without a directory", fileName)
+	}
+	return nil
 }
 
 // ReadPadlfile reads a padlfile from the given path
@@ -0,0 +1,119 @@
+package padlfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestFile() *File {
+	return &File{
+		Data: Body{
+			Project:     "proj",
+			Variables:   map[string]string{"TOP": "top-secret"},
+			MemberKeys:  []string{"member-a", "owner-b"},
+			ServiceKeys: []string{"svc-ci", "svc-prod"},
+			SharedKey:   "shared",
+			OwnerKeys:   []string{"owner-b"},
+		},
+	}
+}
+
+func TestForEnv(t *testing.T) {
+	f := newTestFile()
+
+	top, err := f.ForEnv("")
+	assert.Nil(t, err)
+	assert.True(t, top == f, "empty environment should be the padlfile itself")
+
+	_, err = f.ForEnv("prod")
+	assert.EqualError(t, err, "environment prod not in padlfile")
+
+	e := f.AddEnv("prod")
+	assert.Equal(t, f.Data.MemberKeys, e.MemberKeys)
+	assert.Equal(t, f.Data.SharedKey, e.SharedKey)
+	assert.True(t, e == f.AddEnv("prod"), "adding an existing environment should return it")
+
+	view, err := f.ForEnv("prod")
+	assert.Nil(t, err)
+	assert.Equal(t, "proj", view.Data.Project)
+
+	// variables are shared with the environment
+	view.Data.Variables["DB"] = "prod-secret"
+	assert.Equal(t, "prod-secret", f.Data.Environments["prod"].Variables["DB"])
+	assert.NotContains(t, f.Data.Variables, "DB")
+
+	// keys are only copied back with SetEnv
+	view.Data.MemberKeys = []string{"owner-b"}
+	view.Data.ServiceKeys = []string{"svc-prod"}
+	assert.Equal(t, []string{"member-a", "owner-b"}, f.Data.Environments["prod"].MemberKeys)
+	assert.Nil(t, f.SetEnv("prod", view))
+	assert.Equal(t, []string{"owner-b"}, f.Data.Environments["prod"].MemberKeys)
+	assert.Equal(t, []string{"svc-prod"}, f.Data.Environments["prod"].ServiceKeys)
+	assert.Equal(t, []string{"member-a", "owner-b"}, f.Data.MemberKeys)
+
+	assert.EqualError(t, f.SetEnv("dev", view), "environment dev not in padlfile")
+}
+
+func TestEnvNames(t *testing.T) {
+	f := newTestFile()
+	assert.Equal(t, []string{}, f.EnvNames())
+	f.AddEnv("staging")
+	f.AddEnv("dev")
+	f.AddEnv("prod")
+	assert.Equal(t, []string{"dev", "prod", "staging"}, f.EnvNames())
+}
+
+func TestValidateMembers(t *testing.T) {
+	for _, members := range []string{"", EnvMembersAll, EnvMembersOwners} {
+		assert.Nil(t, (&Environment{Members: members}).ValidateMembers())
+	}
+	assert.NotNil(t, (&Environment{Members: "editors"}).ValidateMembers())
+}
+
+func TestSetFile(t *testing.T) {
+	f := newTestFile()
+	assert.Nil(t, f.SetFile("TOP", "top.txt"))
+	assert.Equal(t, map[string]string{"TOP": "top.txt"}, f.Data.Files)
+	assert.EqualError(t, f.SetFile("OTHER", "top.txt"), "file name top.txt is already used by secret TOP")
+	for _, fileName := range []string{"", ".", "..", "dir/top.txt", "..\\top.txt"} {
+		assert.NotNil(t, f.SetFile("TOP", fileName), fileName)
+	}
+
+	// file names of environments are set through their view
+	f.AddEnv("prod")
+	view, err := f.ForEnv("prod")
+	assert.Nil(t, err)
+	view.Data.Variables["CERT"] = "encrypted"
+	assert.Nil(t, view.SetFile("CERT", "tls.crt"))
+	assert.Equal(t, map[string]string{"CERT": "tls.crt"}, f.Data.Environments["prod"].Files)
+
+	view.RemoveVariable("CERT")
+	assert.Empty(t, f.Data.Environments["prod"].Files)
+	assert.Empty(t, f.Data.Environments["prod"].Variables)
+}
+
+func TestWriteReadEnvironments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "padlfile")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, ext := range []string{"yaml", "json"} {
+		f := newTestFile()
+		e := f.AddEnv("prod")
+		e.Members = EnvMembersOwners
+		e.ServiceAccounts = []string{"prod"}
+		e.Variables["DB"] = "prod-secret"
+		e.Files = map[string]string{"DB": "db.conf"}
+		f.Revision = 7
+
+		path := filepath.Join(dir, ".padlfile."+ext)
+		assert.Nil(t, f.Write(path))
+		read, err := ReadPadlfile(path)
+		assert.Nil(t, err)
+		assert.Equal(t, f, read, ext)
+	}
+}
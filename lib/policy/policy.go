@@ -0,0 +1,136 @@
+package policy
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+const (
+	// ServerMember requires the shared project key plus any one member
+	// (or service account) key. This is the default policy
+	ServerMember = "server+member"
+	// BreakGlass requires any two member (or service account) keys,
+	// without the shared project key
+	BreakGlass = "break-glass"
+	// ServerTwoOwners requires the shared project key plus the
+	// keys of any two project owners
+	ServerTwoOwners = "server+2-owners"
+
+	serverPrefix  = "server+"
+	membersSuffix = "-members"
+	ownersSuffix  = "-owners"
+
+	// the shamir implementation caps the number of parts at 255
+	maxThreshold = 255
+
+	// ErrMsgInvalidPolicy is returned when parsing a policy
+	// string which does not follow the policy grammar
+	ErrMsgInvalidPolicy = "invalid policy"
+
+	// ErrMsgThresholdTooLow is returned when a policy would
+	// let a single key decrypt a secret without the server
+	ErrMsgThresholdTooLow = "policies without the server must require at least two keys"
+
+	// ErrMsgThresholdTooHigh is returned when a policy threshold
+	// exceeds what the secret sharing scheme supports
+	ErrMsgThresholdTooHigh = "threshold cannot exceed 255"
+)
+
+var presets = map[string]*Policy{
+	ServerMember:    {RequireServer: true, Threshold: 1},
+	BreakGlass:      {RequireServer: false, Threshold: 2},
+	ServerTwoOwners: {RequireServer: true, Threshold: 2, OwnersOnly: true},
+}
+
+// Policy describes which keys are needed to decrypt a padlfile secret.
+//
+// A secret is recoverable with Threshold distinct holder keys, plus the
+// shared project key (which only the server holds) if RequireServer is set.
+// Holders are the project owners if OwnersOnly is set, otherwise all
+// project members and service accounts.
+//
+// Policies are written as "[server+]N-members" or "[server+]N-owners",
+// e.g. "server+1-members" or "2-owners"; preset names are also accepted
+type Policy struct {
+	RequireServer bool
+	Threshold     int
+	OwnersOnly    bool
+}
+
+// Default returns the default policy, "server+member"
+func Default() *Policy {
+	p := *presets[ServerMember]
+	return &p
+}
+
+// Parse parses a policy string. An empty string
+// is parsed as the default policy
+func Parse(s string) (*Policy, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Default(), nil
+	}
+	if preset, ok := presets[s]; ok {
+		p := *preset
+		return &p, nil
+	}
+
+	p := &Policy{}
+	if strings.HasPrefix(s, serverPrefix) {
+		p.RequireServer = true
+		s = strings.TrimPrefix(s, serverPrefix)
+	}
+	switch {
+	case strings.HasSuffix(s, ownersSuffix):
+		p.OwnersOnly = true
+		s = strings.TrimSuffix(s, ownersSuffix)
+	case strings.HasSuffix(s, membersSuffix):
+		s = strings.TrimSuffix(s, membersSuffix)
+	default:
+		return nil, errors.New(ErrMsgInvalidPolicy)
+	}
+	threshold, err := strconv.Atoi(s)
+	if err != nil {
+		return nil, errors.New(ErrMsgInvalidPolicy)
+	}
+	p.Threshold = threshold
+
+	if err = p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Validate checks that a policy can be enforced
+func (p *Policy) Validate() error {
+	if p.Threshold < 1 {
+		return errors.New(ErrMsgInvalidPolicy)
+	}
+	if !p.RequireServer && p.Threshold < 2 {
+		return errors.New(ErrMsgThresholdTooLow)
+	}
+	if p.Threshold > maxThreshold {
+		return errors.New(ErrMsgThresholdTooHigh)
+	}
+	return nil
+}
+
+// String returns the canonical string representation of a policy
+func (p *Policy) String() string {
+	s := strconv.Itoa(p.Threshold)
+	if p.OwnersOnly {
+		s += ownersSuffix
+	} else {
+		s += membersSuffix
+	}
+	if p.RequireServer {
+		s = serverPrefix + s
+	}
+	return s
+}
+
+// IsDefault returns true if the policy is equivalent to the default policy
+func (p *Policy) IsDefault() bool {
+	return *p == *presets[ServerMember]
+}
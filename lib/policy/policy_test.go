@@ -0,0 +1,110 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		testName     string
+		toParse      string
+		expectPolicy *Policy
+		expectErr    string
+	}{
+		{
+			testName:     "positive test - empty is default",
+			toParse:      "",
+			expectPolicy: &Policy{RequireServer: true, Threshold: 1},
+		},
+		{
+			testName:     "positive test - server+member preset",
+			toParse:      ServerMember,
+			expectPolicy: &Policy{RequireServer: true, Threshold: 1},
+		},
+		{
+			testName:     "positive test - break-glass preset",
+			toParse:      BreakGlass,
+			expectPolicy: &Policy{RequireServer: false, Threshold: 2},
+		},
+		{
+			testName:     "positive test - server+2-owners preset",
+			toParse:      ServerTwoOwners,
+			expectPolicy: &Policy{RequireServer: true, Threshold: 2, OwnersOnly: true},
+		},
+		{
+			testName:     "positive test - custom members policy",
+			toParse:      "3-members",
+			expectPolicy: &Policy{RequireServer: false, Threshold: 3},
+		},
+		{
+			testName:     "positive test - custom owners policy",
+			toParse:      "server+3-owners",
+			expectPolicy: &Policy{RequireServer: true, Threshold: 3, OwnersOnly: true},
+		},
+		{
+			testName:  "negative test - unknown holders",
+			toParse:   "server+2-admins",
+			expectErr: ErrMsgInvalidPolicy,
+		},
+		{
+			testName:  "negative test - non numeric threshold",
+			toParse:   "server+two-owners",
+			expectErr: ErrMsgInvalidPolicy,
+		},
+		{
+			testName:  "negative test - zero threshold",
+			toParse:   "server+0-members",
+			expectErr: ErrMsgInvalidPolicy,
+		},
+		{
+			testName:  "negative test - single key without server",
+			toParse:   "1-owners",
+			expectErr: ErrMsgThresholdTooLow,
+		},
+		{
+			testName:  "negative test - threshold too high",
+			toParse:   "256-members",
+			expectErr: ErrMsgThresholdTooHigh,
+		},
+	}
+
+	for _, test := range tests {
+		p, err := Parse(test.toParse)
+		if test.expectErr != "" {
+			assert.EqualError(t, err, test.expectErr, test.testName)
+			assert.Nil(t, p, test.testName)
+			continue
+		}
+		assert.Nil(t, err, test.testName)
+		assert.Equal(t, test.expectPolicy, p, test.testName)
+	}
+}
+
+func TestString(t *testing.T) {
+	for _, s := range []string{"server+1-members", "2-members", "server+2-owners", "5-owners"} {
+		p, err := Parse(s)
+		assert.Nil(t, err)
+		assert.Equal(t, s, p.String())
+	}
+
+	// presets round trip through their canonical form
+	for _, s := range []string{ServerMember, BreakGlass, ServerTwoOwners} {
+		p, err := Parse(s)
+		assert.Nil(t, err)
+		canonical, err := Parse(p.String())
+		assert.Nil(t, err)
+		assert.Equal(t, p, canonical)
+	}
+}
+
+func TestIsDefault(t *testing.T) {
+	assert.True(t, Default().IsDefault())
+	p, err := Parse("server+1-members")
+	assert.Nil(t, err)
+	assert.True(t, p.IsDefault())
+	p, err = Parse(BreakGlass)
+	assert.Nil(t, err)
+	assert.False(t, p.IsDefault())
+}
@@ -4,11 +4,14 @@ import (
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 )
 
 const (
 	pemBlockType       = "PADL ENCRYPTED SECRET"
+	pemVersionHeader   = "Version"
+	pemPolicyHeader    = "Policy"
 	simpleFmtSeparator = "\n"
 
 	// ErrMsgInvalidSimpleFmt is returned when trying to decode
@@ -18,20 +21,46 @@ const (
 	// ErrMsgCouldNotDecodePEM is returned when trying to decode
 	// a PEM-format secret that is not PEM encoded
 	ErrMsgCouldNotDecodePEM = "could not decode pem block"
+
+	// ErrMsgMixedVersions is returned when trying to encode a
+	// secret with shards of different wire format versions
+	ErrMsgMixedVersions = "secret shards have mixed versions"
+
+	// ErrMsgInvalidVersionHeader is returned when trying to decode
+	// a PEM-format secret with a non-numeric version header
+	ErrMsgInvalidVersionHeader = "invalid version header"
 )
 
 // Secret represents an encrypted secret
 type Secret struct {
 	Shards []*EncryptedShard
+
+	// Policy is the (string representation of the) threshold
+	// policy the secret was split under. Empty means the default
+	Policy string
 }
 
-// EncodePEM returns an encrypted secret in a PEM block
+// EncodePEM returns an encrypted secret in a PEM block. The wire format
+// version of the shards is carried in the block's "Version" header, which
+// is omitted for secrets encrypted before versioning was introduced.
+// Likewise, the policy is carried in the "Policy" header when set
 func (s *Secret) EncodePEM() (string, error) {
-	pemBytes := pem.EncodeToMemory(&pem.Block{
-		Type:  pemBlockType,
-		Bytes: []byte(s.EncodeSimple()),
-	})
-	return string(pemBytes), nil
+	block := &pem.Block{
+		Type:    pemBlockType,
+		Bytes:   []byte(s.EncodeSimple()),
+		Headers: map[string]string{},
+	}
+	version, err := s.version()
+	if err != nil {
+		return "", err
+	}
+	if version != 0 {
+		block.Headers[pemVersionHeader] = strconv.Itoa(version)
+	}
+	if s.Policy != "" {
+		block.Headers[pemPolicyHeader] = s.Policy
+	}
+	return string(pem.EncodeToMemory(block)), nil
 }
 
 // DecodePEM returns an encrypted secret from a pem block
@@ -44,9 +73,34 @@ func DecodePEM(s string) (*Secret, error) {
 	if err != nil {
 		return nil, err
 	}
+	if v, ok := block.Headers[pemVersionHeader]; ok {
+		version, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, errors.New(ErrMsgInvalidVersionHeader)
+		}
+		for _, sh := range sec.Shards {
+			sh.Version = version
+		}
+	}
+	sec.Policy = block.Headers[pemPolicyHeader]
 	return sec, nil
 }
 
+// version returns the wire format version shared by all shards in a secret
+func (s *Secret) version() (int, error) {
+	version := 0
+	for i, sh := range s.Shards {
+		if i == 0 {
+			version = sh.Version
+			continue
+		}
+		if sh.Version != version {
+			return 0, errors.New(ErrMsgMixedVersions)
+		}
+	}
+	return version, nil
+}
+
 // EncodeSimple returns a simple string representation of the encrypted secret.
 // This format is KEY_ID(VALUE)
 func (s *Secret) EncodeSimple() string {
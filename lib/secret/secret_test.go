@@ -11,7 +11,15 @@ func TestEncodePEM(t *testing.T) {
 }
 
 func TestDecodePEM(t *testing.T) {
-	// TODO
+	// legacy (unversioned) secrets decode with no version set
+	sec, err := DecodePEM("-----BEGIN PADL ENCRYPTED SECRET-----\nU09NRUtFWUlEKFNPTUVWQUxVRSk=\n-----END PADL ENCRYPTED SECRET-----\n")
+	assert.Nil(t, err)
+	assert.Equal(t, []*EncryptedShard{{KeyID: "SOMEKEYID", Value: "SOMEVALUE"}}, sec.Shards)
+
+	// a non-numeric version header is rejected
+	sec, err = DecodePEM("-----BEGIN PADL ENCRYPTED SECRET-----\nVersion: two\n\nU09NRUtFWUlEKFNPTUVWQUxVRSk=\n-----END PADL ENCRYPTED SECRET-----\n")
+	assert.Nil(t, sec)
+	assert.EqualError(t, err, ErrMsgInvalidVersionHeader)
 }
 
 func TestEncodeSimple(t *testing.T) {
@@ -91,6 +99,56 @@ func TestEncodeDecodePEM(t *testing.T) {
 			},
 			expectErr: false,
 		},
+		{
+			testName: "positive test - versioned shards",
+			testSecret: &Secret{
+				Shards: []*EncryptedShard{
+					{
+						KeyID:   "some key id",
+						Value:   "asdfghjkl",
+						Version: VersionEnvelope,
+					},
+					{
+						KeyID:   "some other key id",
+						Value:   "qwertyuiop",
+						Version: VersionEnvelope,
+					},
+				},
+			},
+			expectErr: false,
+		},
+		{
+			testName: "positive test - with policy",
+			testSecret: &Secret{
+				Shards: []*EncryptedShard{
+					{
+						KeyID:   "some key id",
+						Value:   "asdfghjkl",
+						Version: VersionEnvelope,
+					},
+				},
+				Policy: "server+2-owners",
+			},
+			expectErr: false,
+		},
+		{
+			testName: "negative test - mixed versions",
+			testSecret: &Secret{
+				Shards: []*EncryptedShard{
+					{
+						KeyID: "some key id",
+						Value: "asdfghjkl",
+					},
+					{
+						KeyID:   "some other key id",
+						Value:   "qwertyuiop",
+						Version: VersionEnvelope,
+					},
+				},
+			},
+			expectErr:     true,
+			expectedError: ErrMsgMixedVersions,
+		},
 	}
 
 	for _, test := range tests {
@@ -30,6 +30,25 @@ const (
 	// ErrMsgCouldNotDecode is returned when a shard value could not
 	// be base64 encoded
 	ErrMsgCouldNotDecode = "could not b64 decode shard value"
+
+	// ErrMsgUnsupportedVersion is returned when the user attempts to
+	// decrypt an EncryptedShard of an unknown wire format version
+	ErrMsgUnsupportedVersion = "unsupported shard version"
+)
+
+const (
+	// VersionRSA is the original wire format, in which shard values are
+	// encrypted directly with RSA-OAEP. It is limited to values shorter
+	// than a single RSA-OAEP block. Shards with no version are VersionRSA
+	VersionRSA = 1
+
+	// VersionEnvelope is the wire format in which shard values are
+	// encrypted with a random AES-256-GCM data key, which is in turn
+	// wrapped with RSA-OAEP. Values can be of any length
+	VersionEnvelope = 2
+
+	// CurrentVersion is the wire format used for newly encrypted shards
+	CurrentVersion = VersionEnvelope
 )
 
 // Shard describes a piece of secret that has been split
@@ -40,8 +59,9 @@ type Shard struct {
 
 // EncryptedShard represents a shard that has been encrypted
 type EncryptedShard struct {
-	Value string `json:"value"`
-	KeyID string `json:"key_id"`
+	Value   string `json:"value"`
+	KeyID   string `json:"key_id"`
+	Version int    `json:"version,omitempty"`
 }
 
 // NewShard returns a populated Shard struct
@@ -64,8 +84,9 @@ func (s *Shard) Encrypt(k *rsa.PublicKey) (*EncryptedShard, error) {
 		return nil, err
 	}
 	return &EncryptedShard{
-		Value: armoured,
-		KeyID: keys.GetFingerprint(k),
+		Value:   armoured,
+		KeyID:   keys.GetFingerprint(k),
+		Version: CurrentVersion,
 	}, nil
 }
 
@@ -75,21 +96,40 @@ func (es *EncryptedShard) Decrypt(k *rsa.PrivateKey) (*Shard, error) {
 	if es.KeyID != fp {
 		return nil, errors.New(ErrMsgIncorrectDecryptionKey)
 	}
-	val, err := decryptAndUnarmourShamirPart(es.Value, k)
+	val, err := decryptAndUnarmourShamirPart(es.Value, es.version(), k)
 	if err != nil {
 		return nil, err
 	}
 	return &Shard{Value: val}, nil
 }
 
-func decryptAndUnarmourShamirPart(data string, k *rsa.PrivateKey) ([]byte, error) {
+// version returns the wire format version of an encrypted shard,
+// accounting for shards encrypted before versioning was introduced
+func (es *EncryptedShard) version() int {
+	if es.Version == 0 {
+		return VersionRSA
+	}
+	return es.Version
+}
+
+func decryptAndUnarmourShamirPart(data string, version int, k *rsa.PrivateKey) ([]byte, error) {
+	// pick decryption scheme as per wire format version
+	var decrypt func([]byte, *rsa.PrivateKey) ([]byte, error)
+	switch version {
+	case VersionRSA:
+		decrypt = keys.DecryptMessage
+	case VersionEnvelope:
+		decrypt = keys.DecryptEnvelope
+	default:
+		return nil, fmt.Errorf("%s: %d", ErrMsgUnsupportedVersion, version)
+	}
 	// remove ASCII armour from piece
 	raw, err := base64.StdEncoding.DecodeString(data)
 	if err != nil {
 		return nil, fmt.Errorf("%s: %s", ErrMsgCouldNotDecode, err)
 	}
 	// decrypt the raw encrypted message
-	dec, err := keys.DecryptMessage(raw, k)
+	dec, err := decrypt(raw, k)
 	if err != nil {
 		return nil, fmt.Errorf("%s: %s", ErrMsgCouldNotDecrypt, err)
 	}
@@ -98,7 +138,7 @@ func decryptAndUnarmourShamirPart(data string, k *rsa.PrivateKey) ([]byte, error
 
 func encryptAndArmourShamirPart(data []byte, k *rsa.PublicKey) (string, error) {
 	// encrypt shard value
-	enc, err := keys.EncryptMessage(data, k)
+	enc, err := keys.EncryptEnvelope(data, k)
 	if err != nil {
 		return "", fmt.Errorf("%s: %s", ErrMsgCouldNotEncrypt, err)
 	}
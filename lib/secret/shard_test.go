@@ -2,6 +2,7 @@ package secret
 
 import (
 	"crypto/rsa"
+	"encoding/base64"
 	"fmt"
 	"testing"
 
@@ -67,6 +68,14 @@ func TestEncrypt(t *testing.T) {
 			key:       goodKey,
 			expectErr: false,
 		},
+		{
+			testName: "larger than rsa block test",
+			shard: &Shard{
+				Value: make([]byte, 4096),
+			},
+			key:       goodKey,
+			expectErr: false,
+		},
 		{
 			testName: "empty value test",
 			shard: &Shard{
@@ -96,6 +105,7 @@ func TestEncrypt(t *testing.T) {
 			assert.Nil(t, err)
 			assert.NotEqual(t, es.Value, test.shard.Value, test.testName)
 			assert.Equal(t, es.KeyID, keys.GetFingerprint(test.key), test.testName)
+			assert.Equal(t, es.Version, CurrentVersion, test.testName)
 		}
 	}
 }
@@ -127,6 +137,16 @@ func TestDecrypt(t *testing.T) {
 		assert.FailNow(t, "could not encrypt mock shard")
 	}
 
+	// a shard encrypted with the original (unversioned) wire format
+	legacyCiphertext, err := keys.EncryptMessage([]byte(mockSecret), goodPub)
+	if err != nil {
+		assert.FailNow(t, "could not encrypt mock legacy shard")
+	}
+	legacyEncryptedShard := &EncryptedShard{
+		Value: base64.StdEncoding.EncodeToString(legacyCiphertext),
+		KeyID: keys.GetFingerprint(goodPub),
+	}
+
 	tests := []struct {
 		testName    string
 		encShard    *EncryptedShard
@@ -140,6 +160,23 @@ func TestDecrypt(t *testing.T) {
 			key:       goodPriv,
 			expectErr: false,
 		},
+		{
+			testName:  "legacy shard test",
+			encShard:  legacyEncryptedShard,
+			key:       goodPriv,
+			expectErr: false,
+		},
+		{
+			testName: "unsupported version test",
+			encShard: &EncryptedShard{
+				Value:   goodEncryptedShard.Value,
+				KeyID:   goodEncryptedShard.KeyID,
+				Version: 99,
+			},
+			key:         goodPriv,
+			expectErr:   true,
+			expectedErr: fmt.Sprintf("%s: %d", ErrMsgUnsupportedVersion, 99),
+		},
 		{
 			testName:    "incorerct key test",
 			encShard:    goodEncryptedShard,
@@ -0,0 +1,107 @@
+package secretfiles
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/adrianosela/padl/lib/padlfile"
+)
+
+// memoryDirs are the directories, backed by memory rather than disk,
+// in which secrets directories are created, in order of preference
+var memoryDirs = []string{os.Getenv("XDG_RUNTIME_DIR"), "/dev/shm"}
+
+// Dir is a private directory holding secrets as files,
+// which is only readable and writable by its owner
+type Dir struct {
+	path  string
+	files []string
+}
+
+// NewDir creates a secrets directory on a memory backed file system
+// if one is available, and in the temporary directory otherwise
+func NewDir() (*Dir, error) {
+	for _, base := range memoryDirs {
+		if base == "" {
+			continue
+		}
+		if fi, err := os.Stat(base); err == nil && fi.IsDir() {
+			if d, err := NewDirIn(base); err == nil {
+				return d, nil
+			}
+		}
+	}
+	return NewDirIn(os.TempDir())
+}
+
+// NewDirIn creates a secrets directory within the given directory
+func NewDirIn(base string) (*Dir, error) {
+	path, err := ioutil.TempDir(base, "padl-")
+	if err != nil {
+		return nil, fmt.Errorf("could not create secrets directory: %s", err)
+	}
+	// TempDir creates directories with mode 0700
+	return &Dir{path: path}, nil
+}
+
+// Path returns the path of the directory
+func (d *Dir) Path() string {
+	return d.path
+}
+
+// Write writes a secret to a file in the directory,
+// readable only by its owner, and returns its path
+func (d *Dir) Write(name string, secret []byte) (string, error) {
+	if err := padlfile.ValidateFileName(name); err != nil {
+		return "", err
+	}
+	path := filepath.Join(d.path, name)
+	fd, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", fmt.Errorf("could not create secret file %s: %s", name, err)
+	}
+	d.files = append(d.files, path)
+	defer fd.Close()
+	if _, err = fd.Write(secret); err != nil {
+		return "", fmt.Errorf("could not write secret file %s: %s", name, err)
+	}
+	return path, nil
+}
+
+// Remove wipes the secrets written to the directory, overwriting
+// them with zeros before removing them, and removes the directory
+func (d *Dir) Remove() error {
+	failed := []string{}
+	for _, path := range d.files {
+		if err := wipe(path); err != nil && !os.IsNotExist(err) {
+			failed = append(failed, fmt.Sprintf("%s: %s", filepath.Base(path), err))
+		}
+	}
+	if err := os.RemoveAll(d.path); err != nil {
+		failed = append(failed, err.Error())
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("could not remove secrets directory %s: %s", d.path, strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// wipe overwrites the contents of a file with zeros
+func wipe(path string) error {
+	fd, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	fi, err := fd.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err = fd.Write(make([]byte, fi.Size())); err != nil {
+		return err
+	}
+	return fd.Sync()
+}
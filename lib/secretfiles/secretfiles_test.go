@@ -0,0 +1,43 @@
+package secretfiles
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteRemove(t *testing.T) {
+	base, err := ioutil.TempDir("", "secretfiles")
+	assert.Nil(t, err)
+	defer os.RemoveAll(base)
+
+	d, err := NewDirIn(base)
+	assert.Nil(t, err)
+	fi, err := os.Stat(d.Path())
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0700), fi.Mode().Perm())
+
+	path, err := d.Write("tls.key", []byte("supersecret"))
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join(d.Path(), "tls.key"), path)
+	fi, err = os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	dat, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "supersecret", string(dat))
+
+	_, err = d.Write("tls.key", []byte("again"))
+	assert.NotNil(t, err, "secret files should not be overwritten")
+	for _, name := range []string{"", ".", "..", "../escape", "dir/file"} {
+		_, err = d.Write(name, []byte("secret"))
+		assert.NotNil(t, err, name)
+	}
+
+	assert.Nil(t, d.Remove())
+	_, err = os.Stat(d.Path())
+	assert.True(t, os.IsNotExist(err))
+}
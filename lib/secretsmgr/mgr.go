@@ -3,11 +3,16 @@ package secretsmgr
 import (
 	"crypto/rsa"
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/adrianosela/padl/api/client"
+	"github.com/adrianosela/padl/api/payloads"
 	"github.com/adrianosela/padl/lib/keymgr"
 	"github.com/adrianosela/padl/lib/keys"
 	"github.com/adrianosela/padl/lib/padlfile"
+	"github.com/adrianosela/padl/lib/policy"
 	"github.com/adrianosela/padl/lib/secret"
 	"github.com/adrianosela/padl/lib/shamir"
 )
@@ -28,87 +33,252 @@ func NewSecretsMgr(client *client.Padl, keyMgr keymgr.Manager, pf *padlfile.File
 	}
 }
 
+// maxConcurrentBatches is how many batch decryption
+// requests are sent to the server at once
+const maxConcurrentBatches = 4
+
+// DecryptErrors maps the variables of a padlfile
+// which could not be decrypted to why
+type DecryptErrors map[string]error
+
+func (e DecryptErrors) Error() string {
+	names := []string{}
+	for name := range e {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	msgs := []string{}
+	for _, name := range names {
+		msgs = append(msgs, fmt.Sprintf("could not decrypt secret for var %s: %s", name, e[name]))
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// partialSecret is a secret whose member shares have been decrypted,
+// but whose shared key shard (if any) is yet to be decrypted by the server
+type partialSecret struct {
+	holderPart []byte
+	shared     *secret.EncryptedShard // nil if the server is not required
+}
+
 // DecryptPadlFileSecrets uses the network and the file system to decrypt
-// the contents of a padlfile
-func (smgr *SecretsMgr) DecryptPadlFileSecrets(userPriv *rsa.PrivateKey) (map[string]string, error) {
+// the contents of a padlfile. Secrets whose policy requires more than
+// one member key need as many private keys to be provided. The shared
+// key shards of all secrets are decrypted by the server in (concurrent)
+// batches, rather than one request per secret. If any secret can not be
+// decrypted, the others are still returned along with DecryptErrors
+func (smgr *SecretsMgr) DecryptPadlFileSecrets(privs ...*rsa.PrivateKey) (map[string]string, error) {
+	privsByID := keysByID(privs)
 	decrypted := make(map[string]string)
+	errs := make(DecryptErrors)
 
+	// decrypt member shares locally, grouping the secrets which
+	// need the server by the key their shared shard is encrypted with
+	partials := make(map[string]*partialSecret)
+	pending := make(map[string][]string)
 	for varName, encrypted := range smgr.padlFile.Data.Variables {
-		plain, err := smgr.DecryptSecret(encrypted, userPriv)
+		ps, err := smgr.decryptHolderPart(encrypted, privsByID)
 		if err != nil {
-			return nil, fmt.Errorf("could not decrypt secret for var %s: %s", varName, err)
+			errs[varName] = err
+			continue
+		}
+		if ps.shared == nil {
+			decrypted[varName] = string(ps.holderPart)
+			continue
+		}
+		partials[varName] = ps
+		pending[ps.shared.KeyID] = append(pending[ps.shared.KeyID], varName)
+	}
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxConcurrentBatches)
+	for kid, varNames := range pending {
+		sort.Strings(varNames)
+		for len(varNames) > 0 {
+			n := len(varNames)
+			if n > payloads.MaxDecryptBatchSize {
+				n = payloads.MaxDecryptBatchSize
+			}
+			wg.Add(1)
+			go func(kid string, batch []string) {
+				defer wg.Done()
+				sem <- struct{}{}
+				defer func() { <-sem }()
+
+				secrets := make([]*payloads.DecryptSecretRequest, len(batch))
+				for i, varName := range batch {
+					sh := partials[varName].shared
+					secrets[i] = &payloads.DecryptSecretRequest{Secret: sh.Value, Version: sh.Version}
+				}
+				plains, itemErrs, err := smgr.client.DecryptSecrets(kid, secrets)
+
+				mutex.Lock()
+				defer mutex.Unlock()
+				for i, varName := range batch {
+					// a failed request fails the whole batch
+					if err != nil {
+						errs[varName] = fmt.Errorf("could not decrypt shared shard: %s", err)
+						continue
+					}
+					if itemErrs[i] != nil {
+						errs[varName] = fmt.Errorf("could not decrypt shared shard: %s", itemErrs[i])
+						continue
+					}
+					plain, err := shamir.Combine([][]byte{[]byte(plains[i]), partials[varName].holderPart})
+					if err != nil {
+						errs[varName] = fmt.Errorf("could not shamir.Combine decrypted parts: %s", err)
+						continue
+					}
+					decrypted[varName] = string(plain)
+				}
+			}(kid, varNames[:n])
+			varNames = varNames[n:]
 		}
-		decrypted[varName] = string(plain)
 	}
+	wg.Wait()
 
+	if len(errs) > 0 {
+		return decrypted, errs
+	}
 	return decrypted, nil
 }
 
-// DecryptSecret ecrypts a single pem encoded secret
-func (smgr *SecretsMgr) DecryptSecret(ciphertext string, usrOrSvcPriv *rsa.PrivateKey) (string, error) {
+// DecryptSecret decrypts a single pem encoded secret, gathering shares
+// as per the policy the secret was encrypted under: a share is decrypted
+// with each of the given (user or service account) private keys, and the
+// shared key share is decrypted by the server if the policy requires it
+func (smgr *SecretsMgr) DecryptSecret(ciphertext string, privs ...*rsa.PrivateKey) (string, error) {
+	ps, err := smgr.decryptHolderPart(ciphertext, keysByID(privs))
+	if err != nil {
+		return "", err
+	}
+	if ps.shared == nil {
+		return string(ps.holderPart), nil
+	}
+	decryptedSharedShard, err := smgr.client.DecryptSecret(ps.shared.Value, ps.shared.KeyID, ps.shared.Version)
+	if err != nil {
+		return "", fmt.Errorf("could not decrypt shared shard: %s", err)
+	}
+	plain, err := shamir.Combine([][]byte{[]byte(decryptedSharedShard), ps.holderPart})
+	if err != nil {
+		return "", fmt.Errorf("could not shamir.Combine decrypted parts: %s", err)
+	}
+
+	return string(plain), nil
+}
+
+// decryptHolderPart decodes a pem encoded secret and decrypts (and
+// combines) the member shares of it with the given private keys
+func (smgr *SecretsMgr) decryptHolderPart(ciphertext string, privsByID map[string]*rsa.PrivateKey) (*partialSecret, error) {
 	sec, err := secret.DecodePEM(ciphertext)
 	if err != nil {
-		return "", fmt.Errorf("could not decode PEM secret %s", err)
+		return nil, fmt.Errorf("could not decode PEM secret %s", err)
+	}
+	pol, err := policy.Parse(sec.Policy)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse secret policy %s: %s", sec.Policy, err)
 	}
-	
-	privID := keys.GetFingerprint(&usrOrSvcPriv.PublicKey)
 
-	parts := [][]byte{}
+	var sharedShard *secret.EncryptedShard
+	holderParts := [][]byte{}
 	for _, sh := range sec.Shards {
 		if sh.KeyID == smgr.padlFile.Data.SharedKey {
-			decryptedSharedShard, err := smgr.client.DecryptSecret(sh.Value, sh.KeyID)
-			if err != nil {
-				return "", fmt.Errorf("could not decrypt shared shard: %s", err)
-			}
-			parts = append(parts, []byte(decryptedSharedShard))
-		} else if sh.KeyID == privID {
-			decryptedUserShard, err := sh.Decrypt(usrOrSvcPriv)
-			if err != nil {
-				return "", fmt.Errorf("could not decrypt user shard: %s", err)
-			}
-			parts = append(parts, decryptedUserShard.Value)
+			sharedShard = sh
+			continue
+		}
+		priv, ok := privsByID[sh.KeyID]
+		if !ok || len(holderParts) == pol.Threshold {
+			continue
+		}
+		decryptedHolderShard, err := sh.Decrypt(priv)
+		if err != nil {
+			return nil, fmt.Errorf("could not decrypt user shard: %s", err)
 		}
+		holderParts = append(holderParts, decryptedHolderShard.Value)
+	}
+	if len(holderParts) < pol.Threshold {
+		return nil, fmt.Errorf("policy %s requires %d member keys, only %d provided for var", pol, pol.Threshold, len(holderParts))
 	}
 
-	if len(parts) < 2 {
-		return "", fmt.Errorf("could not decrypt necessary parts for var")
+	// with a threshold of one every holder has the same part
+	holderPart := holderParts[0]
+	if pol.Threshold > 1 {
+		if holderPart, err = shamir.Combine(holderParts); err != nil {
+			return nil, fmt.Errorf("could not shamir.Combine decrypted member parts: %s", err)
+		}
 	}
-	plain, err := shamir.Combine(parts)
-	if err != nil {
-		return "", fmt.Errorf("could not shamir.Combine decrypted parts: %s", err)
+	if !pol.RequireServer {
+		return &partialSecret{holderPart: holderPart}, nil
 	}
+	if sharedShard == nil {
+		return nil, fmt.Errorf("no shared key shard for var")
+	}
+	return &partialSecret{holderPart: holderPart, shared: sharedShard}, nil
+}
 
-	return string(plain), nil
+// keysByID indexes private keys by the id (fingerprint) of their public key
+func keysByID(privs []*rsa.PrivateKey) map[string]*rsa.PrivateKey {
+	privsByID := make(map[string]*rsa.PrivateKey)
+	for _, priv := range privs {
+		privsByID[keys.GetFingerprint(&priv.PublicKey)] = priv
+	}
+	return privsByID
 }
 
-// EncryptSecret encrypts a single secret
+// EncryptSecret encrypts a single secret as per the padlfile's policy
 func (smgr *SecretsMgr) EncryptSecret(plaintext string) (string, error) {
+	pol, err := policy.Parse(smgr.padlFile.Data.Policy)
+	if err != nil {
+		return "", fmt.Errorf("could not parse padlfile policy %s: %s", smgr.padlFile.Data.Policy, err)
+	}
+	holders := smgr.holderKeys(pol)
+	if len(holders) < pol.Threshold {
+		return "", fmt.Errorf("policy %s requires %d member keys, padlfile has %d", pol, pol.Threshold, len(holders))
+	}
 	// precache necessary encryption keys in the filesystem
 	pubs, err := smgr.PrecachePubs()
 	if err != nil {
 		return "", fmt.Errorf("could not precache public keys: %s", err)
 	}
-	// establish secret object
+	// establish secret object, the default policy is left
+	// implicit such that older clients can still decrypt
 	s := secret.Secret{Shards: []*secret.EncryptedShard{}}
-	// we begin by splitting the plaintext into two top-level shares
-	topLevelParts, err := shamir.Split([]byte(plaintext), 2, 2)
-	if err != nil {
-		return "", fmt.Errorf("could not split plaintext secret: %s", err)
+	if !pol.IsDefault() {
+		s.Policy = pol.String()
 	}
-	// we encrypt one of them with the shared public key
-	sharedShard, err := encryptPart(topLevelParts[0], pubs[smgr.padlFile.Data.SharedKey])
-	if err != nil {
-		return "", fmt.Errorf("could not encrypt shared shard: %s", err)
-	}
-	s.Shards = append(s.Shards, sharedShard)
-	// we encrypt the other top level shard N times (with each of the N user/service keys)
-	memberKeys, serviceKeys := smgr.padlFile.Data.MemberKeys, smgr.padlFile.Data.ServiceKeys
-	for _, k := range append(memberKeys, serviceKeys...) {
-		usrShard, err := encryptPart(topLevelParts[1], pubs[k])
+	// if the server is required, we begin by splitting the plaintext into two
+	// top-level shares, and encrypt one of them with the shared public key
+	holderSecret := []byte(plaintext)
+	if pol.RequireServer {
+		topLevelParts, err := shamir.Split([]byte(plaintext), 2, 2)
+		if err != nil {
+			return "", fmt.Errorf("could not split plaintext secret: %s", err)
+		}
+		sharedShard, err := encryptPart(topLevelParts[0], pubs[smgr.padlFile.Data.SharedKey])
 		if err != nil {
 			return "", fmt.Errorf("could not encrypt shared shard: %s", err)
 		}
-		s.Shards = append(s.Shards, usrShard)
+		s.Shards = append(s.Shards, sharedShard)
+		holderSecret = topLevelParts[1]
+	}
+	// with a threshold of one we encrypt the holders' share N times (with each
+	// of the N holder keys), otherwise we split it into N shares first
+	holderParts := make([][]byte, len(holders))
+	for i := range holders {
+		holderParts[i] = holderSecret
+	}
+	if pol.Threshold > 1 {
+		if holderParts, err = shamir.Split(holderSecret, len(holders), pol.Threshold); err != nil {
+			return "", fmt.Errorf("could not split secret among members: %s", err)
+		}
+	}
+	for i, k := range holders {
+		holderShard, err := encryptPart(holderParts[i], pubs[k])
+		if err != nil {
+			return "", fmt.Errorf("could not encrypt member shard: %s", err)
+		}
+		s.Shards = append(s.Shards, holderShard)
 	}
 	// we then PEM encode the secret data
 	padlPEMSecret, err := s.EncodePEM()
@@ -118,6 +288,25 @@ func (smgr *SecretsMgr) EncryptSecret(plaintext string) (string, error) {
 	return padlPEMSecret, nil
 }
 
+// holderKeys returns the (deduplicated) ids of the keys
+// which hold a share of secrets under the given policy
+func (smgr *SecretsMgr) holderKeys(pol *policy.Policy) []string {
+	candidates := append([]string{}, smgr.padlFile.Data.MemberKeys...)
+	candidates = append(candidates, smgr.padlFile.Data.ServiceKeys...)
+	if pol.OwnersOnly {
+		candidates = smgr.padlFile.Data.OwnerKeys
+	}
+	seen := make(map[string]bool)
+	holders := []string{}
+	for _, k := range candidates {
+		if !seen[k] {
+			seen[k] = true
+			holders = append(holders, k)
+		}
+	}
+	return holders
+}
+
 func encryptPart(part []byte, pub *rsa.PublicKey) (*secret.EncryptedShard, error) {
 	plainShard, err := secret.NewShard(part)
 	if err != nil {
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"flag"
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/adrianosela/padl/api/config"
 	"github.com/adrianosela/padl/api/service"
@@ -12,10 +14,23 @@ const filePath = "./api/config/config.yaml"
 
 var (
 	version string // injected at build-time
+
+	dataFile = flag.String("data", "", "run in embedded mode, keeping all state in the given data file")
+	admins   = flag.String("admins", "", "comma separated emails of users allowed to perform admin operations, in addition to configured admins")
 )
 
 func main() {
-	c := config.BuildConfig(filePath, version)
+	flag.Parse()
+
+	var c *config.Config
+	if *dataFile != "" {
+		c = config.BuildEmbeddedConfig(*dataFile, version)
+	} else {
+		c = config.BuildConfig(filePath, version)
+	}
+	if *admins != "" {
+		c.Auth.Admins = append(c.Auth.Admins, strings.Split(*admins, ",")...)
+	}
 
 	svc := service.NewPadlService(c)
 